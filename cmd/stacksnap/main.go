@@ -1,19 +1,32 @@
 package main
 
 import (
+	"bufio"
 	"context"
+	"crypto/ecdh"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"embed"
 	"io/fs"
 
 	"github.com/spf13/cobra"
 	"github.com/stacksnap/stacksnap/internal/api"
+	"github.com/stacksnap/stacksnap/internal/auth"
 	"github.com/stacksnap/stacksnap/internal/backup"
 	"github.com/stacksnap/stacksnap/internal/compose"
+	"github.com/stacksnap/stacksnap/internal/config"
+	"github.com/stacksnap/stacksnap/internal/crypto"
 	"github.com/stacksnap/stacksnap/internal/docker"
+	"github.com/stacksnap/stacksnap/internal/keyring"
+	"github.com/stacksnap/stacksnap/internal/repo"
+	"github.com/stacksnap/stacksnap/internal/signing"
 	"github.com/stacksnap/stacksnap/internal/storage"
 )
 
@@ -33,14 +46,51 @@ func main() {
 	rootCmd.AddCommand(backupCmd())
 	rootCmd.AddCommand(backupStackCmd())
 	rootCmd.AddCommand(restoreCmd())
+	rootCmd.AddCommand(restoreStackCmd())
 	rootCmd.AddCommand(listCmd())
+	rootCmd.AddCommand(pruneCmd())
 	rootCmd.AddCommand(serverCmd())
+	rootCmd.AddCommand(authCmd())
+	rootCmd.AddCommand(keysCmd())
+	rootCmd.AddCommand(repoCmd())
 
 	if err := rootCmd.Execute(); err != nil {
 		os.Exit(1)
 	}
 }
 
+type storageFlags struct {
+	s3Bucket    string
+	s3Region    string
+	s3Endpoint  string
+	s3AccessKey string
+	s3SecretKey string
+}
+
+func (f *storageFlags) register(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&f.s3Bucket, "s3-bucket", "", "S3 bucket name (default: storage from config)")
+	cmd.Flags().StringVar(&f.s3Region, "s3-region", "us-east-1", "AWS region")
+	cmd.Flags().StringVar(&f.s3Endpoint, "s3-endpoint", "", "S3 endpoint URL (for LocalStack/MinIO)")
+	cmd.Flags().StringVar(&f.s3AccessKey, "s3-access-key", "", "AWS Access Key ID")
+	cmd.Flags().StringVar(&f.s3SecretKey, "s3-secret-key", "", "AWS Secret Access Key")
+}
+
+func (f *storageFlags) provider(ctx context.Context) (storage.Provider, error) {
+	if f.s3Bucket != "" {
+		return storage.NewS3Provider(ctx, f.s3Bucket, f.s3Region, f.s3Endpoint, f.s3AccessKey, f.s3SecretKey)
+	}
+
+	if cfg, err := config.Load(); err == nil {
+		return storage.NewProviderFromConfig(ctx, cfg.Storage)
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get current directory: %w", err)
+	}
+	return storage.NewLocalProvider(cwd)
+}
+
 func discoverCmd() *cobra.Command {
 	return &cobra.Command{
 		Use:   "discover",
@@ -59,8 +109,8 @@ func discoverCmd() *cobra.Command {
 			fmt.Printf(" Stack: %s\n", stack.Name)
 			fmt.Printf(" Compose file: %s\n", stack.ComposeFile)
 			fmt.Printf("\n Services:\n")
-			for _, svc := range stack.Services {
-				fmt.Printf("  • %s\n", svc)
+			for name := range stack.Services {
+				fmt.Printf("  • %s\n", name)
 			}
 
 			if len(stack.NamedVolumes) > 0 {
@@ -87,6 +137,212 @@ func discoverCmd() *cobra.Command {
 	}
 }
 
+func (f *storageFlags) restoreSource(ctx context.Context, input string) (storage.Provider, error) {
+	if f.s3Bucket == "" {
+		if _, err := os.Stat(input); err == nil {
+			return nil, nil
+		}
+	}
+	return f.provider(ctx)
+}
+
+func loadEncryptionKey(key, keyFile string) ([]byte, error) {
+	if key != "" && keyFile != "" {
+		return nil, fmt.Errorf("use either --key or --key-file, not both")
+	}
+	if keyFile != "" {
+		data, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read key file: %w", err)
+		}
+		if len(data) == 32 {
+			return data, nil
+		}
+		key = strings.TrimSpace(string(data))
+	}
+	if key == "" {
+		return nil, nil
+	}
+
+	if strings.HasPrefix(key, crypto.IdentityPrefix) {
+		identity, err := crypto.ParseIdentity(key)
+		if err != nil {
+			return nil, err
+		}
+		return identity.Bytes(), nil
+	}
+	if len(key) == 64 {
+		if k, err := crypto.KeyFromHex(key); err == nil {
+			return k, nil
+		}
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("encryption key must be exactly 32 bytes or 64 hex digits (got %d)", len(key))
+	}
+	return []byte(key), nil
+}
+
+const passphraseEnv = "STACKSNAP_PASSPHRASE"
+
+func signaturePolicy(trusted []string, require bool) (*backup.SignaturePolicy, error) {
+	if cfg, err := config.Load(); err == nil {
+		trusted = append(trusted, cfg.TrustedSigningKeys...)
+		require = require || cfg.RequireSignatures
+	}
+
+	keys, err := signing.ParsePublicKeys(trusted)
+	if err != nil {
+		return nil, err
+	}
+	if signer, err := signing.Load(config.SigningKeyPath()); err == nil {
+		keys = append(keys, signer.PublicKey())
+	}
+	return &backup.SignaturePolicy{Trusted: keys, Strict: require}, nil
+}
+
+func configKeyProvider() (crypto.KeyProvider, error) {
+	cfg, err := config.Load()
+	if err != nil || cfg.KMS == nil {
+		return nil, nil
+	}
+	kms, err := backup.NewKeyProvider(cfg.KMS)
+	if err != nil {
+		return nil, fmt.Errorf("invalid KMS configuration: %w", err)
+	}
+	return kms, nil
+}
+
+func kmsForBackup(err error) (crypto.KeyProvider, error) {
+	if !errors.Is(err, crypto.ErrKMSRequired) {
+		return nil, err
+	}
+	kms, err := configKeyProvider()
+	if err == nil && kms == nil {
+		err = fmt.Errorf("%w (configure kms in config.yaml)", crypto.ErrKMSRequired)
+	}
+	return kms, err
+}
+
+func loadPassphrase(path string, prompt bool) (string, error) {
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read passphrase file: %w", err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+	if !prompt {
+		return "", nil
+	}
+	if passphrase := os.Getenv(passphraseEnv); passphrase != "" {
+		return passphrase, nil
+	}
+	return promptNewPassphrase(" Backup passphrase: ")
+}
+
+func openKeyring() (*keyring.Keyring, error) {
+	ring, err := keyring.Open(config.KeyringPath())
+	if err != nil {
+		return nil, err
+	}
+	if _, err := ring.UnlockFromEnv(); err != nil {
+		return nil, err
+	}
+	return ring, nil
+}
+
+func unlockKeyring(ring *keyring.Keyring) error {
+	if !ring.Locked() {
+		return nil
+	}
+	passphrase, err := promptLine(" Keyring passphrase: ")
+	if err != nil {
+		return err
+	}
+	return ring.Unlock(passphrase)
+}
+
+func keyFromKeyring(ctx context.Context, provider storage.Provider, input string) ([]byte, error) {
+	ring, err := openKeyring()
+	if err != nil {
+		return nil, err
+	}
+
+	key, id, err := backup.ResolveKey(ctx, provider, input, ring)
+	if errors.Is(err, backup.ErrPassphraseRequired) {
+		if passphrase := os.Getenv(passphraseEnv); passphrase != "" {
+			return []byte(passphrase), nil
+		}
+		passphrase, err := promptLine(" Backup passphrase: ")
+		if err != nil {
+			return nil, err
+		}
+		return []byte(passphrase), nil
+	}
+	if errors.Is(err, backup.ErrIdentityRequired) {
+		return nil, fmt.Errorf("%w (use --key-file with the identity file)", err)
+	}
+	if errors.Is(err, crypto.ErrKMSRequired) {
+		return nil, err
+	}
+	if errors.Is(err, keyring.ErrLocked) {
+		if err := unlockKeyring(ring); err != nil {
+			return nil, err
+		}
+		key, id, err = backup.ResolveKey(ctx, provider, input, ring)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w (use --key or --key-file)", err)
+	}
+	if key != nil {
+		fmt.Printf(" Using key %s from keyring\n", id)
+	}
+	return key, nil
+}
+
+func repoLocation(provider storage.Provider, location string) (storage.Provider, string, error) {
+	if provider != nil {
+		return provider, strings.Trim(location, "/"), nil
+	}
+	local, err := storage.NewLocalProvider(location)
+	if err != nil {
+		return nil, "", err
+	}
+	return local, "", nil
+}
+
+func openRepository(ctx context.Context, provider storage.Provider, location string, key []byte) (*repo.Repository, error) {
+	provider, prefix, err := repoLocation(provider, location)
+	if err != nil {
+		return nil, err
+	}
+
+	if key == nil {
+		cfg, err := repo.ReadConfig(ctx, provider, prefix)
+		if err != nil {
+			return nil, err
+		}
+		if cfg.Encrypted {
+			ring, err := openKeyring()
+			if err != nil {
+				return nil, err
+			}
+			key, err = ring.Get(cfg.KeyID)
+			if errors.Is(err, keyring.ErrLocked) {
+				if err := unlockKeyring(ring); err != nil {
+					return nil, err
+				}
+				key, err = ring.Get(cfg.KeyID)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%w: %v (use --key or --key-file)", repo.ErrKeyRequired, err)
+			}
+			fmt.Printf(" Using key %s from keyring\n", cfg.KeyID)
+		}
+	}
+	return repo.Open(ctx, provider, prefix, key)
+}
+
 func backupCmd() *cobra.Command {
 	var output string
 	var pause bool
@@ -134,6 +390,15 @@ func backupStackCmd() *cobra.Command {
 	var s3SecretKey string
 
 	var encryptionKey string
+	var recipients []string
+	var usePassphrase bool
+	var passphraseFile string
+	var sign bool
+	var useKMS bool
+	var repoPath string
+	var modeName string
+	var workers int
+	var compressionName string
 
 	cmd := &cobra.Command{
 		Use:   "backup-stack",
@@ -144,6 +409,15 @@ func backupStackCmd() *cobra.Command {
 				return fmt.Errorf("failed to get current directory: %w", err)
 			}
 
+			mode, err := backup.ParseBackupMode(modeName)
+			if err != nil {
+				return err
+			}
+			compression, err := backup.ParseCompression(compressionName)
+			if err != nil {
+				return err
+			}
+
 			var keyBytes []byte
 			if encryptionKey != "" {
 				if len(encryptionKey) != 32 {
@@ -152,6 +426,40 @@ func backupStackCmd() *cobra.Command {
 				keyBytes = []byte(encryptionKey)
 			}
 
+			var recipientKeys []*ecdh.PublicKey
+			for _, r := range recipients {
+				pub, err := crypto.ParseRecipient(r)
+				if err != nil {
+					return err
+				}
+				recipientKeys = append(recipientKeys, pub)
+			}
+			passphrase, err := loadPassphrase(passphraseFile, usePassphrase)
+			if err != nil {
+				return err
+			}
+
+			var kms crypto.KeyProvider
+			if useKMS {
+				kms, err = configKeyProvider()
+				if err != nil {
+					return err
+				}
+				if kms == nil {
+					return fmt.Errorf("--kms needs a kms section in config.yaml")
+				}
+			}
+
+			modes := 0
+			for _, set := range []bool{keyBytes != nil, len(recipientKeys) > 0, passphrase != "", kms != nil} {
+				if set {
+					modes++
+				}
+			}
+			if modes > 1 {
+				return fmt.Errorf("use only one of --encryption-key, --recipient, --passphrase and --kms")
+			}
+
 			var provider storage.Provider
 			if s3Bucket != "" {
 				var err error
@@ -161,6 +469,36 @@ func backupStackCmd() *cobra.Command {
 				}
 			}
 
+			var repository *repo.Repository
+			if repoPath != "" {
+				if modes > 0 && keyBytes == nil {
+					return fmt.Errorf("--repo only supports --encryption-key")
+				}
+				if compressionName != "" {
+					return fmt.Errorf("--compression does not apply to --repo, repositories compress each chunk")
+				}
+				if sign {
+					return fmt.Errorf("--sign does not apply to --repo, repository snapshots are not signed")
+				}
+				repoProvider, prefix, err := repoLocation(provider, repoPath)
+				if err != nil {
+					return err
+				}
+				repository, err = repo.OpenOrInit(context.Background(), repoProvider, prefix, keyBytes)
+				if err != nil {
+					return fmt.Errorf("failed to open repository: %w", err)
+				}
+				provider, keyBytes = nil, nil
+			}
+
+			var signer *signing.Signer
+			if sign {
+				signer, err = signing.LoadOrCreate(config.SigningKeyPath())
+				if err != nil {
+					return fmt.Errorf("failed to load signing key: %w", err)
+				}
+			}
+
 			client, err := docker.NewClient()
 			if err != nil {
 				return err
@@ -171,19 +509,30 @@ func backupStackCmd() *cobra.Command {
 				return fmt.Errorf("cannot connect to Docker: %w", err)
 			}
 
+			parallel := backup.DefaultParallelConfig()
+			parallel.MaxWorkers = workers
+
 			_, err = backup.BackupStack(client, backup.StackBackupOptions{
 				Directory:       cwd,
 				OutputPath:      output,
 				PauseContainers: pause,
 				IncludeDatabase: dumpDatabases,
+				Mode:            mode,
+				Parallel:        parallel,
+				Compression:     compression,
 				StorageProvider: provider,
 				EncryptionKey:   keyBytes,
+				Recipients:      recipientKeys,
+				Passphrase:      passphrase,
+				KMS:             kms,
+				Repository:      repository,
+				Signer:          signer,
 			})
 			return err
 		},
 	}
 
-	cmd.Flags().StringVarP(&output, "output", "o", "", "Output file path (default: <stack>_<timestamp>.tar.gz)")
+	cmd.Flags().StringVarP(&output, "output", "o", "", "Output file path (default: <stack>_<timestamp>.tar.gz, or .tar.zst / .tar for other codecs)")
 	cmd.Flags().BoolVarP(&pause, "pause", "p", true, "Pause containers during backup for consistency")
 	cmd.Flags().BoolVarP(&dumpDatabases, "databases", "d", true, "Dump databases (PostgreSQL, MySQL) before backup")
 
@@ -194,18 +543,62 @@ func backupStackCmd() *cobra.Command {
 	cmd.Flags().StringVar(&s3SecretKey, "s3-secret-key", "", "AWS Secret Access Key")
 
 	cmd.Flags().StringVar(&encryptionKey, "encryption-key", "", "32-byte encryption key for AES-256")
+	cmd.Flags().StringArrayVar(&recipients, "recipient", nil, "Encrypt to this X25519 public key (repeatable)")
+	cmd.Flags().BoolVar(&usePassphrase, "passphrase", false, "Encrypt with a passphrase (prompted, or read from $STACKSNAP_PASSPHRASE)")
+	cmd.Flags().StringVar(&passphraseFile, "passphrase-file", "", "Encrypt with the passphrase in this file")
+	cmd.Flags().BoolVar(&useKMS, "kms", false, "Wrap the data key with the KMS configured in config.yaml")
+	cmd.Flags().BoolVar(&sign, "sign", false, "Sign the backup with this instance's signing key")
+	cmd.Flags().StringVar(&modeName, "mode", "", "Backup mode: full, incremental or differential (records a file index for later incremental runs)")
+	cmd.Flags().StringVar(&repoPath, "repo", "", "Store a deduplicated snapshot in this repository (directory, or key prefix with --s3-bucket)")
+	cmd.Flags().StringVar(&compressionName, "compression", "", "Archive compression: gzip, zstd or none, with an optional level such as zstd:9 (default gzip)")
+	cmd.Flags().IntVar(&workers, "parallel", backup.DefaultParallelConfig().MaxWorkers, "Number of volumes to dump at the same time")
 
 	return cmd
 }
 
 func restoreCmd() *cobra.Command {
-	return &cobra.Command{
+	var key string
+	var keyFile string
+	var passphraseFile string
+	var yes bool
+	var sf storageFlags
+
+	cmd := &cobra.Command{
 		Use:   "restore <volume-name> <backup-file>",
-		Short: "Restore a Docker volume from a .tar.gz backup",
+		Short: "Restore a Docker volume from a .tar.gz backup (local, remote or encrypted)",
 		Args:  cobra.ExactArgs(2),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			volumeName := args[0]
 			backupFile := args[1]
+			ctx := cmd.Context()
+
+			keyBytes, err := loadEncryptionKey(key, keyFile)
+			if err != nil {
+				return err
+			}
+			if passphraseFile != "" {
+				if keyBytes != nil {
+					return fmt.Errorf("use either a key or --passphrase-file, not both")
+				}
+				passphrase, err := loadPassphrase(passphraseFile, false)
+				if err != nil {
+					return err
+				}
+				keyBytes = []byte(passphrase)
+			}
+
+			provider, err := sf.restoreSource(ctx, backupFile)
+			if err != nil {
+				return err
+			}
+
+			var kms crypto.KeyProvider
+			if keyBytes == nil {
+				keyBytes, err = keyFromKeyring(ctx, provider, backupFile)
+				if kms, err = kmsForBackup(err); err != nil {
+					return err
+				}
+			}
 
 			client, err := docker.NewClient()
 			if err != nil {
@@ -217,13 +610,159 @@ func restoreCmd() *cobra.Command {
 				return fmt.Errorf("cannot connect to Docker: %w", err)
 			}
 
+			if !yes {
+				ok, err := confirm(fmt.Sprintf(" This will overwrite all data in volume %q. Continue? [y/N] ", volumeName))
+				if err != nil {
+					return err
+				}
+				if !ok {
+					return fmt.Errorf("restore aborted")
+				}
+			}
+
 			_, err = backup.Restore(client, backup.RestoreOptions{
-				VolumeName: volumeName,
-				InputPath:  backupFile,
+				VolumeName:      volumeName,
+				InputPath:       backupFile,
+				StorageProvider: provider,
+				EncryptionKey:   keyBytes,
+				KMS:             kms,
+				Context:         ctx,
 			})
 			return err
 		},
 	}
+
+	sf.register(cmd)
+	cmd.Flags().StringVar(&key, "key", "", "Encryption key (32 characters or 64 hex digits)")
+	cmd.Flags().StringVar(&keyFile, "key-file", "", "Path to a file containing the encryption key")
+	cmd.Flags().StringVar(&passphraseFile, "passphrase-file", "", "Path to a file containing the backup passphrase")
+	cmd.Flags().BoolVarP(&yes, "yes", "y", false, "Skip the confirmation prompt")
+	return cmd
+}
+
+func restoreStackCmd() *cobra.Command {
+	var projectName string
+	var key string
+	var keyFile string
+	var passphraseFile string
+	var trustedKeys []string
+	var requireSignature bool
+	var yes bool
+	var repoPath string
+	var sf storageFlags
+
+	cmd := &cobra.Command{
+		Use:   "restore-stack <backup>",
+		Short: "Restore an entire stack from a local or remote backup",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			backupFile := args[0]
+			ctx := cmd.Context()
+
+			if projectName == "" {
+				parse := backup.ParseBackupKey
+				if repoPath != "" {
+					parse = backup.ParseSnapshotID
+				}
+				stack, _, ok := parse(backupFile)
+				if !ok {
+					return fmt.Errorf("cannot determine stack name from %s, use --project", backupFile)
+				}
+				projectName = stack
+			}
+
+			keyBytes, err := loadEncryptionKey(key, keyFile)
+			if err != nil {
+				return err
+			}
+			if passphraseFile != "" {
+				if keyBytes != nil {
+					return fmt.Errorf("use either a key or --passphrase-file, not both")
+				}
+				passphrase, err := loadPassphrase(passphraseFile, false)
+				if err != nil {
+					return err
+				}
+				keyBytes = []byte(passphrase)
+			}
+
+			var provider storage.Provider
+			var repository *repo.Repository
+			var kms crypto.KeyProvider
+			if repoPath != "" {
+				if sf.s3Bucket != "" {
+					if provider, err = sf.provider(ctx); err != nil {
+						return err
+					}
+				}
+				repository, err = openRepository(ctx, provider, repoPath, keyBytes)
+				if err != nil {
+					return fmt.Errorf("failed to open repository: %w", err)
+				}
+				provider, keyBytes = nil, nil
+			} else {
+				provider, err = sf.restoreSource(ctx, backupFile)
+				if err != nil {
+					return err
+				}
+				if keyBytes == nil {
+					keyBytes, err = keyFromKeyring(ctx, provider, backupFile)
+					if kms, err = kmsForBackup(err); err != nil {
+						return err
+					}
+				}
+			}
+
+			client, err := docker.NewClient()
+			if err != nil {
+				return err
+			}
+			defer client.Close()
+
+			if err := client.Ping(); err != nil {
+				return fmt.Errorf("cannot connect to Docker: %w", err)
+			}
+
+			if !yes {
+				fmt.Printf(" Restoring %s into stack %q\n", backupFile, projectName)
+				fmt.Println(" Running containers of the stack will be stopped and their volumes overwritten.")
+				ok, err := confirm(" Continue? [y/N] ")
+				if err != nil {
+					return err
+				}
+				if !ok {
+					return fmt.Errorf("restore aborted")
+				}
+			}
+
+			policy, err := signaturePolicy(trustedKeys, requireSignature)
+			if err != nil {
+				return err
+			}
+
+			return backup.RestoreStack(client, backup.StackRestoreOptions{
+				StackName:       projectName,
+				InputPath:  backupFile,
+				StorageProvider: provider,
+				EncryptionKey:   keyBytes,
+				KMS:             kms,
+				Repository:      repository,
+				Signatures:      policy,
+				Context:         ctx,
+			})
+		},
+	}
+
+	sf.register(cmd)
+	cmd.Flags().StringVarP(&projectName, "project", "P", "", "Compose project name to restore into (default: taken from the backup name)")
+	cmd.Flags().StringVar(&key, "key", "", "Encryption key (32 characters or 64 hex digits)")
+	cmd.Flags().StringVar(&keyFile, "key-file", "", "Path to a file containing the encryption key")
+	cmd.Flags().StringVar(&passphraseFile, "passphrase-file", "", "Path to a file containing the backup passphrase")
+	cmd.Flags().StringArrayVar(&trustedKeys, "trusted-key", nil, "Trust backups signed by this public key (repeatable)")
+	cmd.Flags().BoolVar(&requireSignature, "require-signature", false, "Refuse backups without a valid signature from a trusted key")
+	cmd.Flags().StringVar(&repoPath, "repo", "", "Restore a snapshot from this deduplicated repository (directory, or key prefix with --s3-bucket)")
+	cmd.Flags().BoolVarP(&yes, "yes", "y", false, "Skip the confirmation prompt")
+	return cmd
 }
 
 func listCmd() *cobra.Command {
@@ -266,6 +805,92 @@ func listCmd() *cobra.Command {
 	}
 }
 
+func pruneCmd() *cobra.Command {
+	var stackName string
+	var dryRun bool
+	var policy config.RetentionPolicy
+	var sf storageFlags
+
+	cmd := &cobra.Command{
+		Use:   "prune",
+		Short: "Delete old backups according to retention policies",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := context.Background()
+
+			provider, err := sf.provider(ctx)
+			if err != nil {
+				return err
+			}
+
+			cfg, _ := config.Load()
+			policyFor := cfg.RetentionFor
+			if !policy.IsEmpty() {
+				policyFor = func(string) (config.RetentionPolicy, bool) {
+					return policy, true
+				}
+			}
+
+			verfMap := backup.LoadVerifications(config.VerificationsPath())
+			result, err := backup.Prune(backup.PruneOptions{
+				StackName:       stackName,
+				Policy:          policyFor,
+				DryRun:          dryRun,
+				StorageProvider: provider,
+				Verifications:   verfMap,
+				Context:         ctx,
+			})
+			if err != nil {
+				return err
+			}
+
+			if len(result.Plans) == 0 {
+				fmt.Println("No stacks with a retention policy found")
+				return nil
+			}
+
+			for _, plan := range result.Plans {
+				fmt.Printf("\n Stack: %s\n", plan.Stack)
+				for _, d := range plan.Keep {
+					fmt.Printf("  keep    %s (%s)\n", d.Key, strings.Join(d.Reasons, ", "))
+				}
+				for _, d := range plan.Remove {
+					fmt.Printf("  remove  %s\n", d.Key)
+				}
+			}
+
+			if dryRun {
+				fmt.Println("\n Dry run: nothing was deleted")
+				return nil
+			}
+
+			for _, key := range result.Deleted {
+				delete(verfMap, key)
+			}
+			if len(result.Deleted) > 0 {
+				backup.SaveVerifications(config.VerificationsPath(), verfMap)
+			}
+
+			fmt.Printf("\n Deleted %d backup(s)\n", len(result.Deleted))
+			if len(result.Errors) > 0 {
+				return fmt.Errorf("failed to delete %d backup(s)", len(result.Errors))
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&stackName, "stack", "", "Only prune backups of this stack")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Report what would be deleted without deleting anything")
+	cmd.Flags().IntVar(&policy.KeepLast, "keep-last", 0, "Keep the N most recent backups")
+	cmd.Flags().IntVar(&policy.KeepHourly, "keep-hourly", 0, "Keep the last backup of each of the last N hours")
+	cmd.Flags().IntVar(&policy.KeepDaily, "keep-daily", 0, "Keep the last backup of each of the last N days")
+	cmd.Flags().IntVar(&policy.KeepWeekly, "keep-weekly", 0, "Keep the last backup of each of the last N weeks")
+	cmd.Flags().IntVar(&policy.KeepMonthly, "keep-monthly", 0, "Keep the last backup of each of the last N months")
+	cmd.Flags().IntVar(&policy.KeepYearly, "keep-yearly", 0, "Keep the last backup of each of the last N years")
+	sf.register(cmd)
+
+	return cmd
+}
+
 func serverCmd() *cobra.Command {
 	var port int
 
@@ -315,3 +940,658 @@ func serverCmd() *cobra.Command {
 
 	return cmd
 }
+
+func authCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "auth",
+		Short: "Manage the dashboard admin account and API tokens",
+	}
+
+	var username string
+	setPassword := &cobra.Command{
+		Use:   "set-password",
+		Short: "Create or reset the admin account",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			store, err := auth.Open(config.AuthPath())
+			if err != nil {
+				return err
+			}
+
+			password := os.Getenv("STACKSNAP_ADMIN_PASSWORD")
+			if password == "" {
+				password, err = promptLine(fmt.Sprintf("New password for %s: ", username))
+				if err != nil {
+					return err
+				}
+			}
+
+			if err := store.SetAdmin(username, password); err != nil {
+				return err
+			}
+			fmt.Printf(" Admin account %s saved\n", username)
+			return nil
+		},
+	}
+	setPassword.Flags().StringVar(&username, "username", "admin", "Admin username")
+
+	tokens := &cobra.Command{
+		Use:   "token",
+		Short: "Manage API tokens",
+	}
+
+	var tokenScope string
+	create := &cobra.Command{
+		Use:   "create <name>",
+		Short: "Create an API token (scopes: read, backup, admin)",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			scope, err := auth.ParseScope(tokenScope)
+			if err != nil {
+				return err
+			}
+
+			store, err := auth.Open(config.AuthPath())
+			if err != nil {
+				return err
+			}
+
+			secret, token, err := store.CreateToken(args[0], scope)
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf(" Token %s (%s) created. It will not be shown again:\n\n  %s\n\n", token.ID, token.Scope, secret)
+			fmt.Println(" Use it with: Authorization: Bearer <token>")
+			return nil
+		},
+	}
+	create.Flags().StringVar(&tokenScope, "scope", string(auth.ScopeRead), "Token scope: read, backup or admin")
+
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "List API tokens",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			store, err := auth.Open(config.AuthPath())
+			if err != nil {
+				return err
+			}
+
+			list := store.Tokens()
+			if len(list) == 0 {
+				fmt.Println("No API tokens found")
+				return nil
+			}
+
+			for _, t := range list {
+				lastUsed := "never"
+				if t.LastUsed != nil {
+					lastUsed = t.LastUsed.Format(time.RFC3339)
+				}
+				fmt.Printf("  %s  %-7s  %s (...%s, last used %s)\n", t.ID, t.Scope, t.Name, t.Hint, lastUsed)
+			}
+			return nil
+		},
+	}
+
+	revoke := &cobra.Command{
+		Use:   "revoke <id>",
+		Short: "Revoke an API token",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			store, err := auth.Open(config.AuthPath())
+			if err != nil {
+				return err
+			}
+			if err := store.RevokeToken(args[0]); err != nil {
+				return err
+			}
+			fmt.Printf(" Token %s revoked\n", args[0])
+			return nil
+		},
+	}
+
+	tokens.AddCommand(create, list, revoke)
+	cmd.AddCommand(setPassword, tokens)
+	return cmd
+}
+
+func repoCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "repo",
+		Short: "Inspect deduplicated snapshot repositories",
+	}
+
+	var key string
+	var keyFile string
+	var sf storageFlags
+
+	snapshots := &cobra.Command{
+		Use:   "snapshots <repo>",
+		Short: "List the snapshots stored in a repository",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+
+			keyBytes, err := loadEncryptionKey(key, keyFile)
+			if err != nil {
+				return err
+			}
+
+			var provider storage.Provider
+			if sf.s3Bucket != "" {
+				if provider, err = sf.provider(ctx); err != nil {
+					return err
+				}
+			}
+			repository, err := openRepository(ctx, provider, args[0], keyBytes)
+			if err != nil {
+				return err
+			}
+
+			items, err := repository.Snapshots(ctx)
+			if err != nil {
+				return err
+			}
+			if len(items) == 0 {
+				fmt.Println("No snapshots found")
+				return nil
+			}
+
+			cfg := repository.Config()
+			fmt.Printf(" Repository %s (encrypted: %t)\n", cfg.ID, cfg.Encrypted)
+			for _, item := range items {
+				fmt.Printf("  • %s  %s\n", item.Key, item.LastModified.Format(time.RFC3339))
+			}
+			return nil
+		},
+	}
+	sf.register(snapshots)
+	snapshots.Flags().StringVar(&key, "key", "", "Repository key (32 characters or 64 hex digits)")
+	snapshots.Flags().StringVar(&keyFile, "key-file", "", "Path to a file containing the repository key")
+
+	cmd.AddCommand(snapshots)
+	return cmd
+}
+
+func keysCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "keys",
+		Short: "Manage encryption keys in the server keyring",
+	}
+
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "List keys in the keyring",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ring, err := keyring.Open(config.KeyringPath())
+			if err != nil {
+				return err
+			}
+
+			keys := ring.Keys()
+			if len(keys) == 0 {
+				fmt.Println("No keys in the keyring")
+				return nil
+			}
+
+			fmt.Printf(" Keys (%s):\n", config.KeyringPath())
+			for _, k := range keys {
+				marker := " "
+				if k.ID == ring.DefaultID() {
+					marker = "*"
+				}
+				status := ""
+				if k.RetiredAt != nil {
+					status = " (retired)"
+				}
+				fmt.Printf(" %s %s  %-20s created %s%s\n", marker, k.ID, k.Name, k.CreatedAt.Format("2006-01-02"), status)
+			}
+			return nil
+		},
+	}
+
+	var from, to, newName, stackName string
+	var dryRun bool
+	var sf storageFlags
+
+	rotate := &cobra.Command{
+		Use:   "rotate",
+		Short: "Re-encrypt existing backups with a new key and retire the old one",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+
+			ring, err := openKeyring()
+			if err != nil {
+				return err
+			}
+			if err := unlockKeyring(ring); err != nil {
+				return err
+			}
+
+			if from == "" {
+				from = ring.DefaultID()
+			}
+			if from == "" {
+				return fmt.Errorf("keyring has no default key, use --from")
+			}
+			if to == "" {
+				if newName == "" {
+					return fmt.Errorf("use --to with an existing key or --name to generate a new one")
+				}
+				if dryRun {
+					return fmt.Errorf("--dry-run needs an existing key in --to")
+				}
+				key, err := ring.Generate(newName)
+				if err != nil {
+					return err
+				}
+				fmt.Printf(" Generated key %s (%s)\n", key.ID, key.Name)
+				to = key.ID
+			}
+
+			provider, err := sf.provider(ctx)
+			if err != nil {
+				return err
+			}
+
+			// Signed archives are re-signed after rotation; without a
+			// signing key they are left untouched and reported as failed.
+			signer, _ := signing.Load(config.SigningKeyPath())
+
+			res, err := backup.RotateKeys(backup.RotateOptions{
+				FromKeyID:       from,
+				ToKeyID:         to,
+				StackName:       stackName,
+				StatePath:       config.RotationStatePath(),
+				DryRun:          dryRun,
+				StorageProvider: provider,
+				Keyring:         ring,
+				Signer:          signer,
+				Context:         ctx,
+			})
+			if err != nil {
+				return err
+			}
+
+			for _, a := range res.Archives {
+				detail := a.Reason
+				if a.Error != "" {
+					detail = a.Error
+				}
+				fmt.Printf("  %-8s %s %s\n", a.Status, a.Key, detail)
+			}
+			if res.KeyRetired {
+				fmt.Printf(" Key %s retired, new default is %s\n", res.FromKeyID, res.ToKeyID)
+			}
+			if res.Failed > 0 {
+				return fmt.Errorf("%d archives failed to rotate, run the command again to resume", res.Failed)
+			}
+			return nil
+		},
+	}
+	sf.register(rotate)
+	rotate.Flags().StringVar(&from, "from", "", "Key ID or name to rotate away from (default: current default key)")
+	rotate.Flags().StringVar(&to, "to", "", "Existing key ID or name to re-encrypt with")
+	rotate.Flags().StringVar(&newName, "name", "", "Generate a new key with this name and re-encrypt with it")
+	rotate.Flags().StringVarP(&stackName, "stack", "s", "", "Only rotate backups of this stack")
+	rotate.Flags().BoolVar(&dryRun, "dry-run", false, "Show which backups would be re-encrypted")
+
+	generate := &cobra.Command{
+		Use:   "generate <name>",
+		Short: "Generate a new backup encryption key in the keyring",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ring, err := openKeyring()
+			if err != nil {
+				return err
+			}
+			if err := unlockKeyring(ring); err != nil {
+				return err
+			}
+
+			key, err := ring.Generate(args[0])
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf(" Generated key %s (%s)\n", key.ID, key.Name)
+			if ring.DefaultID() == key.ID {
+				fmt.Println(" It is now the default key for new backups")
+			}
+			fmt.Printf(" Export a recovery kit now: stacksnap keys export-recovery %s -o recovery-kit.txt\n", key.Name)
+			return nil
+		},
+	}
+
+	var kitOut, kitFormat, kitHint string
+
+	exportRecovery := &cobra.Command{
+		Use:   "export-recovery [key]",
+		Short: "Export a passphrase-protected recovery kit for a key",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if kitFormat != "text" && kitFormat != "json" {
+				return fmt.Errorf("invalid format %q (expected text or json)", kitFormat)
+			}
+
+			ring, err := openKeyring()
+			if err != nil {
+				return err
+			}
+			if err := unlockKeyring(ring); err != nil {
+				return err
+			}
+
+			id := ring.DefaultID()
+			if len(args) > 0 {
+				id = args[0]
+			}
+			if id == "" {
+				return fmt.Errorf("keyring has no default key, name the key to export")
+			}
+			key, err := ring.Get(id)
+			if err != nil {
+				return err
+			}
+
+			passphrase, err := promptNewPassphrase(" Recovery kit passphrase: ")
+			if err != nil {
+				return err
+			}
+
+			kit, err := crypto.CreateRecoveryKit(key, passphrase, kitHint)
+			if err != nil {
+				return err
+			}
+			kit.KeyID = keyring.Fingerprint(key)
+
+			out, err := formatRecoveryKit(kit, kitFormat)
+			if err != nil {
+				return err
+			}
+
+			if kitOut == "" {
+				fmt.Print(out)
+				return nil
+			}
+			if err := os.WriteFile(kitOut, []byte(out), 0600); err != nil {
+				return fmt.Errorf("failed to write recovery kit: %w", err)
+			}
+			fmt.Printf(" Recovery kit for key %s written to %s\n", kit.KeyID, kitOut)
+			return nil
+		},
+	}
+	exportRecovery.Flags().StringVarP(&kitOut, "output", "o", "", "Write the kit to this file instead of stdout")
+	exportRecovery.Flags().StringVar(&kitFormat, "format", "text", "Kit format: text (printable) or json")
+	exportRecovery.Flags().StringVar(&kitHint, "hint", "", "Passphrase hint stored in the kit")
+
+	var recoverName, recoverOut string
+
+	recoverKey := &cobra.Command{
+		Use:   "recover <kit-file>",
+		Short: "Rebuild a key from a recovery kit and its passphrase",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			data, err := os.ReadFile(args[0])
+			if err != nil {
+				return fmt.Errorf("failed to read recovery kit: %w", err)
+			}
+			kit, err := crypto.ParseRecoveryKit(data)
+			if err != nil {
+				return err
+			}
+
+			if kit.Hint != "" {
+				fmt.Printf(" Hint: %s\n", kit.Hint)
+			}
+			passphrase, err := promptLine(" Recovery kit passphrase: ")
+			if err != nil {
+				return err
+			}
+			key, err := crypto.RecoverKey(kit, passphrase)
+			if err != nil {
+				return err
+			}
+			if id := keyring.Fingerprint(key); kit.KeyID != "" && kit.KeyID != id {
+				return fmt.Errorf("recovered key %s does not match the kit's key ID %s", id, kit.KeyID)
+			}
+			return storeRecoveredKey(key, recoverName, recoverOut)
+		},
+	}
+	recoverKey.Flags().StringVar(&recoverName, "name", "", "Name for the recovered key (default: recovered-<id>)")
+	recoverKey.Flags().StringVarP(&recoverOut, "output", "o", "", "Write the key as hex to this file instead of adding it to the keyring")
+
+	var threshold, shareCount int
+	var shareDir, shareFormat string
+
+	split := &cobra.Command{
+		Use:   "split [key]",
+		Short: "Split a key into shares so that any threshold of them can rebuild it",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if shareFormat != "text" && shareFormat != "json" {
+				return fmt.Errorf("invalid format %q (expected text or json)", shareFormat)
+			}
+
+			ring, err := openKeyring()
+			if err != nil {
+				return err
+			}
+			if err := unlockKeyring(ring); err != nil {
+				return err
+			}
+
+			id := ring.DefaultID()
+			if len(args) > 0 {
+				id = args[0]
+			}
+			if id == "" {
+				return fmt.Errorf("keyring has no default key, name the key to split")
+			}
+			key, err := ring.Get(id)
+			if err != nil {
+				return err
+			}
+
+			shares, err := crypto.SplitKey(key, threshold, shareCount)
+			if err != nil {
+				return err
+			}
+
+			if err := os.MkdirAll(shareDir, 0700); err != nil {
+				return err
+			}
+			for _, share := range shares {
+				out, err := formatShare(share, shareFormat)
+				if err != nil {
+					return err
+				}
+				path := filepath.Join(shareDir, fmt.Sprintf("stacksnap-share-%s-%d-of-%d.%s", share.KeyID, share.Index, share.Total, shareExt(shareFormat)))
+				if err := os.WriteFile(path, []byte(out), 0600); err != nil {
+					return fmt.Errorf("failed to write share: %w", err)
+				}
+				fmt.Printf(" Wrote %s\n", path)
+			}
+			fmt.Printf(" Key %s split into %d shares, any %d of them rebuild it. Hand each share to a different person.\n", keyring.Fingerprint(key), shareCount, threshold)
+			return nil
+		},
+	}
+	split.Flags().IntVarP(&threshold, "threshold", "t", 2, "Number of shares needed to rebuild the key")
+	split.Flags().IntVarP(&shareCount, "shares", "n", 3, "Number of shares to create")
+	split.Flags().StringVarP(&shareDir, "output-dir", "o", ".", "Directory to write the share files to")
+	split.Flags().StringVar(&shareFormat, "format", "text", "Share format: text (printable) or json")
+
+	var combineName, combineOut string
+
+	combine := &cobra.Command{
+		Use:   "combine <share-file>...",
+		Short: "Rebuild a key from its shares",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var shares []*crypto.Share
+			for _, path := range args {
+				data, err := os.ReadFile(path)
+				if err != nil {
+					return fmt.Errorf("failed to read share: %w", err)
+				}
+				share, err := crypto.ParseShare(data)
+				if err != nil {
+					return fmt.Errorf("%s: %w", path, err)
+				}
+				shares = append(shares, share)
+			}
+
+			key, err := crypto.CombineShares(shares)
+			if err != nil {
+				return err
+			}
+			return storeRecoveredKey(key, combineName, combineOut)
+		},
+	}
+	combine.Flags().StringVar(&combineName, "name", "", "Name for the rebuilt key (default: recovered-<id>)")
+	combine.Flags().StringVarP(&combineOut, "output", "o", "", "Write the key as hex to this file instead of adding it to the keyring")
+
+	signingKey := &cobra.Command{
+		Use:   "signing-key",
+		Short: "Show this instance's backup signing key, creating it if needed",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			signer, err := signing.LoadOrCreate(config.SigningKeyPath())
+			if err != nil {
+				return err
+			}
+			fmt.Printf(" Key ID:     %s\n", signer.KeyID())
+			fmt.Printf(" Public key: %s\n", signing.EncodePublicKey(signer.PublicKey()))
+			fmt.Println(" Add the public key to trusted_signing_keys on machines that restore these backups.")
+			return nil
+		},
+	}
+
+	var identityOut string
+
+	identity := &cobra.Command{
+		Use:   "identity",
+		Short: "Generate an X25519 identity for public-key encrypted backups",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if identityOut == "" {
+				return fmt.Errorf("--output is required")
+			}
+			if _, err := os.Stat(identityOut); err == nil {
+				return fmt.Errorf("%s already exists", identityOut)
+			}
+
+			priv, err := crypto.GenerateIdentity()
+			if err != nil {
+				return err
+			}
+			if err := os.WriteFile(identityOut, []byte(crypto.EncodeIdentity(priv)+"\n"), 0600); err != nil {
+				return fmt.Errorf("failed to write identity: %w", err)
+			}
+
+			fmt.Printf(" Identity written to %s (keep it offline, it is needed to restore)\n", identityOut)
+			fmt.Printf(" Recipient: %s\n", crypto.EncodeRecipient(priv.PublicKey()))
+			return nil
+		},
+	}
+	identity.Flags().StringVarP(&identityOut, "output", "o", "", "File to write the private identity to")
+
+	cmd.AddCommand(list, generate, exportRecovery, recoverKey, split, combine, rotate, identity, signingKey)
+	return cmd
+}
+
+func confirm(prompt string) (bool, error) {
+	answer, err := promptLine(prompt)
+	if err != nil {
+		return false, err
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes", nil
+}
+
+func promptNewPassphrase(prompt string) (string, error) {
+	passphrase, err := promptLine(prompt)
+	if err != nil {
+		return "", err
+	}
+	again, err := promptLine(" Repeat passphrase: ")
+	if err != nil {
+		return "", err
+	}
+	if passphrase != again {
+		return "", fmt.Errorf("passphrases do not match")
+	}
+	return passphrase, nil
+}
+
+func formatRecoveryKit(kit *crypto.RecoveryKit, format string) (string, error) {
+	if format == "json" {
+		data, err := json.MarshalIndent(kit, "", "  ")
+		if err != nil {
+			return "", err
+		}
+		return string(data) + "\n", nil
+	}
+	return kit.Text()
+}
+
+func formatShare(share *crypto.Share, format string) (string, error) {
+	if format == "json" {
+		data, err := json.MarshalIndent(share, "", "  ")
+		if err != nil {
+			return "", err
+		}
+		return string(data) + "\n", nil
+	}
+	return share.Text()
+}
+
+func shareExt(format string) string {
+	if format == "json" {
+		return "json"
+	}
+	return "txt"
+}
+
+func storeRecoveredKey(key []byte, name, out string) error {
+	id := keyring.Fingerprint(key)
+
+	if out != "" {
+		if err := os.WriteFile(out, []byte(crypto.KeyToHex(key)+"\n"), 0600); err != nil {
+			return fmt.Errorf("failed to write key file: %w", err)
+		}
+		fmt.Printf(" Key %s written to %s (use it with --key-file)\n", id, out)
+		return nil
+	}
+
+	ring, err := openKeyring()
+	if err != nil {
+		return err
+	}
+	if err := unlockKeyring(ring); err != nil {
+		return err
+	}
+	if _, ok := ring.Lookup(id); ok {
+		fmt.Printf(" Key %s is already in the keyring\n", id)
+		return nil
+	}
+
+	if name == "" {
+		name = "recovered-" + id
+	}
+	entry, err := ring.Add(name, key)
+	if err != nil {
+		return err
+	}
+	fmt.Printf(" Recovered key %s (%s) into the keyring\n", entry.ID, entry.Name)
+	return nil
+}
+
+var stdin = bufio.NewReader(os.Stdin)
+
+func promptLine(prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	line, err := stdin.ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("failed to read input: %w", err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
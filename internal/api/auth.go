@@ -0,0 +1,268 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/stacksnap/stacksnap/internal/auth"
+)
+
+const sessionCookieName = "stacksnap_session"
+
+func (s *Server) originAllowed(r *http.Request, origin string) bool {
+	if u, err := url.Parse(origin); err == nil && u.Host == r.Host {
+		return true
+	}
+	if s.config == nil {
+		return false
+	}
+	for _, allowed := range s.config.CORSAllowedOrigins {
+		if allowed == "*" || strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) applyCORS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Vary", "Origin")
+
+	origin := r.Header.Get("Origin")
+	if origin == "" || !s.originAllowed(r, origin) {
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", origin)
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+}
+
+func (s *Server) principal(r *http.Request) (*auth.Principal, bool) {
+	if s.auth == nil {
+		return nil, false
+	}
+
+	bearer := ""
+	if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		bearer = strings.TrimSpace(strings.TrimPrefix(h, "Bearer "))
+	}
+
+	sessionID := ""
+	if c, err := r.Cookie(sessionCookieName); err == nil {
+		sessionID = c.Value
+	}
+
+	return s.auth.Authenticate(sessionID, bearer)
+}
+
+func (s *Server) authorize(read, write auth.Scope, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.auth == nil {
+			http.Error(w, "Authentication store unavailable", http.StatusInternalServerError)
+			return
+		}
+
+		p, ok := s.principal(r)
+		if !ok {
+			http.Error(w, "Authentication required", http.StatusUnauthorized)
+			return
+		}
+
+		safe := r.Method == http.MethodGet || r.Method == http.MethodHead
+		required := write
+		if safe {
+			required = read
+		}
+		if !p.Scope.Allows(required) {
+			http.Error(w, "Token scope "+string(p.Scope)+" does not allow this operation", http.StatusForbidden)
+			return
+		}
+
+		if p.Kind == "session" && !safe {
+			if origin := r.Header.Get("Origin"); origin != "" && !s.originAllowed(r, origin) {
+				http.Error(w, "Cross-origin request rejected", http.StatusForbidden)
+				return
+			}
+		}
+
+		h(w, r)
+	}
+}
+
+func (s *Server) setSessionCookie(w http.ResponseWriter, r *http.Request, id string, expires time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    id,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (s *Server) handleAuthStatus(w http.ResponseWriter, r *http.Request) {
+	resp := map[string]interface{}{
+		"admin_configured": s.auth != nil && s.auth.HasAdmin(),
+		"authenticated":    false,
+	}
+	if p, ok := s.principal(r); ok {
+		resp["authenticated"] = true
+		resp["principal"] = p
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (s *Server) handleAuthSetup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.auth == nil {
+		http.Error(w, "Authentication store unavailable", http.StatusInternalServerError)
+		return
+	}
+
+	var req struct {
+		SetupToken string `json:"setup_token"`
+		Username   string `json:"username"`
+		Password   string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.auth.CreateAdminWithToken(req.SetupToken, req.Username, req.Password); err != nil {
+		status := http.StatusBadRequest
+		switch {
+		case errors.Is(err, auth.ErrInvalidSetupToken):
+			status = http.StatusForbidden
+		case errors.Is(err, auth.ErrAdminExists):
+			status = http.StatusConflict
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	id, expires, err := s.auth.Login(strings.TrimSpace(req.Username), req.Password)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.setSessionCookie(w, r, id, expires)
+
+	s.track("admin_created", nil)
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.auth == nil {
+		http.Error(w, "Authentication store unavailable", http.StatusInternalServerError)
+		return
+	}
+
+	var req struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	id, expires, err := s.auth.Login(req.Username, req.Password)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	s.setSessionCookie(w, r, id, expires)
+
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+func (s *Server) handleLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if c, err := r.Cookie(sessionCookieName); err == nil && s.auth != nil {
+		s.auth.Logout(c.Value)
+	}
+	s.setSessionCookie(w, r, "", time.Unix(0, 0))
+
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+func (s *Server) handleTokens(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		json.NewEncoder(w).Encode(s.auth.Tokens())
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Name  string `json:"name"`
+		Scope string `json:"scope"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	scope, err := auth.ParseScope(req.Scope)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	secret, token, err := s.auth.CreateToken(req.Name, scope)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.track("api_token_created", map[string]interface{}{"scope": scope})
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"token":  secret,
+		"detail": token,
+	})
+}
+
+func (s *Server) handleRevokeToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.auth.RevokeToken(req.ID); err != nil {
+		if errors.Is(err, auth.ErrTokenNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stacksnap/stacksnap/internal/auth"
+	"github.com/stacksnap/stacksnap/internal/jobs"
+)
+
+func newAuthTestServer(t *testing.T) *Server {
+	t.Helper()
+	store, err := auth.Open(filepath.Join(t.TempDir(), "auth.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Server{auth: store, jobs: jobs.NewManager(), broker: NewEventBroker()}
+}
+
+func TestAuthSetupRequiresToken(t *testing.T) {
+	s := newAuthTestServer(t)
+	token := s.auth.SetupToken()
+
+	tests := []struct {
+		name  string
+		token string
+		want  int
+	}{
+		{"missing token", "", http.StatusForbidden},
+		{"wrong token", "0123456789abcdef", http.StatusForbidden},
+		{"setup token", token, http.StatusOK},
+		{"token already used", token, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := `{"setup_token":"` + tt.token + `","username":"admin","password":"correct horse"}`
+			w := httptest.NewRecorder()
+			s.handleAuthSetup(w, httptest.NewRequest(http.MethodPost, "/api/auth/setup", strings.NewReader(body)))
+			if w.Code != tt.want {
+				t.Fatalf("got status %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+}
+
+func TestJobCancelScope(t *testing.T) {
+	s := newAuthTestServer(t)
+	block := func(ctx context.Context) (interface{}, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	tests := []struct {
+		jobType string
+		scope   auth.Scope
+		want    int
+	}{
+		{"backup", auth.ScopeRead, http.StatusForbidden},
+		{"backup", auth.ScopeBackup, http.StatusAccepted},
+		{"restore", auth.ScopeBackup, http.StatusForbidden},
+		{"restore", auth.ScopeAdmin, http.StatusAccepted},
+		{"rotate", auth.ScopeBackup, http.StatusForbidden},
+		{"rotate", auth.ScopeAdmin, http.StatusAccepted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.jobType+"/"+string(tt.scope), func(t *testing.T) {
+			secret, _, err := s.auth.CreateToken("test", tt.scope)
+			if err != nil {
+				t.Fatal(err)
+			}
+			job := s.jobs.Submit(tt.jobType, "", block)
+			defer s.jobs.Cancel(job.ID)
+
+			r := httptest.NewRequest(http.MethodPost, "/api/jobs/"+job.ID+"/cancel", nil)
+			r.Header.Set("Authorization", "Bearer "+secret)
+			w := httptest.NewRecorder()
+			s.authorize(auth.ScopeRead, auth.ScopeBackup, s.handleJob)(w, r)
+			if w.Code != tt.want {
+				t.Fatalf("got status %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+}
+
+func TestPruneRequiresStack(t *testing.T) {
+	s := newAuthTestServer(t)
+	w := httptest.NewRecorder()
+	s.handlePrune(w, httptest.NewRequest(http.MethodPost, "/api/prune", strings.NewReader(`{"dry_run":false}`)))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("prune without a stack: got status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
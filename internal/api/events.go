@@ -0,0 +1,252 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stacksnap/stacksnap/internal/backup"
+	"github.com/stacksnap/stacksnap/internal/jobs"
+)
+
+const (
+	LevelInfo  = "info"
+	LevelWarn  = "warn"
+	LevelError = "error"
+)
+
+const (
+	eventHistorySize = 512
+	clientBufferSize = 64
+	keepAliveEvery   = 30 * time.Second
+)
+
+type Event struct {
+	ID             uint64    `json:"id"`
+	Time           time.Time `json:"time"`
+	JobID          string    `json:"job_id,omitempty"`
+	Stack          string    `json:"stack,omitempty"`
+	Phase          string    `json:"phase,omitempty"`
+	Level          string    `json:"level"`
+	Message        string    `json:"message,omitempty"`
+	BytesProcessed int64     `json:"bytes_processed,omitempty"`
+	BytesTotal     int64     `json:"bytes_total,omitempty"`
+}
+
+type eventClient struct {
+	job string
+	ch  chan Event
+}
+
+type EventBroker struct {
+	mu      sync.Mutex
+	nextID  uint64
+	history []Event
+	start   int
+	clients map[*eventClient]bool
+}
+
+func NewEventBroker() *EventBroker {
+	return &EventBroker{
+		history: make([]Event, 0, eventHistorySize),
+		clients: make(map[*eventClient]bool),
+	}
+}
+
+func (b *EventBroker) Publish(ev Event) Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.nextID++
+	ev.ID = b.nextID
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	if ev.Level == "" {
+		ev.Level = LevelInfo
+	}
+
+	if len(b.history) < eventHistorySize {
+		b.history = append(b.history, ev)
+	} else {
+		b.history[b.start] = ev
+		b.start = (b.start + 1) % eventHistorySize
+	}
+
+	for c := range b.clients {
+		if c.job != "" && c.job != ev.JobID {
+			continue
+		}
+		select {
+		case c.ch <- ev:
+		default:
+			delete(b.clients, c)
+			close(c.ch)
+		}
+	}
+	return ev
+}
+
+func (b *EventBroker) subscribe(job string, lastID uint64) (*eventClient, []Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var replay []Event
+	if lastID > 0 {
+		for i := 0; i < len(b.history); i++ {
+			ev := b.history[(b.start+i)%len(b.history)]
+			if ev.ID > lastID && (job == "" || ev.JobID == job) {
+				replay = append(replay, ev)
+			}
+		}
+	}
+
+	c := &eventClient{job: job, ch: make(chan Event, clientBufferSize)}
+	b.clients[c] = true
+	return c, replay
+}
+
+func (b *EventBroker) unsubscribe(c *eventClient) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.clients[c] {
+		delete(b.clients, c)
+		close(c.ch)
+	}
+}
+
+type jobEvents struct {
+	broker *EventBroker
+	jobID  string
+	stack  string
+	mu     sync.Mutex
+	phase  string
+}
+
+func (s *Server) newJobEvents(jobID, stack string) *jobEvents {
+	return &jobEvents{broker: s.broker, jobID: jobID, stack: stack}
+}
+
+func (e *jobEvents) publish(ev Event) {
+	ev.JobID = e.jobID
+	ev.Stack = e.stack
+	e.broker.Publish(ev)
+}
+
+func (e *jobEvents) currentPhase() string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.phase
+}
+
+func (e *jobEvents) Log(msg string) {
+	msg = strings.TrimSpace(msg)
+	if msg == "" {
+		return
+	}
+	level := LevelInfo
+	switch {
+	case strings.HasPrefix(msg, "Failed") || strings.Contains(msg, " failed:"):
+		level = LevelError
+	case strings.HasPrefix(msg, "Warning") || strings.Contains(msg, "Warning:"):
+		level = LevelWarn
+	}
+	e.publish(Event{Phase: e.currentPhase(), Level: level, Message: msg})
+}
+
+func (e *jobEvents) Progress(p backup.Progress) {
+	e.mu.Lock()
+	e.phase = p.Phase
+	e.mu.Unlock()
+	e.publish(Event{
+		Phase:          p.Phase,
+		Level:          LevelInfo,
+		BytesProcessed: p.BytesProcessed,
+		BytesTotal:     p.BytesTotal,
+	})
+}
+
+func (e *jobEvents) Complete(msg string) {
+	e.publish(Event{Phase: backup.PhaseComplete, Level: LevelInfo, Message: msg})
+}
+
+func (e *jobEvents) Fail(err error) {
+	e.publish(Event{Phase: backup.PhaseFailed, Level: LevelError, Message: err.Error()})
+}
+
+func lastEventID(r *http.Request) uint64 {
+	raw := r.Header.Get("Last-Event-ID")
+	if raw == "" {
+		raw = r.URL.Query().Get("last_event_id")
+	}
+	id, _ := strconv.ParseUint(raw, 10, 64)
+	return id
+}
+
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	job := r.URL.Query().Get("job")
+	if job != "" {
+		if _, found := s.jobs.Get(job); !found {
+			http.Error(w, jobs.ErrNotFound.Error(), http.StatusNotFound)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	client, replay := s.broker.subscribe(job, lastEventID(r))
+	defer s.broker.unsubscribe(client)
+
+	write := func(ev Event) bool {
+		data, err := json.Marshal(ev)
+		if err != nil {
+			return true
+		}
+		if _, err := fmt.Fprintf(w, "id: %d\ndata: %s\n\n", ev.ID, data); err != nil {
+			return false
+		}
+		return true
+	}
+
+	fmt.Fprint(w, "retry: 3000\n\n")
+	for _, ev := range replay {
+		if !write(ev) {
+			return
+		}
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(keepAliveEvery)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		case ev, open := <-client.ch:
+			if !open {
+				return
+			}
+			if !write(ev) {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package api
+
+import (
+	"testing"
+)
+
+func publishN(b *EventBroker, n int, job string) {
+	for i := 0; i < n; i++ {
+		b.Publish(Event{JobID: job, Message: "line"})
+	}
+}
+
+func TestEventReplay(t *testing.T) {
+	tests := []struct {
+		name      string
+		published int
+		job       string
+		lastID    uint64
+		wantFirst uint64
+		wantCount int
+	}{
+		{"no last id replays nothing", 10, "", 0, 0, 0},
+		{"events after the last id", 10, "", 7, 8, 3},
+		{"caught up", 10, "", 10, 0, 0},
+		{"wrapped buffer keeps the newest events", eventHistorySize + 100, "", 1, 101, eventHistorySize},
+		{"wrapped buffer after the last id", eventHistorySize + 100, "", eventHistorySize + 90, eventHistorySize + 91, 10},
+		{"filtered by job", 10, "other", 1, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewEventBroker()
+			publishN(b, tt.published, "job1")
+
+			c, replay := b.subscribe(tt.job, tt.lastID)
+			defer b.unsubscribe(c)
+
+			if len(replay) != tt.wantCount {
+				t.Fatalf("replayed %d events, want %d", len(replay), tt.wantCount)
+			}
+			for i, ev := range replay {
+				if want := tt.wantFirst + uint64(i); ev.ID != want {
+					t.Fatalf("event %d has id %d, want %d", i, ev.ID, want)
+				}
+			}
+		})
+	}
+}
+
+func TestEventJobFilter(t *testing.T) {
+	b := NewEventBroker()
+	all, _ := b.subscribe("", 0)
+	defer b.unsubscribe(all)
+	one, _ := b.subscribe("job1", 0)
+	defer b.unsubscribe(one)
+
+	b.Publish(Event{JobID: "job1", Message: "a"})
+	b.Publish(Event{JobID: "job2", Message: "b"})
+
+	if got := len(all.ch); got != 2 {
+		t.Fatalf("unfiltered client got %d events, want 2", got)
+	}
+	if got := len(one.ch); got != 1 {
+		t.Fatalf("filtered client got %d events, want 1", got)
+	}
+	if ev := <-one.ch; ev.JobID != "job1" {
+		t.Fatalf("filtered client got an event for %s", ev.JobID)
+	}
+}
+
+func TestSlowConsumerDisconnected(t *testing.T) {
+	b := NewEventBroker()
+	slow, _ := b.subscribe("", 0)
+	defer b.unsubscribe(slow)
+	fast, _ := b.subscribe("", 0)
+	defer b.unsubscribe(fast)
+
+	for i := 0; i <= clientBufferSize; i++ {
+		b.Publish(Event{Message: "line"})
+		<-fast.ch
+	}
+
+	received := 0
+	for range slow.ch {
+		received++
+	}
+	if received != clientBufferSize {
+		t.Fatalf("slow client got %d events before disconnect, want %d", received, clientBufferSize)
+	}
+
+	b.Publish(Event{Message: "after"})
+	if ev := <-fast.ch; ev.Message != "after" {
+		t.Fatalf("fast client got %q, want the next event", ev.Message)
+	}
+}
@@ -0,0 +1,412 @@
+package api
+
+import (
+	"context"
+	"crypto/ecdh"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/stacksnap/stacksnap/internal/backup"
+	"github.com/stacksnap/stacksnap/internal/config"
+	"github.com/stacksnap/stacksnap/internal/crypto"
+	"github.com/stacksnap/stacksnap/internal/jobs"
+	"github.com/stacksnap/stacksnap/internal/keyring"
+	"github.com/stacksnap/stacksnap/internal/signing"
+)
+
+const noEncryption = "none"
+
+func (s *Server) encryptionKey(id string) ([]byte, string, error) {
+	if id == noEncryption {
+		return nil, "", nil
+	}
+	if s.keyring == nil {
+		if id != "" {
+			return nil, "", fmt.Errorf("keyring unavailable")
+		}
+		return nil, "", nil
+	}
+
+	if id == "" {
+		if s.keyring.DefaultID() == "" {
+			return nil, "", nil
+		}
+		id, key, err := s.keyring.Default()
+		return key, id, err
+	}
+
+	key, err := s.keyring.Get(id)
+	if err != nil {
+		return nil, "", err
+	}
+	return key, keyring.Fingerprint(key), nil
+}
+
+func (s *Server) encryptionRecipients(id string) ([]*ecdh.PublicKey, error) {
+	if id != "" || s.config == nil || len(s.config.EncryptionRecipients) == 0 {
+		return nil, nil
+	}
+
+	recipients := make([]*ecdh.PublicKey, 0, len(s.config.EncryptionRecipients))
+	for _, r := range s.config.EncryptionRecipients {
+		pub, err := crypto.ParseRecipient(r)
+		if err != nil {
+			return nil, fmt.Errorf("invalid encryption recipient in config: %w", err)
+		}
+		recipients = append(recipients, pub)
+	}
+	return recipients, nil
+}
+
+func (s *Server) keyProvider() (crypto.KeyProvider, error) {
+	if s.config == nil || s.config.KMS == nil {
+		return nil, nil
+	}
+	kms, err := backup.NewKeyProvider(s.config.KMS)
+	if err != nil {
+		return nil, fmt.Errorf("invalid KMS configuration: %w", err)
+	}
+	return kms, nil
+}
+
+type backupKeys struct {
+	key        []byte
+	keyID      string
+	recipients []*ecdh.PublicKey
+	kms        crypto.KeyProvider
+}
+
+func (s *Server) backupEncryption(id string) (*backupKeys, error) {
+	recipients, err := s.encryptionRecipients(id)
+	if err != nil || recipients != nil {
+		return &backupKeys{recipients: recipients}, err
+	}
+	if id == "" {
+		kms, err := s.keyProvider()
+		if err != nil || kms != nil {
+			return &backupKeys{kms: kms}, err
+		}
+	}
+	key, keyID, err := s.encryptionKey(id)
+	return &backupKeys{key: key, keyID: keyID}, err
+}
+
+func keyErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, keyring.ErrLocked):
+		return http.StatusLocked
+	case errors.Is(err, keyring.ErrKeyNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, keyring.ErrWrongPassphrase):
+		return http.StatusUnauthorized
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+func (s *Server) handleKeys(w http.ResponseWriter, r *http.Request) {
+	if s.keyring == nil {
+		http.Error(w, "Keyring unavailable", http.StatusInternalServerError)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"initialized": s.keyring.Initialized(),
+			"locked":      s.keyring.Locked(),
+			"default":     s.keyring.DefaultID(),
+			"keys":        s.keyring.Keys(),
+		})
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	key, err := s.keyring.Generate(req.Name)
+	if err != nil {
+		http.Error(w, err.Error(), keyErrorStatus(err))
+		return
+	}
+
+	s.track("encryption_key_created", nil)
+	json.NewEncoder(w).Encode(key)
+}
+
+func (s *Server) handleUnlockKeys(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.keyring == nil {
+		http.Error(w, "Keyring unavailable", http.StatusInternalServerError)
+		return
+	}
+
+	var req struct {
+		Passphrase string `json:"passphrase"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.keyring.Unlock(req.Passphrase); err != nil {
+		http.Error(w, err.Error(), keyErrorStatus(err))
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"status": "unlocked"})
+}
+
+func (s *Server) handleDefaultKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.keyring == nil {
+		http.Error(w, "Keyring unavailable", http.StatusInternalServerError)
+		return
+	}
+
+	var req struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.keyring.SetDefault(req.ID); err != nil {
+		http.Error(w, err.Error(), keyErrorStatus(err))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) handleRecoveryKit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.keyring == nil {
+		http.Error(w, "Keyring unavailable", http.StatusInternalServerError)
+		return
+	}
+
+	var req struct {
+		KeyID      string `json:"key_id"`
+		Passphrase string `json:"passphrase"`
+		Hint       string `json:"hint"`
+		Format     string `json:"format"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	id := req.KeyID
+	if id == "" {
+		id = s.keyring.DefaultID()
+	}
+	if id == "" {
+		http.Error(w, keyring.ErrNoDefaultKey.Error(), http.StatusNotFound)
+		return
+	}
+	key, err := s.keyring.Get(id)
+	if err != nil {
+		http.Error(w, err.Error(), keyErrorStatus(err))
+		return
+	}
+
+	kit, err := crypto.CreateRecoveryKit(key, req.Passphrase, req.Hint)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	kit.KeyID = keyring.Fingerprint(key)
+
+	s.track("recovery_kit_exported", map[string]interface{}{"format": req.Format})
+
+	if req.Format == "text" {
+		text, err := kit.Text()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Content-Disposition", "attachment; filename=stacksnap-recovery-"+kit.KeyID+".txt")
+		w.Write([]byte(text))
+		return
+	}
+	json.NewEncoder(w).Encode(kit)
+}
+
+func (s *Server) handleRecoverKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.keyring == nil {
+		http.Error(w, "Keyring unavailable", http.StatusInternalServerError)
+		return
+	}
+
+	var req struct {
+		Kit        string `json:"kit"`
+		Passphrase string `json:"passphrase"`
+		Name       string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	kit, err := crypto.ParseRecoveryKit([]byte(req.Kit))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	key, err := crypto.RecoverKey(kit, req.Passphrase)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	id := keyring.Fingerprint(key)
+	if existing, ok := s.keyring.Lookup(id); ok {
+		json.NewEncoder(w).Encode(existing)
+		return
+	}
+
+	name := req.Name
+	if name == "" {
+		name = "recovered-" + id
+	}
+	entry, err := s.keyring.Add(name, key)
+	if err != nil {
+		http.Error(w, err.Error(), keyErrorStatus(err))
+		return
+	}
+
+	s.track("encryption_key_recovered", nil)
+	json.NewEncoder(w).Encode(entry)
+}
+
+func (s *Server) handleRotateKeys(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		state, err := backup.LoadRotationState(config.RotationStatePath())
+		if errors.Is(err, os.ErrNotExist) {
+			json.NewEncoder(w).Encode(map[string]interface{}{"in_progress": false})
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"in_progress": true,
+			"state":       state,
+		})
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.keyring == nil || s.provider == nil {
+		http.Error(w, "Keyring or storage unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	var req struct {
+		From   string `json:"from"`
+		To     string `json:"to"`
+		Name   string `json:"name"`
+		Stack  string `json:"stack"`
+		DryRun bool   `json:"dry_run"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	for _, j := range s.jobs.List() {
+		if j.Type == "rotate" && !j.State.Finished() {
+			http.Error(w, "A key rotation is already running", http.StatusConflict)
+			return
+		}
+	}
+
+	if s.keyring.Locked() {
+		http.Error(w, keyring.ErrLocked.Error(), http.StatusLocked)
+		return
+	}
+	if req.From == "" {
+		req.From = s.keyring.DefaultID()
+	}
+	if req.From == "" {
+		http.Error(w, "No source key given and the keyring has no default key", http.StatusBadRequest)
+		return
+	}
+	if req.To == "" {
+		if req.Name == "" || req.DryRun {
+			http.Error(w, "A target key (to) is required; name generates a new one outside dry runs", http.StatusBadRequest)
+			return
+		}
+		key, err := s.keyring.Generate(req.Name)
+		if err != nil {
+			http.Error(w, err.Error(), keyErrorStatus(err))
+			return
+		}
+		req.To = key.ID
+	}
+
+	signer, _ := signing.Load(config.SigningKeyPath())
+
+	job := s.jobs.Submit("rotate", "", func(ctx context.Context) (interface{}, error) {
+		events := s.newJobEvents(jobs.IDFromContext(ctx), "")
+		res, err := backup.RotateKeys(backup.RotateOptions{
+			FromKeyID:       req.From,
+			ToKeyID:         req.To,
+			StackName:       req.Stack,
+			StatePath:       config.RotationStatePath(),
+			DryRun:          req.DryRun,
+			StorageProvider: s.provider,
+			Keyring:         s.keyring,
+			Signer:          signer,
+			Context:         ctx,
+			Logger:          events.Log,
+		})
+		if err != nil {
+			events.Fail(err)
+			return res, err
+		}
+		if res.Failed > 0 {
+			err := fmt.Errorf("%d archives failed to rotate", res.Failed)
+			events.Fail(err)
+			return res, err
+		}
+		events.Complete("Key rotation completed")
+		return res, nil
+	})
+
+	s.track("key_rotation_started", map[string]interface{}{"dry_run": req.DryRun})
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{
+		"status": "rotation_started",
+		"job_id": job.ID,
+		"to":     req.To,
+	})
+}
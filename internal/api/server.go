@@ -3,20 +3,28 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/fs"
 	"net/http"
 	"os"
+	"path/filepath"
 	"sort"
 	"strings"
 	"time"
 
 	"github.com/posthog/posthog-go"
+	"github.com/stacksnap/stacksnap/internal/auth"
 	"github.com/stacksnap/stacksnap/internal/backup"
 	"github.com/stacksnap/stacksnap/internal/compose"
 	"github.com/stacksnap/stacksnap/internal/config"
 	"github.com/stacksnap/stacksnap/internal/docker"
+	"github.com/stacksnap/stacksnap/internal/jobs"
+	"github.com/stacksnap/stacksnap/internal/keyring"
 	"github.com/stacksnap/stacksnap/internal/license"
+	"github.com/stacksnap/stacksnap/internal/lock"
+	"github.com/stacksnap/stacksnap/internal/scheduler"
+	"github.com/stacksnap/stacksnap/internal/signing"
 	"github.com/stacksnap/stacksnap/internal/storage"
 )
 
@@ -31,6 +39,10 @@ type Server struct {
 	broker        *EventBroker
 	phClient      posthog.Client
 	machineID     string
+	scheduler     *scheduler.Scheduler
+	jobs          *jobs.Manager
+	auth          *auth.Store
+	keyring       *keyring.Keyring
 }
 
 func NewServer(provider storage.Provider, uiFS fs.FS) *Server {
@@ -53,21 +65,11 @@ func NewServer(provider storage.Provider, uiFS fs.FS) *Server {
 				setupRequired = true
 			} else {
 
-				ctx := context.Background()
-				if cfg.Storage.Type == config.StorageS3 {
-					p, err := storage.NewS3Provider(
-						ctx,
-						cfg.Storage.S3Bucket,
-						cfg.Storage.S3Region,
-						cfg.Storage.S3Endpoint,
-						cfg.Storage.S3AccessKey,
-						cfg.Storage.S3SecretKey,
-					)
-					if err == nil {
-						provider = p
-					} else {
-						fmt.Printf("Error initializing S3 provider: %v\n", err)
-					}
+				p, err := storage.NewProviderFromConfig(context.Background(), cfg.Storage)
+				if err == nil {
+					provider = p
+				} else {
+					fmt.Printf("Error initializing %s storage provider: %v\n", cfg.Storage.Type, err)
 				}
 			}
 
@@ -81,6 +83,30 @@ func NewServer(provider storage.Provider, uiFS fs.FS) *Server {
 		setupRequired: setupRequired,
 		uiFS:          uiFS,
 		broker:        NewEventBroker(),
+		jobs:          jobs.NewManager(),
+	}
+
+	authStore, err := auth.Open(config.AuthPath())
+	if err != nil {
+		fmt.Printf(" Failed to load authentication store: %v\n", err)
+	} else {
+		s.auth = authStore
+		if !authStore.HasAdmin() {
+			fmt.Println(" No admin account configured. Create one with 'stacksnap auth set-password',")
+			fmt.Printf(" or in the dashboard with the one-time setup token %s\n", authStore.SetupToken())
+		}
+	}
+
+	ring, err := keyring.Open(config.KeyringPath())
+	if err != nil {
+		fmt.Printf(" Failed to load keyring: %v\n", err)
+	} else {
+		s.keyring = ring
+		if unlocked, err := ring.UnlockFromEnv(); err != nil {
+			fmt.Printf(" Failed to unlock keyring: %v\n", err)
+		} else if !unlocked && len(ring.Keys()) > 0 {
+			fmt.Printf(" Keyring is locked. Set %s or unlock it from the dashboard to run encrypted backups.\n", keyring.PassphraseEnv)
+		}
 	}
 
 	if PostHogKey != "" {
@@ -97,6 +123,12 @@ func NewServer(provider storage.Provider, uiFS fs.FS) *Server {
 	hostname, _ := os.Hostname()
 	s.machineID = hostname
 
+	s.scheduler = scheduler.New(config.SchedulerStatePath(), s.runScheduledBackup)
+	if cfg != nil {
+		s.scheduler.SetSchedules(cfg.Schedules)
+	}
+	go s.scheduler.Start(context.Background())
+
 	s.track("server_started", map[string]interface{}{
 		"mode": func() string {
 			if setupRequired {
@@ -130,9 +162,7 @@ func (s *Server) track(event string, properties map[string]interface{}) {
 
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	s.applyCORS(w, r)
 
 	if r.Method == "OPTIONS" {
 		w.WriteHeader(http.StatusOK)
@@ -142,93 +172,46 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	s.mux.ServeHTTP(w, r)
 }
 
-type EventBroker struct {
-	clients    map[chan string]bool
-	newClients chan chan string
-	defunct    chan chan string
-	messages   chan string
-}
-
-func NewEventBroker() *EventBroker {
-	b := &EventBroker{
-		clients:    make(map[chan string]bool),
-		newClients: make(chan chan string),
-		defunct:    make(chan chan string),
-		messages:   make(chan string),
-	}
-	go b.listen()
-	return b
-}
-
-func (b *EventBroker) listen() {
-	for {
-		select {
-		case s := <-b.newClients:
-			b.clients[s] = true
-		case s := <-b.defunct:
-			delete(b.clients, s)
-			close(s)
-		case msg := <-b.messages:
-			for s := range b.clients {
-
-				select {
-				case s <- msg:
-				default:
-				}
-			}
-		}
-	}
-}
-
-func (b *EventBroker) Broadcast(msg string) {
-	b.messages <- msg
-}
-
-func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
-
-	w.Header().Set("Content-Type", "text/event-stream")
-	w.Header().Set("Cache-Control", "no-cache")
-	w.Header().Set("Connection", "keep-alive")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-
-	clientChan := make(chan string)
-	s.broker.newClients <- clientChan
-
-	defer func() {
-		s.broker.defunct <- clientChan
-	}()
-
-	notify := r.Context().Done()
-
-	for {
-		select {
-		case <-notify:
-			return
-		case msg := <-clientChan:
-			fmt.Fprintf(w, "data: %s\n\n", msg)
-			w.(http.Flusher).Flush()
-		}
-	}
-}
-
 func (s *Server) routes() {
 	s.mux.HandleFunc("/api/health", s.handleHealth)
-	s.mux.HandleFunc("/api/events", s.handleEvents)
-	s.mux.HandleFunc("/api/setup", s.handleSetup)
-
-	s.mux.HandleFunc("/api/stacks", s.handleListStacks)
-	s.mux.HandleFunc("/api/backups", s.handleBackups)
-	s.mux.HandleFunc("/api/stats", s.handleStats)
-	s.mux.HandleFunc("/api/history", s.handleHistory)
-	s.mux.HandleFunc("/api/restore", s.handleRestore)
-	s.mux.HandleFunc("/api/logs", s.handleLogs)
-	s.mux.HandleFunc("/api/config", s.handleConfig)
-	s.mux.HandleFunc("/api/test-storage", s.handleTestStorage)
-	s.mux.HandleFunc("/api/verify", s.handleVerify)
-	s.mux.HandleFunc("/api/history/peek", s.handleHistoryPeek)
-	s.mux.HandleFunc("/api/stacks/add", s.handleAddStack)
-	s.mux.HandleFunc("/api/stacks/remove", s.handleRemoveStack)
-	s.mux.HandleFunc("/api/system-health", s.handleSystemHealth)
+	s.mux.HandleFunc("/api/auth/status", s.handleAuthStatus)
+	s.mux.HandleFunc("/api/auth/setup", s.handleAuthSetup)
+	s.mux.HandleFunc("/api/auth/login", s.handleLogin)
+	s.mux.HandleFunc("/api/auth/logout", s.handleLogout)
+	s.mux.HandleFunc("/api/auth/tokens", s.authorize(auth.ScopeAdmin, auth.ScopeAdmin, s.handleTokens))
+	s.mux.HandleFunc("/api/auth/tokens/revoke", s.authorize(auth.ScopeAdmin, auth.ScopeAdmin, s.handleRevokeToken))
+
+	s.mux.HandleFunc("/api/events", s.authorize(auth.ScopeRead, auth.ScopeRead, s.handleEvents))
+	s.mux.HandleFunc("/api/setup", s.authorize(auth.ScopeAdmin, auth.ScopeAdmin, s.handleSetup))
+
+	s.mux.HandleFunc("/api/stacks", s.authorize(auth.ScopeRead, auth.ScopeRead, s.handleListStacks))
+	s.mux.HandleFunc("/api/backups", s.authorize(auth.ScopeBackup, auth.ScopeBackup, s.handleBackups))
+	s.mux.HandleFunc("/api/stats", s.authorize(auth.ScopeRead, auth.ScopeRead, s.handleStats))
+	s.mux.HandleFunc("/api/history", s.authorize(auth.ScopeRead, auth.ScopeRead, s.handleHistory))
+	s.mux.HandleFunc("/api/restore", s.authorize(auth.ScopeAdmin, auth.ScopeAdmin, s.handleRestore))
+	s.mux.HandleFunc("/api/logs", s.authorize(auth.ScopeRead, auth.ScopeRead, s.handleLogs))
+	s.mux.HandleFunc("/api/config", s.authorize(auth.ScopeAdmin, auth.ScopeAdmin, s.handleConfig))
+	s.mux.HandleFunc("/api/test-storage", s.authorize(auth.ScopeBackup, auth.ScopeBackup, s.handleTestStorage))
+	s.mux.HandleFunc("/api/verify", s.authorize(auth.ScopeBackup, auth.ScopeBackup, s.handleVerify))
+	s.mux.HandleFunc("/api/history/peek", s.authorize(auth.ScopeRead, auth.ScopeRead, s.handleHistoryPeek))
+	s.mux.HandleFunc("/api/stacks/add", s.authorize(auth.ScopeAdmin, auth.ScopeAdmin, s.handleAddStack))
+	s.mux.HandleFunc("/api/stacks/remove", s.authorize(auth.ScopeAdmin, auth.ScopeAdmin, s.handleRemoveStack))
+	s.mux.HandleFunc("/api/system-health", s.authorize(auth.ScopeRead, auth.ScopeRead, s.handleSystemHealth))
+	s.mux.HandleFunc("/api/prune", s.authorize(auth.ScopeAdmin, auth.ScopeAdmin, s.handlePrune))
+	s.mux.HandleFunc("/api/schedules", s.authorize(auth.ScopeRead, auth.ScopeAdmin, s.handleSchedules))
+	s.mux.HandleFunc("/api/schedules/remove", s.authorize(auth.ScopeAdmin, auth.ScopeAdmin, s.handleRemoveSchedule))
+	s.mux.HandleFunc("/api/keys", s.authorize(auth.ScopeRead, auth.ScopeAdmin, s.handleKeys))
+	s.mux.HandleFunc("/api/keys/unlock", s.authorize(auth.ScopeAdmin, auth.ScopeAdmin, s.handleUnlockKeys))
+	s.mux.HandleFunc("/api/keys/default", s.authorize(auth.ScopeAdmin, auth.ScopeAdmin, s.handleDefaultKey))
+	s.mux.HandleFunc("/api/keys/rotate", s.authorize(auth.ScopeAdmin, auth.ScopeAdmin, s.handleRotateKeys))
+	s.mux.HandleFunc("/api/keys/recovery-kit", s.authorize(auth.ScopeAdmin, auth.ScopeAdmin, s.handleRecoveryKit))
+	s.mux.HandleFunc("/api/keys/recover", s.authorize(auth.ScopeAdmin, auth.ScopeAdmin, s.handleRecoverKey))
+	s.mux.HandleFunc("/api/signing-key", s.authorize(auth.ScopeRead, auth.ScopeRead, s.handleSigningKey))
+	s.mux.HandleFunc("/api/jobs", s.authorize(auth.ScopeRead, auth.ScopeBackup, s.handleListJobs))
+	s.mux.HandleFunc("/api/jobs/", s.authorize(auth.ScopeRead, auth.ScopeBackup, s.handleJob))
+	s.mux.HandleFunc("/api/", func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	})
 
 	if s.uiFS != nil {
 		fileServer := http.FileServer(http.FS(s.uiFS))
@@ -303,25 +286,16 @@ func (s *Server) handleSetup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ctx := context.Background()
-	if req.Storage.Type == config.StorageS3 {
-		p, err := storage.NewS3Provider(
-			ctx,
-			req.Storage.S3Bucket,
-			req.Storage.S3Region,
-			req.Storage.S3Endpoint,
-			req.Storage.S3AccessKey,
-			req.Storage.S3SecretKey,
-		)
-		if err != nil {
-			http.Error(w, "Failed to initialize storage provider: "+err.Error(), http.StatusBadRequest)
-			return
-		}
-		s.provider = p
+	p, err := storage.NewProviderFromConfig(context.Background(), req.Storage)
+	if err != nil {
+		http.Error(w, "Failed to initialize storage provider: "+err.Error(), http.StatusBadRequest)
+		return
 	}
+	s.provider = p
 
 	s.config = &req
 	s.setupRequired = false
+	s.scheduler.SetSchedules(req.Schedules)
 
 	json.NewEncoder(w).Encode(map[string]string{
 		"status":  "setup_complete",
@@ -502,15 +476,7 @@ func (s *Server) handleBackups(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var req struct {
-		Location        string `json:"location"`
-		ProjectName     string `json:"project_name"`
-		Pause           bool   `json:"pause"`
-		IncludeDB       bool   `json:"include_db"`
-		Verify          bool   `json:"verify"`
-		SnapshotImages  bool   `json:"snapshot_images"`
-		EncryptionKeyID string `json:"encryption_key_id"`
-	}
+	var req backupRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
@@ -521,88 +487,221 @@ func (s *Server) handleBackups(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var key []byte
+	if err := s.checkStackAvailable(req.stackName(), req.Queue); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
 
-	s.track("backup_initiated", map[string]interface{}{
-		"project":         req.ProjectName,
-		"snapshot_images": req.SnapshotImages,
-		"verify":          req.Verify,
+	if _, err := s.backupEncryption(req.EncryptionKeyID); err != nil {
+		http.Error(w, err.Error(), keyErrorStatus(err))
+		return
+	}
+
+	if _, err := backup.ParseBackupMode(req.Mode); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if _, err := backup.ParseCompression(req.Compression); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	job := s.submitBackup(req)
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":  "backup_started",
+		"message": "Backup job has been queued",
+		"job_id":  job.ID,
 	})
+}
 
-	go func() {
-		dockerClient, err := docker.NewClient()
+type backupRequest struct {
+	Location        string `json:"location"`
+	ProjectName     string `json:"project_name"`
+	Pause           bool   `json:"pause"`
+	IncludeDB       bool   `json:"include_db"`
+	Verify          bool   `json:"verify"`
+	SnapshotImages  bool   `json:"snapshot_images"`
+	EncryptionKeyID string `json:"encryption_key_id"`
+	Mode            string `json:"mode"`
+	Compression     string `json:"compression"`
+	Queue           bool   `json:"queue"`
+}
+
+func (r backupRequest) stackName() string {
+	if r.ProjectName != "" {
+		return r.ProjectName
+	}
+	if stack, err := compose.DiscoverStack(r.Location); err == nil {
+		return stack.Name
+	}
+	return filepath.Base(r.Location)
+}
+
+func (s *Server) checkStackAvailable(stack string, queue bool) error {
+	if queue || stack == "" {
+		return nil
+	}
+	if s.jobs.Busy(stack) {
+		return fmt.Errorf("%w: another operation for stack %s is already running or queued", lock.ErrLocked, stack)
+	}
+	if holder, held := lock.Default().Holder(stack); held {
+		return &lock.LockedError{Holder: holder}
+	}
+	return nil
+}
+
+func (s *Server) submitBackup(req backupRequest) jobs.Job {
+	stack := req.stackName()
+	return s.jobs.Submit("backup", stack, func(ctx context.Context) (interface{}, error) {
+		res, err := s.runBackup(ctx, req)
 		if err != nil {
-			fmt.Printf("Error creating docker client: %v\n", err)
-			s.broker.Broadcast("ERROR: Internal Docker client error")
-			return
+			return nil, err
 		}
-		defer dockerClient.Close()
+		return res, nil
+	})
+}
 
-		logFunc := func(msg string) {
+func (s *Server) runBackup(ctx context.Context, req backupRequest) (*backup.StackBackupResult, error) {
+	events := s.newJobEvents(jobs.IDFromContext(ctx), req.stackName())
 
-			cleanMsg := strings.TrimSpace(msg)
-			if cleanMsg != "" {
-				s.broker.Broadcast(fmt.Sprintf("[%s] INFO: %s", time.Now().Format("15:04:05"), cleanMsg))
-			}
-		}
+	keys, err := s.backupEncryption(req.EncryptionKeyID)
+	if err != nil {
+		events.Fail(err)
+		return nil, err
+	}
+	mode, err := backup.ParseBackupMode(req.Mode)
+	if err != nil {
+		events.Fail(err)
+		return nil, err
+	}
+	compression, err := backup.ParseCompression(req.Compression)
+	if err != nil {
+		events.Fail(err)
+		return nil, err
+	}
+	signer, err := s.backupSigner()
+	if err != nil {
+		err = fmt.Errorf("failed to load signing key: %w", err)
+		events.Fail(err)
+		return nil, err
+	}
 
-		if req.ProjectName != "" {
-			logFunc(fmt.Sprintf("Starting backup for project: %s", req.ProjectName))
-		} else {
-			logFunc(fmt.Sprintf("Starting backup for location: %s", req.Location))
-		}
+	s.track("backup_initiated", map[string]interface{}{
+		"project":         req.ProjectName,
+		"snapshot_images": req.SnapshotImages,
+		"verify":          req.Verify,
+	})
 
-		res, err := backup.BackupStack(dockerClient, backup.StackBackupOptions{
-			Directory:       req.Location,
-			ProjectName:     req.ProjectName,
-			PauseContainers: req.Pause,
-			IncludeDatabase: req.IncludeDB,
-			SnapshotImages:  req.SnapshotImages,
-			StorageProvider: s.provider,
-			EncryptionKey:   key,
-			Logger:          logFunc,
-		})
+	dockerClient, err := docker.NewClient()
+	if err != nil {
+		fmt.Printf("Error creating docker client: %v\n", err)
+		events.Fail(fmt.Errorf("internal Docker client error"))
+		return nil, err
+	}
+	defer dockerClient.Close()
 
-		if err != nil {
-			fmt.Printf("Backup failed: %v\n", err)
-			logFunc(fmt.Sprintf(" Backup failed: %v", err))
-			s.broker.Broadcast("ERROR: " + err.Error())
-			s.track("backup_failed", map[string]interface{}{
-				"project": req.ProjectName,
-				"error":   err.Error(),
-			})
-			return
-		}
+	logFunc := events.Log
+
+	if req.ProjectName != "" {
+		logFunc(fmt.Sprintf("Starting backup for project: %s", req.ProjectName))
+	} else {
+		logFunc(fmt.Sprintf("Starting backup for location: %s", req.Location))
+	}
+
+	res, err := backup.BackupStack(dockerClient, backup.StackBackupOptions{
+		Directory:       req.Location,
+		ProjectName:     req.ProjectName,
+		PauseContainers: req.Pause,
+		IncludeDatabase: req.IncludeDB,
+		SnapshotImages:  req.SnapshotImages,
+		Mode:            mode,
+		Parallel:        backup.DefaultParallelConfig(),
+		Compression:     compression,
+		StorageProvider: s.provider,
+		EncryptionKey:   keys.key,
+		EncryptionKeyID: keys.keyID,
+		Recipients:      keys.recipients,
+		KMS:             keys.kms,
+		Signer:          signer,
+		WaitForLock:     true,
+		Logger:          logFunc,
+		Progress:        events.Progress,
+		Context:         ctx,
+	})
 
-		logFunc(" Backup completed successfully")
-		s.track("backup_completed", map[string]interface{}{
+	if err != nil {
+		fmt.Printf("Backup failed: %v\n", err)
+		logFunc(fmt.Sprintf(" Backup failed: %v", err))
+		events.Fail(err)
+		s.track("backup_failed", map[string]interface{}{
 			"project": req.ProjectName,
-			"size":    res.Size,
+			"error":   err.Error(),
 		})
+		return nil, err
+	}
 
-		if req.Verify {
-			logFunc(" Auto-verifying backup integrity...")
-			vRes, vErr := backup.VerifyBackup(context.Background(), dockerClient, s.provider, res.OutputPath)
-			if vErr != nil {
-				logFunc(fmt.Sprintf(" Verification failed: %v", vErr))
+	logFunc(" Backup completed successfully")
+	s.track("backup_completed", map[string]interface{}{
+		"project": req.ProjectName,
+		"size":    res.Size,
+	})
 
-			} else {
-				logFunc(fmt.Sprintf(" Verified (Checksum: %s)", "OK"))
+	if req.Verify {
+		logFunc(" Auto-verifying backup integrity...")
+		vRes, vErr := backup.VerifyBackup(ctx, dockerClient, s.provider, res.OutputPath, backup.DecryptionKeys{
+			Key:     keys.key,
+			Keyring: s.keyring,
+			KMS:     keys.kms,
+		})
+		if vErr != nil {
+			logFunc(fmt.Sprintf(" Verification failed: %v", vErr))
 
-				verfMap := s.loadVerifications()
-				verfMap[res.OutputPath] = vRes
-				s.saveVerifications(verfMap)
+		} else {
+			if vRes.Verified {
+				logFunc(fmt.Sprintf(" Verified (Checksum: %s)", "OK"))
+			} else {
+				logFunc(fmt.Sprintf(" Verification failed (%s): %s", vRes.Outcome, vRes.ErrorMessage))
 			}
+
+			verfMap := s.loadVerifications()
+			verfMap[res.OutputPath] = vRes
+			s.saveVerifications(verfMap)
 		}
+	}
 
-		s.broker.Broadcast("COMPLETE")
-	}()
+	events.Complete("Backup completed")
+	return res, nil
+}
 
-	w.WriteHeader(http.StatusAccepted)
-	json.NewEncoder(w).Encode(map[string]string{
-		"status":  "backup_started",
-		"message": "Backup job has been queued",
+func (s *Server) runScheduledBackup(ctx context.Context, sc config.ScheduleConfig) error {
+	if s.setupRequired {
+		return fmt.Errorf("setup required")
+	}
+
+	job := s.submitBackup(backupRequest{
+		Location:        sc.Location,
+		ProjectName:     sc.Stack,
+		Pause:           sc.Pause,
+		IncludeDB:       sc.IncludeDB,
+		SnapshotImages:  sc.SnapshotImages,
+		Verify:          sc.Verify,
+		EncryptionKeyID: sc.EncryptionKeyID,
+		Mode:            sc.Mode,
+		Compression:     sc.Compression,
+		Queue:           true,
 	})
+
+	job, err := s.jobs.Wait(ctx, job.ID)
+	if err != nil {
+		return err
+	}
+	if job.State != jobs.StateSucceeded {
+		return fmt.Errorf("backup %s: %s", job.State, job.Error)
+	}
+	return nil
 }
 
 func (s *Server) handleRestore(w http.ResponseWriter, r *http.Request) {
@@ -611,72 +710,116 @@ func (s *Server) handleRestore(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var req struct {
-		Filename    string `json:"filename"`
-		ProjectName string `json:"project_name"`
-	}
+	var req restoreRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 
-	ctx := context.Background()
+	stack := req.ProjectName
+	if stack == "" {
+		stack, _, _ = backup.ParseBackupKey(req.Filename)
+	}
+	if err := s.checkStackAvailable(stack, req.Queue); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
 
-	go func() {
-		dockerClient, err := docker.NewClient()
-		if err != nil {
-			fmt.Printf("Error creating docker client: %v\n", err)
-			return
-		}
-		defer dockerClient.Close()
+	job := s.jobs.Submit("restore", stack, func(ctx context.Context) (interface{}, error) {
+		return nil, s.runRestore(ctx, req)
+	})
 
-		go func() {
-			logFunc := func(msg string) {
-				cleanMsg := strings.TrimSpace(msg)
-				if cleanMsg != "" {
-					s.broker.Broadcast(fmt.Sprintf("[%s] INFO: %s", time.Now().Format("15:04:05"), cleanMsg))
-				}
-			}
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{
+		"status": "restore_started",
+		"job_id": job.ID,
+	})
+}
 
-			logFunc(fmt.Sprintf("Starting restore for %s...", req.Filename))
-			s.track("restore_initiated", map[string]interface{}{
-				"project": req.ProjectName,
-			})
+type restoreRequest struct {
+	Filename        string `json:"filename"`
+	ProjectName     string `json:"project_name"`
+	EncryptionKeyID string `json:"encryption_key_id"`
+	Queue           bool   `json:"queue"`
+}
 
-			var key string
-			var keyBytes []byte
-			if key != "" {
-				keyBytes = []byte(key)
-			}
+func (s *Server) runRestore(ctx context.Context, req restoreRequest) error {
+	stack := req.ProjectName
+	if stack == "" {
+		stack, _, _ = backup.ParseBackupKey(req.Filename)
+	}
+	events := s.newJobEvents(jobs.IDFromContext(ctx), stack)
 
-			err := backup.RestoreStack(dockerClient, backup.StackRestoreOptions{
-				StackName:       req.ProjectName,
-				InputPath:       req.Filename,
-				StorageProvider: s.provider,
-				EncryptionKey:   keyBytes,
-				Logger:          logFunc,
-				Context:         ctx,
-			})
-			if err != nil {
-				fmt.Printf(" Restore failed: %v\n", err)
-				logFunc(fmt.Sprintf(" Restore failed: %v", err))
-				s.broker.Broadcast("ERROR: " + err.Error())
-				s.track("restore_failed", map[string]interface{}{
-					"project": req.ProjectName,
-					"error":   err.Error(),
-				})
-			} else {
-				logFunc(" Restore completed successfully")
-				s.track("restore_completed", map[string]interface{}{
-					"project": req.ProjectName,
-				})
-				s.broker.Broadcast("COMPLETE")
-			}
-		}()
-	}()
+	dockerClient, err := docker.NewClient()
+	if err != nil {
+		fmt.Printf("Error creating docker client: %v\n", err)
+		events.Fail(fmt.Errorf("internal Docker client error"))
+		return err
+	}
+	defer dockerClient.Close()
 
-	w.WriteHeader(http.StatusAccepted)
-	json.NewEncoder(w).Encode(map[string]string{"status": "restore_started"})
+	logFunc := events.Log
+
+	logFunc(fmt.Sprintf("Starting restore for %s...", req.Filename))
+	s.track("restore_initiated", map[string]interface{}{
+		"project": req.ProjectName,
+	})
+
+	var keyBytes []byte
+	if req.EncryptionKeyID != "" {
+		if s.keyring == nil {
+			err := fmt.Errorf("keyring unavailable")
+			events.Fail(err)
+			return err
+		}
+		keyBytes, err = s.keyring.Get(req.EncryptionKeyID)
+		if err != nil {
+			events.Fail(err)
+			return err
+		}
+	}
+
+	policy, err := s.signaturePolicy()
+	if err != nil {
+		events.Fail(err)
+		return err
+	}
+	kms, err := s.keyProvider()
+	if err != nil {
+		events.Fail(err)
+		return err
+	}
+
+	err = backup.RestoreStack(dockerClient, backup.StackRestoreOptions{
+		StackName:       req.ProjectName,
+		InputPath:       req.Filename,
+		StorageProvider: s.provider,
+		EncryptionKey:   keyBytes,
+		Keyring:         s.keyring,
+		KMS:             kms,
+		Signatures:      policy,
+		WaitForLock:     true,
+		Logger:          logFunc,
+		Progress:        events.Progress,
+		Context:         ctx,
+	})
+	if err != nil {
+		fmt.Printf(" Restore failed: %v\n", err)
+		logFunc(fmt.Sprintf(" Restore failed: %v", err))
+		events.Fail(err)
+		s.track("restore_failed", map[string]interface{}{
+			"project": req.ProjectName,
+			"error":   err.Error(),
+		})
+		return err
+	}
+
+	logFunc(" Restore completed successfully")
+	s.track("restore_completed", map[string]interface{}{
+		"project": req.ProjectName,
+	})
+	events.Complete("Restore completed")
+	return nil
 }
 
 func (s *Server) handleHistory(w http.ResponseWriter, r *http.Request) {
@@ -688,18 +831,6 @@ func (s *Server) handleHistory(w http.ResponseWriter, r *http.Request) {
 
 	if s.provider != nil {
 		items, err = s.provider.List(ctx, prefix)
-	} else {
-
-		all := listLocalBackups()
-		if prefix == "" {
-			items = all
-		} else {
-			for _, i := range all {
-				if strings.HasPrefix(i.Key, prefix) {
-					items = append(items, i)
-				}
-			}
-		}
 	}
 
 	if err != nil {
@@ -715,14 +846,26 @@ func (s *Server) handleHistory(w http.ResponseWriter, r *http.Request) {
 	type HistoryResponseItem struct {
 		storage.BackupItem
 		Verification *backup.VerificationResult `json:"verification,omitempty"`
+		Signed       bool                       `json:"signed"`
 	}
 
-	resp := make([]HistoryResponseItem, len(items))
-	for i, item := range items {
-		resp[i] = HistoryResponseItem{
+	signed := make(map[string]bool)
+	for _, item := range items {
+		if strings.HasSuffix(item.Key, signing.SignatureSuffix) {
+			signed[strings.TrimSuffix(item.Key, signing.SignatureSuffix)] = true
+		}
+	}
+
+	resp := make([]HistoryResponseItem, 0, len(items))
+	for _, item := range items {
+		if strings.HasSuffix(item.Key, signing.SignatureSuffix) {
+			continue
+		}
+		resp = append(resp, HistoryResponseItem{
 			BackupItem:   item,
 			Verification: verfMap[item.Key],
-		}
+			Signed:       signed[item.Key],
+		})
 	}
 
 	json.NewEncoder(w).Encode(resp)
@@ -749,13 +892,27 @@ func (s *Server) handleVerify(w http.ResponseWriter, r *http.Request) {
 	}
 	defer client.Close()
 
+	kms, err := s.keyProvider()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	ctx := context.Background()
-	result, err := backup.VerifyBackup(ctx, client, s.provider, req.Key)
+	result, err := backup.VerifyBackup(ctx, client, s.provider, req.Key, backup.DecryptionKeys{
+		Keyring: s.keyring,
+		KMS:     kms,
+	})
+	if errors.Is(err, lock.ErrLocked) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
 	if err != nil {
 
 		result = &backup.VerificationResult{
 			BackupKey:    req.Key,
 			Verified:     false,
+			Outcome:      backup.OutcomeFailed,
 			TestedAt:     time.Now(),
 			ErrorMessage: err.Error(),
 		}
@@ -769,17 +926,73 @@ func (s *Server) handleVerify(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) loadVerifications() map[string]*backup.VerificationResult {
-	res := make(map[string]*backup.VerificationResult)
-	data, err := os.ReadFile(config.VerificationsPath())
-	if err == nil {
-		json.Unmarshal(data, &res)
-	}
-	return res
+	return backup.LoadVerifications(config.VerificationsPath())
 }
 
 func (s *Server) saveVerifications(m map[string]*backup.VerificationResult) {
-	data, _ := json.Marshal(m)
-	os.WriteFile(config.VerificationsPath(), data, 0644)
+	backup.SaveVerifications(config.VerificationsPath(), m)
+}
+
+func (s *Server) handlePrune(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		StackName string                  `json:"stack_name"`
+		DryRun    *bool                   `json:"dry_run"`
+		Policy    *config.RetentionPolicy `json:"policy"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.StackName == "" {
+		http.Error(w, "stack_name is required", http.StatusBadRequest)
+		return
+	}
+
+	dryRun := true
+	if req.DryRun != nil {
+		dryRun = *req.DryRun
+	}
+
+	policy := s.config.RetentionFor
+	if req.Policy != nil {
+		override := *req.Policy
+		policy = func(string) (config.RetentionPolicy, bool) {
+			return override, true
+		}
+	}
+
+	verfMap := s.loadVerifications()
+	result, err := backup.Prune(backup.PruneOptions{
+		StackName:       req.StackName,
+		Policy:          policy,
+		DryRun:          dryRun,
+		StorageProvider: s.provider,
+		Verifications:   verfMap,
+		Context:         r.Context(),
+	})
+	if err != nil {
+		http.Error(w, "Prune failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if len(result.Deleted) > 0 {
+		for _, key := range result.Deleted {
+			delete(verfMap, key)
+		}
+		s.saveVerifications(verfMap)
+
+		s.track("backups_pruned", map[string]interface{}{
+			"count": len(result.Deleted),
+		})
+	}
+
+	json.NewEncoder(w).Encode(result)
 }
 
 func (s *Server) handleVerificationsLoad() map[string]*backup.VerificationResult {
@@ -793,9 +1006,17 @@ func (s *Server) handleHistoryPeek(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	kms, err := s.keyProvider()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	files, err := backup.PeekBackup(backup.StackRestoreOptions{
 		InputPath:       key,
 		StorageProvider: s.provider,
+		Keyring:         s.keyring,
+		KMS:             kms,
 		Context:         context.Background(),
 	})
 	if err != nil {
@@ -813,14 +1034,15 @@ func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
 
 	if s.provider != nil {
 		items, _ = s.provider.List(ctx, "")
-	} else {
-		items = listLocalBackups()
 	}
 
-	totalBackups := len(items)
+	totalBackups := 0
 	var totalSize int64
 	for _, item := range items {
 		totalSize += item.Size
+		if !strings.HasSuffix(item.Key, signing.SignatureSuffix) {
+			totalBackups++
+		}
 	}
 
 	var sizeStr string
@@ -857,30 +1079,9 @@ func (s *Server) handleTestStorage(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{"status": "ok", "message": "Connection to S3 bucket successful!"})
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok", "message": "Connection to storage successful!"})
 }
 
-func listLocalBackups() []storage.BackupItem {
-	cwd, _ := os.Getwd()
-	entries, _ := os.ReadDir(cwd)
-	var items []storage.BackupItem
-
-	for _, entry := range entries {
-		if !entry.IsDir() {
-			name := entry.Name()
-			if (len(name) > 7 && name[len(name)-7:] == ".tar.gz") ||
-				(len(name) > 4 && name[len(name)-4:] == ".enc") {
-				info, _ := entry.Info()
-				items = append(items, storage.BackupItem{
-					Key:          name,
-					Size:         info.Size(),
-					LastModified: info.ModTime(),
-				})
-			}
-		}
-	}
-	return items
-}
 func (s *Server) handleConfig(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet {
 		s.handleGetConfig(w, r)
@@ -925,13 +1126,42 @@ func (s *Server) handleUpdateConfig(w http.ResponseWriter, r *http.Request) {
 	if req.Storage.S3SecretKey == "********" && current != nil {
 		req.Storage.S3SecretKey = current.Storage.S3SecretKey
 	}
+	if req.Schedules == nil && current != nil {
+		req.Schedules = current.Schedules
+	}
+	if req.Retention == nil && current != nil {
+		req.Retention = current.Retention
+	}
+	if req.CORSAllowedOrigins == nil && current != nil {
+		req.CORSAllowedOrigins = current.CORSAllowedOrigins
+	}
+	if req.EncryptionRecipients == nil && current != nil {
+		req.EncryptionRecipients = current.EncryptionRecipients
+	}
+	if req.TrustedSigningKeys == nil && current != nil {
+		req.TrustedSigningKeys = current.TrustedSigningKeys
+	}
+	if req.KMS == nil && current != nil {
+		req.KMS = current.KMS
+	}
+	if _, err := signing.ParsePublicKeys(req.TrustedSigningKeys); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	if err := config.Save(&req); err != nil {
 		http.Error(w, "Failed to save config", http.StatusInternalServerError)
 		return
 	}
 
+	if p, err := storage.NewProviderFromConfig(context.Background(), req.Storage); err == nil {
+		s.provider = p
+	} else {
+		fmt.Printf("Error initializing %s storage provider: %v\n", req.Storage.Type, err)
+	}
+
 	s.config = &req
+	s.scheduler.SetSchedules(req.Schedules)
 
 	json.NewEncoder(w).Encode(map[string]string{"status": "ok", "message": "Configuration updated"})
 }
@@ -1055,3 +1285,181 @@ func (s *Server) handleRemoveStack(w http.ResponseWriter, r *http.Request) {
 	s.config = cfg
 	w.WriteHeader(http.StatusOK)
 }
+
+func (s *Server) handleSchedules(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		json.NewEncoder(w).Encode(s.scheduler.Status())
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req config.ScheduleConfig
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	req.Stack = strings.TrimSpace(req.Stack)
+	if req.Stack == "" {
+		http.Error(w, "stack is required", http.StatusBadRequest)
+		return
+	}
+	if _, err := scheduler.ParseCron(req.Cron); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	cfg, _ := config.Load()
+	if cfg == nil {
+		http.Error(w, "Config not found", http.StatusInternalServerError)
+		return
+	}
+
+	replaced := false
+	for i, sc := range cfg.Schedules {
+		if sc.Stack == req.Stack {
+			cfg.Schedules[i] = req
+			replaced = true
+		}
+	}
+	if !replaced {
+		cfg.Schedules = append(cfg.Schedules, req)
+	}
+
+	if err := config.Save(cfg); err != nil {
+		http.Error(w, "Failed to save config", http.StatusInternalServerError)
+		return
+	}
+
+	s.config = cfg
+	s.scheduler.SetSchedules(cfg.Schedules)
+	s.track("schedule_saved", map[string]interface{}{
+		"project": req.Stack,
+		"cron":    req.Cron,
+	})
+
+	json.NewEncoder(w).Encode(s.scheduler.Status())
+}
+
+func (s *Server) handleRemoveSchedule(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Stack string `json:"stack"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	cfg, _ := config.Load()
+	if cfg == nil {
+		http.Error(w, "Config not found", http.StatusInternalServerError)
+		return
+	}
+
+	var schedules []config.ScheduleConfig
+	for _, sc := range cfg.Schedules {
+		if sc.Stack != req.Stack {
+			schedules = append(schedules, sc)
+		}
+	}
+
+	cfg.Schedules = schedules
+	if err := config.Save(cfg); err != nil {
+		http.Error(w, "Failed to save config", http.StatusInternalServerError)
+		return
+	}
+
+	s.config = cfg
+	s.scheduler.SetSchedules(cfg.Schedules)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) handleListJobs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	list := s.jobs.List()
+	if stack := r.URL.Query().Get("stack"); stack != "" {
+		filtered := make([]jobs.Job, 0, len(list))
+		for _, job := range list {
+			if job.Stack == stack {
+				filtered = append(filtered, job)
+			}
+		}
+		list = filtered
+	}
+
+	json.NewEncoder(w).Encode(list)
+}
+
+func (s *Server) handleJob(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/jobs/"), "/"), "/")
+	id := parts[0]
+	if id == "" {
+		s.handleListJobs(w, r)
+		return
+	}
+
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		job, ok := s.jobs.Get(id)
+		if !ok {
+			http.Error(w, "Job not found", http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(job)
+
+	case len(parts) == 2 && parts[1] == "cancel" && r.Method == http.MethodPost:
+		job, ok := s.jobs.Get(id)
+		if !ok {
+			http.Error(w, "Job not found", http.StatusNotFound)
+			return
+		}
+		if p, _ := s.principal(r); p == nil || !p.Scope.Allows(jobScope(job.Type)) {
+			http.Error(w, "Token scope does not allow cancelling a "+job.Type+" job", http.StatusForbidden)
+			return
+		}
+
+		job, err := s.jobs.Cancel(id)
+		if errors.Is(err, jobs.ErrNotFound) {
+			http.Error(w, "Job not found", http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, jobs.ErrFinished) {
+			http.Error(w, fmt.Sprintf("Job already %s", job.State), http.StatusConflict)
+			return
+		}
+
+		s.track("job_cancelled", map[string]interface{}{
+			"type":    job.Type,
+			"project": job.Stack,
+		})
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(job)
+
+	case len(parts) <= 2:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// jobScope is the scope needed to start a job of the given type, and so
+// to cancel it.
+func jobScope(jobType string) auth.Scope {
+	if jobType == "backup" {
+		return auth.ScopeBackup
+	}
+	return auth.ScopeAdmin
+}
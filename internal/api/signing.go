@@ -0,0 +1,53 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/stacksnap/stacksnap/internal/backup"
+	"github.com/stacksnap/stacksnap/internal/config"
+	"github.com/stacksnap/stacksnap/internal/signing"
+)
+
+func (s *Server) backupSigner() (*signing.Signer, error) {
+	if s.config == nil || !s.config.SignBackups {
+		return nil, nil
+	}
+	return signing.LoadOrCreate(config.SigningKeyPath())
+}
+
+func (s *Server) signaturePolicy() (*backup.SignaturePolicy, error) {
+	policy := &backup.SignaturePolicy{}
+	if s.config != nil {
+		trusted, err := signing.ParsePublicKeys(s.config.TrustedSigningKeys)
+		if err != nil {
+			return nil, err
+		}
+		policy.Trusted = trusted
+		policy.Strict = s.config.RequireSignatures
+	}
+	if signer, err := signing.Load(config.SigningKeyPath()); err == nil {
+		policy.Trusted = append(policy.Trusted, signer.PublicKey())
+	}
+	return policy, nil
+}
+
+func (s *Server) handleSigningKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	resp := map[string]interface{}{
+		"sign_backups":       s.config != nil && s.config.SignBackups,
+		"require_signatures": s.config != nil && s.config.RequireSignatures,
+	}
+	if s.config != nil {
+		resp["trusted_keys"] = s.config.TrustedSigningKeys
+	}
+	if signer, err := signing.Load(config.SigningKeyPath()); err == nil {
+		resp["key_id"] = signer.KeyID()
+		resp["public_key"] = signing.EncodePublicKey(signer.PublicKey())
+	}
+	json.NewEncoder(w).Encode(resp)
+}
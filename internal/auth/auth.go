@@ -0,0 +1,408 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+type Scope string
+
+const (
+	ScopeRead   Scope = "read"
+	ScopeBackup Scope = "backup"
+	ScopeAdmin  Scope = "admin"
+)
+
+const (
+	TokenPrefix     = "ssnap_"
+	SessionDuration = 24 * time.Hour
+)
+
+var (
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	ErrAdminExists        = errors.New("admin account already configured")
+	ErrTokenNotFound      = errors.New("token not found")
+	ErrInvalidSetupToken  = errors.New("invalid setup token")
+)
+
+func ParseScope(s string) (Scope, error) {
+	switch Scope(strings.ToLower(strings.TrimSpace(s))) {
+	case ScopeRead:
+		return ScopeRead, nil
+	case ScopeBackup:
+		return ScopeBackup, nil
+	case ScopeAdmin, "restore":
+		return ScopeAdmin, nil
+	default:
+		return "", fmt.Errorf("invalid scope %q (expected read, backup or admin)", s)
+	}
+}
+
+func (s Scope) rank() int {
+	switch s {
+	case ScopeRead:
+		return 1
+	case ScopeBackup:
+		return 2
+	case ScopeAdmin:
+		return 3
+	default:
+		return 0
+	}
+}
+
+func (s Scope) Allows(required Scope) bool {
+	return s.rank() > 0 && s.rank() >= required.rank()
+}
+
+type Admin struct {
+	Username     string    `json:"username"`
+	PasswordHash string    `json:"password_hash"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+type Token struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	Scope     Scope      `json:"scope"`
+	Hint      string     `json:"hint"`
+	Hash      string     `json:"hash,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	LastUsed  *time.Time `json:"last_used,omitempty"`
+}
+
+type Principal struct {
+	Name  string `json:"name"`
+	Scope Scope  `json:"scope"`
+	Kind  string `json:"kind"`
+}
+
+type fileData struct {
+	Admin  *Admin  `json:"admin,omitempty"`
+	Tokens []Token `json:"tokens,omitempty"`
+}
+
+type session struct {
+	username  string
+	expiresAt time.Time
+}
+
+type Store struct {
+	mu       sync.Mutex
+	path     string
+	modTime  time.Time
+	data     fileData
+	sessions map[string]session
+	setup    string
+}
+
+func Open(path string) (*Store, error) {
+	s := &Store{
+		path:     path,
+		sessions: make(map[string]session),
+	}
+	if err := s.reloadLocked(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Store) reloadLocked() error {
+	info, err := os.Stat(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		s.data = fileData{}
+		s.modTime = time.Time{}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.ModTime().Equal(s.modTime) {
+		return nil
+	}
+
+	raw, err := os.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+
+	var data fileData
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", s.path, err)
+	}
+	s.data = data
+	s.modTime = info.ModTime()
+	return nil
+}
+
+func (s *Store) refreshLocked() {
+	if err := s.reloadLocked(); err != nil {
+		fmt.Printf(" Warning: failed to reload auth store: %v\n", err)
+	}
+}
+
+func (s *Store) saveLocked() error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+
+	raw, err := json.MarshalIndent(s.data, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return err
+	}
+
+	if info, err := os.Stat(s.path); err == nil {
+		s.modTime = info.ModTime()
+	}
+	return nil
+}
+
+func (s *Store) HasAdmin() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refreshLocked()
+	return s.data.Admin != nil
+}
+
+func (s *Store) CreateAdmin(username, password string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refreshLocked()
+
+	if s.data.Admin != nil {
+		return ErrAdminExists
+	}
+	return s.setAdminLocked(username, password)
+}
+
+// SetupToken returns a new one-time token that allows creating the first
+// admin account with CreateAdminWithToken. Only its hash is kept, in memory.
+func (s *Store) SetupToken() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token := randomHex(16)
+	s.setup = hashSecret(token)
+	return token
+}
+
+func (s *Store) CreateAdminWithToken(token, username, password string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refreshLocked()
+
+	if s.setup == "" || subtle.ConstantTimeCompare([]byte(hashSecret(token)), []byte(s.setup)) != 1 {
+		return ErrInvalidSetupToken
+	}
+	if s.data.Admin != nil {
+		return ErrAdminExists
+	}
+	if err := s.setAdminLocked(username, password); err != nil {
+		return err
+	}
+	s.setup = ""
+	return nil
+}
+
+func (s *Store) SetAdmin(username, password string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refreshLocked()
+
+	if err := s.setAdminLocked(username, password); err != nil {
+		return err
+	}
+	s.sessions = make(map[string]session)
+	return nil
+}
+
+func (s *Store) setAdminLocked(username, password string) error {
+	username = strings.TrimSpace(username)
+	if username == "" {
+		return fmt.Errorf("username is required")
+	}
+	if len(password) < 8 {
+		return fmt.Errorf("password must be at least 8 characters")
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	s.data.Admin = &Admin{
+		Username:     username,
+		PasswordHash: string(hash),
+		UpdatedAt:    time.Now(),
+	}
+	return s.saveLocked()
+}
+
+func (s *Store) Login(username, password string) (string, time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refreshLocked()
+
+	admin := s.data.Admin
+	if admin == nil || admin.Username != username {
+		bcrypt.CompareHashAndPassword(dummyHash(), []byte(password))
+		return "", time.Time{}, ErrInvalidCredentials
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(admin.PasswordHash), []byte(password)); err != nil {
+		return "", time.Time{}, ErrInvalidCredentials
+	}
+
+	id := randomHex(32)
+	expires := time.Now().Add(SessionDuration)
+	s.sessions[hashSecret(id)] = session{username: username, expiresAt: expires}
+	return id, expires, nil
+}
+
+func (s *Store) Logout(sessionID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, hashSecret(sessionID))
+}
+
+func (s *Store) Authenticate(sessionID, bearer string) (*Principal, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refreshLocked()
+
+	if bearer != "" {
+		hash := hashSecret(bearer)
+		for i := range s.data.Tokens {
+			t := &s.data.Tokens[i]
+			if t.Hash != hash {
+				continue
+			}
+			now := time.Now()
+			if t.LastUsed == nil || now.Sub(*t.LastUsed) > time.Minute {
+				t.LastUsed = &now
+				s.saveLocked()
+			}
+			return &Principal{Name: t.Name, Scope: t.Scope, Kind: "token"}, true
+		}
+		return nil, false
+	}
+
+	if sessionID == "" || s.data.Admin == nil {
+		return nil, false
+	}
+
+	key := hashSecret(sessionID)
+	sess, ok := s.sessions[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(sess.expiresAt) || sess.username != s.data.Admin.Username {
+		delete(s.sessions, key)
+		return nil, false
+	}
+	return &Principal{Name: sess.username, Scope: ScopeAdmin, Kind: "session"}, true
+}
+
+func (s *Store) CreateToken(name string, scope Scope) (string, Token, error) {
+	if scope.rank() == 0 {
+		return "", Token{}, fmt.Errorf("invalid scope %q", scope)
+	}
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", Token{}, fmt.Errorf("token name is required")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refreshLocked()
+
+	secret := TokenPrefix + randomHex(24)
+	t := Token{
+		ID:        randomHex(6),
+		Name:      name,
+		Scope:     scope,
+		Hint:      secret[len(secret)-4:],
+		Hash:      hashSecret(secret),
+		CreatedAt: time.Now(),
+	}
+	s.data.Tokens = append(s.data.Tokens, t)
+	if err := s.saveLocked(); err != nil {
+		return "", Token{}, err
+	}
+
+	t.Hash = ""
+	return secret, t, nil
+}
+
+func (s *Store) Tokens() []Token {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refreshLocked()
+
+	tokens := make([]Token, len(s.data.Tokens))
+	for i, t := range s.data.Tokens {
+		t.Hash = ""
+		tokens[i] = t
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].CreatedAt.Before(tokens[j].CreatedAt)
+	})
+	return tokens
+}
+
+func (s *Store) RevokeToken(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refreshLocked()
+
+	for i, t := range s.data.Tokens {
+		if t.ID == id {
+			s.data.Tokens = append(s.data.Tokens[:i], s.data.Tokens[i+1:]...)
+			return s.saveLocked()
+		}
+	}
+	return ErrTokenNotFound
+}
+
+var (
+	dummyOnce sync.Once
+	dummy     []byte
+)
+
+func dummyHash() []byte {
+	dummyOnce.Do(func() {
+		dummy, _ = bcrypt.GenerateFromPassword([]byte(randomHex(16)), bcrypt.DefaultCost)
+	})
+	return dummy
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
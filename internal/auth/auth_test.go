@@ -0,0 +1,213 @@
+package auth
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func openTestStore(t *testing.T) *Store {
+	t.Helper()
+	s, err := Open(filepath.Join(t.TempDir(), "auth.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestScopeAllows(t *testing.T) {
+	tests := []struct {
+		scope    Scope
+		required Scope
+		want     bool
+	}{
+		{ScopeRead, ScopeRead, true},
+		{ScopeRead, ScopeBackup, false},
+		{ScopeRead, ScopeAdmin, false},
+		{ScopeBackup, ScopeRead, true},
+		{ScopeBackup, ScopeBackup, true},
+		{ScopeBackup, ScopeAdmin, false},
+		{ScopeAdmin, ScopeBackup, true},
+		{ScopeAdmin, ScopeAdmin, true},
+		{Scope("bogus"), ScopeRead, false},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.scope)+"/"+string(tt.required), func(t *testing.T) {
+			if got := tt.scope.Allows(tt.required); got != tt.want {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseScope(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Scope
+		wantErr bool
+	}{
+		{"read", ScopeRead, false},
+		{" Backup ", ScopeBackup, false},
+		{"restore", ScopeAdmin, false},
+		{"admin", ScopeAdmin, false},
+		{"root", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseScope(tt.in)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Fatalf("got %q, %v; want %q (error %v)", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTokenAuthentication(t *testing.T) {
+	s := openTestStore(t)
+	secret, tok, err := s.CreateToken("ci", ScopeBackup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tok.Hash != "" {
+		t.Fatal("token hash returned to the caller")
+	}
+
+	tests := []struct {
+		name   string
+		bearer string
+		want   bool
+	}{
+		{"valid token", secret, true},
+		{"unknown token", TokenPrefix + "0000", false},
+		{"token prefix only", TokenPrefix, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, ok := s.Authenticate("", tt.bearer)
+			if ok != tt.want {
+				t.Fatalf("authenticated %v, want %v", ok, tt.want)
+			}
+			if ok && (p.Scope != ScopeBackup || p.Kind != "token") {
+				t.Fatalf("principal %+v, want a backup-scoped token", p)
+			}
+		})
+	}
+
+	if err := s.RevokeToken(tok.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.Authenticate("", secret); ok {
+		t.Fatal("revoked token still authenticates")
+	}
+	if err := s.RevokeToken(tok.ID); !errors.Is(err, ErrTokenNotFound) {
+		t.Fatalf("revoking twice: got %v, want ErrTokenNotFound", err)
+	}
+}
+
+func TestLogin(t *testing.T) {
+	s := openTestStore(t)
+	if err := s.CreateAdmin("admin", "correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CreateAdmin("other", "correct horse"); !errors.Is(err, ErrAdminExists) {
+		t.Fatalf("second admin: got %v, want ErrAdminExists", err)
+	}
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		wantErr  error
+	}{
+		{"correct password", "admin", "correct horse", nil},
+		{"wrong password", "admin", "battery staple", ErrInvalidCredentials},
+		{"unknown user", "root", "correct horse", ErrInvalidCredentials},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, _, err := s.Login(tt.username, tt.password)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			p, ok := s.Authenticate(id, "")
+			if !ok || p.Scope != ScopeAdmin || p.Kind != "session" {
+				t.Fatalf("session not accepted: %+v, %v", p, ok)
+			}
+		})
+	}
+}
+
+func TestSessionExpiry(t *testing.T) {
+	s := openTestStore(t)
+	if err := s.CreateAdmin("admin", "correct horse"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		expire func(s *Store, id string)
+	}{
+		{"expired", func(s *Store, id string) {
+			sess := s.sessions[hashSecret(id)]
+			sess.expiresAt = time.Now().Add(-time.Second)
+			s.sessions[hashSecret(id)] = sess
+		}},
+		{"logged out", func(s *Store, id string) { s.Logout(id) }},
+		{"password changed", func(s *Store, id string) {
+			if err := s.SetAdmin("admin", "battery staple"); err != nil {
+				t.Fatal(err)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, _, err := s.Login("admin", "correct horse")
+			if err != nil {
+				id, _, err = s.Login("admin", "battery staple")
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := s.Authenticate(id, ""); !ok {
+				t.Fatal("fresh session rejected")
+			}
+			tt.expire(s, id)
+			if _, ok := s.Authenticate(id, ""); ok {
+				t.Fatal("session still accepted")
+			}
+		})
+	}
+}
+
+func TestCreateAdminWithSetupToken(t *testing.T) {
+	s := openTestStore(t)
+	if err := s.CreateAdminWithToken("", "admin", "correct horse"); !errors.Is(err, ErrInvalidSetupToken) {
+		t.Fatalf("without a setup token issued: got %v, want ErrInvalidSetupToken", err)
+	}
+	token := s.SetupToken()
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		{"wrong token", "0123456789abcdef", ErrInvalidSetupToken},
+		{"empty token", "", ErrInvalidSetupToken},
+		{"setup token", token, nil},
+		{"token reused", token, ErrInvalidSetupToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := s.CreateAdminWithToken(tt.token, "admin", "correct horse"); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+	if !s.HasAdmin() {
+		t.Fatal("admin not created")
+	}
+}
@@ -0,0 +1,163 @@
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+type Codec string
+
+const (
+	CodecGzip Codec = "gzip"
+	CodecZstd Codec = "zstd"
+	CodecNone Codec = "none"
+)
+
+const MetadataCompression = "stacksnap-compression"
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+type Compression struct {
+	Codec Codec `json:"codec"`
+	Level int   `json:"level,omitempty"`
+}
+
+func ParseCompression(s string) (Compression, error) {
+	name, levelStr, hasLevel := strings.Cut(strings.ToLower(strings.TrimSpace(s)), ":")
+	c := Compression{Codec: Codec(name)}
+	if hasLevel {
+		level, err := strconv.Atoi(levelStr)
+		if err != nil {
+			return Compression{}, fmt.Errorf("invalid compression level %q", levelStr)
+		}
+		c.Level = level
+	}
+
+	switch c.Codec {
+	case "":
+		if hasLevel {
+			return Compression{}, fmt.Errorf("compression level %d needs a codec (gzip or zstd)", c.Level)
+		}
+		return Compression{}, nil
+	case CodecGzip:
+		if hasLevel && (c.Level < gzip.BestSpeed || c.Level > gzip.BestCompression) {
+			return Compression{}, fmt.Errorf("gzip level must be between 1 and 9, got %d", c.Level)
+		}
+	case CodecZstd:
+		if hasLevel && (c.Level < 1 || c.Level > 22) {
+			return Compression{}, fmt.Errorf("zstd level must be between 1 and 22, got %d", c.Level)
+		}
+	case CodecNone:
+		if hasLevel {
+			return Compression{}, fmt.Errorf("codec none does not take a level")
+		}
+	default:
+		return Compression{}, fmt.Errorf("unknown compression codec %q (use gzip, zstd or none)", name)
+	}
+	return c, nil
+}
+
+func (c Compression) codec() Codec {
+	if c.Codec == "" {
+		return CodecGzip
+	}
+	return c.Codec
+}
+
+func (c Compression) String() string {
+	if c.Level == 0 {
+		return string(c.codec())
+	}
+	return fmt.Sprintf("%s:%d", c.codec(), c.Level)
+}
+
+func (c Compression) Extension() string {
+	switch c.codec() {
+	case CodecZstd:
+		return ".tar.zst"
+	case CodecNone:
+		return ".tar"
+	default:
+		return ".tar.gz"
+	}
+}
+
+func newArchiveCompressor(w io.Writer, c Compression, cfg ParallelConfig) (io.WriteCloser, error) {
+	switch c.codec() {
+	case CodecNone:
+		return nopWriteCloser{w}, nil
+	case CodecZstd:
+		level := zstd.SpeedDefault
+		if c.Level > 0 {
+			level = zstd.EncoderLevelFromZstd(c.Level)
+		}
+		return zstd.NewWriter(w, zstd.WithEncoderLevel(level))
+	}
+
+	level := c.Level
+	if level == 0 {
+		level = cfg.GzipCompressionLevel
+	}
+	if level < gzip.BestSpeed || level > gzip.BestCompression {
+		level = gzip.DefaultCompression
+	}
+	if !cfg.UseParallelGzip {
+		return gzip.NewWriterLevel(w, level)
+	}
+	if level == gzip.DefaultCompression {
+		level = 6
+	}
+	if pigzAvailable() {
+		return NewParallelGzipWriter(w, level, 0)
+	}
+	return newParallelGzipWriter(w, level, 0)
+}
+
+func newArchiveDecompressor(r io.Reader) (io.ReadCloser, Codec, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(len(zstdMagic))
+	if len(magic) == 0 {
+		if err == nil || err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, "", fmt.Errorf("failed to read archive header: %w", err)
+	}
+	if err != nil && err != io.EOF {
+		return nil, "", err
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, CodecGzip, fmt.Errorf("invalid gzip stream: %w", err)
+		}
+		return gz, CodecGzip, nil
+	case bytes.HasPrefix(magic, zstdMagic):
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, CodecZstd, fmt.Errorf("invalid zstd stream: %w", err)
+		}
+		return zr.IOReadCloser(), CodecZstd, nil
+	default:
+		return io.NopCloser(br), CodecNone, nil
+	}
+}
+
+func abortCompressor(w io.WriteCloser) {
+	if a, ok := w.(interface{ Abort() }); ok {
+		a.Abort()
+		return
+	}
+	w.Close()
+}
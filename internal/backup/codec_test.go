@@ -0,0 +1,253 @@
+package backup
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"runtime"
+	"slices"
+	"testing"
+	"time"
+)
+
+var testCodecs = []Compression{
+	{Codec: CodecGzip},
+	{Codec: CodecZstd, Level: 9},
+	{Codec: CodecNone},
+}
+
+func TestParseCompression(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Compression
+		wantErr bool
+	}{
+		{"", Compression{}, false},
+		{"gzip", Compression{Codec: CodecGzip}, false},
+		{"GZIP:9", Compression{Codec: CodecGzip, Level: 9}, false},
+		{"zstd", Compression{Codec: CodecZstd}, false},
+		{"zstd:19", Compression{Codec: CodecZstd, Level: 19}, false},
+		{"none", Compression{Codec: CodecNone}, false},
+		{"gzip:0", Compression{}, true},
+		{"gzip:10", Compression{}, true},
+		{"zstd:23", Compression{}, true},
+		{"zstd:fast", Compression{}, true},
+		{"none:1", Compression{}, true},
+		{":3", Compression{}, true},
+		{"lz4", Compression{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseCompression(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestArchiveCodecRoundTrip(t *testing.T) {
+	data := bytes.Repeat([]byte("stacksnap codec round trip "), 100000)
+	for _, c := range testCodecs {
+		for _, cfg := range []ParallelConfig{{}, {MaxWorkers: 4, UseParallelGzip: true, GzipCompressionLevel: 6}} {
+			var buf bytes.Buffer
+			w, err := newArchiveCompressor(&buf, c, cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := w.Write(data); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			r, codec, err := newArchiveDecompressor(&buf)
+			if err != nil {
+				t.Fatalf("%s: %v", c, err)
+			}
+			if codec != c.Codec {
+				t.Fatalf("%s: detected %s", c, codec)
+			}
+			got, err := io.ReadAll(r)
+			r.Close()
+			if err != nil || !bytes.Equal(got, data) {
+				t.Fatalf("%s: round trip failed: %v", c, err)
+			}
+		}
+	}
+}
+
+func TestIsEncrypted(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 32)
+	for _, c := range testCodecs {
+		for _, encrypted := range []bool{false, true} {
+			a := testArchive{compression: c, volumes: map[string][]byte{"data": volumeTar(t, map[string]string{"a": "1"})}}
+			if encrypted {
+				a.key = key
+			}
+			got := IsEncrypted(bufio.NewReader(bytes.NewReader(a.bytes(t))))
+			if got != encrypted {
+				t.Errorf("%s encrypted=%v: IsEncrypted = %v", c, encrypted, got)
+			}
+		}
+	}
+}
+
+func TestUnencryptedCodecsVerifyAndOpen(t *testing.T) {
+	ring, keys := newTestKeyring(t, "default")
+	var ringKey []byte
+	for _, k := range keys {
+		ringKey = k
+	}
+	volume := volumeTar(t, map[string]string{"hello.txt": "hello", "dir/data.bin": "0123456789"})
+
+	for _, c := range testCodecs {
+		for _, encrypted := range []bool{false, true} {
+			name := c.String()
+			if encrypted {
+				name += "+encrypted"
+			}
+			t.Run(name, func(t *testing.T) {
+				provider := newTestProvider(t)
+				key := "app_20260101_120000" + c.Extension()
+				a := testArchive{compression: c, volumes: map[string][]byte{"data": volume}}
+				if encrypted {
+					a.key = ringKey
+					key += ".enc"
+				}
+				uploadTestArchive(t, provider, key, a.bytes(t), nil)
+				ctx := t.Context()
+
+				result, err := VerifyBackupLight(ctx, provider, key, DecryptionKeys{Keyring: ring}, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !result.Verified {
+					t.Fatalf("verification failed: %s (outcome %s)", result.ErrorMessage, result.Outcome)
+				}
+				if !result.HasManifest || result.VolumeCount != 1 {
+					t.Fatalf("unexpected result: %+v", result)
+				}
+
+				opts := StackRestoreOptions{InputPath: key, StorageProvider: provider, Keyring: ring, Context: ctx}
+				files, err := PeekBackup(opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !slices.Contains(files, "volumes/data.tar") || !slices.Contains(files, "metadata.json") {
+					t.Fatalf("unexpected peek listing: %v", files)
+				}
+
+				checked, err := checkStackArchive(ctx, opts, key, func(string, ...interface{}) {})
+				if err != nil {
+					t.Fatal(err)
+				}
+				_, archive, err := openCheckedArchive(ctx, opts, checked)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer archive.Close()
+				tr := newManifestReader(tar.NewReader(archive))
+				for {
+					h, err := tr.Next()
+					if err == io.EOF {
+						break
+					}
+					if err != nil {
+						t.Fatal(err)
+					}
+					if h.Name == segmentName("data", 0) {
+						got, err := io.ReadAll(tr)
+						if err != nil || !bytes.Equal(got, volume) {
+							t.Fatalf("volume data differs: %v", err)
+						}
+					}
+				}
+				if err := tr.Verify(); err != nil {
+					t.Fatal(err)
+				}
+				if err := archive.Close(); err != nil {
+					t.Fatal(err)
+				}
+			})
+		}
+	}
+}
+
+func TestUnencryptedArchiveNeedsNoKey(t *testing.T) {
+	for _, c := range testCodecs {
+		provider := newTestProvider(t)
+		key := "app_20260101_120000" + c.Extension()
+		a := testArchive{compression: c, volumes: map[string][]byte{"data": volumeTar(t, map[string]string{"a": "1"})}}
+		uploadTestArchive(t, provider, key, a.bytes(t), nil)
+
+		result, err := VerifyBackupLight(t.Context(), provider, key, DecryptionKeys{}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !result.Verified || result.Outcome == "key_required" {
+			t.Fatalf("%s: %s (outcome %s)", c, result.ErrorMessage, result.Outcome)
+		}
+	}
+}
+
+type failingWriter struct{ budget int }
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if len(p) > w.budget {
+		return 0, errors.New("upload failed")
+	}
+	w.budget -= len(p)
+	return len(p), nil
+}
+
+func TestAbortCompressorReleasesWorkers(t *testing.T) {
+	data := randomTestData(8 << 20)
+	for _, c := range testCodecs {
+		for _, cfg := range []ParallelConfig{{}, {MaxWorkers: 4, UseParallelGzip: true, GzipCompressionLevel: 6}} {
+			before := runtime.NumGoroutine()
+			w, err := newArchiveCompressor(&failingWriter{budget: 64 << 10}, c, cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w.Write(data)
+
+			done := make(chan struct{})
+			go func() {
+				abortCompressor(w)
+				close(done)
+			}()
+			select {
+			case <-done:
+			case <-time.After(10 * time.Second):
+				t.Fatalf("%s parallel=%v: abort did not return", c, cfg.UseParallelGzip)
+			}
+
+			deadline := time.Now().Add(5 * time.Second)
+			for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+			if n := runtime.NumGoroutine(); n > before {
+				t.Fatalf("%s parallel=%v: %d goroutines left running after abort", c, cfg.UseParallelGzip, n-before)
+			}
+		}
+	}
+}
+
+func randomTestData(n int) []byte {
+	data := make([]byte, n)
+	var x uint32 = 1
+	for i := range data {
+		x ^= x << 13
+		x ^= x >> 17
+		x ^= x << 5
+		data[i] = byte(x)
+	}
+	return data
+}
@@ -0,0 +1,159 @@
+package backup
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"runtime"
+	"sync"
+)
+
+const (
+	gzipBlockSize = 1 << 20
+	gzipDictSize  = 32 << 10
+)
+
+var errCompressionAborted = errors.New("compression aborted")
+
+type gzipBlock struct {
+	data   []byte
+	dict   []byte
+	result chan gzipResult
+}
+
+type gzipResult struct {
+	data []byte
+	err  error
+}
+
+type parallelGzipWriter struct {
+	w     io.Writer
+	level int
+	buf   []byte
+	dict  []byte
+	crc   uint32
+	size  uint32
+	queue chan *gzipBlock
+	done  chan struct{}
+
+	mu  sync.Mutex
+	err error
+}
+
+func newParallelGzipWriter(w io.Writer, level, threads int) (*parallelGzipWriter, error) {
+	if threads <= 0 {
+		threads = runtime.NumCPU()
+	}
+	if level < gzip.BestSpeed || level > gzip.BestCompression {
+		level = gzip.DefaultCompression
+	}
+	header := []byte{0x1f, 0x8b, 8, 0, 0, 0, 0, 0, 0, 0xff}
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+
+	g := &parallelGzipWriter{
+		w:     w,
+		level: level,
+		buf:   make([]byte, 0, gzipBlockSize),
+		queue: make(chan *gzipBlock, threads),
+		done:  make(chan struct{}),
+	}
+	go g.output()
+	return g, nil
+}
+
+func (g *parallelGzipWriter) output() {
+	defer close(g.done)
+	for block := range g.queue {
+		res := <-block.result
+		if g.failed() != nil {
+			continue
+		}
+		if res.err == nil {
+			_, res.err = g.w.Write(res.data)
+		}
+		if res.err != nil {
+			g.mu.Lock()
+			g.err = res.err
+			g.mu.Unlock()
+		}
+	}
+}
+
+func (g *parallelGzipWriter) failed() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.err
+}
+
+func (g *parallelGzipWriter) Write(p []byte) (int, error) {
+	if err := g.failed(); err != nil {
+		return 0, err
+	}
+	g.crc = crc32.Update(g.crc, crc32.IEEETable, p)
+	g.size += uint32(len(p))
+
+	written := 0
+	for len(p) > 0 {
+		n := copy(g.buf[len(g.buf):cap(g.buf)], p)
+		g.buf = g.buf[:len(g.buf)+n]
+		p = p[n:]
+		written += n
+		if len(g.buf) == cap(g.buf) {
+			g.submit()
+		}
+	}
+	return written, nil
+}
+
+func (g *parallelGzipWriter) submit() {
+	block := &gzipBlock{data: g.buf, dict: g.dict, result: make(chan gzipResult, 1)}
+	go func() {
+		var out bytes.Buffer
+		fw, err := flate.NewWriterDict(&out, g.level, block.dict)
+		if err == nil {
+			_, err = fw.Write(block.data)
+		}
+		if err == nil {
+			err = fw.Flush()
+		}
+		block.result <- gzipResult{data: out.Bytes(), err: err}
+	}()
+	g.queue <- block
+
+	g.dict = g.buf[max(0, len(g.buf)-gzipDictSize):]
+	g.buf = make([]byte, 0, gzipBlockSize)
+}
+
+func (g *parallelGzipWriter) Close() error {
+	if len(g.buf) > 0 {
+		g.submit()
+	}
+	close(g.queue)
+	<-g.done
+	if err := g.failed(); err != nil {
+		return err
+	}
+
+	trailer := make([]byte, 10)
+	trailer[0] = 0x03
+	binary.LittleEndian.PutUint32(trailer[2:], g.crc)
+	binary.LittleEndian.PutUint32(trailer[6:], g.size)
+	_, err := g.w.Write(trailer)
+	return err
+}
+
+func (g *parallelGzipWriter) Abort() {
+	g.mu.Lock()
+	if g.err == nil {
+		g.err = errCompressionAborted
+	}
+	g.mu.Unlock()
+	close(g.queue)
+	<-g.done
+}
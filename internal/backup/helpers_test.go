@@ -0,0 +1,179 @@
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/stacksnap/stacksnap/internal/crypto"
+	"github.com/stacksnap/stacksnap/internal/keyring"
+	"github.com/stacksnap/stacksnap/internal/storage"
+)
+
+// TestMain points HOME at a scratch directory so stack locks and other
+// state under ~/.stacksnap never touch the real one.
+func TestMain(m *testing.M) {
+	home, err := os.MkdirTemp("", "stacksnap-test-home-*")
+	if err != nil {
+		panic(err)
+	}
+	os.Setenv("HOME", home)
+	code := m.Run()
+	os.RemoveAll(home)
+	os.Exit(code)
+}
+
+type testArchive struct {
+	stack       string
+	compression Compression
+	key         []byte
+	volumes     map[string][]byte
+	dropLast    string
+	unlisted    string
+	failed      string
+}
+
+func volumeTar(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, name := range names {
+		data := files[name]
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(tw, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func (a testArchive) bytes(t *testing.T) []byte {
+	t.Helper()
+	var out bytes.Buffer
+	var dst io.WriteCloser = nopWriteCloser{&out}
+	if a.key != nil {
+		enc, err := crypto.NewEncryptWriter(a.key, &out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dst = enc
+	}
+	compressor, err := newArchiveCompressor(dst, a.compression, ParallelConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tw := newManifestWriter(tar.NewWriter(compressor))
+
+	if err := addToTar(tw, "docker-compose.yml", []byte("services:\n  app:\n    image: alpine\n")); err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0, len(a.volumes))
+	for name := range a.volumes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var listed []string
+	var failed []string
+	for _, name := range names {
+		// Volumes go through the backup's own writer. A failed volume's
+		// dump breaks off after its first segment.
+		d := newVolumeDump(t.Context(), name)
+		go func(data []byte) {
+			d.send(volumeEvent{data: data, last: name != a.dropLast && name != a.failed})
+			var err error
+			if name == a.failed {
+				err = errors.New("tar: read error")
+			}
+			d.finish(err)
+		}(a.volumes[name])
+		if err := writeVolumeDump(tw, d); err != nil {
+			t.Fatal(err)
+		}
+		switch name {
+		case a.failed:
+			failed = append(failed, name)
+		case a.unlisted:
+		default:
+			listed = append(listed, name)
+		}
+	}
+
+	stack := a.stack
+	if stack == "" {
+		stack = "app"
+	}
+	metadata, err := json.Marshal(StackMetadata{StackName: stack, Volumes: listed, FailedVolumes: failed, Compression: a.compression.String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := addToTar(tw, "metadata.json", metadata); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := compressor.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := dst.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes()
+}
+
+func newTestProvider(t *testing.T) *storage.LocalProvider {
+	t.Helper()
+	provider, err := storage.NewLocalProvider(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return provider
+}
+
+func uploadTestArchive(t *testing.T, provider storage.Provider, key string, data []byte, meta map[string]string) {
+	t.Helper()
+	if err := provider.Upload(t.Context(), key, bytes.NewReader(data), storage.WithMetadata(meta)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newTestKeyring(t *testing.T, keys ...string) (*keyring.Keyring, map[string][]byte) {
+	t.Helper()
+	ring, err := keyring.Open(filepath.Join(t.TempDir(), "keyring.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ring.Unlock("correct horse battery staple"); err != nil {
+		t.Fatal(err)
+	}
+	ids := make(map[string][]byte)
+	for _, name := range keys {
+		k, err := ring.Generate(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		key, err := ring.Get(k.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[k.ID] = key
+	}
+	return ring, ids
+}
@@ -0,0 +1,315 @@
+package backup
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/stacksnap/stacksnap/internal/config"
+	"github.com/stacksnap/stacksnap/internal/docker"
+	"github.com/stacksnap/stacksnap/internal/storage"
+)
+
+type BackupMode string
+
+const (
+	ModeFull         BackupMode = "full"
+	ModeIncremental  BackupMode = "incremental"
+	ModeDifferential BackupMode = "differential"
+)
+
+const (
+	MetadataMode   = "stacksnap-mode"
+	MetadataParent = "stacksnap-parent"
+
+	IndexVersion   = 1
+	indexSuffix    = ".index.json"
+	deletedSuffix  = ".deleted.json"
+	maxChainLength = 1000
+)
+
+func ParseBackupMode(s string) (BackupMode, error) {
+	switch mode := BackupMode(strings.ToLower(s)); mode {
+	case "":
+		return "", nil
+	case ModeFull, ModeIncremental, ModeDifferential:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown backup mode %q (use full, incremental or differential)", s)
+	}
+}
+
+type FileEntry struct {
+	Path    string `json:"path"`
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"mtime"`
+	Inode   uint64 `json:"inode"`
+	Mode    uint32 `json:"mode,omitempty"`
+	SHA256  string `json:"sha256,omitempty"`
+}
+
+type BackupIndex struct {
+	Version   int                    `json:"version"`
+	Stack     string                 `json:"stack"`
+	Key       string                 `json:"key"`
+	Mode      BackupMode             `json:"mode"`
+	Parent    string                 `json:"parent,omitempty"`
+	CreatedAt time.Time              `json:"created_at"`
+	Volumes   map[string][]FileEntry `json:"volumes"`
+}
+
+func indexPath(stack, key string) string {
+	return filepath.Join(config.IndexDir(), stack, filepath.Base(key)+".json")
+}
+
+func saveIndex(index *BackupIndex) error {
+	p := indexPath(index.Stack, index.Key)
+	if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+		return err
+	}
+	data, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	tmp := p + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p)
+}
+
+func loadIndex(p string) (*BackupIndex, error) {
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	var index BackupIndex
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("invalid index %s: %w", p, err)
+	}
+	if index.Version != IndexVersion {
+		return nil, fmt.Errorf("unsupported index version %d in %s", index.Version, p)
+	}
+	return &index, nil
+}
+
+func findParentIndex(ctx context.Context, provider storage.Provider, stack string, mode BackupMode) *BackupIndex {
+	dir := filepath.Join(config.IndexDir(), stack)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+
+	type candidate struct {
+		path string
+		time time.Time
+	}
+	var candidates []candidate
+	for _, e := range entries {
+		name := strings.TrimSuffix(e.Name(), ".json")
+		if e.IsDir() || name == e.Name() {
+			continue
+		}
+		if _, ts, ok := ParseBackupKey(name); ok {
+			candidates = append(candidates, candidate{filepath.Join(dir, e.Name()), ts})
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].time.After(candidates[j].time)
+	})
+
+	for _, c := range candidates {
+		index, err := loadIndex(c.path)
+		if err != nil {
+			continue
+		}
+		if mode == ModeDifferential && index.Mode != ModeFull {
+			continue
+		}
+		p, k, err := resolveStorage(provider, index.Key)
+		if err != nil {
+			continue
+		}
+		if _, err := p.Stat(ctx, k); err != nil {
+			continue
+		}
+		return index
+	}
+	return nil
+}
+
+func diffVolume(prev map[string]FileEntry, files []docker.VolumeFile) (changed, deleted []string) {
+	seen := make(map[string]bool, len(files))
+	for _, f := range files {
+		seen[f.Path] = true
+		old, ok := prev[f.Path]
+		// Indexes written before modes were recorded have Mode 0; only a
+		// recorded mode is compared so upgrading does not force a full layer.
+		if !ok || old.Size != f.Size || old.ModTime != f.ModTime || old.Inode != f.Inode || (old.Mode != 0 && old.Mode != f.Mode) {
+			changed = append(changed, f.Path)
+		}
+	}
+	for p := range prev {
+		if !seen[p] {
+			deleted = append(deleted, p)
+		}
+	}
+	sort.Strings(deleted)
+	return changed, deleted
+}
+
+func volumeEntries(files []docker.VolumeFile, prev map[string]FileEntry, hashes map[string]string) []FileEntry {
+	entries := make([]FileEntry, 0, len(files))
+	for _, f := range files {
+		sum, ok := hashes[f.Path]
+		if !ok {
+			sum = prev[f.Path].SHA256
+		}
+		entries = append(entries, FileEntry{
+			Path:    f.Path,
+			Size:    f.Size,
+			ModTime: f.ModTime,
+			Inode:   f.Inode,
+			Mode:    f.Mode,
+			SHA256:  sum,
+		})
+	}
+	return entries
+}
+
+func (idx *BackupIndex) files(volume string) (map[string]FileEntry, bool) {
+	if idx == nil {
+		return nil, false
+	}
+	entries, ok := idx.Volumes[volume]
+	if !ok {
+		return nil, false
+	}
+	files := make(map[string]FileEntry, len(entries))
+	for _, e := range entries {
+		files[e.Path] = e
+	}
+	return files, true
+}
+
+func hashTar(dst io.Writer, src io.Reader) (int64, map[string]string, error) {
+	counter := &countingReader{r: src}
+	tr := tar.NewReader(io.TeeReader(counter, dst))
+	hashes := make(map[string]string)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return counter.n, nil, fmt.Errorf("invalid volume archive: %w", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		sum := sha256.New()
+		if _, err := io.Copy(sum, tr); err != nil {
+			return counter.n, nil, err
+		}
+		hashes[path.Clean(header.Name)] = hex.EncodeToString(sum.Sum(nil))
+	}
+	if _, err := io.Copy(dst, counter); err != nil {
+		return counter.n, nil, err
+	}
+	return counter.n, hashes, nil
+}
+
+func parentKey(child, parent string) string {
+	return path.Join(path.Dir(child), parent)
+}
+
+func resolveChain(ctx context.Context, provider storage.Provider, key string) ([]string, error) {
+	chain := []string{key}
+	seen := map[string]bool{key: true}
+	for current := key; ; {
+		p, k, err := resolveStorage(provider, current)
+		if err != nil {
+			return nil, err
+		}
+		var parentName string
+		info, err := p.Stat(ctx, k)
+		switch {
+		case err == nil && info.Metadata != nil:
+			parentName = info.Metadata[MetadataParent]
+		case err == nil || (current == key && errors.Is(err, storage.ErrNotFound)):
+			// Files outside a storage root carry no metadata; the index
+			// saved with the backup records its parent instead. A backup
+			// that cannot be found is only taken as full on its index's
+			// word.
+			indexed, ok := indexedParent(current)
+			if !ok && err != nil {
+				return nil, fmt.Errorf("failed to read backup %s: %w", current, err)
+			}
+			parentName = indexed
+		default:
+			return nil, fmt.Errorf("failed to read backup %s: %w", current, err)
+		}
+		if parentName == "" {
+			break
+		}
+
+		parent := parentKey(current, parentName)
+		pp, pk, err := resolveStorage(provider, parent)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := pp.Stat(ctx, pk); errors.Is(err, storage.ErrNotFound) {
+			return nil, fmt.Errorf("backup chain is broken: parent %s of %s not found", parent, current)
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to read parent %s of %s: %w", parent, current, err)
+		}
+		if seen[parent] || len(chain) >= maxChainLength {
+			return nil, fmt.Errorf("backup chain of %s does not end in a full backup", key)
+		}
+		seen[parent] = true
+		chain = append([]string{parent}, chain...)
+		current = parent
+	}
+	return chain, nil
+}
+
+// indexedParent returns the parent recorded in the index saved with the
+// backup at key, and whether such an index was found.
+func indexedParent(key string) (string, bool) {
+	stack, _, ok := ParseBackupKey(key)
+	if !ok {
+		return "", false
+	}
+	index, err := loadIndex(indexPath(stack, key))
+	if err != nil {
+		return "", false
+	}
+	if index.Parent == "" {
+		return "", true
+	}
+	return path.Base(index.Parent), true
+}
+
+func readDeletions(r io.Reader) ([]string, error) {
+	var paths []string
+	if err := json.NewDecoder(r).Decode(&paths); err != nil {
+		return nil, fmt.Errorf("invalid deletion list: %w", err)
+	}
+	for _, p := range paths {
+		if p == "" || path.IsAbs(p) || p != path.Clean(p) || p == ".." || strings.HasPrefix(p, "../") {
+			return nil, fmt.Errorf("invalid path in deletion list: %q", p)
+		}
+	}
+	return paths, nil
+}
@@ -0,0 +1,165 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stacksnap/stacksnap/internal/docker"
+	"github.com/stacksnap/stacksnap/internal/storage"
+)
+
+func TestDiffVolume(t *testing.T) {
+	prev := map[string]FileEntry{
+		"a.txt":  {Path: "a.txt", Size: 1, ModTime: 10, Inode: 1, Mode: 0o100644},
+		"dir":    {Path: "dir", Size: 4096, ModTime: 10, Inode: 2, Mode: 0o040755},
+		"old":    {Path: "old", Size: 4096, ModTime: 10, Inode: 3, Mode: 0o040755},
+		"legacy": {Path: "legacy", Size: 1, ModTime: 10, Inode: 4},
+	}
+	unchanged := []docker.VolumeFile{
+		{Path: "a.txt", Size: 1, ModTime: 10, Inode: 1, Mode: 0o100644},
+		{Path: "dir", Size: 4096, ModTime: 10, Inode: 2, Mode: 0o040755},
+		{Path: "old", Size: 4096, ModTime: 10, Inode: 3, Mode: 0o040755},
+		{Path: "legacy", Size: 1, ModTime: 10, Inode: 4, Mode: 0o100600},
+	}
+	with := func(edit func([]docker.VolumeFile) []docker.VolumeFile) []docker.VolumeFile {
+		return edit(slices.Clone(unchanged))
+	}
+
+	tests := []struct {
+		name        string
+		files       []docker.VolumeFile
+		wantChanged []string
+		wantDeleted []string
+	}{
+		{name: "nothing changed", files: unchanged},
+		{
+			name: "mode change",
+			files: with(func(f []docker.VolumeFile) []docker.VolumeFile {
+				f[0].Mode = 0o100600
+				return f
+			}),
+			wantChanged: []string{"a.txt"},
+		},
+		{
+			name: "new empty directory",
+			files: with(func(f []docker.VolumeFile) []docker.VolumeFile {
+				return append(f, docker.VolumeFile{Path: "dir/empty", Size: 4096, ModTime: 20, Inode: 5, Mode: 0o040700})
+			}),
+			wantChanged: []string{"dir/empty"},
+		},
+		{
+			name: "removed directory",
+			files: with(func(f []docker.VolumeFile) []docker.VolumeFile {
+				return slices.Delete(f, 2, 3)
+			}),
+			wantDeleted: []string{"old"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changed, deleted := diffVolume(prev, tt.files)
+			if !slices.Equal(changed, tt.wantChanged) {
+				t.Errorf("changed %v, want %v", changed, tt.wantChanged)
+			}
+			if !slices.Equal(deleted, tt.wantDeleted) {
+				t.Errorf("deleted %v, want %v", deleted, tt.wantDeleted)
+			}
+		})
+	}
+}
+
+func TestResolveChainOutsideStorageRoot(t *testing.T) {
+	dir := t.TempDir()
+	full := filepath.Join(dir, "app_20260101_120000.tar.gz")
+	incr := filepath.Join(dir, "app_20260102_120000.tar.gz")
+	for _, p := range []string{full, incr} {
+		if err := os.WriteFile(p, []byte("archive"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := saveIndex(&BackupIndex{Version: IndexVersion, Stack: "app", Key: incr, Mode: ModeIncremental, Parent: full})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chain, err := resolveChain(t.Context(), nil, incr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{full, incr}; !slices.Equal(chain, want) {
+		t.Fatalf("chain %v, want %v", chain, want)
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".meta")); !os.IsNotExist(err) {
+		t.Fatalf("metadata directory written next to the backups: %v", err)
+	}
+}
+
+// statErrProvider fails Stat for the given keys.
+type statErrProvider struct {
+	storage.Provider
+	errs map[string]error
+}
+
+func (p statErrProvider) Stat(ctx context.Context, key string) (*storage.ObjectInfo, error) {
+	if err := p.errs[key]; err != nil {
+		return nil, err
+	}
+	return p.Provider.Stat(ctx, key)
+}
+
+func TestResolveChainStatErrors(t *testing.T) {
+	const (
+		full    = "chain_20260101_120000.tar.gz"
+		incr    = "chain_20260102_120000.tar.gz"
+		missing = "chain_20260103_120000.tar.gz"
+		gone    = "chain_20260104_120000.tar.gz"
+	)
+	local := newTestProvider(t)
+	uploadTestArchive(t, local, full, []byte("full"), nil)
+	uploadTestArchive(t, local, incr, []byte("incr"), map[string]string{MetadataParent: full})
+	if err := saveIndex(&BackupIndex{Version: IndexVersion, Stack: "chain", Key: missing, Mode: ModeIncremental, Parent: full}); err != nil {
+		t.Fatal(err)
+	}
+	if err := saveIndex(&BackupIndex{Version: IndexVersion, Stack: "chain", Key: gone, Mode: ModeFull}); err != nil {
+		t.Fatal(err)
+	}
+	unavailable := errors.New("service unavailable")
+
+	tests := []struct {
+		name      string
+		key       string
+		errs      map[string]error
+		want      []string
+		wantError string
+	}{
+		{name: "incremental", key: incr, want: []string{full, incr}},
+		{name: "leaf stat fails", key: incr, errs: map[string]error{incr: unavailable}, wantError: "service unavailable"},
+		{name: "parent stat fails", key: incr, errs: map[string]error{full: unavailable}, wantError: "service unavailable"},
+		{name: "parent not found", key: incr, errs: map[string]error{full: storage.ErrNotFound}, wantError: "chain is broken"},
+		{name: "leaf not found without an index", key: "chain_20260105_120000.tar.gz", wantError: "not found"},
+		{name: "leaf not found, index records a parent", key: missing, want: []string{full, missing}},
+		{name: "leaf not found, index records a full backup", key: gone, want: []string{gone}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chain, err := resolveChain(t.Context(), statErrProvider{local, tt.errs}, tt.key)
+			if tt.wantError != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantError) {
+					t.Fatalf("got chain %v, error %v; want an error containing %q", chain, err, tt.wantError)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(chain, tt.want) {
+				t.Fatalf("chain %v, want %v", chain, tt.want)
+			}
+		})
+	}
+}
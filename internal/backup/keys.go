@@ -0,0 +1,180 @@
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ecdh"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/stacksnap/stacksnap/internal/crypto"
+	"github.com/stacksnap/stacksnap/internal/keyring"
+	"github.com/stacksnap/stacksnap/internal/storage"
+)
+
+const (
+	MetadataKeyID      = "stacksnap-key-id"
+	MetadataRecipients = "stacksnap-recipients"
+)
+
+const keyProbeSize = 2 * crypto.GCMChunkSize
+
+var (
+	ErrNoMatchingKey      = errors.New("no key in the keyring can decrypt this backup")
+	ErrIdentityRequired   = errors.New("backup is encrypted to X25519 recipients; an identity is required to decrypt it")
+	ErrPassphraseRequired = errors.New("backup is encrypted with a passphrase; the passphrase is required to decrypt it")
+	ErrKeyRequired        = errors.New("backup is encrypted and no key was provided")
+	ErrWrongKey           = errors.New("backup cannot be decrypted with the given key")
+)
+
+type DecryptionKeys struct {
+	Key     []byte
+	Keyring *keyring.Keyring
+	KMS     crypto.KeyProvider
+}
+
+func (k DecryptionKeys) decrypt(ctx context.Context, provider storage.Provider, inputPath string, r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	if !IsEncrypted(br) {
+		return br, nil
+	}
+
+	key := k.Key
+	if key == nil && k.Keyring != nil {
+		resolved, _, err := ResolveKey(ctx, provider, inputPath, k.Keyring)
+		if err != nil && !(k.KMS != nil && errors.Is(err, crypto.ErrKMSRequired)) {
+			return nil, err
+		}
+		key = resolved
+	}
+	if key == nil && k.KMS == nil {
+		return nil, ErrKeyRequired
+	}
+
+	dec, err := decryptStream(ctx, br, key, k.KMS)
+	if err != nil {
+		return nil, err
+	}
+	return &wrongKeyReader{r: dec}, nil
+}
+
+type wrongKeyReader struct {
+	r io.Reader
+	n int64
+}
+
+func (w *wrongKeyReader) Read(p []byte) (int, error) {
+	n, err := w.r.Read(p)
+	w.n += int64(n)
+	if w.n == 0 && errors.Is(err, crypto.ErrAuthenticationFailed) {
+		err = fmt.Errorf("%w: %v", ErrWrongKey, err)
+	}
+	return n, err
+}
+
+func IsWrongKey(err error) bool {
+	return errors.Is(err, ErrWrongKey) || errors.Is(err, ErrNoMatchingKey) || errors.Is(err, crypto.ErrNoMatchingRecipient)
+}
+
+func IsKeyRequired(err error) bool {
+	return errors.Is(err, ErrKeyRequired) || errors.Is(err, ErrIdentityRequired) || errors.Is(err, ErrPassphraseRequired) ||
+		errors.Is(err, crypto.ErrKMSRequired) || errors.Is(err, keyring.ErrLocked)
+}
+
+func IsEncrypted(r *bufio.Reader) bool {
+	magic, _ := r.Peek(len(crypto.MagicHeader))
+	return bytes.Equal(magic, crypto.MagicHeader)
+}
+
+func ResolveKey(ctx context.Context, provider storage.Provider, inputPath string, ring *keyring.Keyring) ([]byte, string, error) {
+	p, k, err := resolveStorage(provider, inputPath)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to open backup location: %w", err)
+	}
+
+	if info, err := p.Stat(ctx, k); err == nil && ring != nil {
+		if id := info.Metadata[MetadataKeyID]; id != "" {
+			key, err := ring.Get(id)
+			if err != nil {
+				return nil, "", fmt.Errorf("backup was encrypted with key %s: %w", id, err)
+			}
+			return key, id, nil
+		}
+	}
+
+	reader, err := p.Download(ctx, k)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to open backup: %w", err)
+	}
+	defer reader.Close()
+
+	head := make([]byte, keyProbeSize)
+	n, err := io.ReadFull(reader, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, "", fmt.Errorf("failed to read backup: %w", err)
+	}
+	head = head[:n]
+
+	if !IsEncrypted(bufio.NewReader(bytes.NewReader(head))) {
+		return nil, "", nil
+	}
+	switch archiveVersion(head) {
+	case crypto.VersionX25519, crypto.VersionX25519Framed:
+		return nil, "", ErrIdentityRequired
+	case crypto.VersionPassphrase:
+		return nil, "", ErrPassphraseRequired
+	case crypto.VersionKMS:
+		return nil, "", crypto.ErrKMSRequired
+	}
+	if ring == nil {
+		return nil, "", fmt.Errorf("backup %s is encrypted and no key was provided", inputPath)
+	}
+
+	entries, err := ring.Entries()
+	if err != nil {
+		return nil, "", err
+	}
+	for _, e := range entries {
+		if keyDecrypts(e.Key, head) {
+			return e.Key, e.ID, nil
+		}
+	}
+	return nil, "", ErrNoMatchingKey
+}
+
+func keyDecrypts(key, head []byte) bool {
+	if !bytes.HasPrefix(head, crypto.MagicHeader) {
+		return false
+	}
+	dec, err := crypto.NewDecryptReader(key, bytes.NewReader(head))
+	if err != nil {
+		return false
+	}
+	_, err = dec.Read(make([]byte, 1))
+	return err == nil || err == io.EOF
+}
+
+func archiveVersion(head []byte) byte {
+	if !bytes.HasPrefix(head, crypto.MagicHeader) || len(head) <= len(crypto.MagicHeader) {
+		return 0
+	}
+	return head[len(crypto.MagicHeader)]
+}
+
+func recipientFingerprints(recipients []*ecdh.PublicKey) string {
+	return strings.Join(recipientList(recipients), ",")
+}
+
+func recipientList(recipients []*ecdh.PublicKey) []string {
+	if len(recipients) == 0 {
+		return nil
+	}
+	ids := make([]string, len(recipients))
+	for i, r := range recipients {
+		ids[i] = crypto.RecipientFingerprint(r)
+	}
+	return ids
+}
@@ -0,0 +1,71 @@
+package backup
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/stacksnap/stacksnap/internal/config"
+	"github.com/stacksnap/stacksnap/internal/crypto"
+)
+
+const MetadataKMSKey = "stacksnap-kms-key"
+
+func NewKeyProvider(cfg *config.KMSConfig) (crypto.KeyProvider, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+
+	switch cfg.Provider {
+	case config.KMSVaultTransit, "":
+		address := cfg.Address
+		if address == "" {
+			address = os.Getenv("VAULT_ADDR")
+		}
+		token := os.Getenv("VAULT_TOKEN")
+		if cfg.TokenFile != "" {
+			data, err := os.ReadFile(cfg.TokenFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read vault token file: %w", err)
+			}
+			token = strings.TrimSpace(string(data))
+		}
+
+		vault, err := crypto.NewVaultTransit(address, token, cfg.Mount, cfg.KeyName)
+		if err != nil {
+			return nil, err
+		}
+		vault.Namespace = cfg.Namespace
+		if vault.Namespace == "" {
+			vault.Namespace = os.Getenv("VAULT_NAMESPACE")
+		}
+		return vault, nil
+	default:
+		return nil, fmt.Errorf("unsupported KMS provider %q", cfg.Provider)
+	}
+}
+
+func kmsKeyID(kms crypto.KeyProvider) string {
+	if kms == nil {
+		return ""
+	}
+	return kms.KeyID()
+}
+
+func decryptStream(ctx context.Context, r io.Reader, key []byte, kms crypto.KeyProvider) (io.Reader, error) {
+	if kms != nil {
+		br := bufio.NewReader(r)
+		head, _ := br.Peek(len(crypto.MagicHeader) + 1)
+		if archiveVersion(head) == crypto.VersionKMS {
+			return crypto.NewDecryptReaderKMS(ctx, kms, br)
+		}
+		r = br
+	}
+	if key == nil {
+		return r, nil
+	}
+	return crypto.NewDecryptReader(key, r)
+}
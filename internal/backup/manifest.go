@@ -0,0 +1,246 @@
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"sort"
+)
+
+const (
+	ManifestFile    = "manifest.json"
+	ManifestVersion = 1
+)
+
+var ErrNoManifest = errors.New("archive has no manifest")
+
+type ManifestEntry struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+type Manifest struct {
+	Version int             `json:"version"`
+	Entries []ManifestEntry `json:"entries"`
+}
+
+type ManifestMismatchError struct {
+	Entry  string
+	Reason string
+}
+
+func (e *ManifestMismatchError) Error() string {
+	return fmt.Sprintf("archive entry %s %s", e.Entry, e.Reason)
+}
+
+type entryWriter interface {
+	io.Writer
+	WriteHeader(*tar.Header) error
+}
+
+type manifestWriter struct {
+	tw      *tar.Writer
+	entries []ManifestEntry
+	name    string
+	hash    hash.Hash
+	size    int64
+}
+
+func newManifestWriter(tw *tar.Writer) *manifestWriter {
+	return &manifestWriter{tw: tw}
+}
+
+func (m *manifestWriter) WriteHeader(h *tar.Header) error {
+	m.finish()
+	if err := m.tw.WriteHeader(h); err != nil {
+		return err
+	}
+	m.name, m.hash, m.size = h.Name, sha256.New(), 0
+	return nil
+}
+
+func (m *manifestWriter) Write(p []byte) (int, error) {
+	n, err := m.tw.Write(p)
+	if m.hash != nil {
+		m.hash.Write(p[:n])
+		m.size += int64(n)
+	}
+	return n, err
+}
+
+func (m *manifestWriter) finish() {
+	if m.hash == nil {
+		return
+	}
+	m.entries = append(m.entries, ManifestEntry{
+		Name:   m.name,
+		Size:   m.size,
+		SHA256: hex.EncodeToString(m.hash.Sum(nil)),
+	})
+	m.hash = nil
+}
+
+func (m *manifestWriter) Close() error {
+	m.finish()
+	data, err := json.MarshalIndent(Manifest{Version: ManifestVersion, Entries: m.entries}, "", " ")
+	if err != nil {
+		return err
+	}
+	if err := addToTar(m.tw, ManifestFile, data); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	return m.tw.Close()
+}
+
+type manifestReader struct {
+	tr       *tar.Reader
+	src      io.Reader
+	entries  []ManifestEntry
+	name     string
+	hash     hash.Hash
+	size     int64
+	manifest *Manifest
+	err      error
+	pushed   *tar.Header
+}
+
+func newManifestReader(tr *tar.Reader) *manifestReader {
+	return &manifestReader{tr: tr, src: tr}
+}
+
+func (m *manifestReader) Next() (*tar.Header, error) {
+	if h := m.pushed; h != nil {
+		m.pushed = nil
+		return h, nil
+	}
+	if err := m.finish(); err != nil {
+		return nil, err
+	}
+
+	h, err := m.tr.Next()
+	if err != nil {
+		return h, err
+	}
+	m.src = m.tr
+
+	if h.Name == ManifestFile {
+		data, err := io.ReadAll(m.tr)
+		if err != nil {
+			return nil, err
+		}
+		var manifest Manifest
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			m.err = fmt.Errorf("invalid %s: %w", ManifestFile, err)
+		} else if manifest.Version != ManifestVersion {
+			m.err = fmt.Errorf("unsupported manifest version %d", manifest.Version)
+		}
+		m.manifest = &manifest
+		m.src = bytes.NewReader(data)
+		return h, nil
+	}
+
+	m.name, m.hash, m.size = h.Name, sha256.New(), 0
+	return h, nil
+}
+
+func (m *manifestReader) push(h *tar.Header) {
+	m.pushed = h
+}
+
+func (m *manifestReader) Read(p []byte) (int, error) {
+	n, err := m.src.Read(p)
+	if m.hash != nil {
+		m.hash.Write(p[:n])
+		m.size += int64(n)
+	}
+	return n, err
+}
+
+func (m *manifestReader) finish() error {
+	if m.hash == nil {
+		return nil
+	}
+	if _, err := io.Copy(io.Discard, m); err != nil {
+		return err
+	}
+	m.entries = append(m.entries, ManifestEntry{
+		Name:   m.name,
+		Size:   m.size,
+		SHA256: hex.EncodeToString(m.hash.Sum(nil)),
+	})
+	m.hash = nil
+	return nil
+}
+
+func (m *manifestReader) Verify() error {
+	mismatches, err := m.Mismatches()
+	if err != nil {
+		return err
+	}
+	if len(mismatches) > 0 {
+		return mismatches[0]
+	}
+	return nil
+}
+
+// Mismatches checks every entry read so far against the manifest and returns
+// each one that differs, in archive order, followed by the missing entries.
+func (m *manifestReader) Mismatches() ([]*ManifestMismatchError, error) {
+	if err := m.finish(); err != nil {
+		return nil, err
+	}
+	if m.err != nil {
+		return nil, m.err
+	}
+	if m.manifest == nil {
+		return nil, ErrNoManifest
+	}
+
+	want := make(map[string][]ManifestEntry)
+	for _, e := range m.manifest.Entries {
+		want[e.Name] = append(want[e.Name], e)
+	}
+
+	var mismatches []*ManifestMismatchError
+	for _, got := range m.entries {
+		expected := want[got.Name]
+		if len(expected) == 0 {
+			mismatches = append(mismatches, &ManifestMismatchError{Entry: got.Name, Reason: "is not listed in the manifest"})
+			continue
+		}
+		e := expected[0]
+		want[got.Name] = expected[1:]
+
+		if got.Size != e.Size {
+			mismatches = append(mismatches, &ManifestMismatchError{Entry: got.Name, Reason: fmt.Sprintf("is %d bytes, expected %d", got.Size, e.Size)})
+		} else if got.SHA256 != e.SHA256 {
+			mismatches = append(mismatches, &ManifestMismatchError{Entry: got.Name, Reason: fmt.Sprintf("has SHA-256 %s, expected %s", got.SHA256, e.SHA256)})
+		}
+	}
+
+	var missing []string
+	for name, rest := range want {
+		if len(rest) > 0 {
+			missing = append(missing, name)
+		}
+	}
+	sort.Strings(missing)
+	for _, name := range missing {
+		mismatches = append(mismatches, &ManifestMismatchError{Entry: name, Reason: "is missing from the archive"})
+	}
+	return mismatches, nil
+}
+
+func (m *manifestReader) Entries() int {
+	if m.manifest == nil {
+		return 0
+	}
+	return len(m.manifest.Entries)
+}
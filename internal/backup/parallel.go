@@ -96,6 +96,12 @@ func (p *ParallelGzipWriter) Write(data []byte) (int, error) {
 	return p.stdin.Write(data)
 }
 
+func (p *ParallelGzipWriter) Abort() {
+	p.cmd.Process.Kill()
+	p.stdin.Close()
+	<-p.done
+}
+
 func (p *ParallelGzipWriter) Close() error {
 
 	if err := p.stdin.Close(); err != nil {
@@ -133,11 +139,9 @@ func ParallelVolumeBackup(
 
 	for i, vol := range volumes {
 		wg.Add(1)
+		sem <- struct{}{}
 		go func(idx int, volumeName string) {
 			defer wg.Done()
-
-
-			sem <- struct{}{}
 			defer func() { <-sem }()
 
 
@@ -110,6 +110,15 @@ func PreflightChecks(client *docker.Client, opts StackBackupOptions) *PreflightR
 		}
 	}
 
+	if opts.Passphrase != "" && len(opts.Passphrase) < 8 {
+		result.Warnings = append(result.Warnings, PreflightWarning{
+			Severity: "error",
+			Message:  "Backup passphrase is too short",
+			Fix:      "Use a passphrase with at least 8 characters",
+		})
+		result.CanProceed = false
+	}
+
 	return result
 }
 
@@ -0,0 +1,19 @@
+package backup
+
+const (
+	PhasePreflight = "preflight"
+	PhaseSnapshot  = "snapshot"
+	PhaseDatabase  = "database"
+	PhaseVolumes   = "volumes"
+	PhaseFinalize  = "finalize"
+	PhaseDownload  = "download"
+	PhaseRestore   = "restore"
+	PhaseComplete  = "complete"
+	PhaseFailed    = "failed"
+)
+
+type Progress struct {
+	Phase          string
+	BytesProcessed int64
+	BytesTotal     int64
+}
@@ -2,12 +2,12 @@
 package backup
 
 import (
-	"compress/gzip"
 	"context"
 	"fmt"
-	"os"
+	"io"
 	"time"
 
+	"github.com/stacksnap/stacksnap/internal/crypto"
 	"github.com/stacksnap/stacksnap/internal/docker"
 	"github.com/stacksnap/stacksnap/internal/storage"
 )
@@ -18,6 +18,8 @@ type RestoreOptions struct {
 	InputPath string
 
 	StorageProvider storage.Provider
+	EncryptionKey   []byte
+	KMS             crypto.KeyProvider
 	Context     context.Context
 	Logger     func(string)
 }
@@ -32,9 +34,16 @@ type RestoreResult struct {
 
 func Restore(client *docker.Client, opts RestoreOptions) (*RestoreResult, error) {
 	startTime := time.Now()
+	ctx := opts.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
 
-
-	stat, err := os.Stat(opts.InputPath)
+	provider, key, err := resolveStorage(opts.StorageProvider, opts.InputPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open backup location: %w", err)
+	}
+	stat, err := provider.Stat(ctx, key)
 	if err != nil {
 		return nil, fmt.Errorf("backup file not found: %w", err)
 	}
@@ -51,24 +60,30 @@ func Restore(client *docker.Client, opts RestoreOptions) (*RestoreResult, error)
 	fmt.Printf(" Restoring volume %q from %s (%.2f MB)...\n",
 		opts.VolumeName,
 		opts.InputPath,
-		float64(stat.Size())/(1024*1024))
+		float64(stat.Size)/(1024*1024))
 
-
-	inFile, err := os.Open(opts.InputPath)
+	inFile, err := provider.Download(ctx, key)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open backup file: %w", err)
 	}
 	defer inFile.Close()
 
+	var input io.Reader = inFile
+	if opts.EncryptionKey != nil || opts.KMS != nil {
+		decReader, err := decryptStream(ctx, inFile, opts.EncryptionKey, opts.KMS)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create decryption reader: %w", err)
+		}
+		input = decReader
+	}
 
-	gzReader, err := gzip.NewReader(inFile)
+	archive, _, err := newArchiveDecompressor(input)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read gzip: %w", err)
+		return nil, fmt.Errorf("failed to read archive: %w", err)
 	}
-	defer gzReader.Close()
-
+	defer archive.Close()
 
-	if err := client.RestoreVolume(opts.VolumeName, gzReader); err != nil {
+	if err := client.WithContext(ctx).RestoreVolume(opts.VolumeName, archive); err != nil {
 		return nil, fmt.Errorf("failed to restore volume: %w", err)
 	}
 
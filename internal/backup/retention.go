@@ -0,0 +1,303 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/stacksnap/stacksnap/internal/config"
+	"github.com/stacksnap/stacksnap/internal/signing"
+	"github.com/stacksnap/stacksnap/internal/storage"
+)
+
+const backupTimestampFormat = "20060102_150405"
+
+var (
+	backupKeyPattern  = regexp.MustCompile(`^(.+)_(\d{8}_\d{6})(\.tar|\.tar\.gz|\.tar\.zst)(\.enc)?$`)
+	snapshotIDPattern = regexp.MustCompile(`^(.+)_(\d{8}_\d{6})$`)
+)
+
+func ParseBackupKey(key string) (string, time.Time, bool) {
+	return parseBackupName(backupKeyPattern, key)
+}
+
+func ParseSnapshotID(id string) (string, time.Time, bool) {
+	return parseBackupName(snapshotIDPattern, id)
+}
+
+func parseBackupName(pattern *regexp.Regexp, key string) (string, time.Time, bool) {
+	m := pattern.FindStringSubmatch(path.Base(key))
+	if m == nil {
+		return "", time.Time{}, false
+	}
+
+	ts, err := time.ParseInLocation(backupTimestampFormat, m[2], time.Local)
+	if err != nil {
+		return "", time.Time{}, false
+	}
+	return m[1], ts, true
+}
+
+type BackupRef struct {
+	Key      string    `json:"key"`
+	Stack    string    `json:"stack"`
+	Time     time.Time `json:"time"`
+	Size     int64     `json:"size"`
+	Verified bool      `json:"verified"`
+	Parent   string    `json:"parent,omitempty"`
+}
+
+type PruneDecision struct {
+	BackupRef
+	Reasons []string `json:"reasons,omitempty"`
+}
+
+type PrunePlan struct {
+	Stack  string                 `json:"stack"`
+	Policy config.RetentionPolicy `json:"policy"`
+	Keep   []PruneDecision        `json:"keep"`
+	Remove []PruneDecision        `json:"remove"`
+}
+
+type retentionRule struct {
+	name   string
+	count  int
+	period func(time.Time) string
+}
+
+func dailyPeriod(t time.Time) string {
+	return t.Format("2006-01-02")
+}
+
+func retentionRules(p config.RetentionPolicy) []retentionRule {
+	return []retentionRule{
+		{"hourly", p.KeepHourly, func(t time.Time) string { return t.Format("2006-01-02 15h") }},
+		{"daily", p.KeepDaily, dailyPeriod},
+		{"weekly", p.KeepWeekly, func(t time.Time) string {
+			y, w := t.ISOWeek()
+			return fmt.Sprintf("%d-W%02d", y, w)
+		}},
+		{"monthly", p.KeepMonthly, func(t time.Time) string { return t.Format("2006-01") }},
+		{"yearly", p.KeepYearly, func(t time.Time) string { return t.Format("2006") }},
+	}
+}
+
+func ApplyRetention(stack string, backups []BackupRef, policy config.RetentionPolicy) *PrunePlan {
+	plan := &PrunePlan{Stack: stack, Policy: policy}
+
+	sorted := make([]BackupRef, len(backups))
+	copy(sorted, backups)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Time.After(sorted[j].Time)
+	})
+
+	reasons := make([][]string, len(sorted))
+	keep := func(i int, reason string) {
+		reasons[i] = append(reasons[i], reason)
+	}
+
+	if policy.IsEmpty() {
+		for i := range sorted {
+			keep(i, "no retention policy")
+		}
+	}
+
+	for i := 0; i < len(sorted) && i < policy.KeepLast; i++ {
+		keep(i, fmt.Sprintf("last %d", policy.KeepLast))
+	}
+
+	// Within each period a rule keeps, the newest verified backup is kept
+	// too, so a kept period never loses its last verified backup.
+	for _, rule := range retentionRules(policy) {
+		if rule.count <= 0 {
+			continue
+		}
+
+		order, periods := groupByPeriod(sorted, rule.period)
+		if len(order) > rule.count {
+			order = order[:rule.count]
+		}
+
+		for _, p := range order {
+			members := periods[p]
+			chosen := members[0]
+			keep(chosen, fmt.Sprintf("%s %s", rule.name, p))
+
+			if sorted[chosen].Verified {
+				continue
+			}
+			for _, i := range members[1:] {
+				if sorted[i].Verified {
+					keep(i, fmt.Sprintf("verified backup for %s %s", rule.name, p))
+					break
+				}
+			}
+		}
+	}
+
+	byKey := make(map[string]int, len(sorted))
+	for i, b := range sorted {
+		byKey[b.Key] = i
+	}
+	for i, b := range sorted {
+		if len(reasons[i]) == 0 || b.Parent == "" {
+			continue
+		}
+		if j, ok := byKey[parentKey(b.Key, b.Parent)]; ok && j > i {
+			keep(j, fmt.Sprintf("parent of %s", path.Base(b.Key)))
+		}
+	}
+
+	for i, b := range sorted {
+		d := PruneDecision{BackupRef: b, Reasons: reasons[i]}
+		if len(d.Reasons) > 0 {
+			plan.Keep = append(plan.Keep, d)
+		} else {
+			plan.Remove = append(plan.Remove, d)
+		}
+	}
+	return plan
+}
+
+func groupByPeriod(sorted []BackupRef, period func(time.Time) string) ([]string, map[string][]int) {
+	periods := make(map[string][]int)
+	var order []string
+	for i, b := range sorted {
+		p := period(b.Time)
+		if _, seen := periods[p]; !seen {
+			order = append(order, p)
+		}
+		periods[p] = append(periods[p], i)
+	}
+	return order, periods
+}
+
+type PruneOptions struct {
+	StackName       string
+	Policy          func(stack string) (config.RetentionPolicy, bool)
+	DryRun          bool
+	StorageProvider storage.Provider
+	Verifications   map[string]*VerificationResult
+	Context         context.Context
+	Logger          func(string)
+}
+
+type PruneResult struct {
+	DryRun  bool         `json:"dry_run"`
+	Plans   []*PrunePlan `json:"plans"`
+	Deleted []string     `json:"deleted"`
+	Errors  []string     `json:"errors,omitempty"`
+}
+
+func Prune(opts PruneOptions) (*PruneResult, error) {
+	ctx := opts.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	log := func(format string, args ...interface{}) {
+		msg := fmt.Sprintf(format, args...)
+		fmt.Print(msg)
+		if opts.Logger != nil {
+			opts.Logger(msg)
+		}
+	}
+
+	if opts.StorageProvider == nil {
+		return nil, fmt.Errorf("no storage provider configured")
+	}
+	if opts.Policy == nil {
+		return nil, fmt.Errorf("no retention policy configured")
+	}
+
+	items, err := opts.StorageProvider.List(ctx, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list backups: %w", err)
+	}
+
+	byStack := make(map[string][]BackupRef)
+	for _, item := range items {
+		ref, ok := resolveBackupRef(ctx, opts.StorageProvider, item)
+		if !ok {
+			continue
+		}
+		if opts.StackName != "" && ref.Stack != opts.StackName {
+			continue
+		}
+		if v, ok := opts.Verifications[item.Key]; ok && v != nil && v.Verified {
+			ref.Verified = true
+		}
+		byStack[ref.Stack] = append(byStack[ref.Stack], ref)
+	}
+
+	var stacks []string
+	for name := range byStack {
+		stacks = append(stacks, name)
+	}
+	sort.Strings(stacks)
+
+	result := &PruneResult{DryRun: opts.DryRun, Plans: []*PrunePlan{}, Deleted: []string{}}
+	for _, name := range stacks {
+		policy, ok := opts.Policy(name)
+		if !ok || policy.IsEmpty() {
+			continue
+		}
+
+		plan := ApplyRetention(name, byStack[name], policy)
+		result.Plans = append(result.Plans, plan)
+
+		log(" Retention for %s: keeping %d, removing %d\n", name, len(plan.Keep), len(plan.Remove))
+		for _, d := range plan.Remove {
+			if opts.DryRun {
+				log("  would delete %s\n", d.Key)
+				continue
+			}
+
+			if err := opts.StorageProvider.Delete(ctx, d.Key); err != nil {
+				log("  Failed to delete %s: %v\n", d.Key, err)
+				result.Errors = append(result.Errors, fmt.Sprintf("%s: %v", d.Key, err))
+				continue
+			}
+			opts.StorageProvider.Delete(ctx, d.Key+signing.SignatureSuffix)
+			log("  deleted %s\n", d.Key)
+			result.Deleted = append(result.Deleted, d.Key)
+		}
+	}
+
+	return result, nil
+}
+
+func resolveBackupRef(ctx context.Context, provider storage.Provider, item storage.BackupItem) (BackupRef, bool) {
+	ref := BackupRef{Key: item.Key, Size: item.Size}
+
+	if stack, ts, ok := ParseBackupKey(item.Key); ok {
+		ref.Stack = stack
+		ref.Time = ts
+		if info, err := provider.Stat(ctx, item.Key); err == nil {
+			ref.Parent = info.Metadata[MetadataParent]
+		}
+		return ref, true
+	}
+
+	if !strings.Contains(path.Base(item.Key), ".tar") {
+		return ref, false
+	}
+
+	info, err := provider.Stat(ctx, item.Key)
+	if err != nil || info.Metadata[MetadataStack] == "" {
+		return ref, false
+	}
+
+	ts, err := time.Parse(time.RFC3339, info.Metadata[MetadataCreatedAt])
+	if err != nil {
+		ts = item.LastModified
+	}
+	ref.Stack = info.Metadata[MetadataStack]
+	ref.Time = ts
+	ref.Parent = info.Metadata[MetadataParent]
+	return ref, true
+}
@@ -0,0 +1,191 @@
+package backup
+
+import (
+	"slices"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stacksnap/stacksnap/internal/config"
+)
+
+func TestParseBackupKey(t *testing.T) {
+	tests := []struct {
+		key       string
+		wantStack string
+		wantOK    bool
+	}{
+		{"app_20260101_120000.tar", "app", true},
+		{"app_20260101_120000.tar.gz", "app", true},
+		{"app_20260101_120000.tar.zst", "app", true},
+		{"app_20260101_120000.tar.gz.enc", "app", true},
+		{"app_20260101_120000.tar.zst.enc", "app", true},
+		{"backups/my_app_20260101_120000.tar.gz", "my_app", true},
+		{"app_20260101_120000", "", false},
+		{"app_20260101_120000.enc", "", false},
+		{"app_20260101_120000.tar.gz.sig", "", false},
+		{"app_20260101_120000.tar.bz2", "", false},
+		{"app_2026-01-01.tar.gz", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			stack, ts, ok := ParseBackupKey(tt.key)
+			if ok != tt.wantOK || stack != tt.wantStack {
+				t.Fatalf("got (%q, %v), want (%q, %v)", stack, ok, tt.wantStack, tt.wantOK)
+			}
+			if ok && !ts.Equal(time.Date(2026, 1, 1, 12, 0, 0, 0, time.Local)) {
+				t.Fatalf("unexpected time %v", ts)
+			}
+		})
+	}
+}
+
+func TestParseSnapshotID(t *testing.T) {
+	tests := []struct {
+		id        string
+		wantStack string
+		wantOK    bool
+	}{
+		{"app_20260101_120000", "app", true},
+		{"my_app_20260101_120000", "my_app", true},
+		{"app_20260101_120000.tar.gz", "", false},
+		{"app", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			stack, _, ok := ParseSnapshotID(tt.id)
+			if ok != tt.wantOK || stack != tt.wantStack {
+				t.Fatalf("got (%q, %v), want (%q, %v)", stack, ok, tt.wantStack, tt.wantOK)
+			}
+		})
+	}
+}
+
+// retentionFixture is one backup a day at noon through Q1 2026, plus two
+// extra backups on 31 March.
+func retentionFixture(verified ...string) []BackupRef {
+	var times []time.Time
+	for d := time.Date(2026, 1, 1, 12, 0, 0, 0, time.Local); d.Month() < 4; d = d.AddDate(0, 0, 1) {
+		times = append(times, d)
+	}
+	times = append(times,
+		time.Date(2026, 3, 31, 6, 0, 0, 0, time.Local),
+		time.Date(2026, 3, 31, 18, 0, 0, 0, time.Local))
+
+	refs := make([]BackupRef, 0, len(times))
+	for _, ts := range times {
+		stamp := ts.Format(backupTimestampFormat)
+		refs = append(refs, BackupRef{
+			Key:      "app_" + stamp + ".tar.gz",
+			Stack:    "app",
+			Time:     ts,
+			Verified: slices.Contains(verified, stamp),
+		})
+	}
+	return refs
+}
+
+func keptStamps(plan *PrunePlan) []string {
+	var stamps []string
+	for _, d := range plan.Keep {
+		stamps = append(stamps, d.Time.Format(backupTimestampFormat))
+	}
+	sort.Strings(stamps)
+	return stamps
+}
+
+func TestApplyRetention(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   config.RetentionPolicy
+		verified []string
+		want     []string
+	}{
+		{
+			name:   "keep last",
+			policy: config.RetentionPolicy{KeepLast: 3},
+			want:   []string{"20260331_060000", "20260331_120000", "20260331_180000"},
+		},
+		{
+			name:   "daily keeps newest of each day",
+			policy: config.RetentionPolicy{KeepDaily: 3},
+			want:   []string{"20260329_120000", "20260330_120000", "20260331_180000"},
+		},
+		{
+			name:   "weekly buckets by ISO week",
+			policy: config.RetentionPolicy{KeepWeekly: 2},
+			want:   []string{"20260329_120000", "20260331_180000"},
+		},
+		{
+			name:   "monthly",
+			policy: config.RetentionPolicy{KeepMonthly: 3},
+			want:   []string{"20260131_120000", "20260228_120000", "20260331_180000"},
+		},
+		{
+			name:   "yearly",
+			policy: config.RetentionPolicy{KeepYearly: 5},
+			want:   []string{"20260331_180000"},
+		},
+		{
+			name:   "rules combine",
+			policy: config.RetentionPolicy{KeepLast: 1, KeepDaily: 2, KeepMonthly: 2},
+			want:   []string{"20260228_120000", "20260330_120000", "20260331_180000"},
+		},
+		{
+			name:     "verified backup kept alongside newest of its period",
+			policy:   config.RetentionPolicy{KeepDaily: 1},
+			verified: []string{"20260331_060000", "20260331_120000"},
+			want:     []string{"20260331_120000", "20260331_180000"},
+		},
+		{
+			name:     "newest verified backup of a kept period",
+			policy:   config.RetentionPolicy{KeepMonthly: 2},
+			verified: []string{"20260305_120000", "20260320_120000"},
+			want:     []string{"20260228_120000", "20260320_120000", "20260331_180000"},
+		},
+		{
+			name:     "keep-last does not guard verified dailies",
+			policy:   config.RetentionPolicy{KeepLast: 2},
+			verified: []string{"20260301_120000", "20260302_120000"},
+			want:     []string{"20260331_120000", "20260331_180000"},
+		},
+		{
+			name:     "only verified backup of a period outside the count",
+			policy:   config.RetentionPolicy{KeepMonthly: 1},
+			verified: []string{"20260110_120000"},
+			want:     []string{"20260331_180000"},
+		},
+		{
+			name:     "several verified backups of a period outside the count",
+			policy:   config.RetentionPolicy{KeepMonthly: 1},
+			verified: []string{"20260105_120000", "20260120_120000"},
+			want:     []string{"20260331_180000"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backups := retentionFixture(tt.verified...)
+			plan := ApplyRetention("app", backups, tt.policy)
+			if got := keptStamps(plan); !slices.Equal(got, tt.want) {
+				t.Fatalf("kept %v, want %v", got, tt.want)
+			}
+			if len(plan.Keep)+len(plan.Remove) != len(backups) {
+				t.Fatalf("plan covers %d backups, want %d", len(plan.Keep)+len(plan.Remove), len(backups))
+			}
+		})
+	}
+}
+
+func TestApplyRetentionKeepsParents(t *testing.T) {
+	backups := retentionFixture()
+	for i := range backups {
+		if backups[i].Time.Day() == 31 && backups[i].Time.Month() == 3 && backups[i].Time.Hour() == 18 {
+			backups[i].Parent = "app_20260301_120000.tar.gz"
+		}
+	}
+	plan := ApplyRetention("app", backups, config.RetentionPolicy{KeepLast: 1})
+	want := []string{"20260301_120000", "20260331_180000"}
+	if got := keptStamps(plan); !slices.Equal(got, want) {
+		t.Fatalf("kept %v, want %v", got, want)
+	}
+}
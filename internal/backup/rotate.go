@@ -0,0 +1,500 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/stacksnap/stacksnap/internal/crypto"
+	"github.com/stacksnap/stacksnap/internal/keyring"
+	"github.com/stacksnap/stacksnap/internal/lock"
+	"github.com/stacksnap/stacksnap/internal/signing"
+	"github.com/stacksnap/stacksnap/internal/storage"
+)
+
+const (
+	RotationPending = "pending"
+	RotationRotated = "rotated"
+	RotationSkipped = "skipped"
+	RotationFailed  = "failed"
+)
+
+type RotateOptions struct {
+	FromKeyID       string
+	ToKeyID         string
+	StackName       string
+	StatePath       string
+	DryRun          bool
+	StorageProvider storage.Provider
+	Keyring         *keyring.Keyring
+	Signer          *signing.Signer
+	Context         context.Context
+	Logger          func(string)
+}
+
+type ArchiveRotation struct {
+	Key       string     `json:"key"`
+	Status    string     `json:"status"`
+	Reason    string     `json:"reason,omitempty"`
+	Error     string     `json:"error,omitempty"`
+	RotatedAt *time.Time `json:"rotated_at,omitempty"`
+}
+
+type RotationState struct {
+	FromKeyID string                      `json:"from_key_id"`
+	ToKeyID   string                      `json:"to_key_id"`
+	StartedAt time.Time                   `json:"started_at"`
+	Archives  map[string]*ArchiveRotation `json:"archives"`
+}
+
+type RotateResult struct {
+	FromKeyID  string             `json:"from_key_id"`
+	ToKeyID    string             `json:"to_key_id"`
+	DryRun     bool               `json:"dry_run"`
+	Archives   []*ArchiveRotation `json:"archives"`
+	Rotated    int                `json:"rotated"`
+	Skipped    int                `json:"skipped"`
+	Failed     int                `json:"failed"`
+	KeyRetired bool               `json:"key_retired"`
+}
+
+func LoadRotationState(path string) (*RotationState, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var state RotationState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse rotation state: %w", err)
+	}
+	if state.Archives == nil {
+		state.Archives = make(map[string]*ArchiveRotation)
+	}
+	return &state, nil
+}
+
+func (s *RotationState) save(path string) error {
+	if path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func RotateKeys(opts RotateOptions) (*RotateResult, error) {
+	ctx := opts.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	log := func(format string, args ...interface{}) {
+		msg := fmt.Sprintf(format, args...)
+		fmt.Print(msg)
+		if opts.Logger != nil {
+			opts.Logger(msg)
+		}
+	}
+
+	if opts.StorageProvider == nil {
+		return nil, fmt.Errorf("no storage provider configured")
+	}
+	if opts.Keyring == nil {
+		return nil, fmt.Errorf("no keyring configured")
+	}
+
+	from, ok := opts.Keyring.Lookup(opts.FromKeyID)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", keyring.ErrKeyNotFound, opts.FromKeyID)
+	}
+	to, ok := opts.Keyring.Lookup(opts.ToKeyID)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", keyring.ErrKeyNotFound, opts.ToKeyID)
+	}
+	if from.ID == to.ID {
+		return nil, fmt.Errorf("source and target key are the same")
+	}
+	if to.RetiredAt != nil {
+		return nil, fmt.Errorf("target key %s is retired", to.ID)
+	}
+
+	oldKey, err := opts.Keyring.Get(from.ID)
+	if err != nil {
+		return nil, err
+	}
+	newKey, err := opts.Keyring.Get(to.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	state := &RotationState{
+		FromKeyID: from.ID,
+		ToKeyID:   to.ID,
+		StartedAt: time.Now(),
+		Archives:  make(map[string]*ArchiveRotation),
+	}
+	if opts.StatePath != "" {
+		prev, err := LoadRotationState(opts.StatePath)
+		switch {
+		case err == nil && (prev.FromKeyID != from.ID || prev.ToKeyID != to.ID):
+			return nil, fmt.Errorf("an unfinished rotation from %s to %s exists (state in %s)", prev.FromKeyID, prev.ToKeyID, opts.StatePath)
+		case err == nil:
+			log(" Resuming rotation started %s\n", prev.StartedAt.Format(time.RFC3339))
+			state = prev
+		case !errors.Is(err, os.ErrNotExist):
+			return nil, err
+		}
+	}
+
+	result := &RotateResult{FromKeyID: from.ID, ToKeyID: to.ID, DryRun: opts.DryRun}
+	log(" Rotating backups from key %s to %s\n", from.ID, to.ID)
+
+	if !opts.DryRun && opts.StackName == "" {
+		// Switch the default first so backups that start from here on are
+		// written under the new key rather than racing the rotation.
+		if def := opts.Keyring.DefaultID(); def == from.ID || def == "" {
+			if err := opts.Keyring.SetDefault(to.ID); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// Each stack stays locked from its first archive until the rotation
+	// ends, so no backup can add an archive under the old key behind it.
+	locks := make(map[string]*lock.Lock)
+	defer func() {
+		for _, l := range locks {
+			l.Release()
+		}
+	}()
+	lockStack := func(stack string) error {
+		if opts.DryRun || locks[stack] != nil {
+			return nil
+		}
+		l, err := lock.Default().Acquire(ctx, stack, "rotate")
+		if err != nil {
+			return err
+		}
+		locks[stack] = l
+		return nil
+	}
+
+	// Keep listing until a pass turns up nothing new: an archive finished
+	// by a backup that was already running when the rotation started must
+	// still be rotated before the old key is retired.
+	seen := make(map[string]bool)
+	for {
+		items, err := opts.StorageProvider.List(ctx, "")
+		if err != nil {
+			return result, fmt.Errorf("failed to list backups: %w", err)
+		}
+		sort.Slice(items, func(i, j int) bool { return items[i].Key < items[j].Key })
+
+		found := false
+		for _, item := range items {
+			if seen[item.Key] {
+				continue
+			}
+			seen[item.Key] = true
+
+			if err := ctx.Err(); err != nil {
+				state.save(opts.StatePath)
+				return result, fmt.Errorf("rotation cancelled: %w", err)
+			}
+
+			ref, ok := resolveBackupRef(ctx, opts.StorageProvider, item)
+			if !ok {
+				continue
+			}
+			if opts.StackName != "" && ref.Stack != opts.StackName {
+				continue
+			}
+			found = true
+			if err := lockStack(ref.Stack); err != nil {
+				state.save(opts.StatePath)
+				return result, fmt.Errorf("failed to lock stack %s: %w", ref.Stack, err)
+			}
+
+			entry := state.Archives[item.Key]
+			if entry != nil && entry.Status == RotationRotated {
+				result.Archives = append(result.Archives, entry)
+				result.Rotated++
+				continue
+			}
+
+			entry = &ArchiveRotation{Key: item.Key, Status: RotationPending}
+			reason := rotationSkipReason(ctx, opts.StorageProvider, item.Key, from.ID, to.ID, oldKey)
+			if reason == skipAlreadyRotated && !opts.DryRun {
+				// A rotation interrupted between the upload and the new
+				// signature leaves the archive under the target key with
+				// the old ciphertext's signature.
+				resigned, err := resignRotatedArchive(ctx, opts.StorageProvider, item.Key, newKey, opts.Signer)
+				if err != nil {
+					log("  Failed to repair the signature of %s: %v\n", item.Key, err)
+					entry.Status = RotationFailed
+					entry.Error = err.Error()
+					result.Failed++
+				} else if resigned {
+					log("  Re-signed %s after an interrupted rotation\n", item.Key)
+					now := time.Now()
+					entry.Status = RotationRotated
+					entry.RotatedAt = &now
+					result.Rotated++
+				}
+				if err != nil || resigned {
+					state.Archives[item.Key] = entry
+					result.Archives = append(result.Archives, entry)
+					if err := state.save(opts.StatePath); err != nil {
+						log("  Warning: failed to save rotation state: %v\n", err)
+					}
+					continue
+				}
+			}
+			if reason != "" {
+				entry.Status = RotationSkipped
+				entry.Reason = reason
+				result.Archives = append(result.Archives, entry)
+				result.Skipped++
+				continue
+			}
+
+			if opts.DryRun {
+				log("  would rotate %s\n", item.Key)
+				result.Archives = append(result.Archives, entry)
+				continue
+			}
+
+			log("  Rotating %s...\n", item.Key)
+			if err := reencryptArchive(ctx, opts.StorageProvider, item.Key, oldKey, newKey, to.ID, opts.Signer); err != nil {
+				log("  Failed to rotate %s: %v\n", item.Key, err)
+				entry.Status = RotationFailed
+				entry.Error = err.Error()
+				result.Failed++
+			} else {
+				now := time.Now()
+				entry.Status = RotationRotated
+				entry.RotatedAt = &now
+				result.Rotated++
+			}
+
+			state.Archives[item.Key] = entry
+			result.Archives = append(result.Archives, entry)
+			if err := state.save(opts.StatePath); err != nil {
+				log("  Warning: failed to save rotation state: %v\n", err)
+			}
+		}
+		if !found || opts.DryRun {
+			break
+		}
+	}
+
+	if opts.DryRun {
+		return result, nil
+	}
+
+	if result.Failed > 0 {
+		log(" Rotation incomplete: %d rotated, %d failed. Run it again to retry.\n", result.Rotated, result.Failed)
+		return result, nil
+	}
+
+	if opts.StackName == "" {
+		if err := opts.Keyring.Retire(from.ID); err != nil {
+			return result, err
+		}
+		result.KeyRetired = true
+	}
+	if opts.StatePath != "" {
+		os.Remove(opts.StatePath)
+	}
+
+	log(" Rotation complete: %d rotated, %d skipped\n", result.Rotated, result.Skipped)
+	return result, nil
+}
+
+const skipAlreadyRotated = "already encrypted with the target key"
+
+func rotationSkipReason(ctx context.Context, provider storage.Provider, key, fromID, toID string, oldKey []byte) string {
+	info, err := provider.Stat(ctx, key)
+	if err == nil {
+		switch info.Metadata[MetadataKeyID] {
+		case "":
+		case fromID:
+			return ""
+		case toID:
+			return skipAlreadyRotated
+		default:
+			return "encrypted with key " + info.Metadata[MetadataKeyID]
+		}
+	}
+
+	reader, err := provider.Download(ctx, key)
+	if err != nil {
+		return ""
+	}
+	defer reader.Close()
+
+	head := make([]byte, keyProbeSize)
+	n, _ := io.ReadFull(reader, head)
+	head = head[:n]
+
+	if !bytes.HasPrefix(head, crypto.MagicHeader) {
+		return "not encrypted"
+	}
+	if !keyDecrypts(oldKey, head) {
+		return "not encrypted with the source key"
+	}
+	return ""
+}
+
+// resignRotatedArchive brings the signature of an archive already encrypted
+// under newKey back in line with its contents. The archive is decrypted in
+// full first: only ciphertext that authenticates under newKey is re-signed.
+// It reports whether a new signature was written.
+func resignRotatedArchive(ctx context.Context, provider storage.Provider, key string, newKey []byte, signer *signing.Signer) (bool, error) {
+	sig, err := loadSignature(ctx, provider, key)
+	if errors.Is(err, signing.ErrUnsigned) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	src, err := provider.Download(ctx, key)
+	if err != nil {
+		return false, fmt.Errorf("failed to download: %w", err)
+	}
+	defer src.Close()
+
+	hash := sha256.New()
+	stored := &countingReader{r: io.TeeReader(src, hash)}
+	plain, err := crypto.NewDecryptReader(newKey, stored)
+	if err != nil {
+		return false, fmt.Errorf("failed to decrypt: %w", err)
+	}
+	if _, err := io.Copy(io.Discard, plain); err != nil {
+		return false, fmt.Errorf("failed to decrypt: %w", err)
+	}
+	if _, err := io.Copy(io.Discard, stored); err != nil {
+		return false, fmt.Errorf("failed to download: %w", err)
+	}
+
+	digest := hash.Sum(nil)
+	if sig.CheckArchive(digest, stored.n) == nil {
+		return false, nil
+	}
+	if signer == nil {
+		return false, fmt.Errorf("signature does not match the rotated archive and no signing key is available to re-sign it")
+	}
+	metadataDigest, err := hex.DecodeString(sig.MetadataSHA256)
+	if err != nil {
+		return false, fmt.Errorf("invalid metadata digest in signature: %w", err)
+	}
+	if err := uploadSignature(ctx, provider, key, signer.Sign(digest, stored.n, metadataDigest)); err != nil {
+		return false, fmt.Errorf("failed to upload signature: %w", err)
+	}
+	return true, nil
+}
+
+// reencryptArchive replaces the archive at key with one encrypted under
+// newKey. A signed archive is checked against its signature while it is
+// read, so tampered contents are never re-signed, and the rewritten archive
+// gets a fresh signature over the new ciphertext.
+func reencryptArchive(ctx context.Context, provider storage.Provider, key string, oldKey, newKey []byte, newKeyID string, signer *signing.Signer) error {
+	meta := make(map[string]string)
+	if info, err := provider.Stat(ctx, key); err == nil {
+		for k, v := range info.Metadata {
+			meta[k] = v
+		}
+	}
+	meta[MetadataKeyID] = newKeyID
+
+	oldSig, err := loadSignature(ctx, provider, key)
+	switch {
+	case errors.Is(err, signing.ErrUnsigned):
+		oldSig = nil
+	case err != nil:
+		return err
+	case signer == nil:
+		return fmt.Errorf("archive is signed and no signing key is available to re-sign it")
+	}
+
+	src, err := provider.Download(ctx, key)
+	if err != nil {
+		return fmt.Errorf("failed to download: %w", err)
+	}
+	defer src.Close()
+
+	srcHash := sha256.New()
+	stored := &countingReader{r: io.TeeReader(src, srcHash)}
+	plain, err := crypto.NewDecryptReader(oldKey, stored)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt: %w", err)
+	}
+
+	// Framed GCM (VersionGCMFramed) is what NewEncryptWriter writes for new
+	// backups. Plain NewEncryptWriterGCM has no final-chunk marker, so a
+	// rotated archive cut at a chunk boundary would still decrypt cleanly.
+	pr, pw := io.Pipe()
+	go func() {
+		enc, err := crypto.NewEncryptWriterFramed(newKey, pw)
+		if err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		if _, err := io.Copy(enc, plain); err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		if oldSig != nil {
+			// Drain anything the decrypter did not need so the digest
+			// covers the whole stored object.
+			if _, err := io.Copy(io.Discard, stored); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			if err := oldSig.CheckArchive(srcHash.Sum(nil), stored.n); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+		pw.CloseWithError(enc.Close())
+	}()
+
+	dstHash := sha256.New()
+	uploaded := &countingReader{r: io.TeeReader(pr, dstHash)}
+	if err := provider.Upload(ctx, key, uploaded, storage.WithMetadata(meta)); err != nil {
+		pr.CloseWithError(err)
+		return fmt.Errorf("failed to upload: %w", err)
+	}
+
+	if oldSig == nil {
+		return nil
+	}
+	metadataDigest, err := hex.DecodeString(oldSig.MetadataSHA256)
+	if err != nil {
+		return fmt.Errorf("invalid metadata digest in signature: %w", err)
+	}
+	sig := signer.Sign(dstHash.Sum(nil), uploaded.n, metadataDigest)
+	if err := uploadSignature(ctx, provider, key, sig); err != nil {
+		return fmt.Errorf("failed to upload signature: %w", err)
+	}
+	return nil
+}
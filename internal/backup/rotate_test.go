@@ -0,0 +1,303 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stacksnap/stacksnap/internal/crypto"
+	"github.com/stacksnap/stacksnap/internal/keyring"
+	"github.com/stacksnap/stacksnap/internal/lock"
+	"github.com/stacksnap/stacksnap/internal/signing"
+	"github.com/stacksnap/stacksnap/internal/storage"
+)
+
+func newTestSigner(t *testing.T) *signing.Signer {
+	t.Helper()
+	signer, err := signing.LoadOrCreate(filepath.Join(t.TempDir(), "signing.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+func signTestArchive(t *testing.T, provider storage.Provider, signer *signing.Signer, key string, data []byte) {
+	t.Helper()
+	digest := sha256.Sum256(data)
+	metadata := sha256.Sum256([]byte("metadata"))
+	sig := signer.Sign(digest[:], int64(len(data)), metadata[:])
+	if err := uploadSignature(t.Context(), provider, key, sig); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func downloadTestObject(t *testing.T, provider storage.Provider, key string) []byte {
+	t.Helper()
+	rc, err := provider.Download(t.Context(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func newRotationKeyring(t *testing.T) (ring *keyring.Keyring, keys map[string][]byte, fromID, toID string) {
+	t.Helper()
+	ring, keys = newTestKeyring(t, "old", "new")
+	for id := range keys {
+		if k, _ := ring.Lookup(id); k.Name == "old" {
+			fromID = id
+		} else {
+			toID = id
+		}
+	}
+	if err := ring.SetDefault(fromID); err != nil {
+		t.Fatal(err)
+	}
+	return ring, keys, fromID, toID
+}
+
+func TestRotateKeysResignsArchives(t *testing.T) {
+	ring, keys, fromID, toID := newRotationKeyring(t)
+	signer := newTestSigner(t)
+	trusted := []ed25519.PublicKey{signer.PublicKey()}
+	const key = "app_20260101_120000.tar.gz.enc"
+
+	tests := []struct {
+		name       string
+		signer     *signing.Signer
+		tamper     bool
+		wantRotate bool
+	}{
+		{"signed archive is re-signed", signer, false, true},
+		{"signed archive without a signing key", nil, false, false},
+		{"replaced archive is not re-signed", signer, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := newTestProvider(t)
+			a := testArchive{key: keys[fromID], volumes: map[string][]byte{"data": volumeTar(t, map[string]string{"a": "1"})}}
+			data := a.bytes(t)
+			signTestArchive(t, provider, signer, key, data)
+			if tt.tamper {
+				a.volumes["data"] = volumeTar(t, map[string]string{"a": "2"})
+				data = a.bytes(t)
+			}
+			uploadTestArchive(t, provider, key, data, nil)
+
+			res, err := RotateKeys(RotateOptions{
+				FromKeyID:       fromID,
+				ToKeyID:         toID,
+				StackName:       "app",
+				StorageProvider: provider,
+				Keyring:         ring,
+				Signer:          tt.signer,
+				Context:         t.Context(),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			stored := downloadTestObject(t, provider, key)
+			if !tt.wantRotate {
+				if res.Failed != 1 {
+					t.Fatalf("expected the rotation to fail, got %+v", res)
+				}
+				if !bytes.Equal(stored, data) {
+					t.Fatal("archive was rewritten although the rotation failed")
+				}
+				return
+			}
+
+			if res.Rotated != 1 {
+				t.Fatalf("expected one rotated archive, got %+v", res)
+			}
+			if bytes.Equal(stored, data) {
+				t.Fatal("archive was not re-encrypted")
+			}
+			if _, err := VerifySignature(t.Context(), provider, key, trusted); err != nil {
+				t.Fatalf("signature check after rotation: %v", err)
+			}
+		})
+	}
+}
+
+// sigFailProvider fails every signature upload, like a rotation that dies
+// after writing the new ciphertext but before its signature.
+type sigFailProvider struct {
+	storage.Provider
+}
+
+func (p sigFailProvider) Upload(ctx context.Context, key string, r io.Reader, opts ...storage.UploadOption) error {
+	if strings.HasSuffix(key, signing.SignatureSuffix) {
+		return errors.New("interrupted")
+	}
+	return p.Provider.Upload(ctx, key, r, opts...)
+}
+
+func TestRotateKeysRepairsStaleSignature(t *testing.T) {
+	signer := newTestSigner(t)
+	trusted := []ed25519.PublicKey{signer.PublicKey()}
+	const key = "app_20260101_120000.tar.gz.enc"
+
+	tests := []struct {
+		name       string
+		replace    bool
+		wantRepair bool
+	}{
+		{"interrupted rotation is re-signed", false, true},
+		{"archive replaced under the target key is not re-signed", true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ring, keys, fromID, toID := newRotationKeyring(t)
+			provider := newTestProvider(t)
+			a := testArchive{key: keys[fromID], volumes: map[string][]byte{"data": volumeTar(t, map[string]string{"a": "1"})}}
+			data := a.bytes(t)
+			uploadTestArchive(t, provider, key, data, nil)
+			signTestArchive(t, provider, signer, key, data)
+
+			opts := RotateOptions{
+				FromKeyID:       fromID,
+				ToKeyID:         toID,
+				StackName:       "app",
+				StorageProvider: sigFailProvider{provider},
+				Keyring:         ring,
+				Signer:          signer,
+				Context:         t.Context(),
+			}
+			if res, err := RotateKeys(opts); err != nil || res.Failed != 1 {
+				t.Fatalf("interrupted rotation: %+v, %v", res, err)
+			}
+			if _, err := VerifySignature(t.Context(), provider, key, trusted); err == nil {
+				t.Fatal("signature matches although it was never rewritten")
+			}
+			if tt.replace {
+				other, _ := crypto.GenerateKey()
+				a.key = other
+				uploadTestArchive(t, provider, key, a.bytes(t), map[string]string{MetadataKeyID: toID})
+			}
+
+			opts.StorageProvider = provider
+			res, err := RotateKeys(opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, sigErr := VerifySignature(t.Context(), provider, key, trusted)
+			if !tt.wantRepair {
+				if res.Failed != 1 || sigErr == nil {
+					t.Fatalf("replaced archive was accepted: %+v, signature error %v", res, sigErr)
+				}
+				return
+			}
+			if res.Rotated != 1 || res.Failed != 0 {
+				t.Fatalf("expected the resumed rotation to repair the archive, got %+v", res)
+			}
+			if sigErr != nil {
+				t.Fatalf("signature check after the resumed rotation: %v", sigErr)
+			}
+		})
+	}
+}
+
+// lateBackupProvider uploads one more archive under the old key after the
+// first listing, like a backup that was already running when the rotation
+// started.
+type lateBackupProvider struct {
+	storage.Provider
+	lists int
+	late  func()
+}
+
+func (p *lateBackupProvider) List(ctx context.Context, prefix string) ([]storage.BackupItem, error) {
+	items, err := p.Provider.List(ctx, prefix)
+	if p.lists++; p.lists == 1 {
+		p.late()
+	}
+	return items, err
+}
+
+func TestRotateKeysCatchesArchivesWrittenDuringRotation(t *testing.T) {
+	ring, keys, fromID, toID := newRotationKeyring(t)
+	volume := map[string][]byte{"data": volumeTar(t, map[string]string{"a": "1"})}
+
+	local := newTestProvider(t)
+	uploadTestArchive(t, local, "app_20260101_120000.tar.gz.enc", testArchive{key: keys[fromID], volumes: volume}.bytes(t), nil)
+	provider := &lateBackupProvider{Provider: local, late: func() {
+		uploadTestArchive(t, local, "web_20260101_130000.tar.gz.enc", testArchive{stack: "web", key: keys[fromID], volumes: volume}.bytes(t), nil)
+	}}
+
+	res, err := RotateKeys(RotateOptions{
+		FromKeyID:       fromID,
+		ToKeyID:         toID,
+		StorageProvider: provider,
+		Keyring:         ring,
+		Context:         t.Context(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Rotated != 2 || !res.KeyRetired {
+		t.Fatalf("expected both archives rotated and the key retired, got %+v", res)
+	}
+	if ring.DefaultID() != toID {
+		t.Fatalf("default key is %s, want %s", ring.DefaultID(), toID)
+	}
+}
+
+func TestRotateKeysWaitsForStackLock(t *testing.T) {
+	ring, keys, fromID, toID := newRotationKeyring(t)
+	provider := newTestProvider(t)
+	a := testArchive{key: keys[fromID], volumes: map[string][]byte{"data": volumeTar(t, map[string]string{"a": "1"})}}
+	uploadTestArchive(t, provider, "app_20260101_120000.tar.gz.enc", a.bytes(t), nil)
+
+	held, err := lock.Default().TryAcquire("app", "backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan *RotateResult, 1)
+	go func() {
+		res, err := RotateKeys(RotateOptions{
+			FromKeyID:       fromID,
+			ToKeyID:         toID,
+			StorageProvider: provider,
+			Keyring:         ring,
+			Context:         t.Context(),
+		})
+		if err != nil {
+			t.Error(err)
+		}
+		done <- res
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("rotation ran while a backup held the stack lock")
+	case <-time.After(300 * time.Millisecond):
+	}
+	held.Release()
+
+	select {
+	case res := <-done:
+		if res == nil || res.Rotated != 1 {
+			t.Fatalf("unexpected result %+v", res)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("rotation did not resume after the lock was released")
+	}
+	if _, locked := lock.Default().Holder("app"); locked {
+		t.Fatal("rotation did not release the stack lock")
+	}
+}
@@ -0,0 +1,110 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/stacksnap/stacksnap/internal/signing"
+	"github.com/stacksnap/stacksnap/internal/storage"
+)
+
+type SignaturePolicy struct {
+	Trusted []ed25519.PublicKey
+	Strict  bool
+}
+
+func uploadSignature(ctx context.Context, provider storage.Provider, key string, sig *signing.Signature) error {
+	data, err := sig.Marshal()
+	if err != nil {
+		return err
+	}
+	return provider.Upload(ctx, key+signing.SignatureSuffix, bytes.NewReader(data))
+}
+
+func loadSignature(ctx context.Context, provider storage.Provider, key string) (*signing.Signature, error) {
+	if _, err := provider.Stat(ctx, key+signing.SignatureSuffix); err != nil {
+		return nil, signing.ErrUnsigned
+	}
+
+	rc, err := provider.Download(ctx, key+signing.SignatureSuffix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download signature: %w", err)
+	}
+	defer rc.Close()
+
+	data, err := io.ReadAll(io.LimitReader(rc, 64*1024))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signature: %w", err)
+	}
+	return signing.ParseSignature(data)
+}
+
+func VerifySignature(ctx context.Context, provider storage.Provider, inputPath string, trusted []ed25519.PublicKey) (*signing.Signature, error) {
+	p, k, err := resolveStorage(provider, inputPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open backup location: %w", err)
+	}
+
+	sig, err := loadSignature(ctx, p, k)
+	if err != nil {
+		return nil, err
+	}
+	if err := sig.Verify(trusted); err != nil {
+		return sig, err
+	}
+
+	rc, err := p.Download(ctx, k)
+	if err != nil {
+		return sig, fmt.Errorf("failed to open backup: %w", err)
+	}
+	defer rc.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(h, rc)
+	if err != nil {
+		return sig, fmt.Errorf("failed to read backup: %w", err)
+	}
+	return sig, sig.CheckArchive(h.Sum(nil), n)
+}
+
+// check verifies the signature of inputPath against the digest and size of
+// the copy that is about to be restored rather than downloading it again.
+func (p *SignaturePolicy) check(ctx context.Context, provider storage.Provider, inputPath string, digest []byte, size int64, log func(string, ...interface{})) error {
+	if p == nil {
+		return nil
+	}
+
+	sig, err := checkArchiveSignature(ctx, provider, inputPath, p.Trusted, digest, size)
+	switch {
+	case err == nil:
+		log(" Signature verified (key %s, signed %s)\n", sig.KeyID, sig.SignedAt.Format("2006-01-02 15:04"))
+		return nil
+	case p.Strict:
+		return fmt.Errorf("signature check failed: %w", err)
+	case errors.Is(err, signing.ErrUnsigned):
+		log(" Warning: backup is not signed\n")
+	default:
+		log(" Warning: signature check failed: %v\n", err)
+	}
+	return nil
+}
+
+func checkArchiveSignature(ctx context.Context, provider storage.Provider, inputPath string, trusted []ed25519.PublicKey, digest []byte, size int64) (*signing.Signature, error) {
+	p, k, err := resolveStorage(provider, inputPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open backup location: %w", err)
+	}
+	sig, err := loadSignature(ctx, p, k)
+	if err != nil {
+		return nil, err
+	}
+	if err := sig.Verify(trusted); err != nil {
+		return sig, err
+	}
+	return sig, sig.CheckArchive(digest, size)
+}
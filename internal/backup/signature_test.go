@@ -0,0 +1,114 @@
+package backup
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stacksnap/stacksnap/internal/signing"
+	"github.com/stacksnap/stacksnap/internal/storage"
+)
+
+type countingProvider struct {
+	storage.Provider
+	downloads map[string]int
+}
+
+func (p *countingProvider) Download(ctx context.Context, key string) (io.ReadCloser, error) {
+	p.downloads[key]++
+	return p.Provider.Download(ctx, key)
+}
+
+func TestCheckStackArchiveChecksSignature(t *testing.T) {
+	signer := newTestSigner(t)
+	other := newTestSigner(t)
+	const key = "app_20260101_120000.tar.gz"
+	a := testArchive{volumes: map[string][]byte{"data": volumeTar(t, map[string]string{"a": "1"})}}
+	data := a.bytes(t)
+	a.volumes["data"] = volumeTar(t, map[string]string{"a": "2"})
+	replacement := a.bytes(t)
+
+	tests := []struct {
+		name     string
+		signer   *signing.Signer
+		replaced bool
+		strict   bool
+		wantErr  error
+	}{
+		{"valid signature", signer, false, true, nil},
+		{"unsigned, strict", nil, false, true, signing.ErrUnsigned},
+		{"unsigned, lenient", nil, false, false, nil},
+		{"untrusted key", other, false, true, signing.ErrUntrustedKey},
+		{"replaced archive", signer, true, true, signing.ErrDigestMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := &countingProvider{Provider: newTestProvider(t), downloads: make(map[string]int)}
+			if tt.signer != nil {
+				signTestArchive(t, provider, tt.signer, key, data)
+			}
+			stored := data
+			if tt.replaced {
+				stored = replacement
+			}
+			uploadTestArchive(t, provider, key, stored, nil)
+
+			opts := StackRestoreOptions{
+				InputPath:       key,
+				StorageProvider: provider,
+				Signatures:      &SignaturePolicy{Trusted: []ed25519.PublicKey{signer.PublicKey()}, Strict: tt.strict},
+				Context:         t.Context(),
+			}
+			_, err := checkStackArchive(t.Context(), opts, key, func(string, ...interface{}) {})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got %v, want %v", err, tt.wantErr)
+			}
+			if n := provider.downloads[key]; n != 1 {
+				t.Fatalf("archive downloaded %d times, want 1", n)
+			}
+		})
+	}
+}
+
+func TestOpenCheckedArchiveDetectsReplacement(t *testing.T) {
+	const key = "app_20260101_120000.tar.gz"
+	a := testArchive{volumes: map[string][]byte{"data": volumeTar(t, map[string]string{"a": "1"})}}
+	data := a.bytes(t)
+	a.volumes["data"] = volumeTar(t, map[string]string{"a": "2"})
+	replacement := a.bytes(t)
+
+	tests := []struct {
+		name     string
+		replaced bool
+	}{
+		{"unchanged", false},
+		{"replaced after verification", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := newTestProvider(t)
+			uploadTestArchive(t, provider, key, data, nil)
+			opts := StackRestoreOptions{InputPath: key, StorageProvider: provider, Context: t.Context()}
+			checked, err := checkStackArchive(t.Context(), opts, key, func(string, ...interface{}) {})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.replaced {
+				uploadTestArchive(t, provider, key, replacement, nil)
+			}
+
+			_, archive, err := openCheckedArchive(t.Context(), opts, checked)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := io.Copy(io.Discard, archive); err != nil {
+				t.Fatal(err)
+			}
+			if err := archive.Close(); (err != nil) != tt.replaced {
+				t.Fatalf("got %v, want an error %v", err, tt.replaced)
+			}
+		})
+	}
+}
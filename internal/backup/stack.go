@@ -3,20 +3,26 @@ package backup
 
 import (
 	"archive/tar"
-	"compress/gzip"
 	"context"
+	"crypto/ecdh"
+	"crypto/sha256"
 	"encoding/json"
 	"fmt"
 	"io"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/stacksnap/stacksnap/internal/compose"
 	"github.com/stacksnap/stacksnap/internal/crypto"
 	"github.com/stacksnap/stacksnap/internal/database"
 	"github.com/stacksnap/stacksnap/internal/docker"
+	"github.com/stacksnap/stacksnap/internal/lock"
+	"github.com/stacksnap/stacksnap/internal/repo"
+	"github.com/stacksnap/stacksnap/internal/signing"
 	"github.com/stacksnap/stacksnap/internal/storage"
 )
 
@@ -28,24 +34,40 @@ type StackBackupOptions struct {
 	PauseContainers bool
 	IncludeDatabase bool
 	SnapshotImages bool
-
+	Mode            BackupMode
+	Parallel        ParallelConfig
+	Compression     Compression
 
 	StorageProvider storage.Provider
 	EncryptionKey  []byte
+	EncryptionKeyID string
+	Recipients      []*ecdh.PublicKey
+	Passphrase      string
+	KMS             crypto.KeyProvider
+	Repository      *repo.Repository
+	Signer          *signing.Signer
+	WaitForLock     bool
 	Context     context.Context
 	Logger     func(string)
+	Progress        func(Progress)
 }
 
 
 type StackBackupResult struct {
-	StackName    string
-	OutputPath    string
-	Size       int64
-	Duration     time.Duration
-	VolumesBackedUp []string
-	DatabasesDumped []string
-	PausedContainers int
-	Encrypted    bool
+	StackName        string        `json:"stack_name"`
+	OutputPath       string        `json:"output_path"`
+	Size             int64         `json:"size"`
+	Duration         time.Duration `json:"duration"`
+	VolumesBackedUp  []string      `json:"volumes_backed_up"`
+	VolumesFailed    []string      `json:"volumes_failed,omitempty"`
+	DatabasesDumped  []string      `json:"databases_dumped"`
+	PausedContainers int           `json:"paused_containers"`
+	Encrypted        bool          `json:"encrypted"`
+	Signed           bool          `json:"signed"`
+	Deduplicated     bool          `json:"deduplicated"`
+	StoredSize       int64         `json:"stored_size,omitempty"`
+	Mode             BackupMode    `json:"mode,omitempty"`
+	Parent           string        `json:"parent,omitempty"`
 }
 
 
@@ -54,6 +76,7 @@ type StackMetadata struct {
 	CreatedAt  time.Time `json:"created_at"`
 	ComposeFile string  `json:"compose_file"`
 	Volumes   []string `json:"volumes"`
+	FailedVolumes []string   `json:"failed_volumes,omitempty"`
 	Services   []string `json:"services"`
 	Databases  []string `json:"databases,omitempty"`
 	Secrets   []string `json:"secrets,omitempty"`
@@ -61,8 +84,20 @@ type StackMetadata struct {
 	Images    []string `json:"images,omitempty"`
 	StackSnapVer string  `json:"stacksnap_version"`
 	Encrypted  bool   `json:"encrypted"`
+	KeyID         string     `json:"key_id,omitempty"`
+	Recipients    []string   `json:"recipients,omitempty"`
+	KMSKey        string     `json:"kms_key,omitempty"`
+	Repository    string     `json:"repository,omitempty"`
+	Mode          BackupMode `json:"mode,omitempty"`
+	Parent        string     `json:"parent,omitempty"`
+	Compression   string     `json:"compression,omitempty"`
+	SigningKeyID  string     `json:"signing_key_id,omitempty"`
 }
 
+const (
+	MetadataStack     = "stacksnap-stack"
+	MetadataCreatedAt = "stacksnap-created-at"
+)
 
 func BackupStack(client *docker.Client, opts StackBackupOptions) (*StackBackupResult, error) {
 	startTime := time.Now()
@@ -71,18 +106,27 @@ func BackupStack(client *docker.Client, opts StackBackupOptions) (*StackBackupRe
 		ctx = context.Background()
 	}
 
+	cleanupClient := client
+	client = client.WithContext(ctx)
 
+	var logMu sync.Mutex
 	log := func(format string, args ...interface{}) {
 		msg := fmt.Sprintf(format, args...)
+		logMu.Lock()
+		defer logMu.Unlock()
 		fmt.Print(msg)
 		if opts.Logger != nil {
 			opts.Logger(msg)
 		}
 	}
 
+	report := func(phase string, processed, total int64) {
+		if opts.Progress != nil {
+			opts.Progress(Progress{Phase: phase, BytesProcessed: processed, BytesTotal: total})
+		}
+	}
 
-
-
+	report(PhasePreflight, 0, 0)
 
 	preflightResult := PreflightChecks(client, opts)
 	if len(preflightResult.Warnings) > 0 {
@@ -129,83 +173,184 @@ func BackupStack(client *docker.Client, opts StackBackupOptions) (*StackBackupRe
 		return nil, fmt.Errorf("either Directory or ProjectName must be provided for backup")
 	}
 
+	stackLock, err := acquireStackLock(ctx, stack.Name, "backup", opts.WaitForLock)
+	if err != nil {
+		return nil, err
+	}
+	defer stackLock.Release()
+
 	log(" Backing up stack: %s\n", stack.Name)
-	if opts.EncryptionKey != nil {
-		log(" Encryption enabled (AES-256-CTR)\n")
+	encrypted := opts.EncryptionKey != nil || len(opts.Recipients) > 0 || opts.Passphrase != "" || opts.KMS != nil
+	if opts.KMS != nil {
+		log(" Encryption enabled (AES-256-GCM, data key wrapped by %s)\n", opts.KMS.KeyID())
+	} else if opts.Passphrase != "" {
+		log(" Encryption enabled (passphrase, Argon2id)\n")
+	} else if len(opts.Recipients) > 0 {
+		log(" Encryption enabled (X25519, %d recipient(s))\n", len(opts.Recipients))
+	} else if opts.EncryptionKey != nil {
+		log(" Encryption enabled (AES-256-GCM)\n")
 	}
-	if opts.StorageProvider != nil {
+	if opts.Repository != nil {
+		if encrypted {
+			return nil, fmt.Errorf("repository snapshots are encrypted with the repository key; do not set another encryption mode")
+		}
+		if opts.Signer != nil {
+			return nil, fmt.Errorf("repository snapshots cannot be signed; drop the signing key or back up without a repository")
+		}
+		log(" Writing deduplicated snapshot to repository %s\n", opts.Repository.Config().ID)
+	} else if opts.StorageProvider != nil {
 		log(" Uploading to remote storage\n")
 	}
 
-
-	timestamp := time.Now().Format("20060102_150405")
-	filename := fmt.Sprintf("%s_%s.tar.gz", stack.Name, timestamp)
-	if opts.EncryptionKey != nil {
+	createdAt := time.Now()
+	timestamp := createdAt.Format(backupTimestampFormat)
+	filename := stack.Name + "_" + timestamp + opts.Compression.Extension()
+	if encrypted {
 		filename += ".enc"
 	}
+	if opts.Repository != nil {
+		filename = fmt.Sprintf("%s_%s", stack.Name, timestamp)
+	}
 
-
-
-	var finalWriter io.WriteCloser
-	var uploadErrCh chan error
-
-	if opts.StorageProvider != nil {
-
-
-		pr, pw := io.Pipe()
-		finalWriter = pw
-
-		uploadErrCh = make(chan error, 1)
-		go func() {
-			log(" Starting upload to: %s\n", filename)
-			err := opts.StorageProvider.Upload(ctx, filename, pr)
-			if err != nil {
-				log(" Upload failed: %v\n", err)
-			} else {
-				log(" Upload complete\n")
-			}
-
-			io.Copy(io.Discard, pr)
-			uploadErrCh <- err
-		}()
-	} else {
-
+	provider := opts.StorageProvider
+	uploadKey := filename
+	if provider == nil && opts.Repository == nil {
 		outputPath := opts.OutputPath
 		if outputPath == "" {
 			outputPath = filename
 		}
-		f, err := os.Create(outputPath)
+		p, k, err := resolveStorage(nil, outputPath)
 		if err != nil {
 			return nil, fmt.Errorf("failed to create output file: %w", err)
 		}
-		finalWriter = f
+		provider, uploadKey = p, k
 		filename = outputPath
 	}
 
+	mode := opts.Mode
+	var parent *BackupIndex
+	var index *BackupIndex
+	if mode != "" {
+		if opts.Repository != nil {
+			return nil, fmt.Errorf("%s backups are not supported with a repository, which already deduplicates", mode)
+		}
+		if mode != ModeFull {
+			parent = findParentIndex(ctx, opts.StorageProvider, stack.Name, mode)
+			if parent == nil {
+				log(" No earlier backup with a file index found, taking a full backup\n")
+				mode = ModeFull
+			} else {
+				log(" Taking %s backup on top of %s\n", mode, parent.Key)
+			}
+		}
+		index = &BackupIndex{
+			Version:   IndexVersion,
+			Stack:     stack.Name,
+			Key:       filename,
+			Mode:      mode,
+			CreatedAt: createdAt.UTC(),
+			Volumes:   make(map[string][]FileEntry),
+		}
+		if parent != nil {
+			index.Parent = parent.Key
+		}
+	}
 
+	pr, pw := io.Pipe()
+	var finalWriter io.WriteCloser = pw
+	archiveHash := sha256.New()
+	uploaded := &countingReader{r: io.TeeReader(pr, archiveHash)}
 
+	var snapshot *repo.Snapshot
+	uploadErrCh := make(chan error, 1)
+	go func() {
+		if opts.StorageProvider != nil {
+			log(" Starting upload to: %s\n", filename)
+		}
+		meta := map[string]string{
+			MetadataStack:     stack.Name,
+			MetadataCreatedAt: createdAt.UTC().Format(time.RFC3339),
+		}
+		if opts.KMS != nil {
+			meta[MetadataKMSKey] = opts.KMS.KeyID()
+		} else if len(opts.Recipients) > 0 {
+			meta[MetadataRecipients] = recipientFingerprints(opts.Recipients)
+		} else if opts.EncryptionKey != nil && opts.EncryptionKeyID != "" {
+			meta[MetadataKeyID] = opts.EncryptionKeyID
+		}
+		if index != nil {
+			meta[MetadataMode] = string(mode)
+		}
+		if parent != nil {
+			meta[MetadataParent] = path.Base(parent.Key)
+		}
+		if opts.Repository == nil {
+			meta[MetadataCompression] = opts.Compression.String()
+		}
+		var err error
+		if opts.Repository != nil {
+			snapshot, err = opts.Repository.Store(ctx, filename, uploaded, meta)
+		} else {
+			err = provider.Upload(ctx, uploadKey, uploaded, storage.WithMetadata(meta))
+		}
+		if err != nil {
+			log(" Upload failed: %v\n", err)
+		} else if opts.StorageProvider != nil {
+			log(" Upload complete\n")
+		}
 
+		io.Copy(io.Discard, pr)
+		uploadErrCh <- err
+	}()
 
-	var outputStream io.WriteCloser = finalWriter
+	var compressor io.WriteCloser
+	abort := func(err error) error {
+		pw.CloseWithError(err)
+		if compressor != nil {
+			abortCompressor(compressor)
+		}
+		<-uploadErrCh
+		return err
+	}
 
+	cancelled := func() error {
+		if err := ctx.Err(); err != nil {
+			log(" Backup cancelled\n")
+			return abort(fmt.Errorf("backup cancelled: %w", err))
+		}
+		return nil
+	}
+
+	var outputStream io.WriteCloser = finalWriter
 
-	if opts.EncryptionKey != nil {
-		encWriter, err := crypto.NewEncryptWriter(opts.EncryptionKey, outputStream)
+	if encrypted {
+		var encWriter io.WriteCloser
+		switch {
+		case opts.KMS != nil:
+			encWriter, err = crypto.NewEncryptWriterKMS(ctx, opts.KMS, outputStream)
+		case opts.Passphrase != "":
+			encWriter, err = crypto.NewEncryptWriterPassphrase(opts.Passphrase, outputStream)
+		case len(opts.Recipients) > 0:
+			encWriter, err = crypto.NewEncryptWriterX25519(opts.Recipients, outputStream)
+		default:
+			encWriter, err = crypto.NewEncryptWriter(opts.EncryptionKey, outputStream)
+		}
 		if err != nil {
-			finalWriter.Close()
-			return nil, fmt.Errorf("failed to create encryption writer: %w", err)
+			return nil, abort(fmt.Errorf("failed to create encryption writer: %w", err))
 		}
 		outputStream = encWriter
 	}
 
+	var gzWriter io.WriteCloser = nopWriteCloser{outputStream}
+	if opts.Repository == nil {
+		gzWriter, err = newArchiveCompressor(outputStream, opts.Compression, opts.Parallel)
+		if err != nil {
+			return nil, abort(fmt.Errorf("failed to start compression: %w", err))
+		}
+		compressor = gzWriter
+	}
 
-	gzWriter := gzip.NewWriter(outputStream)
-
-
-	tarWriter := tar.NewWriter(gzWriter)
-
-
-
+	tarWriter := newManifestWriter(tar.NewWriter(gzWriter))
 
 	allContainers, err := client.ListContainersForProject(stack.Name)
 	if err != nil {
@@ -262,9 +407,9 @@ func BackupStack(client *docker.Client, opts StackBackupOptions) (*StackBackupRe
 				if err := client.PauseContainer(ctr.ID); err != nil {
 
 					for _, id := range pausedContainers {
-						client.UnpauseContainer(id)
+						cleanupClient.UnpauseContainer(id)
 					}
-					return nil, fmt.Errorf("failed to pause container %s: %w", ctr.Name, err)
+					return nil, abort(fmt.Errorf("failed to pause container %s: %w", ctr.Name, err))
 				}
 				pausedContainers = append(pausedContainers, ctr.ID)
 			}
@@ -274,7 +419,7 @@ func BackupStack(client *docker.Client, opts StackBackupOptions) (*StackBackupRe
 		defer func() {
 			for _, id := range pausedContainers {
 				log(" Resuming container...\n")
-				client.UnpauseContainer(id)
+				cleanupClient.UnpauseContainer(id)
 			}
 		}()
 
@@ -285,9 +430,13 @@ func BackupStack(client *docker.Client, opts StackBackupOptions) (*StackBackupRe
 		}
 	}
 
+	if err := cancelled(); err != nil {
+		return nil, err
+	}
 
 	var backedUpImages []string
 	if opts.SnapshotImages {
+		report(PhaseSnapshot, 0, 0)
 		log(" Creating container snapshots...\n")
 
 		imgTmpDir, err := os.MkdirTemp("", "stacksnap-images")
@@ -347,9 +496,13 @@ func BackupStack(client *docker.Client, opts StackBackupOptions) (*StackBackupRe
 		}
 	}
 
+	if err := cancelled(); err != nil {
+		return nil, err
+	}
 
 	var databasesDumped []string
 	if opts.IncludeDatabase {
+		report(PhaseDatabase, 0, 0)
 		for _, ctr := range allContainers {
 			dbInfo, err := database.DetectDatabase(client, ctr.ID)
 			if err != nil || dbInfo.Type == database.DatabaseUnknown {
@@ -431,86 +584,118 @@ func BackupStack(client *docker.Client, opts StackBackupOptions) (*StackBackupRe
 		}
 	}
 
-
 	var volumesBackedUp []string
+	var volumesFailed []string
+	var volumeBytes int64
+	report(PhaseVolumes, 0, 0)
+
+	volumeCtx, stopVolumes := context.WithCancel(ctx)
+	defer stopVolumes()
+	var volumeNames []string
+	dumps := make(map[string]*volumeDump)
 	for _, volName := range stack.NamedVolumes {
-		log(" Backing up volume %s...\n", volName)
+		if dumps[volName] == nil {
+			dumps[volName] = newVolumeDump(volumeCtx, volName)
+			volumeNames = append(volumeNames, volName)
+		}
+	}
+
+	dumpVolume := func(d *volumeDump) error {
+		log(" Backing up volume %s...\n", d.name)
+		volClient := client.WithContext(d.ctx)
+
+		var err error
+		if index != nil {
+			d.files, err = volClient.ListVolumeFiles(d.name)
+			if err != nil {
+				return fmt.Errorf("failed to list files: %w", err)
+			}
+		}
+		var layered bool
+		d.prev, layered = parent.files(d.name)
+		var changed, deleted []string
+		if layered {
+			changed, deleted = diffVolume(d.prev, d.files)
+			log("  %s: %d changed, %d deleted since %s\n", d.name, len(changed), len(deleted), path.Base(parent.Key))
+		}
+
+		if len(deleted) > 0 {
+			if err := d.send(volumeEvent{deleted: deleted}); err != nil {
+				return err
+			}
+		}
 
 		pr, pw := io.Pipe()
 
 		errCh := make(chan error, 1)
 		go func() {
-			err := client.BackupVolume(volName, pw)
+			var err error
+			if layered {
+				err = volClient.BackupVolumeFiles(d.name, changed, pw)
+			} else {
+				err = volClient.BackupVolume(d.name, pw)
+			}
 			pw.CloseWithError(err)
 			errCh <- err
 		}()
 
-
-
-
-
-
-
-
-
-
-
-
-
-
-
-
-
-
-
-
-
-
-
-		tempFile, err := os.CreateTemp("", "stacksnap-vol-*.tar")
+		segments := newSegmenter(func(data []byte, last bool) error {
+			return d.send(volumeEvent{data: data, last: last})
+		})
+		if index != nil {
+			d.size, d.hashes, err = hashTar(segments, pr)
+		} else {
+			d.size, err = io.Copy(segments, pr)
+		}
 		if err != nil {
-			log(" Failed to create temp file: %v\n", err)
-			continue
+			pr.CloseWithError(err)
 		}
 
-
-		size, err := io.Copy(tempFile, pr)
-
-		backupErr := <-errCh
-		if backupErr != nil {
-			tempFile.Close()
-			os.Remove(tempFile.Name())
-			log(" Failed to backup volume %s: %v\n", volName, backupErr)
-			continue
+		if backupErr := <-errCh; backupErr != nil {
+			return backupErr
 		}
+		if err != nil {
+			return err
+		}
+		return segments.Close()
+	}
 
+	go ParallelVolumeBackup(volumeNames, func(volName string) (*VolumeBackupJob, error) {
+		d := dumps[volName]
+		err := dumpVolume(d)
+		d.finish(err)
+		return &VolumeBackupJob{VolumeName: volName, Size: d.size, Error: err}, err
+	}, opts.Parallel, nil)
 
-		tempFile.Seek(0, 0)
-
-		header := &tar.Header{
-			Name:  filepath.Join("volumes", volName+".tar"),
-			Size:  size,
-			Mode:  0644,
-			ModTime: time.Now(),
+	for _, volName := range volumeNames {
+		if err := cancelled(); err != nil {
+			return nil, err
 		}
 
-		if err := tarWriter.WriteHeader(header); err != nil {
-			tempFile.Close()
-			os.Remove(tempFile.Name())
-			log(" Failed to write header: %v\n", err)
-			continue
+		d := dumps[volName]
+		if err := writeVolumeDump(tarWriter, d); err != nil {
+			return nil, abort(fmt.Errorf("failed to write volume %s: %w", volName, err))
 		}
-
-		if _, err := io.Copy(tarWriter, tempFile); err != nil {
-			tempFile.Close()
-			os.Remove(tempFile.Name())
-			log(" Failed to copy volume data: %v\n", err)
+		if d.err != nil {
+			log(" Failed to backup volume %s: %v\n", volName, d.err)
+			volumesFailed = append(volumesFailed, volName)
 			continue
 		}
 
-		tempFile.Close()
-		os.Remove(tempFile.Name())
+		if index != nil {
+			index.Volumes[volName] = volumeEntries(d.files, d.prev, d.hashes)
+			indexJSON, _ := json.Marshal(index.Volumes[volName])
+			if err := addToTar(tarWriter, path.Join("volumes", volName+indexSuffix), indexJSON); err != nil {
+				log(" Failed to write file index for %s: %v\n", volName, err)
+			}
+		}
 		volumesBackedUp = append(volumesBackedUp, volName)
+		volumeBytes += d.size
+		report(PhaseVolumes, volumeBytes, 0)
+	}
+
+	if err := cancelled(); err != nil {
+		return nil, err
 	}
 
 	var metadataSecrets []string
@@ -534,49 +719,86 @@ func BackupStack(client *docker.Client, opts StackBackupOptions) (*StackBackupRe
 		CreatedAt:  time.Now(),
 		ComposeFile: filepath.Base(stack.ComposeFile),
 		Volumes:   volumesBackedUp,
+		FailedVolumes: volumesFailed,
 		Services:   serviceNames,
 		Databases:  databasesDumped,
 		Secrets:   metadataSecrets,
 		BuildFiles:  metadataBuildFiles,
 		Images:    backedUpImages,
 		StackSnapVer: "1.0",
-		Encrypted:  opts.EncryptionKey != nil,
+		Encrypted:     encrypted,
+		KeyID:         opts.EncryptionKeyID,
+		Recipients:    recipientList(opts.Recipients),
+		KMSKey:        kmsKeyID(opts.KMS),
 	}
+	if opts.Signer != nil {
+		metadata.SigningKeyID = opts.Signer.KeyID()
+	}
+	if index != nil {
+		metadata.Mode = mode
+	}
+	if parent != nil {
+		metadata.Parent = path.Base(parent.Key)
+	}
+	if opts.Repository == nil {
+		metadata.Compression = opts.Compression.String()
+	}
+	if opts.Repository != nil {
+		metadata.Encrypted = opts.Repository.Encrypted()
+		metadata.Repository = opts.Repository.Config().ID
+	}
+	report(PhaseFinalize, volumeBytes, 0)
 	metadataJSON, _ := json.MarshalIndent(metadata, "", " ")
 	addToTar(tarWriter, "metadata.json", metadataJSON)
 
+	if err := tarWriter.Close(); err != nil {
+		return nil, abort(err)
+	}
+	err = gzWriter.Close()
+	compressor = nil
+	if err != nil {
+		return nil, abort(fmt.Errorf("failed to finish compression: %w", err))
+	}
 
-	tarWriter.Close()
-	gzWriter.Close()
-
-	if opts.EncryptionKey != nil {
+	if encrypted {
 		outputStream.Close()
 	}
 
 	finalWriter.Close()
 
+	if err := <-uploadErrCh; err != nil {
+		return nil, fmt.Errorf("upload failed: %w", err)
+	}
 
-	if uploadErrCh != nil {
-		err := <-uploadErrCh
-		if err != nil {
-			return nil, fmt.Errorf("upload failed: %w", err)
+	finalSize := uploaded.n
+
+	if index != nil {
+		if err := saveIndex(index); err != nil {
+			log(" Warning: failed to save file index, the next incremental backup will be a full one: %v\n", err)
 		}
 	}
 
-	duration := time.Since(startTime)
-	var finalSize int64 = 0
-	if f, ok := finalWriter.(*os.File); ok {
-		stat, err := f.Stat()
-		if err == nil {
-			finalSize = stat.Size()
-		} else {
-
-			if info, err := os.Stat(f.Name()); err == nil {
-				finalSize = info.Size()
-			}
+	if opts.Signer != nil {
+		metadataHash := sha256.Sum256(metadataJSON)
+		sig := opts.Signer.Sign(archiveHash.Sum(nil), finalSize, metadataHash[:])
+		if err := uploadSignature(ctx, provider, uploadKey, sig); err != nil {
+			return nil, fmt.Errorf("failed to upload signature: %w", err)
 		}
+		log(" Signed with key %s\n", sig.KeyID)
+	}
+
+	var storedSize int64
+	if snapshot != nil {
+		storedSize = snapshot.Stats.StoredBytes
+		log(" Stored %d new chunks (%.2f MB), reused %d of %d\n",
+			snapshot.Stats.NewChunks, float64(storedSize)/(1024*1024), snapshot.Stats.ReusedChunks, snapshot.Stats.Chunks)
 	}
 
+	duration := time.Since(startTime)
+
+	if len(volumesFailed) > 0 {
+		log(" Warning: volume(s) %s failed and are not in this backup\n", strings.Join(volumesFailed, ", "))
+	}
 	log(" Stack backup complete: %s (Duration: %s)\n",
 		filename,
 		duration.Round(time.Millisecond))
@@ -587,14 +809,25 @@ func BackupStack(client *docker.Client, opts StackBackupOptions) (*StackBackupRe
 		Size:       finalSize,
 		Duration:     duration,
 		VolumesBackedUp: volumesBackedUp,
+		VolumesFailed:    volumesFailed,
 		DatabasesDumped: databasesDumped,
 		PausedContainers: len(pausedContainers),
-		Encrypted:    opts.EncryptionKey != nil,
+		Encrypted:        encrypted,
+		Signed:           opts.Signer != nil,
+		Deduplicated:     snapshot != nil,
+		StoredSize:       storedSize,
+		Mode:             metadata.Mode,
+		Parent:           metadata.Parent,
 	}, nil
 }
 
+type nopWriteCloser struct {
+	io.Writer
+}
 
-func addToTar(tw *tar.Writer, name string, data []byte) error {
+func (nopWriteCloser) Close() error { return nil }
+
+func addToTar(tw entryWriter, name string, data []byte) error {
 	header := &tar.Header{
 		Name:  name,
 		Size:  int64(len(data)),
@@ -609,3 +842,12 @@ func addToTar(tw *tar.Writer, name string, data []byte) error {
 	_, err := tw.Write(data)
 	return err
 }
+
+// acquireStackLock takes the stack's lock for operation. With wait set it
+// queues behind whatever holds the lock instead of failing.
+func acquireStackLock(ctx context.Context, stack, operation string, wait bool) (*lock.Lock, error) {
+	if wait {
+		return lock.Default().Acquire(ctx, stack, operation)
+	}
+	return lock.Default().TryAcquire(stack, operation)
+}
@@ -2,17 +2,26 @@ package backup
 
 import (
 	"archive/tar"
-	"compress/gzip"
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
+	"slices"
+	"sort"
 	"strings"
 
 	"github.com/stacksnap/stacksnap/internal/crypto"
 	"github.com/stacksnap/stacksnap/internal/docker"
+	"github.com/stacksnap/stacksnap/internal/keyring"
+	"github.com/stacksnap/stacksnap/internal/repo"
 	"github.com/stacksnap/stacksnap/internal/storage"
 )
 
@@ -22,8 +31,14 @@ type StackRestoreOptions struct {
 	InputPath    string
 	StorageProvider storage.Provider
 	EncryptionKey  []byte
+	Keyring         *keyring.Keyring
+	KMS             crypto.KeyProvider
+	Repository      *repo.Repository
+	Signatures      *SignaturePolicy
+	WaitForLock     bool
 	Context     context.Context
 	Logger     func(string)
+	Progress        func(Progress)
 }
 
 
@@ -33,6 +48,8 @@ func RestoreStack(client *docker.Client, opts StackRestoreOptions) error {
 		ctx = context.Background()
 	}
 
+	cleanupClient := client
+	client = client.WithContext(ctx)
 
 	log := func(format string, args ...interface{}) {
 		msg := fmt.Sprintf(format, args...)
@@ -42,46 +59,96 @@ func RestoreStack(client *docker.Client, opts StackRestoreOptions) error {
 		}
 	}
 
+	report := func(phase string, processed, total int64) {
+		if opts.Progress != nil {
+			opts.Progress(Progress{Phase: phase, BytesProcessed: processed, BytesTotal: total})
+		}
+	}
+
+	lockName := opts.StackName
+	if lockName == "" && opts.Repository != nil {
+		lockName, _, _ = ParseSnapshotID(opts.InputPath)
+	} else if lockName == "" {
+		lockName, _, _ = ParseBackupKey(opts.InputPath)
+	}
+	if lockName != "" {
+		stackLock, err := acquireStackLock(ctx, lockName, "restore", opts.WaitForLock)
+		if err != nil {
+			return err
+		}
+		defer stackLock.Release()
+	}
+
 	log(" Restoring stack %s from %s...\n", opts.StackName, opts.InputPath)
 
+	if opts.Repository != nil {
+		if opts.Signatures != nil && opts.Signatures.Strict {
+			return fmt.Errorf("repository snapshots are not signed")
+		}
+	}
 
-	var reader io.ReadCloser
-	var err error
+	var archive io.Reader
+	var downloaded *countingReader
+	var totalSize int64
+	var layers []*checkedArchive
+	var reports []*volumeReport
 
-	if opts.StorageProvider != nil {
-		log(" Downloading from remote storage...\n")
-		reader, err = opts.StorageProvider.Download(ctx, opts.InputPath)
+	if opts.Repository != nil {
+		snapshot, err := opts.Repository.LoadSnapshot(ctx, opts.InputPath)
 		if err != nil {
-			return fmt.Errorf("failed to download backup: %w", err)
+			return fmt.Errorf("failed to open snapshot: %w", err)
 		}
-	} else {
-		reader, err = os.Open(opts.InputPath)
+		totalSize = snapshot.Stats.TotalBytes
+		report(PhaseDownload, 0, totalSize)
+
+		check := opts.Repository.ArchiveReader(ctx, snapshot)
+		volumes, err := verifyArchive(check)
+		check.Close()
 		if err != nil {
-			return fmt.Errorf("failed to open backup file: %w", err)
+			return fmt.Errorf("snapshot %s failed verification, nothing was restored from it: %w", opts.InputPath, err)
 		}
-	}
-	defer reader.Close()
+		reports = append(reports, volumes)
 
-
-	var input io.Reader = reader
-	if opts.EncryptionKey != nil {
-		log(" Decrypting parameters...\n")
-		decReader, err := crypto.NewDecryptReader(opts.EncryptionKey, reader)
+		reader := opts.Repository.ArchiveReader(ctx, snapshot)
+		defer reader.Close()
+		downloaded = &countingReader{r: reader}
+		archive = downloaded
+	} else {
+		chain, err := resolveChain(ctx, opts.StorageProvider, opts.InputPath)
 		if err != nil {
-			return fmt.Errorf("failed to create decryption reader: %w", err)
+			return err
+		}
+		if len(chain) > 1 {
+			log(" Backup is part of a chain, replaying %d earlier backup(s) first\n", len(chain)-1)
 		}
-		input = decReader
-	}
 
+		if opts.StorageProvider != nil {
+			log(" Downloading from remote storage...\n")
+		}
+		if p, k, err := resolveStorage(opts.StorageProvider, opts.InputPath); err == nil {
+			if info, err := p.Stat(ctx, k); err == nil {
+				totalSize = info.Size
+			}
+		}
 
-	gzReader, err := gzip.NewReader(input)
-	if err != nil {
-		return fmt.Errorf("failed to create gzip reader: %w", err)
+		// Every layer is downloaded and checked before any container is
+		// stopped, so a bad signature never leaves a half-restored stack.
+		report(PhaseDownload, 0, totalSize)
+		for _, key := range chain {
+			checked, err := checkStackArchive(ctx, opts, key, log)
+			if err != nil {
+				return err
+			}
+			layers = append(layers, checked)
+			reports = append(reports, checked.volumes)
+		}
 	}
-	defer gzReader.Close()
-
-
 
+	skips := chainSkips(reports)
+	skip := skips[len(skips)-1]
+	for _, volName := range skip.names(false) {
+		log(" Skipping volume %s: %s\n", volName, skip[volName].reason)
+	}
 
 	var restartedContainers []string
 	serviceToImage := make(map[string]string)
@@ -155,20 +222,54 @@ func RestoreStack(client *docker.Client, opts StackRestoreOptions) error {
 		if !recreated {
 			for _, id := range restartedContainers {
 				log(" Restarting container %s after restore (legacy restart)...\n", id)
-				if err := client.StartContainer(id); err != nil {
+				if err := cleanupClient.StartContainer(id); err != nil {
 					log(" Warning: failed to restart container %s: %v\n", id, err)
 				}
 			}
 		}
 	}()
 
-	log(" Restoring volume from archive...\n")
+	var leaf *checkedStream
+	if len(layers) > 0 {
+		for i, layer := range layers[:len(layers)-1] {
+			log(" Replaying volumes from %s...\n", layer.key)
+			for _, volName := range skips[i].names(false) {
+				if _, skipped := skip[volName]; !skipped {
+					log(" Skipping volume %s, a later backup holds all of it: %s\n", volName, skips[i][volName].reason)
+				}
+			}
+			_, stream, err := openCheckedArchive(ctx, opts, layer)
+			if err == nil {
+				err = restoreVolumeLayer(ctx, client, stream, skips[i], log)
+				if closeErr := stream.Close(); err == nil {
+					err = closeErr
+				}
+			}
+			if err != nil {
+				return fmt.Errorf("failed to replay %s: %w", layer.key, err)
+			}
+		}
 
+		var err error
+		downloaded, leaf, err = openCheckedArchive(ctx, opts, layers[len(layers)-1])
+		if err != nil {
+			return err
+		}
+		defer leaf.Close()
+		archive = leaf
+	}
+
+	log(" Restoring volume from archive...\n")
 
-	tarReader := tar.NewReader(gzReader)
+	tarReader := newManifestReader(tar.NewReader(archive))
 	foundVolumes := 0
 
 	for {
+		if err := ctx.Err(); err != nil {
+			log(" Restore cancelled\n")
+			return fmt.Errorf("restore cancelled: %w", err)
+		}
+
 		header, err := tarReader.Next()
 		if err == io.EOF {
 			break
@@ -178,6 +279,10 @@ func RestoreStack(client *docker.Client, opts StackRestoreOptions) error {
 		}
 
 
+		if _, skipped := skip[entryVolume(header.Name)]; skipped {
+			continue
+		}
+
 		if strings.HasPrefix(header.Name, "volumes/") && strings.HasSuffix(header.Name, ".tar") {
 
 			baseName := filepath.Base(header.Name)
@@ -185,21 +290,7 @@ func RestoreStack(client *docker.Client, opts StackRestoreOptions) error {
 
 			log(" Restoring volume: %s (Size: %d bytes)\n", volName, header.Size)
 
-
-
-
-
-
-
-
-
-
-
-
-
-
-
-
+			report(PhaseRestore, downloaded.n, totalSize)
 			err := client.RestoreVolume(volName, tarReader)
 			if err != nil {
 				log(" Failed to restore volume %s: %v\n", volName, err)
@@ -207,6 +298,35 @@ func RestoreStack(client *docker.Client, opts StackRestoreOptions) error {
 				log(" Volume %s restored\n", volName)
 				foundVolumes++
 			}
+			report(PhaseRestore, downloaded.n, totalSize)
+		} else if volName, n, ok := parseSegment(header.Name); ok {
+			if n != 0 {
+				log(" Skipping stray volume segment %s\n", header.Name)
+				continue
+			}
+			segments, err := newSegmentReader(tarReader, header)
+			if err != nil {
+				return err
+			}
+
+			log(" Restoring volume: %s (streamed)\n", volName)
+			report(PhaseRestore, downloaded.n, totalSize)
+			err = client.RestoreVolume(volName, segments)
+			if _, drainErr := io.Copy(io.Discard, segments); err == nil {
+				err = drainErr
+			}
+			if err != nil {
+				log(" Failed to restore volume %s: %v\n", volName, err)
+			} else {
+				log(" Volume %s restored\n", volName)
+				foundVolumes++
+			}
+			report(PhaseRestore, downloaded.n, totalSize)
+		} else if strings.HasPrefix(header.Name, "volumes/") && strings.HasSuffix(header.Name, deletedSuffix) {
+			volName := strings.TrimSuffix(filepath.Base(header.Name), deletedSuffix)
+			if err := applyDeletions(client, volName, tarReader, log); err != nil {
+				log(" Failed to apply deletions to volume %s: %v\n", volName, err)
+			}
 		} else if strings.HasPrefix(header.Name, "images/") && strings.HasSuffix(header.Name, ".tar") {
 
 			log(" Restoring snapshot image: %s...\n", header.Name)
@@ -244,11 +364,11 @@ func RestoreStack(client *docker.Client, opts StackRestoreOptions) error {
 
 
 				filterPattern := fmt.Sprintf("stacksnap-backup-%s", serviceName)
-				out, err := exec.Command("docker", "images", "--format", "{{.Repository}}:{{.Tag}}", "--filter", fmt.Sprintf("reference=%s:*", filterPattern)).Output()
+				out, err := exec.CommandContext(ctx, "docker", "images", "--format", "{{.Repository}}:{{.Tag}}", "--filter", fmt.Sprintf("reference=%s:*", filterPattern)).Output()
 
 				if err != nil {
 
-					out, _ = exec.Command("bash", "-c", fmt.Sprintf("docker images --format '{{.Repository}}:{{.Tag}}' | grep 'stacksnap-backup-%s:' | head -1", serviceName)).Output()
+					out, _ = exec.CommandContext(ctx, "bash", "-c", fmt.Sprintf("docker images --format '{{.Repository}}:{{.Tag}}' | grep 'stacksnap-backup-%s:' | head -1", serviceName)).Output()
 				}
 
 				sourceTag := strings.TrimSpace(string(out))
@@ -265,7 +385,7 @@ func RestoreStack(client *docker.Client, opts StackRestoreOptions) error {
 					}
 				} else {
 
-					debugOut, _ := exec.Command("docker", "images", "--format", "{{.Repository}}:{{.Tag}}", "--filter", "reference=stacksnap-backup*").Output()
+					debugOut, _ := exec.CommandContext(ctx, "docker", "images", "--format", "{{.Repository}}:{{.Tag}}", "--filter", "reference=stacksnap-backup*").Output()
 					log(" Could not find loaded image for %s\n", serviceName)
 					if len(debugOut) > 0 {
 						log("  Available backup images: %s\n", strings.TrimSpace(string(debugOut)))
@@ -279,6 +399,27 @@ func RestoreStack(client *docker.Client, opts StackRestoreOptions) error {
 
 	}
 
+	switch err := verifyRestored(tarReader, skip); {
+	case errors.Is(err, ErrNoManifest):
+		log(" No manifest in archive, skipping checksum verification\n")
+	case err != nil:
+		log(" Checksum verification failed: %v\n", err)
+		return fmt.Errorf("archive failed checksum verification: %w", err)
+	default:
+		log(" Checksums verified for %d entries\n", tarReader.Entries())
+	}
+	if leaf != nil {
+		if err := leaf.Close(); err != nil {
+			return err
+		}
+	}
+	if damaged := skip.names(true); len(damaged) > 0 {
+		return fmt.Errorf("volume(s) %s are damaged and were not restored", strings.Join(damaged, ", "))
+	}
+
+	if foundVolumes == 0 && len(skip) > 0 {
+		return fmt.Errorf("none of the volumes in the backup could be restored")
+	}
 	if foundVolumes == 0 {
 		return fmt.Errorf("no volumes found in backup archive (is this a valid stack backup?)")
 	}
@@ -287,6 +428,375 @@ func RestoreStack(client *docker.Client, opts StackRestoreOptions) error {
 	return nil
 }
 
+// checkedArchive is what the verification pass learned about a backup.
+// The restore pass downloads the backup again and must see the same bytes.
+type checkedArchive struct {
+	key           string
+	encryptionKey []byte
+	digest        []byte
+	size          int64
+	volumes       *volumeReport
+}
+
+// checkStackArchive downloads a backup once, hashing it while the archive
+// is decrypted and checked end to end, then checks the signature against
+// that digest. Nothing is kept on disk; openCheckedArchive reads it again.
+func checkStackArchive(ctx context.Context, opts StackRestoreOptions, key string, log func(string, ...interface{})) (*checkedArchive, error) {
+	encryptionKey := opts.EncryptionKey
+	if opts.Keyring != nil && (encryptionKey == nil || key != opts.InputPath) {
+		k, keyID, err := ResolveKey(ctx, opts.StorageProvider, key, opts.Keyring)
+		if err != nil && !(opts.KMS != nil && errors.Is(err, crypto.ErrKMSRequired)) && encryptionKey == nil {
+			return nil, fmt.Errorf("failed to find decryption key: %w", err)
+		}
+		if k != nil {
+			log(" Using key %s from keyring\n", keyID)
+			encryptionKey = k
+		}
+	}
+
+	reader, err := openBackup(ctx, opts.StorageProvider, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open backup: %w", err)
+	}
+	defer reader.Close()
+
+	if encryptionKey != nil || opts.KMS != nil {
+		log(" Decrypting parameters...\n")
+	}
+
+	h := sha256.New()
+	downloaded := &countingReader{r: io.TeeReader(reader, h)}
+	var volumes *volumeReport
+	archive, err := openArchiveStream(ctx, downloaded, encryptionKey, opts.KMS)
+	if err == nil {
+		volumes, err = verifyArchive(archive)
+		archive.Close()
+	}
+	// The rest of the download is hashed even when verification failed, so
+	// a signature problem is reported ahead of the damage it explains.
+	if _, drainErr := io.Copy(io.Discard, downloaded); drainErr != nil {
+		return nil, fmt.Errorf("failed to download backup: %w", drainErr)
+	}
+
+	checked := &checkedArchive{key: key, encryptionKey: encryptionKey, digest: h.Sum(nil), size: downloaded.n, volumes: volumes}
+	if err := opts.Signatures.check(ctx, opts.StorageProvider, key, checked.digest, checked.size, log); err != nil {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s failed verification, nothing was restored from it: %w", key, err)
+	}
+	return checked, nil
+}
+
+// openCheckedArchive downloads a checked backup again for restoring. The
+// returned archive's Close reports an error if the download differed from
+// the one checkStackArchive verified.
+func openCheckedArchive(ctx context.Context, opts StackRestoreOptions, checked *checkedArchive) (*countingReader, *checkedStream, error) {
+	reader, err := openBackup(ctx, opts.StorageProvider, checked.key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open backup: %w", err)
+	}
+
+	h := sha256.New()
+	downloaded := &countingReader{r: io.TeeReader(reader, h)}
+	archive, err := openArchiveStream(ctx, downloaded, checked.encryptionKey, opts.KMS)
+	if err != nil {
+		reader.Close()
+		return nil, nil, err
+	}
+	return downloaded, &checkedStream{ReadCloser: archive, body: reader, downloaded: downloaded, hash: h, checked: checked}, nil
+}
+
+type checkedStream struct {
+	io.ReadCloser
+	body       io.Closer
+	downloaded *countingReader
+	hash       hash.Hash
+	checked    *checkedArchive
+	closed     bool
+	err        error
+}
+
+func (s *checkedStream) Close() error {
+	if s.closed {
+		return s.err
+	}
+	s.closed = true
+
+	// The archive reader is closed before the rest of the download is
+	// drained, so nothing else is reading from it concurrently.
+	s.ReadCloser.Close()
+	_, err := io.Copy(io.Discard, s.downloaded)
+	s.body.Close()
+	switch {
+	case err != nil:
+		s.err = fmt.Errorf("failed to download backup: %w", err)
+	case s.downloaded.n != s.checked.size || !bytes.Equal(s.hash.Sum(nil), s.checked.digest):
+		s.err = fmt.Errorf("%s changed in storage after it was verified", s.checked.key)
+	}
+	return s.err
+}
+
+func openArchiveStream(ctx context.Context, r io.Reader, encryptionKey []byte, kms crypto.KeyProvider) (io.ReadCloser, error) {
+	input := r
+	if encryptionKey != nil || kms != nil {
+		decReader, err := decryptStream(ctx, r, encryptionKey, kms)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create decryption reader: %w", err)
+		}
+		input = decReader
+	}
+
+	archive, _, err := newArchiveDecompressor(input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open archive: %w", err)
+	}
+	return archive, nil
+}
+
+// volumeIssue is why verification left a volume out of a restore. A damaged
+// volume was changed or cut short in storage; any other issue means the
+// backup never held the whole volume.
+type volumeIssue struct {
+	reason  string
+	damaged bool
+}
+
+// volumeReport is what verifyArchive found out about an archive's volumes.
+// Indexed volumes have a file index, so the next backup in a chain holds
+// only their changes.
+type volumeReport struct {
+	issues  map[string]volumeIssue
+	indexed map[string]bool
+}
+
+// verifyArchive reads an archive end to end, checking every entry against
+// the manifest, every streamed volume for its last segment and every volume
+// against metadata.json. A problem confined to one volume is reported as an
+// issue rather than an error, so the other volumes can still be restored.
+func verifyArchive(archive io.Reader) (*volumeReport, error) {
+	tr := newManifestReader(tar.NewReader(archive))
+	issues := make(map[string]volumeIssue)
+	indexed := make(map[string]bool)
+	next := make(map[string]int)
+	var volumes []string
+	var metadata *StackMetadata
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read archive: %w", err)
+		}
+		if header.Name == "metadata.json" {
+			var m StackMetadata
+			if err := json.NewDecoder(tr).Decode(&m); err == nil {
+				metadata = &m
+			}
+			continue
+		}
+		if volName := entryVolume(header.Name); volName != "" && !slices.Contains(volumes, volName) {
+			volumes = append(volumes, volName)
+		}
+		if strings.HasSuffix(header.Name, indexSuffix) {
+			indexed[entryVolume(header.Name)] = true
+		}
+		volName, n, ok := parseSegment(header.Name)
+		if !ok {
+			continue
+		}
+		if next[volName] != n {
+			issues[volName] = volumeIssue{reason: fmt.Sprintf("segment %d is out of order", n), damaged: true}
+		} else if reason, failed := segmentFailure(header); failed {
+			issues[volName] = volumeIssue{reason: "backup of the volume failed: " + reason}
+		}
+		if isLastSegment(header) {
+			next[volName] = -1
+		} else {
+			next[volName] = n + 1
+		}
+	}
+
+	for volName, n := range next {
+		if _, found := issues[volName]; !found && n >= 0 {
+			issues[volName] = volumeIssue{reason: io.ErrUnexpectedEOF.Error(), damaged: true}
+		}
+	}
+
+	mismatches, err := tr.Mismatches()
+	if err != nil && !errors.Is(err, ErrNoManifest) {
+		return nil, err
+	}
+	for _, mismatch := range mismatches {
+		volName := entryVolume(mismatch.Entry)
+		if volName == "" {
+			return nil, mismatch
+		}
+		if issue, found := issues[volName]; !found || !issue.damaged {
+			issues[volName] = volumeIssue{reason: mismatch.Error(), damaged: true}
+		}
+	}
+
+	if metadata != nil {
+		for _, volName := range volumes {
+			if _, found := issues[volName]; !found && !slices.Contains(metadata.Volumes, volName) {
+				issues[volName] = volumeIssue{reason: "not listed as backed up in metadata.json"}
+			}
+		}
+	}
+	return &volumeReport{issues: issues, indexed: indexed}, nil
+}
+
+func entryVolume(name string) string {
+	if volName, _, ok := parseSegment(name); ok {
+		return volName
+	}
+	if !strings.HasPrefix(name, "volumes/") {
+		return ""
+	}
+	base := path.Base(name)
+	for _, suffix := range []string{deletedSuffix, indexSuffix, ".tar"} {
+		if strings.HasSuffix(base, suffix) {
+			return strings.TrimSuffix(base, suffix)
+		}
+	}
+	return ""
+}
+
+// skippedVolumes are the volumes one layer of a restore leaves out.
+type skippedVolumes map[string]volumeIssue
+
+func (s skippedVolumes) add(volName string, issue volumeIssue) {
+	if prev, found := s[volName]; !found || (issue.damaged && !prev.damaged) {
+		s[volName] = issue
+	}
+}
+
+// chainSkips decides which volumes each layer of a chain leaves out. A volume
+// with an issue in one layer is left out of that layer and the ones before
+// it, and of the ones after it until a layer holds all of the volume again,
+// so an increment is never replayed over a base that wasn't restored.
+func chainSkips(reports []*volumeReport) []skippedVolumes {
+	skips := make([]skippedVolumes, len(reports))
+	for i := range skips {
+		skips[i] = make(skippedVolumes)
+	}
+	for i, report := range reports {
+		for volName, issue := range report.issues {
+			end := len(reports)
+			for j := i + 1; j < len(reports); j++ {
+				if !reports[j-1].indexed[volName] {
+					end = j
+					break
+				}
+			}
+			for _, skip := range skips[:end] {
+				skip.add(volName, issue)
+			}
+		}
+	}
+	return skips
+}
+
+func (s skippedVolumes) names(damagedOnly bool) []string {
+	var names []string
+	for volName, issue := range s {
+		if issue.damaged || !damagedOnly {
+			names = append(names, volName)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// verifyRestored checks the entries a restore pass read against the
+// manifest, except those of volumes that were skipped.
+func verifyRestored(tr *manifestReader, skip skippedVolumes) error {
+	mismatches, err := tr.Mismatches()
+	if err != nil {
+		return err
+	}
+	for _, mismatch := range mismatches {
+		if _, skipped := skip[entryVolume(mismatch.Entry)]; !skipped {
+			return mismatch
+		}
+	}
+	return nil
+}
+
+// volumeRestorer is the part of the Docker client that replays a layer.
+type volumeRestorer interface {
+	RestoreVolume(volumeName string, r io.Reader) error
+	RemoveVolumeFiles(volumeName string, paths []string) error
+}
+
+func restoreVolumeLayer(ctx context.Context, client volumeRestorer, archive io.Reader, skip skippedVolumes, log func(string, ...interface{})) error {
+	tarReader := newManifestReader(tar.NewReader(archive))
+	for {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("restore cancelled: %w", err)
+		}
+
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read tar header: %w", err)
+		}
+		if !strings.HasPrefix(header.Name, "volumes/") {
+			continue
+		}
+		if _, skipped := skip[entryVolume(header.Name)]; skipped {
+			continue
+		}
+
+		baseName := filepath.Base(header.Name)
+		if volName, n, ok := parseSegment(header.Name); ok {
+			if n != 0 {
+				continue
+			}
+			segments, err := newSegmentReader(tarReader, header)
+			if err != nil {
+				return err
+			}
+			err = client.RestoreVolume(volName, segments)
+			if _, drainErr := io.Copy(io.Discard, segments); err == nil {
+				err = drainErr
+			}
+			if err != nil {
+				return fmt.Errorf("failed to restore volume %s: %w", volName, err)
+			}
+			continue
+		}
+		switch {
+		case strings.HasSuffix(baseName, deletedSuffix):
+			if err := applyDeletions(client, strings.TrimSuffix(baseName, deletedSuffix), tarReader, log); err != nil {
+				return err
+			}
+		case strings.HasSuffix(baseName, ".tar"):
+			volName := strings.TrimSuffix(baseName, ".tar")
+			if err := client.RestoreVolume(volName, tarReader); err != nil {
+				return fmt.Errorf("failed to restore volume %s: %w", volName, err)
+			}
+		}
+	}
+
+	if err := verifyRestored(tarReader, skip); err != nil && !errors.Is(err, ErrNoManifest) {
+		return fmt.Errorf("archive failed checksum verification: %w", err)
+	}
+	return nil
+}
+
+func applyDeletions(client volumeRestorer, volName string, r io.Reader, log func(string, ...interface{})) error {
+	paths, err := readDeletions(r)
+	if err != nil {
+		return err
+	}
+	log(" Removing %d deleted file(s) from volume %s\n", len(paths), volName)
+	return client.RemoveVolumeFiles(volName, paths)
+}
 
 func PeekBackup(opts StackRestoreOptions) ([]string, error) {
 	ctx := opts.Context
@@ -294,38 +804,51 @@ func PeekBackup(opts StackRestoreOptions) ([]string, error) {
 		ctx = context.Background()
 	}
 
-	var reader io.ReadCloser
-	var err error
-
-	if opts.StorageProvider != nil {
-		reader, err = opts.StorageProvider.Download(ctx, opts.InputPath)
+	if opts.Repository != nil {
+		snapshot, err := opts.Repository.LoadSnapshot(ctx, opts.InputPath)
 		if err != nil {
 			return nil, err
 		}
-	} else {
-		reader, err = os.Open(opts.InputPath)
-		if err != nil {
+		var files []string
+		for _, node := range snapshot.Tree {
+			files = append(files, node.Name)
+		}
+		return files, nil
+	}
+
+	var reader io.ReadCloser
+	var err error
+
+	if opts.EncryptionKey == nil && opts.Keyring != nil {
+		key, _, err := ResolveKey(ctx, opts.StorageProvider, opts.InputPath, opts.Keyring)
+		if err != nil && !(opts.KMS != nil && errors.Is(err, crypto.ErrKMSRequired)) {
 			return nil, err
 		}
+		opts.EncryptionKey = key
+	}
+
+	reader, err = openBackup(ctx, opts.StorageProvider, opts.InputPath)
+	if err != nil {
+		return nil, err
 	}
 	defer reader.Close()
 
 	var input io.Reader = reader
-	if opts.EncryptionKey != nil {
-		decReader, err := crypto.NewDecryptReader(opts.EncryptionKey, reader)
+	if opts.EncryptionKey != nil || opts.KMS != nil {
+		decReader, err := decryptStream(ctx, reader, opts.EncryptionKey, opts.KMS)
 		if err != nil {
 			return nil, err
 		}
 		input = decReader
 	}
 
-	gzReader, err := gzip.NewReader(input)
+	archive, _, err := newArchiveDecompressor(input)
 	if err != nil {
 		return nil, err
 	}
-	defer gzReader.Close()
+	defer archive.Close()
 
-	tarReader := tar.NewReader(gzReader)
+	tarReader := tar.NewReader(archive)
 	var files []string
 	for {
 		header, err := tarReader.Next()
@@ -335,6 +858,12 @@ func PeekBackup(opts StackRestoreOptions) ([]string, error) {
 		if err != nil {
 			return nil, err
 		}
+		if volName, n, ok := parseSegment(header.Name); ok {
+			if n == 0 {
+				files = append(files, path.Join("volumes", volName+".tar"))
+			}
+			continue
+		}
 		files = append(files, header.Name)
 	}
 
@@ -0,0 +1,190 @@
+package backup
+
+import (
+	"bytes"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+)
+
+type fakeVolumeRestorer struct {
+	restored map[string][]byte
+}
+
+func (f *fakeVolumeRestorer) RestoreVolume(volumeName string, r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	f.restored[volumeName] = data
+	return nil
+}
+
+func (f *fakeVolumeRestorer) RemoveVolumeFiles(volumeName string, paths []string) error {
+	return nil
+}
+
+func TestCheckStackArchiveReportsDamagedVolumes(t *testing.T) {
+	volumes := map[string][]byte{
+		"app": volumeTar(t, map[string]string{"config.yml": "debug: false"}),
+		"db":  volumeTar(t, map[string]string{"data/base": "the quick brown fox"}),
+	}
+	const key = "app_20260101_120000.tar"
+
+	tests := []struct {
+		name        string
+		damage      func([]byte) []byte
+		drop        string
+		unlisted    string
+		failed      string
+		wantSkipped string
+		wantDamaged bool
+		wantErr     string
+	}{
+		{name: "intact"},
+		{
+			name:        "flipped byte",
+			damage:      func(b []byte) []byte { return bytes.Replace(b, []byte("quick"), []byte("quack"), 1) },
+			wantSkipped: "db",
+			wantDamaged: true,
+		},
+		{
+			name:        "missing last segment",
+			drop:        "db",
+			wantSkipped: "db",
+			wantDamaged: true,
+		},
+		{
+			name:        "volume not listed in metadata",
+			unlisted:    "db",
+			wantSkipped: "db",
+		},
+		{
+			name:        "volume dump failed during backup",
+			failed:      "db",
+			wantSkipped: "db",
+		},
+		{
+			name:    "damaged compose file",
+			damage:  func(b []byte) []byte { return bytes.Replace(b, []byte("alpine"), []byte("alpina"), 1) },
+			wantErr: "docker-compose.yml",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := newTestProvider(t)
+			data := testArchive{compression: Compression{Codec: CodecNone}, volumes: volumes, dropLast: tt.drop, unlisted: tt.unlisted, failed: tt.failed}.bytes(t)
+			if tt.damage != nil {
+				data = tt.damage(data)
+			}
+			uploadTestArchive(t, provider, key, data, nil)
+
+			opts := StackRestoreOptions{InputPath: key, StorageProvider: provider, Context: t.Context()}
+			checked, err := checkStackArchive(t.Context(), opts, key, func(string, ...interface{}) {})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var wantSkipped []string
+			if tt.wantSkipped != "" {
+				wantSkipped = []string{tt.wantSkipped}
+			}
+			if got := slices.Sorted(maps.Keys(checked.volumes.issues)); !slices.Equal(got, wantSkipped) {
+				t.Fatalf("skipped %v, want %v", got, wantSkipped)
+			}
+			if tt.wantSkipped != "" && checked.volumes.issues[tt.wantSkipped].damaged != tt.wantDamaged {
+				t.Fatalf("issue %+v, want damaged %v", checked.volumes.issues[tt.wantSkipped], tt.wantDamaged)
+			}
+
+			// The other volume is still restored, and the skipped one's
+			// entries don't fail the restore pass's checksum check.
+			skip := chainSkips([]*volumeReport{checked.volumes})[0]
+			_, archive, err := openCheckedArchive(t.Context(), opts, checked)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer archive.Close()
+			restorer := &fakeVolumeRestorer{restored: make(map[string][]byte)}
+			if err := restoreVolumeLayer(t.Context(), restorer, archive, skip, func(string, ...interface{}) {}); err != nil {
+				t.Fatal(err)
+			}
+			if err := archive.Close(); err != nil {
+				t.Fatal(err)
+			}
+			for name, data := range volumes {
+				got, restored := restorer.restored[name]
+				if _, skipped := skip[name]; skipped == restored {
+					t.Fatalf("volume %s restored %v, skipped %v", name, restored, skipped)
+				}
+				if restored && !bytes.Equal(got, data) {
+					t.Fatalf("volume %s restored with different data", name)
+				}
+			}
+		})
+	}
+}
+
+func TestChainSkips(t *testing.T) {
+	failed := volumeIssue{reason: "backup of the volume failed"}
+	damaged := volumeIssue{reason: "unexpected EOF", damaged: true}
+	layer := func(issues map[string]volumeIssue, indexed ...string) *volumeReport {
+		r := &volumeReport{issues: issues, indexed: make(map[string]bool)}
+		for _, name := range indexed {
+			r.indexed[name] = true
+		}
+		return r
+	}
+
+	tests := []struct {
+		name   string
+		layers []*volumeReport
+		want   [][]string
+	}{
+		{
+			name:   "healthy chain",
+			layers: []*volumeReport{layer(nil, "app", "db"), layer(nil, "app", "db")},
+			want:   [][]string{nil, nil},
+		},
+		{
+			name:   "failed in the leaf",
+			layers: []*volumeReport{layer(nil, "app", "db"), layer(map[string]volumeIssue{"db": failed}, "app")},
+			want:   [][]string{{"db"}, {"db"}},
+		},
+		{
+			name: "failed in the middle, dumped in full after",
+			layers: []*volumeReport{
+				layer(nil, "app", "db"),
+				layer(map[string]volumeIssue{"db": failed}, "app"),
+				layer(nil, "app", "db"),
+			},
+			want: [][]string{{"db"}, {"db"}, nil},
+		},
+		{
+			name: "damaged in the middle, increments built on it",
+			layers: []*volumeReport{
+				layer(nil, "app", "db"),
+				layer(map[string]volumeIssue{"db": damaged}, "app", "db"),
+				layer(nil, "app", "db"),
+			},
+			want: [][]string{{"db"}, {"db"}, {"db"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			skips := chainSkips(tt.layers)
+			for i, skip := range skips {
+				if got := skip.names(false); !slices.Equal(got, tt.want[i]) {
+					t.Fatalf("layer %d skips %v, want %v", i, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
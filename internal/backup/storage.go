@@ -0,0 +1,46 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"github.com/stacksnap/stacksnap/internal/storage"
+)
+
+func resolveStorage(provider storage.Provider, key string) (storage.Provider, string, error) {
+	if provider != nil {
+		return provider, key, nil
+	}
+
+	dir, name := filepath.Split(key)
+	if dir == "" {
+		dir = "."
+	}
+
+	local, err := storage.NewLocalDirProvider(dir)
+	if err != nil {
+		return nil, "", err
+	}
+	return local, name, nil
+}
+
+func openBackup(ctx context.Context, provider storage.Provider, key string) (io.ReadCloser, error) {
+	p, k, err := resolveStorage(provider, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open backup location: %w", err)
+	}
+	return p.Download(ctx, k)
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -2,9 +2,10 @@ package backup
 
 import (
 	"archive/tar"
-	"compress/gzip"
 	"context"
+	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -14,25 +15,73 @@ import (
 	"time"
 
 	"github.com/stacksnap/stacksnap/internal/docker"
+	"github.com/stacksnap/stacksnap/internal/lock"
 	"github.com/stacksnap/stacksnap/internal/storage"
 )
 
+const (
+	OutcomeVerified    = "verified"
+	OutcomeFailed      = "failed"
+	OutcomeWrongKey    = "wrong_key"
+	OutcomeKeyRequired = "key_required"
+)
+
+func verificationOutcome(verified bool, err error) string {
+	switch {
+	case verified:
+		return OutcomeVerified
+	case IsWrongKey(err):
+		return OutcomeWrongKey
+	case IsKeyRequired(err):
+		return OutcomeKeyRequired
+	default:
+		return OutcomeFailed
+	}
+}
 
 type VerificationResult struct {
 	BackupKey   string  `json:"backup_key"`
 	Verified   bool   `json:"verified"`
+	Outcome       string    `json:"outcome,omitempty"`
 	TestedAt   time.Time `json:"tested_at"`
 	ErrorMessage string  `json:"error_message,omitempty"`
 	ContainerLogs string  `json:"container_logs,omitempty"`
 }
 
+func LoadVerifications(path string) map[string]*VerificationResult {
+	res := make(map[string]*VerificationResult)
+	data, err := os.ReadFile(path)
+	if err == nil {
+		json.Unmarshal(data, &res)
+	}
+	return res
+}
+
+func SaveVerifications(path string, m map[string]*VerificationResult) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
 
-func VerifyBackup(ctx context.Context, client *docker.Client, provider storage.Provider, key string) (*VerificationResult, error) {
+func VerifyBackup(ctx context.Context, client *docker.Client, provider storage.Provider, key string, keys DecryptionKeys) (*VerificationResult, error) {
 	result := &VerificationResult{
 		BackupKey: key,
 		TestedAt: time.Now(),
 	}
+	var verifyErr error
+	defer func() {
+		result.Outcome = verificationOutcome(result.Verified, verifyErr)
+	}()
 
+	if stackName, _, ok := ParseBackupKey(key); ok {
+		stackLock, err := lock.Default().TryAcquire(stackName, "verify")
+		if err != nil {
+			return nil, err
+		}
+		defer stackLock.Release()
+	}
 
 	tempDir, err := os.MkdirTemp("", "stacksnap-verify-*")
 	if err != nil {
@@ -40,27 +89,23 @@ func VerifyBackup(ctx context.Context, client *docker.Client, provider storage.P
 	}
 	defer os.RemoveAll(tempDir)
 
-
-	var rc io.ReadCloser
-
-
-	if provider != nil {
-		rc, err = provider.Download(ctx, key)
-		if err != nil {
-			return nil, fmt.Errorf("failed to download backup: %w", err)
-		}
-	} else {
-
-		rc, err = os.Open(key)
-		if err != nil {
-			return nil, fmt.Errorf("failed to open local backup: %w", err)
-		}
+	rc, err := openBackup(ctx, provider, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download backup: %w", err)
 	}
 	defer rc.Close()
 
+	input, err := keys.decrypt(ctx, provider, key, rc)
+	if err != nil {
+		verifyErr = err
+		result.Verified = false
+		result.ErrorMessage = fmt.Sprintf("failed to decrypt backup: %v", err)
+		return result, nil
+	}
 
-	err = extractNonVolumeFiles(rc, tempDir)
+	err = extractNonVolumeFiles(input, tempDir)
 	if err != nil {
+		verifyErr = err
 		result.Verified = false
 		result.ErrorMessage = fmt.Sprintf("failed to extract verification files: %v", err)
 		return result, nil
@@ -186,13 +231,13 @@ func VerifyBackup(ctx context.Context, client *docker.Client, provider storage.P
 
 
 func extractNonVolumeFiles(r io.Reader, dest string) error {
-	gzr, err := gzip.NewReader(r)
+	archive, _, err := newArchiveDecompressor(r)
 	if err != nil {
 		return err
 	}
-	defer gzr.Close()
+	defer archive.Close()
 
-	tr := tar.NewReader(gzr)
+	tr := newManifestReader(tar.NewReader(archive))
 	for {
 		header, err := tr.Next()
 		if err == io.EOF {
@@ -221,6 +266,9 @@ func extractNonVolumeFiles(r io.Reader, dest string) error {
 		}
 		f.Close()
 	}
+	if err := tr.Verify(); err != nil && !errors.Is(err, ErrNoManifest) {
+		return err
+	}
 	return nil
 }
 
@@ -270,64 +318,77 @@ type LightVerificationResult struct {
 	Verified    bool   `json:"verified"`
 	TestedAt    time.Time `json:"tested_at"`
 	ErrorMessage  string  `json:"error_message,omitempty"`
+	Outcome         string    `json:"outcome,omitempty"`
 	HasMetadata   bool   `json:"has_metadata"`
 	HasCompose   bool   `json:"has_compose"`
 	HasVolumes   bool   `json:"has_volumes"`
 	HasDatabaseDump bool   `json:"has_database_dump"`
 	VolumeCount   int    `json:"volume_count"`
 	StackName    string  `json:"stack_name,omitempty"`
+	Signed          bool      `json:"signed"`
+	SigningKeyID    string    `json:"signing_key_id,omitempty"`
+	HasManifest     bool      `json:"has_manifest"`
+	CorruptEntry    string    `json:"corrupt_entry,omitempty"`
 	ChecksPerformed []string `json:"checks_performed"`
 }
 
-
-
-
-
-
-
-
-func VerifyBackupLight(ctx context.Context, provider storage.Provider, key string) (*LightVerificationResult, error) {
+func VerifyBackupLight(ctx context.Context, provider storage.Provider, key string, keys DecryptionKeys, policy *SignaturePolicy) (*LightVerificationResult, error) {
 	result := &LightVerificationResult{
 		BackupKey:    key,
 		TestedAt:    time.Now(),
 		ChecksPerformed: []string{},
 	}
+	var verifyErr error
+	defer func() {
+		result.Outcome = verificationOutcome(result.Verified, verifyErr)
+	}()
 
 	fmt.Printf(" Running lightweight verification on %s...\n", key)
 
-
-	var rc io.ReadCloser
-	var err error
-
-	if provider != nil {
-		rc, err = provider.Download(ctx, key)
-		if err != nil {
-			result.ErrorMessage = fmt.Sprintf("Download failed: %v", err)
-			return result, nil
-		}
-	} else {
-		rc, err = os.Open(key)
-		if err != nil {
-			result.ErrorMessage = fmt.Sprintf("Failed to open file: %v", err)
-			return result, nil
-		}
+	rc, err := openBackup(ctx, provider, key)
+	if err != nil {
+		result.ErrorMessage = fmt.Sprintf("Download failed: %v", err)
+		return result, nil
 	}
 	defer rc.Close()
 	result.ChecksPerformed = append(result.ChecksPerformed, "Download/Open")
 
+	archiveHash := sha256.New()
+	archive := &countingReader{r: io.TeeReader(rc, archiveHash)}
 
-	gzr, err := gzip.NewReader(rc)
+	input, err := keys.decrypt(ctx, provider, key, archive)
 	if err != nil {
-		result.ErrorMessage = fmt.Sprintf("Invalid gzip format: %v", err)
+		verifyErr = err
+		result.ErrorMessage = fmt.Sprintf("Decryption failed: %v", err)
 		return result, nil
 	}
-	defer gzr.Close()
-	result.ChecksPerformed = append(result.ChecksPerformed, "Gzip integrity")
+	if _, ok := input.(*wrongKeyReader); ok {
+		result.ChecksPerformed = append(result.ChecksPerformed, "Decryption")
+	}
 
+	stream, codec, err := newArchiveDecompressor(input)
+	if err != nil {
+		verifyErr = err
+		if IsWrongKey(err) {
+			result.ErrorMessage = fmt.Sprintf("Decryption failed: %v", err)
+		} else {
+			result.ErrorMessage = fmt.Sprintf("Invalid archive format: %v", err)
+		}
+		return result, nil
+	}
+	defer stream.Close()
+	switch codec {
+	case CodecGzip:
+		result.ChecksPerformed = append(result.ChecksPerformed, "Gzip integrity")
+	case CodecZstd:
+		result.ChecksPerformed = append(result.ChecksPerformed, "Zstd integrity")
+	}
 
-	tr := tar.NewReader(gzr)
+	tr := newManifestReader(tar.NewReader(stream))
 	volumeCount := 0
+	openVolumes := make(map[string]int)
 	var metadata StackMetadata
+	var metadataJSON []byte
 
 	for {
 		header, err := tr.Next()
@@ -343,7 +404,12 @@ func VerifyBackupLight(ctx context.Context, provider storage.Provider, key strin
 		case header.Name == "metadata.json":
 			result.HasMetadata = true
 
-			if err := json.NewDecoder(tr).Decode(&metadata); err != nil {
+			metadataJSON, err = io.ReadAll(tr)
+			if err != nil {
+				result.ErrorMessage = fmt.Sprintf("Cannot read metadata.json: %v", err)
+				return result, nil
+			}
+			if err := json.Unmarshal(metadataJSON, &metadata); err != nil {
 				result.ErrorMessage = fmt.Sprintf("Invalid metadata.json: %v", err)
 				return result, nil
 			}
@@ -381,6 +447,22 @@ func VerifyBackupLight(ctx context.Context, provider storage.Provider, key strin
 
 			io.Copy(io.Discard, tr)
 
+		case segmentPattern.MatchString(header.Name):
+			volName, n, _ := parseSegment(header.Name)
+			if next, open := openVolumes[volName]; n != 0 && (!open || next != n) {
+				result.ErrorMessage = fmt.Sprintf("Volume stream out of order: %s", header.Name)
+				return result, nil
+			}
+			if n == 0 {
+				result.HasVolumes = true
+				volumeCount++
+			}
+			openVolumes[volName] = n + 1
+			if isLastSegment(header) {
+				delete(openVolumes, volName)
+			}
+			io.Copy(io.Discard, tr)
+
 		case strings.HasSuffix(header.Name, "_dump.sql"):
 			result.HasDatabaseDump = true
 
@@ -405,17 +487,49 @@ func VerifyBackupLight(ctx context.Context, provider storage.Provider, key strin
 	result.VolumeCount = volumeCount
 	result.ChecksPerformed = append(result.ChecksPerformed, fmt.Sprintf("Volume count: %d", volumeCount))
 
+	switch err := tr.Verify(); {
+	case errors.Is(err, ErrNoManifest):
+		result.ChecksPerformed = append(result.ChecksPerformed, "Checksums: no manifest")
+	case err != nil:
+		var mismatch *ManifestMismatchError
+		if errors.As(err, &mismatch) {
+			result.CorruptEntry = mismatch.Entry
+		}
+		result.ErrorMessage = fmt.Sprintf("Checksum verification failed: %v", err)
+		return result, nil
+	default:
+		result.HasManifest = true
+		result.ChecksPerformed = append(result.ChecksPerformed, fmt.Sprintf("Checksums: %d entries", tr.Entries()))
+	}
 
 	if !result.HasMetadata {
 		result.ErrorMessage = "Missing metadata.json"
 		return result, nil
 	}
 
+	for _, vol := range metadata.Volumes {
+		if _, truncated := openVolumes[vol]; truncated {
+			result.ErrorMessage = fmt.Sprintf("Volume stream truncated: %s", vol)
+			return result, nil
+		}
+	}
+	if len(metadata.FailedVolumes) > 0 {
+		result.ErrorMessage = fmt.Sprintf("Volumes failed during backup: %s", strings.Join(metadata.FailedVolumes, ", "))
+		return result, nil
+	}
+
 	if !result.HasVolumes && volumeCount == 0 {
 		result.ErrorMessage = "No volumes found in backup"
 		return result, nil
 	}
 
+	if policy != nil {
+		io.Copy(io.Discard, archive)
+		if msg := checkLightSignature(ctx, provider, key, policy, archiveHash.Sum(nil), archive.n, metadataJSON, result); msg != "" {
+			result.ErrorMessage = msg
+			return result, nil
+		}
+	}
 
 	result.Verified = true
 	fmt.Printf(" Lightweight verification passed (%d volumes, %d checks)\n",
@@ -424,6 +538,36 @@ func VerifyBackupLight(ctx context.Context, provider storage.Provider, key strin
 	return result, nil
 }
 
+func checkLightSignature(ctx context.Context, provider storage.Provider, key string, policy *SignaturePolicy, digest []byte, size int64, metadataJSON []byte, result *LightVerificationResult) string {
+	p, k, err := resolveStorage(provider, key)
+	if err != nil {
+		return fmt.Sprintf("Signature check failed: %v", err)
+	}
+
+	sig, err := loadSignature(ctx, p, k)
+	if err == nil {
+		result.SigningKeyID = sig.KeyID
+		err = sig.Verify(policy.Trusted)
+	}
+	if err == nil {
+		err = sig.CheckArchive(digest, size)
+	}
+	if err == nil {
+		err = sig.CheckMetadata(metadataJSON)
+	}
+
+	if err != nil {
+		if policy.Strict {
+			return fmt.Sprintf("Signature check failed: %v", err)
+		}
+		result.ChecksPerformed = append(result.ChecksPerformed, "Signature: "+err.Error())
+		return ""
+	}
+
+	result.Signed = true
+	result.ChecksPerformed = append(result.ChecksPerformed, "Signature: "+sig.KeyID)
+	return ""
+}
 
 func validateSQLDumpContent(content string) bool {
 
@@ -0,0 +1,303 @@
+package backup
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/stacksnap/stacksnap/internal/docker"
+)
+
+const (
+	minSegmentSize    = 2 << 20
+	maxSegmentSize    = 16 << 20
+	segmentLastRecord = "STACKSNAP.last"
+	segmentFailRecord = "STACKSNAP.failed"
+	segmentQueueDepth = 2
+)
+
+var (
+	segmentPattern = regexp.MustCompile(`^volumes/(.+)\.tar\.part-(\d{6})$`)
+	segmentMask    = uint64(1<<23-1) << (64 - 23)
+	segmentGear    = func() (t [256]uint64) {
+		for i := range t {
+			sum := sha256.Sum256([]byte("stacksnap-segment-" + strconv.Itoa(i)))
+			t[i] = binary.BigEndian.Uint64(sum[:8])
+		}
+		return t
+	}()
+)
+
+func segmentName(volume string, n int) string {
+	return fmt.Sprintf("volumes/%s.tar.part-%06d", volume, n)
+}
+
+func parseSegment(name string) (string, int, bool) {
+	m := segmentPattern.FindStringSubmatch(name)
+	if m == nil {
+		return "", 0, false
+	}
+	n, err := strconv.Atoi(m[2])
+	if err != nil {
+		return "", 0, false
+	}
+	return m[1], n, true
+}
+
+func isLastSegment(h *tar.Header) bool {
+	return h.PAXRecords[segmentLastRecord] == "1"
+}
+
+// segmentFailure returns the error recorded by a volume's failure marker.
+func segmentFailure(h *tar.Header) (string, bool) {
+	reason, failed := h.PAXRecords[segmentFailRecord]
+	return reason, failed
+}
+
+var segmentBuffers = sync.Pool{
+	New: func() any {
+		buf := make([]byte, 0, maxSegmentSize)
+		return &buf
+	},
+}
+
+func getSegmentBuffer() []byte {
+	return (*segmentBuffers.Get().(*[]byte))[:0]
+}
+
+func putSegmentBuffer(buf []byte) {
+	if cap(buf) == maxSegmentSize {
+		segmentBuffers.Put(&buf)
+	}
+}
+
+type segmenter struct {
+	buf  []byte
+	hash uint64
+	emit func(data []byte, last bool) error
+}
+
+func newSegmenter(emit func(data []byte, last bool) error) *segmenter {
+	return &segmenter{buf: getSegmentBuffer(), emit: emit}
+}
+
+func (s *segmenter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		i, cut := 0, false
+		for i < len(p) {
+			s.hash = (s.hash << 1) + segmentGear[p[i]]
+			i++
+			n := len(s.buf) + i
+			if n >= maxSegmentSize || (n >= minSegmentSize && s.hash&segmentMask == 0) {
+				cut = true
+				break
+			}
+		}
+
+		s.buf = append(s.buf, p[:i]...)
+		p = p[i:]
+		written += i
+		if cut {
+			if err := s.flush(false); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+func (s *segmenter) flush(last bool) error {
+	buf := s.buf
+	s.buf = nil
+	if !last {
+		s.buf = getSegmentBuffer()
+	}
+	return s.emit(buf, last)
+}
+
+func (s *segmenter) Close() error {
+	return s.flush(true)
+}
+
+type segmentWriter struct {
+	tw      entryWriter
+	volume  string
+	modTime time.Time
+	next    int
+}
+
+func newSegmentWriter(tw entryWriter, volume string) *segmentWriter {
+	return &segmentWriter{tw: tw, volume: volume, modTime: time.Now()}
+}
+
+func (s *segmentWriter) WriteSegment(data []byte, last bool) error {
+	var records map[string]string
+	if last {
+		records = map[string]string{segmentLastRecord: "1"}
+	}
+	return s.write(data, records)
+}
+
+// WriteFailure ends a volume whose dump failed with an empty last segment
+// that records the error, so the segments before it are never mistaken for
+// the whole volume.
+func (s *segmentWriter) WriteFailure(err error) error {
+	return s.write(nil, map[string]string{segmentLastRecord: "1", segmentFailRecord: err.Error()})
+}
+
+func (s *segmentWriter) write(data []byte, records map[string]string) error {
+	header := &tar.Header{
+		Name:       segmentName(s.volume, s.next),
+		Size:       int64(len(data)),
+		Mode:       0644,
+		ModTime:    s.modTime,
+		PAXRecords: records,
+	}
+	if err := s.tw.WriteHeader(header); err != nil {
+		return err
+	}
+	if _, err := s.tw.Write(data); err != nil {
+		return err
+	}
+	s.next++
+	return nil
+}
+
+type volumeEvent struct {
+	deleted []string
+	data    []byte
+	last    bool
+}
+
+type volumeDump struct {
+	name   string
+	ctx    context.Context
+	cancel context.CancelFunc
+	events chan volumeEvent
+
+	files  []docker.VolumeFile
+	prev   map[string]FileEntry
+	hashes map[string]string
+	size   int64
+	err    error
+}
+
+func newVolumeDump(ctx context.Context, name string) *volumeDump {
+	ctx, cancel := context.WithCancel(ctx)
+	return &volumeDump{
+		name:   name,
+		ctx:    ctx,
+		cancel: cancel,
+		events: make(chan volumeEvent, segmentQueueDepth),
+	}
+}
+
+func (d *volumeDump) send(ev volumeEvent) error {
+	select {
+	case d.events <- ev:
+		return nil
+	case <-d.ctx.Done():
+		return d.ctx.Err()
+	}
+}
+
+func (d *volumeDump) finish(err error) {
+	d.err = err
+	close(d.events)
+	d.cancel()
+}
+
+// writeVolumeDump copies a volume's dump into the archive as it arrives. A
+// dump that fails part way is closed with a failure marker. The returned
+// error is only for failures to write the archive itself.
+func writeVolumeDump(tw entryWriter, d *volumeDump) error {
+	segments := newSegmentWriter(tw, d.name)
+	var writeErr error
+	for ev := range d.events {
+		if writeErr == nil {
+			if ev.deleted != nil {
+				deletedJSON, _ := json.Marshal(ev.deleted)
+				writeErr = addToTar(tw, path.Join("volumes", d.name+deletedSuffix), deletedJSON)
+			} else {
+				writeErr = segments.WriteSegment(ev.data, ev.last)
+			}
+			if writeErr != nil {
+				d.cancel()
+			}
+		}
+		putSegmentBuffer(ev.data)
+	}
+
+	if writeErr == nil && d.err != nil {
+		writeErr = segments.WriteFailure(d.err)
+	}
+	return writeErr
+}
+
+type segmentReader struct {
+	tr     *manifestReader
+	volume string
+	next   int
+	last   bool
+	failed error
+}
+
+func newSegmentReader(tr *manifestReader, first *tar.Header) (*segmentReader, error) {
+	volume, n, ok := parseSegment(first.Name)
+	if !ok || n != 0 {
+		return nil, fmt.Errorf("%s is not the first segment of a volume", first.Name)
+	}
+	s := &segmentReader{tr: tr, volume: volume, next: 1}
+	s.end(first)
+	return s, nil
+}
+
+func (s *segmentReader) end(h *tar.Header) {
+	s.last = isLastSegment(h)
+	if reason, failed := segmentFailure(h); failed {
+		s.failed = fmt.Errorf("volume %s was not backed up in full: %s", s.volume, reason)
+	}
+}
+
+func (s *segmentReader) Read(p []byte) (int, error) {
+	for {
+		n, err := s.tr.Read(p)
+		if n > 0 || err != io.EOF {
+			if err == io.EOF {
+				err = nil
+			}
+			return n, err
+		}
+		if s.failed != nil {
+			return 0, s.failed
+		}
+		if s.last {
+			return 0, io.EOF
+		}
+
+		h, err := s.tr.Next()
+		if err == io.EOF {
+			return 0, fmt.Errorf("volume %s: %w", s.volume, io.ErrUnexpectedEOF)
+		}
+		if err != nil {
+			return 0, err
+		}
+		volume, n, ok := parseSegment(h.Name)
+		if !ok || volume != s.volume || n != s.next {
+			s.tr.push(h)
+			return 0, fmt.Errorf("volume %s: %w", s.volume, io.ErrUnexpectedEOF)
+		}
+		s.next++
+		s.end(h)
+	}
+}
@@ -21,6 +21,65 @@ type Config struct {
 	MachineID    string    `yaml:"machine_id" json:"machine_id"`
 	Storage     StorageConfig `yaml:"storage" json:"storage"`
 	ManualStacks   []string   `yaml:"manual_stacks,omitempty" json:"manual_stacks,omitempty"`
+	Retention            map[string]RetentionPolicy `yaml:"retention,omitempty" json:"retention,omitempty"`
+	Schedules            []ScheduleConfig           `yaml:"schedules,omitempty" json:"schedules,omitempty"`
+	CORSAllowedOrigins   []string                   `yaml:"cors_allowed_origins,omitempty" json:"cors_allowed_origins,omitempty"`
+	EncryptionRecipients []string                   `yaml:"encryption_recipients,omitempty" json:"encryption_recipients,omitempty"`
+	SignBackups          bool                       `yaml:"sign_backups,omitempty" json:"sign_backups"`
+	RequireSignatures    bool                       `yaml:"require_signatures,omitempty" json:"require_signatures"`
+	TrustedSigningKeys   []string                   `yaml:"trusted_signing_keys,omitempty" json:"trusted_signing_keys,omitempty"`
+	KMS                  *KMSConfig                 `yaml:"kms,omitempty" json:"kms,omitempty"`
+}
+
+const KMSVaultTransit = "vault-transit"
+
+type KMSConfig struct {
+	Provider  string `yaml:"provider" json:"provider"`
+	Address   string `yaml:"address,omitempty" json:"address,omitempty"`
+	Mount     string `yaml:"mount,omitempty" json:"mount,omitempty"`
+	KeyName   string `yaml:"key_name" json:"key_name"`
+	Namespace string `yaml:"namespace,omitempty" json:"namespace,omitempty"`
+	TokenFile string `yaml:"token_file,omitempty" json:"token_file,omitempty"`
+}
+
+type ScheduleConfig struct {
+	Stack           string `yaml:"stack" json:"stack"`
+	Location        string `yaml:"location,omitempty" json:"location,omitempty"`
+	Cron            string `yaml:"cron" json:"cron"`
+	Pause           bool   `yaml:"pause,omitempty" json:"pause"`
+	IncludeDB       bool   `yaml:"include_db,omitempty" json:"include_db"`
+	SnapshotImages  bool   `yaml:"snapshot_images,omitempty" json:"snapshot_images"`
+	Verify          bool   `yaml:"verify,omitempty" json:"verify"`
+	EncryptionKeyID string `yaml:"encryption_key_id,omitempty" json:"encryption_key_id,omitempty"`
+	Mode            string `yaml:"mode,omitempty" json:"mode,omitempty"`
+	Compression     string `yaml:"compression,omitempty" json:"compression,omitempty"`
+	Disabled        bool   `yaml:"disabled,omitempty" json:"disabled"`
+}
+
+const DefaultRetentionKey = "*"
+
+type RetentionPolicy struct {
+	KeepLast    int `yaml:"keep_last,omitempty" json:"keep_last,omitempty"`
+	KeepHourly  int `yaml:"keep_hourly,omitempty" json:"keep_hourly,omitempty"`
+	KeepDaily   int `yaml:"keep_daily,omitempty" json:"keep_daily,omitempty"`
+	KeepWeekly  int `yaml:"keep_weekly,omitempty" json:"keep_weekly,omitempty"`
+	KeepMonthly int `yaml:"keep_monthly,omitempty" json:"keep_monthly,omitempty"`
+	KeepYearly  int `yaml:"keep_yearly,omitempty" json:"keep_yearly,omitempty"`
+}
+
+func (p RetentionPolicy) IsEmpty() bool {
+	return p == RetentionPolicy{}
+}
+
+func (c *Config) RetentionFor(stack string) (RetentionPolicy, bool) {
+	if c == nil {
+		return RetentionPolicy{}, false
+	}
+	if p, ok := c.Retention[stack]; ok {
+		return p, true
+	}
+	p, ok := c.Retention[DefaultRetentionKey]
+	return p, ok
 }
 
 type StorageConfig struct {
@@ -42,10 +101,42 @@ func ConfigPath() string {
 	return filepath.Join(ConfigDir(), "config.yaml")
 }
 
+func DefaultBackupsPath() string {
+	return filepath.Join(ConfigDir(), "backups")
+}
+
+func AuthPath() string {
+	return filepath.Join(ConfigDir(), "auth.json")
+}
+
+func KeyringPath() string {
+	return filepath.Join(ConfigDir(), "keyring.json")
+}
+
+func SigningKeyPath() string {
+	return filepath.Join(ConfigDir(), "signing.key")
+}
+
+func RotationStatePath() string {
+	return filepath.Join(ConfigDir(), "rotation_state.json")
+}
+
+func LocksDir() string {
+	return filepath.Join(ConfigDir(), "locks")
+}
+
+func SchedulerStatePath() string {
+	return filepath.Join(ConfigDir(), "schedule_state.json")
+}
+
 func VerificationsPath() string {
 	return filepath.Join(ConfigDir(), "verifications.json")
 }
 
+func IndexDir() string {
+	return filepath.Join(ConfigDir(), "indexes")
+}
+
 func Load() (*Config, error) {
 	path := ConfigPath()
 	if _, err := os.Stat(path); os.IsNotExist(err) {
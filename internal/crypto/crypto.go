@@ -15,6 +15,7 @@ import (
 const (
 	VersionCTR byte = 0x01
 	VersionGCM byte = 0x02
+	VersionGCMFramed byte = 0x04
 )
 
 
@@ -23,6 +24,7 @@ var MagicHeader = []byte("SSNAP")
 
 const GCMChunkSize = 64 * 1024
 
+const finalChunkFlag uint32 = 1 << 31
 
 var (
 	ErrInvalidHeader    = errors.New("invalid encryption header")
@@ -44,6 +46,8 @@ type gcmWriter struct {
 	counter uint64
 	w    io.Writer
 	buf   []byte
+	header  []byte
+	framed  bool
 }
 
 func (g *gcmWriter) Write(p []byte) (int, error) {
@@ -51,7 +55,7 @@ func (g *gcmWriter) Write(p []byte) (int, error) {
 	written := 0
 
 	for len(g.buf) >= GCMChunkSize {
-		if err := g.flushChunk(g.buf[:GCMChunkSize]); err != nil {
+		if err := g.flushChunk(g.buf[:GCMChunkSize], false); err != nil {
 			return written, err
 		}
 		written += GCMChunkSize
@@ -61,19 +65,25 @@ func (g *gcmWriter) Write(p []byte) (int, error) {
 	return len(p), nil
 }
 
-func (g *gcmWriter) flushChunk(chunk []byte) error {
+func (g *gcmWriter) flushChunk(chunk []byte, final bool) error {
 
 	chunkNonce := make([]byte, 12)
 	copy(chunkNonce, g.nonce[:4])
 	binary.BigEndian.PutUint64(chunkNonce[4:], g.counter)
 	g.counter++
 
+	var aad []byte
+	if g.framed {
+		aad = chunkAAD(g.header, final)
+	}
+	ciphertext := g.aead.Seal(nil, chunkNonce, chunk, aad)
 
-	ciphertext := g.aead.Seal(nil, chunkNonce, chunk, nil)
-
-
+	length := uint32(len(ciphertext))
+	if final {
+		length |= finalChunkFlag
+	}
 	lenBuf := make([]byte, 4)
-	binary.BigEndian.PutUint32(lenBuf, uint32(len(ciphertext)))
+	binary.BigEndian.PutUint32(lenBuf, length)
 	if _, err := g.w.Write(lenBuf); err != nil {
 		return err
 	}
@@ -86,8 +96,14 @@ func (g *gcmWriter) flushChunk(chunk []byte) error {
 
 func (g *gcmWriter) Close() error {
 
+	if g.framed {
+		err := g.flushChunk(g.buf, true)
+		g.buf = nil
+		return err
+	}
+
 	if len(g.buf) > 0 {
-		if err := g.flushChunk(g.buf); err != nil {
+		if err := g.flushChunk(g.buf, false); err != nil {
 			return err
 		}
 		g.buf = nil
@@ -143,6 +159,44 @@ func NewEncryptWriterGCM(key []byte, w io.Writer) (io.WriteCloser, error) {
 	}, nil
 }
 
+func NewEncryptWriterFramed(key []byte, w io.Writer) (io.WriteCloser, error) {
+	aead, err := newDataAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, 12)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	header := make([]byte, 0, len(MagicHeader)+1+len(nonce))
+	header = append(header, MagicHeader...)
+	header = append(header, VersionGCMFramed)
+	header = append(header, nonce...)
+
+	if _, err := w.Write(header); err != nil {
+		return nil, fmt.Errorf("failed to write header: %w", err)
+	}
+
+	return &gcmWriter{
+		aead:   aead,
+		nonce:  nonce,
+		w:      w,
+		buf:    make([]byte, 0, GCMChunkSize),
+		header: header,
+		framed: true,
+	}, nil
+}
+
+func chunkAAD(header []byte, final bool) []byte {
+	aad := make([]byte, len(header)+1)
+	copy(aad, header)
+	if final {
+		aad[len(header)] = 1
+	}
+	return aad
+}
 
 type gcmReader struct {
 	aead  cipher.AEAD
@@ -151,6 +205,8 @@ type gcmReader struct {
 	r    io.Reader
 	buf   []byte
 	eof   bool
+	header  []byte
+	framed  bool
 }
 
 func (g *gcmReader) Read(p []byte) (int, error) {
@@ -165,6 +221,9 @@ func (g *gcmReader) Read(p []byte) (int, error) {
 		return 0, io.EOF
 	}
 
+	if g.framed {
+		return g.readFramed(p)
+	}
 
 	lenBuf := make([]byte, 4)
 	if _, err := io.ReadFull(g.r, lenBuf); err != nil {
@@ -203,6 +262,59 @@ func (g *gcmReader) Read(p []byte) (int, error) {
 	return n, nil
 }
 
+func (g *gcmReader) readFramed(p []byte) (int, error) {
+	lenBuf := make([]byte, 4)
+	if _, err := io.ReadFull(g.r, lenBuf); err != nil {
+		return 0, truncated(err)
+	}
+
+	length := binary.BigEndian.Uint32(lenBuf)
+	final := length&finalChunkFlag != 0
+	chunkLen := length &^ finalChunkFlag
+	if chunkLen < uint32(g.aead.Overhead()) || chunkLen > uint32(GCMChunkSize+g.aead.Overhead()) {
+		return 0, ErrAuthenticationFailed
+	}
+
+	ciphertext := make([]byte, chunkLen)
+	if _, err := io.ReadFull(g.r, ciphertext); err != nil {
+		return 0, truncated(err)
+	}
+
+	chunkNonce := make([]byte, 12)
+	copy(chunkNonce, g.nonce[:4])
+	binary.BigEndian.PutUint64(chunkNonce[4:], g.counter)
+	g.counter++
+
+	plaintext, err := g.aead.Open(nil, chunkNonce, ciphertext, chunkAAD(g.header, final))
+	if err != nil {
+		return 0, ErrAuthenticationFailed
+	}
+
+	if final {
+		if _, err := io.ReadFull(g.r, make([]byte, 1)); err == nil {
+			return 0, ErrAuthenticationFailed
+		} else if err != io.EOF {
+			return 0, err
+		}
+		g.eof = true
+	}
+
+	n := copy(p, plaintext)
+	if n < len(plaintext) {
+		g.buf = plaintext[n:]
+	}
+	if n == 0 && g.eof {
+		return 0, io.EOF
+	}
+	return n, nil
+}
+
+func truncated(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrAuthenticationFailed
+	}
+	return err
+}
 
 func NewDecryptReaderGCM(key []byte, r io.Reader) (io.Reader, error) {
 
@@ -248,7 +360,7 @@ func NewDecryptReaderGCM(key []byte, r io.Reader) (io.Reader, error) {
 
 
 func NewEncryptWriter(key []byte, w io.Writer) (io.WriteCloser, error) {
-	return NewEncryptWriterGCM(key, w)
+	return NewEncryptWriterFramed(key, w)
 }
 
 
@@ -288,6 +400,35 @@ func NewDecryptReader(key []byte, r io.Reader) (io.Reader, error) {
 				r:   r,
 			}, nil
 
+		case VersionGCMFramed:
+
+			nonce := make([]byte, 12)
+			if _, err := io.ReadFull(r, nonce); err != nil {
+				return nil, fmt.Errorf("failed to read nonce: %w", err)
+			}
+
+			aead, err := newDataAEAD(key)
+			if err != nil {
+				return nil, err
+			}
+
+			return &gcmReader{
+				aead:   aead,
+				nonce:  nonce,
+				r:      r,
+				header: append(append([]byte{}, peek...), nonce...),
+				framed: true,
+			}, nil
+
+		case VersionX25519, VersionX25519Framed:
+			return newX25519Reader(key, version, r)
+
+		case VersionPassphrase:
+			return newPassphraseReader(key, r)
+
+		case VersionKMS:
+			return nil, ErrKMSRequired
+
 		case VersionCTR:
 
 			return nil, fmt.Errorf("CTR version in new header format not supported")
@@ -0,0 +1,141 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+)
+
+func encryptFramed(t *testing.T, key, plaintext []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := NewEncryptWriterFramed(key, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(plaintext); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func decryptAll(key, data []byte) ([]byte, error) {
+	r, err := NewDecryptReader(key, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+// framedChunks returns the offset of each length prefix in a framed stream.
+func framedChunks(t *testing.T, data []byte) []int {
+	t.Helper()
+	var offsets []int
+	for off := len(MagicHeader) + 1 + 12; off < len(data); {
+		offsets = append(offsets, off)
+		length := binary.BigEndian.Uint32(data[off:]) &^ finalChunkFlag
+		off += 4 + int(length)
+	}
+	return offsets
+}
+
+func TestFramedGCMRoundTrip(t *testing.T) {
+	key, _ := GenerateKey()
+	for _, size := range []int{0, 1, GCMChunkSize - 1, GCMChunkSize, GCMChunkSize + 1, 3 * GCMChunkSize} {
+		plaintext := bytes.Repeat([]byte{0xa5}, size)
+		data := encryptFramed(t, key, plaintext)
+		if data[len(MagicHeader)] != VersionGCMFramed {
+			t.Fatalf("size %d: version %#x", size, data[len(MagicHeader)])
+		}
+
+		chunks := framedChunks(t, data)
+		if want := size/GCMChunkSize + 1; len(chunks) != want {
+			t.Fatalf("size %d: %d chunks, want %d", size, len(chunks), want)
+		}
+		for i, off := range chunks {
+			final := binary.BigEndian.Uint32(data[off:])&finalChunkFlag != 0
+			if final != (i == len(chunks)-1) {
+				t.Fatalf("size %d: chunk %d final flag = %v", size, i, final)
+			}
+		}
+
+		got, err := decryptAll(key, data)
+		if err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+		if !bytes.Equal(got, plaintext) {
+			t.Fatalf("size %d: plaintext mismatch", size)
+		}
+	}
+}
+
+func TestFramedGCMRejectsTampering(t *testing.T) {
+	key, _ := GenerateKey()
+	plaintext := bytes.Repeat([]byte("framed "), GCMChunkSize*5/14)
+	data := encryptFramed(t, key, plaintext)
+	chunks := framedChunks(t, data)
+	if len(chunks) != 3 {
+		t.Fatalf("fixture has %d chunks, want 3", len(chunks))
+	}
+	last := chunks[len(chunks)-1]
+
+	tests := []struct {
+		name   string
+		tamper func(d []byte) []byte
+	}{
+		{"drop the final chunk", func(d []byte) []byte { return d[:last] }},
+		{"cut inside the final chunk", func(d []byte) []byte { return d[:len(d)-10] }},
+		{"cut inside a length prefix", func(d []byte) []byte { return d[:chunks[1]+2] }},
+		{"header only", func(d []byte) []byte { return d[:chunks[0]] }},
+		{"mark a middle chunk final", func(d []byte) []byte {
+			d[chunks[1]] |= 0x80
+			return d[:chunks[2]]
+		}},
+		{"clear the final flag", func(d []byte) []byte {
+			d[last] &^= 0x80
+			return d
+		}},
+		{"trailing data", func(d []byte) []byte { return append(d, 0) }},
+		{"flip a ciphertext byte", func(d []byte) []byte {
+			d[chunks[1]+10] ^= 1
+			return d
+		}},
+		{"flip a nonce byte", func(d []byte) []byte {
+			d[len(MagicHeader)+1] ^= 1
+			return d
+		}},
+		{"swap two chunks", func(d []byte) []byte {
+			out := append([]byte{}, d[:chunks[0]]...)
+			out = append(out, d[chunks[1]:chunks[2]]...)
+			out = append(out, d[chunks[0]:chunks[1]]...)
+			return append(out, d[chunks[2]:]...)
+		}},
+		{"oversized chunk length", func(d []byte) []byte {
+			binary.BigEndian.PutUint32(d[chunks[0]:], GCMChunkSize+1024)
+			return d
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tampered := tt.tamper(append([]byte{}, data...))
+			_, err := decryptAll(key, tampered)
+			if !errors.Is(err, ErrAuthenticationFailed) {
+				t.Fatalf("got %v, want ErrAuthenticationFailed", err)
+			}
+		})
+	}
+}
+
+func TestFramedGCMDowngradeFails(t *testing.T) {
+	key, _ := GenerateKey()
+	data := encryptFramed(t, key, []byte("downgrade"))
+	data[len(MagicHeader)] = VersionGCM
+	if got, err := decryptAll(key, data); err == nil {
+		t.Fatalf("downgraded stream decrypted to %q", got)
+	}
+}
@@ -0,0 +1,154 @@
+package crypto
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const VersionKMS byte = 0x07
+
+const (
+	maxKMSKeyIDLen   = 255
+	maxWrappedKeyLen = 4096
+)
+
+var ErrKMSRequired = errors.New("backup data key is wrapped by an external KMS; a key provider is required to decrypt it")
+
+type KeyProvider interface {
+	KeyID() string
+	WrapKey(ctx context.Context, dataKey []byte) ([]byte, error)
+	UnwrapKey(ctx context.Context, wrapped []byte) ([]byte, error)
+}
+
+func NewEncryptWriterKMS(ctx context.Context, provider KeyProvider, w io.Writer) (io.WriteCloser, error) {
+	keyID := provider.KeyID()
+	if len(keyID) > maxKMSKeyIDLen {
+		return nil, fmt.Errorf("KMS key ID is too long (max %d bytes)", maxKMSKeyIDLen)
+	}
+
+	dataKey, err := GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := provider.WrapKey(ctx, dataKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to wrap data key with %s: %w", keyID, err)
+	}
+	if len(wrapped) == 0 || len(wrapped) > maxWrappedKeyLen {
+		return nil, fmt.Errorf("KMS returned a wrapped key of %d bytes", len(wrapped))
+	}
+
+	nonce := make([]byte, 12)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	var header bytes.Buffer
+	header.Write(MagicHeader)
+	header.WriteByte(VersionKMS)
+	header.Write(nonce)
+	header.WriteByte(byte(len(keyID)))
+	header.WriteString(keyID)
+	binary.Write(&header, binary.BigEndian, uint16(len(wrapped)))
+	header.Write(wrapped)
+
+	aead, err := newDataAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := w.Write(header.Bytes()); err != nil {
+		return nil, fmt.Errorf("failed to write header: %w", err)
+	}
+
+	return &gcmWriter{
+		aead:   aead,
+		nonce:  nonce,
+		w:      w,
+		buf:    make([]byte, 0, GCMChunkSize),
+		header: header.Bytes(),
+		framed: true,
+	}, nil
+}
+
+func NewDecryptReaderKMS(ctx context.Context, provider KeyProvider, r io.Reader) (io.Reader, error) {
+	prefix := make([]byte, len(MagicHeader)+1)
+	if _, err := io.ReadFull(r, prefix); err != nil {
+		return nil, fmt.Errorf("failed to read header: %w", err)
+	}
+	if !bytes.Equal(prefix[:len(MagicHeader)], MagicHeader) {
+		return nil, ErrInvalidHeader
+	}
+	if prefix[len(MagicHeader)] != VersionKMS {
+		return nil, fmt.Errorf("%w: backup is not KMS-encrypted (version %d)", ErrUnsupportedVersion, prefix[len(MagicHeader)])
+	}
+
+	var header bytes.Buffer
+	header.Write(prefix)
+	tr := io.TeeReader(r, &header)
+
+	fixed := make([]byte, 12+1)
+	if _, err := io.ReadFull(tr, fixed); err != nil {
+		return nil, fmt.Errorf("failed to read KMS header: %w", err)
+	}
+	nonce := append([]byte{}, fixed[:12]...)
+
+	keyID := make([]byte, fixed[12])
+	if _, err := io.ReadFull(tr, keyID); err != nil {
+		return nil, fmt.Errorf("failed to read KMS key ID: %w", err)
+	}
+
+	var wrappedLen uint16
+	if err := binary.Read(tr, binary.BigEndian, &wrappedLen); err != nil {
+		return nil, fmt.Errorf("failed to read KMS header: %w", err)
+	}
+	if wrappedLen == 0 || wrappedLen > maxWrappedKeyLen {
+		return nil, ErrInvalidHeader
+	}
+	wrapped := make([]byte, wrappedLen)
+	if _, err := io.ReadFull(tr, wrapped); err != nil {
+		return nil, fmt.Errorf("failed to read wrapped key: %w", err)
+	}
+
+	if id := provider.KeyID(); string(keyID) != id {
+		return nil, fmt.Errorf("backup data key is wrapped by %s, not %s", keyID, id)
+	}
+
+	dataKey, err := provider.UnwrapKey(ctx, wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key with %s: %w", keyID, err)
+	}
+	if err := ValidateKey(dataKey); err != nil {
+		return nil, fmt.Errorf("KMS returned an invalid data key: %w", err)
+	}
+
+	aead, err := newDataAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &gcmReader{
+		aead:   aead,
+		nonce:  nonce,
+		r:      r,
+		header: header.Bytes(),
+		framed: true,
+	}, nil
+}
+
+func KMSKeyID(head []byte) (string, bool) {
+	n := len(MagicHeader) + 1 + 12
+	if len(head) <= n || !bytes.HasPrefix(head, MagicHeader) || head[len(MagicHeader)] != VersionKMS {
+		return "", false
+	}
+	l := int(head[n])
+	if len(head) < n+1+l {
+		return "", false
+	}
+	return string(head[n+1 : n+1+l]), true
+}
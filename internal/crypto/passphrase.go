@@ -0,0 +1,132 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/argon2"
+)
+
+const VersionPassphrase byte = 0x06
+
+const (
+	passphraseSaltLen   = 16
+	passphraseParamsLen = 4 + 4 + 1
+	minPassphraseLen    = 8
+	maxArgon2Time       = 16
+	maxArgon2Memory     = 1024 * 1024
+)
+
+type Argon2Params struct {
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+}
+
+var DefaultArgon2Params = Argon2Params{
+	Time:    argon2Time,
+	Memory:  argon2Memory,
+	Threads: argon2Threads,
+}
+
+func (p Argon2Params) validate() error {
+	if p.Time == 0 || p.Time > maxArgon2Time || p.Memory < 8*uint32(p.Threads) || p.Memory > maxArgon2Memory || p.Threads == 0 {
+		return fmt.Errorf("%w: argon2 parameters out of range", ErrInvalidHeader)
+	}
+	return nil
+}
+
+func DeriveKeyWithParams(passphrase string, salt []byte, params Argon2Params) []byte {
+	return argon2.IDKey([]byte(passphrase), salt, params.Time, params.Memory, params.Threads, argon2KeyLen)
+}
+
+func NewEncryptWriterPassphrase(passphrase string, w io.Writer) (io.WriteCloser, error) {
+	return newEncryptWriterPassphrase(passphrase, DefaultArgon2Params, w)
+}
+
+func newEncryptWriterPassphrase(passphrase string, params Argon2Params, w io.Writer) (io.WriteCloser, error) {
+	if len(passphrase) < minPassphraseLen {
+		return nil, fmt.Errorf("passphrase must be at least %d characters", minPassphraseLen)
+	}
+	if err := params.validate(); err != nil {
+		return nil, err
+	}
+
+	salt := make([]byte, passphraseSaltLen)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+	nonce := make([]byte, 12)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	header := make([]byte, 0, len(MagicHeader)+1+passphraseSaltLen+passphraseParamsLen+len(nonce))
+	header = append(header, MagicHeader...)
+	header = append(header, VersionPassphrase)
+	header = append(header, salt...)
+	header = binary.BigEndian.AppendUint32(header, params.Time)
+	header = binary.BigEndian.AppendUint32(header, params.Memory)
+	header = append(header, params.Threads)
+	header = append(header, nonce...)
+
+	aead, err := newDataAEAD(DeriveKeyWithParams(passphrase, salt, params))
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := w.Write(header); err != nil {
+		return nil, fmt.Errorf("failed to write header: %w", err)
+	}
+
+	return &gcmWriter{
+		aead:   aead,
+		nonce:  nonce,
+		w:      w,
+		buf:    make([]byte, 0, GCMChunkSize),
+		header: header,
+		framed: true,
+	}, nil
+}
+
+func NewDecryptReaderPassphrase(passphrase string, r io.Reader) (io.Reader, error) {
+	return NewDecryptReader([]byte(passphrase), r)
+}
+
+func newPassphraseReader(passphrase []byte, r io.Reader) (io.Reader, error) {
+	rest := make([]byte, passphraseSaltLen+passphraseParamsLen+12)
+	if _, err := io.ReadFull(r, rest); err != nil {
+		return nil, fmt.Errorf("failed to read passphrase header: %w", err)
+	}
+
+	salt := rest[:passphraseSaltLen]
+	params := Argon2Params{
+		Time:    binary.BigEndian.Uint32(rest[passphraseSaltLen:]),
+		Memory:  binary.BigEndian.Uint32(rest[passphraseSaltLen+4:]),
+		Threads: rest[passphraseSaltLen+8],
+	}
+	if err := params.validate(); err != nil {
+		return nil, err
+	}
+	nonce := rest[passphraseSaltLen+passphraseParamsLen:]
+
+	aead, err := newDataAEAD(DeriveKeyWithParams(string(passphrase), salt, params))
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 0, len(MagicHeader)+1+len(rest))
+	header = append(header, MagicHeader...)
+	header = append(header, VersionPassphrase)
+	header = append(header, rest...)
+
+	return &gcmReader{
+		aead:   aead,
+		nonce:  nonce,
+		r:      r,
+		header: header,
+		framed: true,
+	}, nil
+}
@@ -0,0 +1,88 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// testArgon2Params keeps key derivation cheap in tests.
+var testArgon2Params = Argon2Params{Time: 1, Memory: 64, Threads: 1}
+
+func sealPassphrase(t *testing.T, passphrase string, plaintext []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := newEncryptWriterPassphrase(passphrase, testArgon2Params, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(plaintext); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestPassphraseRoundTrip(t *testing.T) {
+	plaintext := bytes.Repeat([]byte("stacksnap"), GCMChunkSize/4)
+	data := sealPassphrase(t, "correct horse", plaintext)
+
+	got, err := decryptAll([]byte("correct horse"), data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, plaintext) {
+		t.Fatal("decrypted data differs")
+	}
+}
+
+func TestPassphraseWrongPassphrase(t *testing.T) {
+	data := sealPassphrase(t, "correct horse", []byte("secret"))
+
+	if _, err := decryptAll([]byte("battery staple"), data); !errors.Is(err, ErrAuthenticationFailed) {
+		t.Fatalf("got %v, want ErrAuthenticationFailed", err)
+	}
+}
+
+func TestPassphraseTamperedHeader(t *testing.T) {
+	data := sealPassphrase(t, "correct horse", []byte("secret"))
+
+	salt := len(MagicHeader) + 1
+	params := salt + passphraseSaltLen
+	nonce := params + passphraseParamsLen
+	tests := []struct {
+		name    string
+		tamper  func(b []byte)
+		wantErr error
+	}{
+		{"salt", func(b []byte) { b[salt] ^= 0x01 }, ErrAuthenticationFailed},
+		{"argon2 time", func(b []byte) { binary.BigEndian.PutUint32(b[params:], 2) }, ErrAuthenticationFailed},
+		{"argon2 memory", func(b []byte) { binary.BigEndian.PutUint32(b[params+4:], 128) }, ErrAuthenticationFailed},
+		{"zero time", func(b []byte) { binary.BigEndian.PutUint32(b[params:], 0) }, ErrInvalidHeader},
+		{"excessive memory", func(b []byte) { binary.BigEndian.PutUint32(b[params+4:], maxArgon2Memory+1) }, ErrInvalidHeader},
+		{"zero threads", func(b []byte) { b[params+8] = 0 }, ErrInvalidHeader},
+		{"nonce", func(b []byte) { b[nonce] ^= 0x01 }, ErrAuthenticationFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tampered := bytes.Clone(data)
+			tt.tamper(tampered)
+			if _, err := decryptAll([]byte("correct horse"), tampered); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPassphraseTooShort(t *testing.T) {
+	var buf bytes.Buffer
+	if _, err := NewEncryptWriterPassphrase("short", &buf); err == nil {
+		t.Fatal("short passphrase accepted")
+	}
+	if buf.Len() != 0 {
+		t.Fatal("header written for a rejected passphrase")
+	}
+}
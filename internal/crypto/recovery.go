@@ -8,6 +8,7 @@ import (
 	"encoding/hex"
 	"fmt"
 	"io"
+	"time"
 
 	"golang.org/x/crypto/argon2"
 )
@@ -24,6 +25,8 @@ type RecoveryKit struct {
 	Salt     string `json:"salt"`
 	EncryptedKey string `json:"encrypted_key"`
 	Hint     string `json:"hint,omitempty"`
+	KeyID        string `json:"key_id,omitempty"`
+	CreatedAt    string `json:"created_at,omitempty"`
 }
 
 
@@ -81,6 +84,7 @@ func CreateRecoveryKit(backupKey []byte, passphrase string, hint string) (*Recov
 		Salt:     hex.EncodeToString(salt),
 		EncryptedKey: hex.EncodeToString(ciphertext),
 		Hint:     hint,
+		CreatedAt:    time.Now().UTC().Format(time.RFC3339),
 	}, nil
 }
 
@@ -165,12 +169,9 @@ func KeyFromHex(hexKey string) ([]byte, error) {
 
 
 
-func DeriveKeyFromPassword(password string, salt []byte) []byte {
-	if salt == nil {
-		salt = make([]byte, 16)
-
-
-		copy(salt, []byte("stacksnap-salt!"))
+func DeriveKeyFromPassword(password string, salt []byte) ([]byte, error) {
+	if len(salt) < passphraseSaltLen {
+		return nil, fmt.Errorf("salt must be at least %d bytes, got %d", passphraseSaltLen, len(salt))
 	}
 
 	return argon2.IDKey(
@@ -180,7 +181,7 @@ func DeriveKeyFromPassword(password string, salt []byte) []byte {
 		argon2Memory,
 		argon2Threads,
 		argon2KeyLen,
-	)
+	), nil
 }
 
 
@@ -0,0 +1,122 @@
+package crypto
+
+import (
+	"encoding/base32"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const RecoveryCodePrefix = "SSNAP-KIT:"
+
+const recoveryLineWidth = 48
+
+var recoveryEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+func (k *RecoveryKit) Code() (string, error) {
+	raw, err := json.Marshal(k)
+	if err != nil {
+		return "", err
+	}
+	return RecoveryCodePrefix + recoveryEncoding.EncodeToString(raw), nil
+}
+
+func (k *RecoveryKit) Text() (string, error) {
+	code, err := k.Code()
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	b.WriteString("StackSnap Recovery Kit\n")
+	b.WriteString("======================\n\n")
+	if k.KeyID != "" {
+		fmt.Fprintf(&b, "Key ID:  %s\n", k.KeyID)
+	}
+	if k.CreatedAt != "" {
+		fmt.Fprintf(&b, "Created: %s\n", k.CreatedAt)
+	}
+	hint := k.Hint
+	if hint == "" {
+		hint = "(none)"
+	}
+	fmt.Fprintf(&b, "Hint:    %s\n\n", hint)
+
+	b.WriteString("Recovery code:\n")
+	writeCodeLines(&b, code)
+	b.WriteString("\n")
+
+	b.WriteString("Restore the key with `stacksnap keys recover <this file>` and the kit passphrase.\n")
+	b.WriteString("Keep this sheet offline. Anyone with it and the passphrase can decrypt your backups.\n")
+	return b.String(), nil
+}
+
+func ParseRecoveryKit(data []byte) (*RecoveryKit, error) {
+	raw, err := decodeCode(data, RecoveryCodePrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var kit RecoveryKit
+	if err := json.Unmarshal(raw, &kit); err != nil {
+		return nil, fmt.Errorf("invalid recovery kit: %w", err)
+	}
+	if kit.Salt == "" || kit.EncryptedKey == "" {
+		return nil, fmt.Errorf("invalid recovery kit: missing key material")
+	}
+	return &kit, nil
+}
+
+func decodeCode(data []byte, prefix string) ([]byte, error) {
+	text := strings.TrimSpace(string(data))
+	if text == "" {
+		return nil, fmt.Errorf("input is empty")
+	}
+	if text[0] == '{' {
+		return []byte(text), nil
+	}
+
+	code, err := extractCode(text, prefix)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := recoveryEncoding.DecodeString(code)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s code: %w", strings.TrimSuffix(prefix, ":"), err)
+	}
+	return raw, nil
+}
+
+func writeCodeLines(b *strings.Builder, code string) {
+	for len(code) > recoveryLineWidth {
+		b.WriteString(code[:recoveryLineWidth] + "\n")
+		code = code[recoveryLineWidth:]
+	}
+	b.WriteString(code + "\n")
+}
+
+func extractCode(text, prefix string) (string, error) {
+	idx := strings.Index(text, prefix)
+	if idx < 0 {
+		return "", fmt.Errorf("no %s code found", prefix)
+	}
+
+	var code strings.Builder
+	for i, line := range strings.Split(text[idx+len(prefix):], "\n") {
+		line = strings.TrimSpace(line)
+		if i > 0 && (line == "" || !isRecoveryCodeLine(line)) {
+			break
+		}
+		code.WriteString(strings.ReplaceAll(line, " ", ""))
+	}
+	return code.String(), nil
+}
+
+func isRecoveryCodeLine(line string) bool {
+	for _, c := range line {
+		if !(c >= 'A' && c <= 'Z' || c >= '2' && c <= '7' || c == ' ') {
+			return false
+		}
+	}
+	return true
+}
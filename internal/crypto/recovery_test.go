@@ -0,0 +1,131 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestRecoveryKitFormats(t *testing.T) {
+	key, _ := GenerateKey()
+	kit, err := CreateRecoveryKit(key, "correct horse battery", "the usual")
+	if err != nil {
+		t.Fatal(err)
+	}
+	kit.KeyID = "0123456789abcdef"
+
+	text, err := kit.Text()
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := kit.Code()
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := json.Marshal(kit)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"text sheet", text},
+		{"bare code", code},
+		{"code with surrounding notes", "printed 2026-01-01\n" + code + "\n\nstored in the safe\n"},
+		{"json", string(raw)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parsed, err := ParseRecoveryKit([]byte(tt.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *parsed != *kit {
+				t.Fatalf("parsed %+v, want %+v", parsed, kit)
+			}
+		})
+	}
+
+	got, err := RecoverKey(kit, "correct horse battery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, key) {
+		t.Fatal("recovered key differs")
+	}
+}
+
+func TestRecoveryKitErrors(t *testing.T) {
+	key, _ := GenerateKey()
+	kit, err := CreateRecoveryKit(key, "correct horse battery", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		run     func() error
+		wantErr string
+	}{
+		{"short passphrase", func() error {
+			_, err := CreateRecoveryKit(key, "short", "")
+			return err
+		}, "at least 8 characters"},
+		{"wrong passphrase", func() error {
+			_, err := RecoverKey(kit, "wrong horse battery")
+			return err
+		}, "wrong passphrase"},
+		{"unknown version", func() error {
+			k := *kit
+			k.Version = RecoveryKitVersion + 1
+			_, err := RecoverKey(&k, "correct horse battery")
+			return err
+		}, "unsupported recovery kit version"},
+		{"empty input", func() error {
+			_, err := ParseRecoveryKit([]byte("  \n"))
+			return err
+		}, "is empty"},
+		{"no code", func() error {
+			_, err := ParseRecoveryKit([]byte("StackSnap Recovery Kit\n"))
+			return err
+		}, "no SSNAP-KIT: code found"},
+		{"missing key material", func() error {
+			_, err := ParseRecoveryKit([]byte(`{"version":1}`))
+			return err
+		}, "missing key material"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.run()
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDeriveKeyFromPasswordSalt(t *testing.T) {
+	tests := []struct {
+		name    string
+		salt    []byte
+		wantErr bool
+	}{
+		{"nil salt", nil, true},
+		{"short salt", []byte("stacksnap-salt!"), true},
+		{"random salt", bytes.Repeat([]byte{0x5a}, passphraseSaltLen), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := DeriveKeyFromPassword("correct horse", tt.salt)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && len(key) != argon2KeyLen {
+				t.Fatalf("derived %d bytes, want %d", len(key), argon2KeyLen)
+			}
+		})
+	}
+}
@@ -0,0 +1,234 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const ShareVersion = 1
+
+const ShareCodePrefix = "SSNAP-SHARE:"
+
+var (
+	ErrShareChecksum = errors.New("share checksum mismatch: the share is corrupted")
+	ErrShareMismatch = errors.New("shares do not belong to the same key")
+)
+
+type Share struct {
+	Version   int    `json:"version"`
+	KeyID     string `json:"key_id"`
+	Threshold int    `json:"threshold"`
+	Total     int    `json:"total"`
+	Index     int    `json:"index"`
+	Data      string `json:"data"`
+	Checksum  string `json:"checksum"`
+}
+
+func KeyFingerprint(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
+
+func SplitKey(key []byte, threshold, total int) ([]*Share, error) {
+	if err := ValidateKey(key); err != nil {
+		return nil, err
+	}
+	if threshold < 2 {
+		return nil, fmt.Errorf("threshold must be at least 2")
+	}
+	if total < threshold {
+		return nil, fmt.Errorf("number of shares (%d) must be at least the threshold (%d)", total, threshold)
+	}
+	if total > 255 {
+		return nil, fmt.Errorf("at most 255 shares are supported")
+	}
+
+	coeffs := make([]byte, threshold-1)
+	values := make([][]byte, total)
+	for i := range values {
+		values[i] = make([]byte, len(key))
+	}
+
+	for b, secret := range key {
+		if _, err := io.ReadFull(rand.Reader, coeffs); err != nil {
+			return nil, fmt.Errorf("failed to generate coefficients: %w", err)
+		}
+		for i := range values {
+			values[i][b] = evalPolynomial(secret, coeffs, byte(i+1))
+		}
+	}
+
+	id := KeyFingerprint(key)
+	shares := make([]*Share, total)
+	for i, v := range values {
+		s := &Share{
+			Version:   ShareVersion,
+			KeyID:     id,
+			Threshold: threshold,
+			Total:     total,
+			Index:     i + 1,
+			Data:      hex.EncodeToString(v),
+		}
+		s.Checksum = s.checksum()
+		shares[i] = s
+	}
+	return shares, nil
+}
+
+func CombineShares(shares []*Share) ([]byte, error) {
+	if len(shares) == 0 {
+		return nil, fmt.Errorf("no shares given")
+	}
+
+	first := shares[0]
+	seen := make(map[int]bool)
+	xs := make([]byte, 0, len(shares))
+	ys := make([][]byte, 0, len(shares))
+
+	for _, s := range shares {
+		if err := s.Validate(); err != nil {
+			return nil, fmt.Errorf("share %d: %w", s.Index, err)
+		}
+		if s.KeyID != first.KeyID || s.Threshold != first.Threshold || s.Total != first.Total {
+			return nil, ErrShareMismatch
+		}
+		if seen[s.Index] {
+			continue
+		}
+		seen[s.Index] = true
+
+		data, _ := hex.DecodeString(s.Data)
+		if len(ys) > 0 && len(data) != len(ys[0]) {
+			return nil, ErrShareMismatch
+		}
+		xs = append(xs, byte(s.Index))
+		ys = append(ys, data)
+	}
+
+	if len(xs) < first.Threshold {
+		return nil, fmt.Errorf("%d of %d required shares given", len(xs), first.Threshold)
+	}
+	xs, ys = xs[:first.Threshold], ys[:first.Threshold]
+
+	key := make([]byte, len(ys[0]))
+	for b := range key {
+		var secret byte
+		for i, xi := range xs {
+			num, den := byte(1), byte(1)
+			for j, xj := range xs {
+				if i == j {
+					continue
+				}
+				num = gfMul(num, xj)
+				den = gfMul(den, xi^xj)
+			}
+			secret ^= gfMul(ys[i][b], gfMul(num, gfInv(den)))
+		}
+		key[b] = secret
+	}
+
+	if KeyFingerprint(key) != first.KeyID {
+		return nil, fmt.Errorf("%w: recombined key does not match fingerprint %s", ErrShareMismatch, first.KeyID)
+	}
+	return key, nil
+}
+
+func (s *Share) Validate() error {
+	if s.Version != ShareVersion {
+		return fmt.Errorf("unsupported share version: %d", s.Version)
+	}
+	if s.Index < 1 || s.Index > 255 || s.Threshold < 2 || s.Total < s.Threshold {
+		return fmt.Errorf("invalid share parameters")
+	}
+	if _, err := hex.DecodeString(s.Data); err != nil {
+		return fmt.Errorf("invalid share data: %w", err)
+	}
+	if s.Checksum != s.checksum() {
+		return ErrShareChecksum
+	}
+	return nil
+}
+
+func (s *Share) checksum() string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%d|%s|%d|%d|%d|%s", s.Version, s.KeyID, s.Threshold, s.Total, s.Index, s.Data)))
+	return hex.EncodeToString(sum[:4])
+}
+
+func (s *Share) Code() (string, error) {
+	raw, err := json.Marshal(s)
+	if err != nil {
+		return "", err
+	}
+	return ShareCodePrefix + recoveryEncoding.EncodeToString(raw), nil
+}
+
+func (s *Share) Text() (string, error) {
+	code, err := s.Code()
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "StackSnap Key Share %d of %d\n", s.Index, s.Total)
+	b.WriteString("===========================\n\n")
+	fmt.Fprintf(&b, "Key ID:    %s\n", s.KeyID)
+	fmt.Fprintf(&b, "Threshold: any %d of %d shares rebuild the key\n\n", s.Threshold, s.Total)
+
+	b.WriteString("Share code:\n")
+	writeCodeLines(&b, code)
+	b.WriteString("\n")
+
+	b.WriteString("Rebuild the key with `stacksnap keys combine <share files...>`.\n")
+	b.WriteString("Keep this share apart from the others.\n")
+	return b.String(), nil
+}
+
+func ParseShare(data []byte) (*Share, error) {
+	raw, err := decodeCode(data, ShareCodePrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var s Share
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return nil, fmt.Errorf("invalid share: %w", err)
+	}
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func evalPolynomial(secret byte, coeffs []byte, x byte) byte {
+	var y byte
+	for i := len(coeffs) - 1; i >= 0; i-- {
+		y = gfMul(y, x) ^ coeffs[i]
+	}
+	return gfMul(y, x) ^ secret
+}
+
+func gfMul(a, b byte) byte {
+	var p byte
+	for i := 0; i < 8; i++ {
+		p ^= -(b & 1) & a
+		hi := a >> 7
+		a <<= 1
+		a ^= -hi & 0x1b
+		b >>= 1
+	}
+	return p
+}
+
+func gfInv(a byte) byte {
+	result := byte(1)
+	for i := 0; i < 254; i++ {
+		result = gfMul(result, a)
+	}
+	return result
+}
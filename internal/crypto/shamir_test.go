@@ -0,0 +1,168 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestGFInverse(t *testing.T) {
+	for a := 1; a < 256; a++ {
+		if got := gfMul(byte(a), gfInv(byte(a))); got != 1 {
+			t.Fatalf("%#x * inv(%#x) = %#x", a, a, got)
+		}
+	}
+}
+
+func pickShares(shares []*Share, indexes ...int) []*Share {
+	picked := make([]*Share, 0, len(indexes))
+	for _, i := range indexes {
+		picked = append(picked, shares[i-1])
+	}
+	return picked
+}
+
+func TestSplitAndCombine(t *testing.T) {
+	tests := []struct {
+		name      string
+		threshold int
+		total     int
+		use       []int
+		wantErr   string
+	}{
+		{name: "2 of 3, first two", threshold: 2, total: 3, use: []int{1, 2}},
+		{name: "2 of 3, last two", threshold: 2, total: 3, use: []int{3, 2}},
+		{name: "3 of 5, spread out", threshold: 3, total: 5, use: []int{5, 1, 3}},
+		{name: "3 of 5, all shares", threshold: 3, total: 5, use: []int{1, 2, 3, 4, 5}},
+		{name: "threshold equals total", threshold: 4, total: 4, use: []int{4, 3, 2, 1}},
+		{name: "too few shares", threshold: 3, total: 5, use: []int{1, 2}, wantErr: "2 of 3 required shares"},
+		{name: "duplicates do not count", threshold: 3, total: 5, use: []int{2, 2, 4}, wantErr: "2 of 3 required shares"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, _ := GenerateKey()
+			shares, err := SplitKey(key, tt.threshold, tt.total)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(shares) != tt.total {
+				t.Fatalf("got %d shares, want %d", len(shares), tt.total)
+			}
+
+			got, err := CombineShares(pickShares(shares, tt.use...))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, key) {
+				t.Fatal("recombined key differs")
+			}
+		})
+	}
+}
+
+func TestSplitKeyRejectsParameters(t *testing.T) {
+	key, _ := GenerateKey()
+	tests := []struct {
+		name      string
+		key       []byte
+		threshold int
+		total     int
+	}{
+		{"threshold below 2", key, 1, 3},
+		{"fewer shares than threshold", key, 3, 2},
+		{"more than 255 shares", key, 2, 256},
+		{"short key", key[:16], 2, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := SplitKey(tt.key, tt.threshold, tt.total); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
+
+func TestCombineSharesRejectsBadShares(t *testing.T) {
+	key, _ := GenerateKey()
+	shares, err := SplitKey(key, 2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, _ := GenerateKey()
+	other, err := SplitKey(otherKey, 2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		shares  func() []*Share
+		wantErr error
+	}{
+		{"corrupted data", func() []*Share {
+			s := *shares[0]
+			data, _ := hex.DecodeString(s.Data)
+			data[0] ^= 1
+			s.Data = hex.EncodeToString(data)
+			return []*Share{&s, shares[1]}
+		}, ErrShareChecksum},
+		{"shares of another key", func() []*Share {
+			return []*Share{shares[0], other[1]}
+		}, ErrShareMismatch},
+		{"altered data with a fixed checksum", func() []*Share {
+			s := *shares[0]
+			data, _ := hex.DecodeString(s.Data)
+			data[0] ^= 1
+			s.Data = hex.EncodeToString(data)
+			s.Checksum = s.checksum()
+			return []*Share{&s, shares[1]}
+		}, ErrShareMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := CombineShares(tt.shares())
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestShareTextRoundTrip(t *testing.T) {
+	key, _ := GenerateKey()
+	shares, err := SplitKey(key, 2, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var parsed []*Share
+	for _, s := range shares {
+		text, err := s.Text()
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, err := ParseShare([]byte(text))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *p != *s {
+			t.Fatalf("parsed %+v, want %+v", p, s)
+		}
+		parsed = append(parsed, p)
+	}
+	got, err := CombineShares(parsed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, key) {
+		t.Fatal("recombined key differs")
+	}
+}
@@ -0,0 +1,139 @@
+package crypto
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const DefaultVaultTransitMount = "transit"
+
+type VaultTransit struct {
+	Address   string
+	Token     string
+	Mount     string
+	KeyName   string
+	Namespace string
+	Client    *http.Client
+}
+
+func NewVaultTransit(address, token, mount, keyName string) (*VaultTransit, error) {
+	if address == "" {
+		return nil, fmt.Errorf("vault address is required")
+	}
+	if _, err := url.Parse(address); err != nil {
+		return nil, fmt.Errorf("invalid vault address: %w", err)
+	}
+	if token == "" {
+		return nil, fmt.Errorf("vault token is required")
+	}
+	if keyName == "" {
+		return nil, fmt.Errorf("vault transit key name is required")
+	}
+	if mount == "" {
+		mount = DefaultVaultTransitMount
+	}
+
+	return &VaultTransit{
+		Address: strings.TrimRight(address, "/"),
+		Token:   token,
+		Mount:   strings.Trim(mount, "/"),
+		KeyName: keyName,
+		Client:  &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+func (v *VaultTransit) KeyID() string {
+	return "vault-transit:" + v.Mount + "/" + v.KeyName
+}
+
+func (v *VaultTransit) WrapKey(ctx context.Context, dataKey []byte) ([]byte, error) {
+	var resp struct {
+		Ciphertext string `json:"ciphertext"`
+	}
+	err := v.call(ctx, "encrypt", map[string]string{
+		"plaintext": base64.StdEncoding.EncodeToString(dataKey),
+	}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(resp.Ciphertext, "vault:") {
+		return nil, fmt.Errorf("vault returned an unexpected ciphertext")
+	}
+	return []byte(resp.Ciphertext), nil
+}
+
+func (v *VaultTransit) UnwrapKey(ctx context.Context, wrapped []byte) ([]byte, error) {
+	var resp struct {
+		Plaintext string `json:"plaintext"`
+	}
+	err := v.call(ctx, "decrypt", map[string]string{
+		"ciphertext": string(wrapped),
+	}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	key, err := base64.StdEncoding.DecodeString(resp.Plaintext)
+	if err != nil {
+		return nil, fmt.Errorf("vault returned an invalid plaintext: %w", err)
+	}
+	return key, nil
+}
+
+func (v *VaultTransit) call(ctx context.Context, op string, payload interface{}, out interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	endpoint := fmt.Sprintf("%s/v1/%s/%s/%s", v.Address, v.Mount, op, url.PathEscape(v.KeyName))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Vault-Token", v.Token)
+	if v.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.Namespace)
+	}
+
+	client := v.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not reach vault: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("failed to read vault response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var verr struct {
+			Errors []string `json:"errors"`
+		}
+		if json.Unmarshal(data, &verr) == nil && len(verr.Errors) > 0 {
+			return fmt.Errorf("vault transit %s failed (%d): %s", op, resp.StatusCode, strings.Join(verr.Errors, "; "))
+		}
+		return fmt.Errorf("vault transit %s failed: %s", op, resp.Status)
+	}
+
+	var envelope struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(data, &envelope); err != nil || len(envelope.Data) == 0 {
+		return fmt.Errorf("vault returned an invalid %s response", op)
+	}
+	return json.Unmarshal(envelope.Data, out)
+}
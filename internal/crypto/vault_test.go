@@ -0,0 +1,223 @@
+package crypto
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testVaultToken = "s.test-token"
+
+// fakeTransit is a minimal stand-in for Vault's transit engine: encrypt and
+// decrypt under named AES-GCM keys, with Vault's token check and error
+// envelope.
+type fakeTransit struct {
+	keys map[string]cipher.AEAD
+}
+
+func newFakeTransit(t *testing.T, names ...string) *httptest.Server {
+	t.Helper()
+	f := &fakeTransit{keys: make(map[string]cipher.AEAD)}
+	for _, name := range names {
+		block, err := aes.NewCipher(bytes.Repeat([]byte(name[:1]), 32))
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.keys[name], _ = cipher.NewGCM(block)
+	}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func (f *fakeTransit) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fail := func(status int, msg string) {
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string][]string{"errors": {msg}})
+	}
+	if r.Header.Get("X-Vault-Token") != testVaultToken {
+		fail(http.StatusForbidden, "permission denied")
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/v1/"), "/")
+	if r.Method != http.MethodPost || len(parts) != 3 || parts[0] != DefaultVaultTransitMount {
+		fail(http.StatusNotFound, "no handler for route")
+		return
+	}
+	aead, ok := f.keys[parts[2]]
+	if !ok {
+		fail(http.StatusBadRequest, "encryption key not found")
+		return
+	}
+
+	var req map[string]string
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		fail(http.StatusBadRequest, "invalid request")
+		return
+	}
+	var data map[string]string
+	switch parts[1] {
+	case "encrypt":
+		plain, err := base64.StdEncoding.DecodeString(req["plaintext"])
+		if err != nil {
+			fail(http.StatusBadRequest, "invalid plaintext")
+			return
+		}
+		nonce := make([]byte, aead.NonceSize())
+		rand.Read(nonce)
+		sealed := aead.Seal(nonce, nonce, plain, nil)
+		data = map[string]string{"ciphertext": "vault:v1:" + base64.StdEncoding.EncodeToString(sealed)}
+	case "decrypt":
+		raw, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(req["ciphertext"], "vault:v1:"))
+		if err != nil || len(raw) < aead.NonceSize() {
+			fail(http.StatusBadRequest, "invalid ciphertext")
+			return
+		}
+		plain, err := aead.Open(nil, raw[:aead.NonceSize()], raw[aead.NonceSize():], nil)
+		if err != nil {
+			fail(http.StatusBadRequest, "cipher: message authentication failed")
+			return
+		}
+		data = map[string]string{"plaintext": base64.StdEncoding.EncodeToString(plain)}
+	default:
+		fail(http.StatusNotFound, "no handler for route")
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+}
+
+func newTestVault(t *testing.T, address, token, keyName string) *VaultTransit {
+	t.Helper()
+	v, err := NewVaultTransit(address, token, "", keyName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestVaultTransitRoundTrip(t *testing.T) {
+	srv := newFakeTransit(t, "backups")
+	v := newTestVault(t, srv.URL, testVaultToken, "backups")
+	ctx := context.Background()
+
+	dataKey, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrapped, err := v.WrapKey(ctx, dataKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(wrapped, []byte("vault:v1:")) {
+		t.Fatalf("unexpected wrapped key %q", wrapped)
+	}
+	got, err := v.UnwrapKey(ctx, wrapped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, dataKey) {
+		t.Fatal("unwrapped key differs")
+	}
+
+	plaintext := bytes.Repeat([]byte("stacksnap vault round trip "), 10000)
+	var archive bytes.Buffer
+	w, err := NewEncryptWriterKMS(ctx, v, &archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(plaintext)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if id, ok := KMSKeyID(archive.Bytes()); !ok || id != v.KeyID() {
+		t.Fatalf("header names key %q, want %q", id, v.KeyID())
+	}
+
+	r, err := NewDecryptReaderKMS(ctx, v, bytes.NewReader(archive.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, plaintext) {
+		t.Fatal("plaintext mismatch after KMS round trip")
+	}
+}
+
+func TestVaultTransitErrors(t *testing.T) {
+	srv := newFakeTransit(t, "backups", "other")
+	ctx := context.Background()
+
+	good := newTestVault(t, srv.URL, testVaultToken, "backups")
+	dataKey, _ := GenerateKey()
+	wrapped, err := good.WrapKey(ctx, dataKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		vault   *VaultTransit
+		op      func(*VaultTransit) error
+		wantErr string
+	}{
+		{
+			name:    "bad token on wrap",
+			vault:   newTestVault(t, srv.URL, "s.wrong", "backups"),
+			op:      func(v *VaultTransit) error { _, err := v.WrapKey(ctx, dataKey); return err },
+			wantErr: "permission denied",
+		},
+		{
+			name:    "bad token on unwrap",
+			vault:   newTestVault(t, srv.URL, "s.wrong", "backups"),
+			op:      func(v *VaultTransit) error { _, err := v.UnwrapKey(ctx, wrapped); return err },
+			wantErr: "permission denied",
+		},
+		{
+			name:    "unknown key name",
+			vault:   newTestVault(t, srv.URL, testVaultToken, "missing"),
+			op:      func(v *VaultTransit) error { _, err := v.WrapKey(ctx, dataKey); return err },
+			wantErr: "encryption key not found",
+		},
+		{
+			name:    "unwrap under another key",
+			vault:   newTestVault(t, srv.URL, testVaultToken, "other"),
+			op:      func(v *VaultTransit) error { _, err := v.UnwrapKey(ctx, wrapped); return err },
+			wantErr: "message authentication failed",
+		},
+		{
+			name:  "archive wrapped by another key",
+			vault: newTestVault(t, srv.URL, testVaultToken, "other"),
+			op: func(v *VaultTransit) error {
+				var archive bytes.Buffer
+				w, err := NewEncryptWriterKMS(ctx, good, &archive)
+				if err != nil {
+					return err
+				}
+				w.Close()
+				_, err = NewDecryptReaderKMS(ctx, v, &archive)
+				return err
+			},
+			wantErr: "is wrapped by vault-transit:transit/backups",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.op(tt.vault)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,255 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	VersionX25519       byte = 0x03
+	VersionX25519Framed byte = 0x05
+)
+
+const (
+	RecipientPrefix = "ssnap-pub1"
+	IdentityPrefix  = "ssnap-secret1"
+)
+
+const (
+	recipientFingerprintLen = 8
+	wrappedKeyLen           = 32 + 16
+	stanzaLen               = recipientFingerprintLen + 32 + wrappedKeyLen
+	maxRecipients           = 255
+	x25519Info              = "stacksnap-x25519-v1"
+)
+
+var ErrNoMatchingRecipient = errors.New("backup is not encrypted to this identity")
+
+func GenerateIdentity() (*ecdh.PrivateKey, error) {
+	return ecdh.X25519().GenerateKey(rand.Reader)
+}
+
+func EncodeRecipient(pub *ecdh.PublicKey) string {
+	return RecipientPrefix + base64.RawURLEncoding.EncodeToString(pub.Bytes())
+}
+
+func EncodeIdentity(priv *ecdh.PrivateKey) string {
+	return IdentityPrefix + base64.RawURLEncoding.EncodeToString(priv.Bytes())
+}
+
+func ParseRecipient(s string) (*ecdh.PublicKey, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, RecipientPrefix) {
+		return nil, fmt.Errorf("invalid recipient: expected %s prefix", RecipientPrefix)
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(s, RecipientPrefix))
+	if err != nil {
+		return nil, fmt.Errorf("invalid recipient: %w", err)
+	}
+	return ecdh.X25519().NewPublicKey(raw)
+}
+
+func ParseIdentity(s string) (*ecdh.PrivateKey, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, IdentityPrefix) {
+		return nil, fmt.Errorf("invalid identity: expected %s prefix", IdentityPrefix)
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(s, IdentityPrefix))
+	if err != nil {
+		return nil, fmt.Errorf("invalid identity: %w", err)
+	}
+	return ecdh.X25519().NewPrivateKey(raw)
+}
+
+func RecipientFingerprint(pub *ecdh.PublicKey) string {
+	sum := sha256.Sum256(pub.Bytes())
+	return fmt.Sprintf("%x", sum[:recipientFingerprintLen])
+}
+
+func wrapKeyFor(ephemeral *ecdh.PrivateKey, recipient *ecdh.PublicKey, dataKey []byte) ([]byte, error) {
+	shared, err := ephemeral.ECDH(recipient)
+	if err != nil {
+		return nil, fmt.Errorf("key agreement failed: %w", err)
+	}
+	aead, err := x25519KEK(shared, ephemeral.PublicKey(), recipient)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(nil, make([]byte, aead.NonceSize()), dataKey, nil), nil
+}
+
+func unwrapKeyFrom(identity *ecdh.PrivateKey, ephemeral *ecdh.PublicKey, wrapped []byte) ([]byte, error) {
+	shared, err := identity.ECDH(ephemeral)
+	if err != nil {
+		return nil, fmt.Errorf("key agreement failed: %w", err)
+	}
+	aead, err := x25519KEK(shared, ephemeral, identity.PublicKey())
+	if err != nil {
+		return nil, err
+	}
+	return aead.Open(nil, make([]byte, aead.NonceSize()), wrapped, nil)
+}
+
+func x25519KEK(shared []byte, ephemeral, recipient *ecdh.PublicKey) (cipher.AEAD, error) {
+	salt := append(append([]byte{}, ephemeral.Bytes()...), recipient.Bytes()...)
+	kek, err := hkdf.Key(sha256.New, shared, salt, x25519Info, 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive wrapping key: %w", err)
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+func NewEncryptWriterX25519(recipients []*ecdh.PublicKey, w io.Writer) (io.WriteCloser, error) {
+	if len(recipients) == 0 {
+		return nil, fmt.Errorf("at least one recipient is required")
+	}
+	if len(recipients) > maxRecipients {
+		return nil, fmt.Errorf("too many recipients (max %d)", maxRecipients)
+	}
+
+	dataKey, err := GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, 12)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	var header bytes.Buffer
+	header.Write(MagicHeader)
+	header.WriteByte(VersionX25519Framed)
+	header.Write(nonce)
+	header.WriteByte(byte(len(recipients)))
+
+	for _, r := range recipients {
+		ephemeral, err := GenerateIdentity()
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate ephemeral key: %w", err)
+		}
+		wrapped, err := wrapKeyFor(ephemeral, r, dataKey)
+		if err != nil {
+			return nil, err
+		}
+
+		sum := sha256.Sum256(r.Bytes())
+		header.Write(sum[:recipientFingerprintLen])
+		header.Write(ephemeral.PublicKey().Bytes())
+		header.Write(wrapped)
+	}
+
+	if _, err := w.Write(header.Bytes()); err != nil {
+		return nil, fmt.Errorf("failed to write header: %w", err)
+	}
+
+	aead, err := newDataAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &gcmWriter{
+		aead:   aead,
+		nonce:  nonce,
+		w:      w,
+		buf:    make([]byte, 0, GCMChunkSize),
+		header: header.Bytes(),
+		framed: true,
+	}, nil
+}
+
+func newX25519Reader(identityKey []byte, version byte, r io.Reader) (io.Reader, error) {
+	identity, err := ecdh.X25519().NewPrivateKey(identityKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid X25519 identity: %w", err)
+	}
+
+	nonce := make([]byte, 12)
+	if _, err := io.ReadFull(r, nonce); err != nil {
+		return nil, fmt.Errorf("failed to read nonce: %w", err)
+	}
+
+	count := make([]byte, 1)
+	if _, err := io.ReadFull(r, count); err != nil {
+		return nil, fmt.Errorf("failed to read recipient count: %w", err)
+	}
+	if count[0] == 0 {
+		return nil, ErrInvalidHeader
+	}
+
+	stanzas := make([]byte, int(count[0])*stanzaLen)
+	if _, err := io.ReadFull(r, stanzas); err != nil {
+		return nil, fmt.Errorf("failed to read recipients: %w", err)
+	}
+
+	own := sha256.Sum256(identity.PublicKey().Bytes())
+
+	var dataKey []byte
+	for i := 0; i < int(count[0]); i++ {
+		stanza := stanzas[i*stanzaLen : (i+1)*stanzaLen]
+		if !bytes.Equal(stanza[:recipientFingerprintLen], own[:recipientFingerprintLen]) {
+			continue
+		}
+
+		ephemeral, err := ecdh.X25519().NewPublicKey(stanza[recipientFingerprintLen : recipientFingerprintLen+32])
+		if err != nil {
+			return nil, ErrInvalidHeader
+		}
+		dataKey, err = unwrapKeyFrom(identity, ephemeral, stanza[recipientFingerprintLen+32:])
+		if err != nil {
+			return nil, ErrAuthenticationFailed
+		}
+		break
+	}
+	if dataKey == nil {
+		return nil, ErrNoMatchingRecipient
+	}
+
+	aead, err := newDataAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	reader := &gcmReader{
+		aead:  aead,
+		nonce: nonce,
+		r:     r,
+	}
+	if version == VersionX25519Framed {
+		var header bytes.Buffer
+		header.Write(MagicHeader)
+		header.WriteByte(version)
+		header.Write(nonce)
+		header.Write(count)
+		header.Write(stanzas)
+		reader.header = header.Bytes()
+		reader.framed = true
+	}
+	return reader, nil
+}
+
+func newDataAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
+	}
+	return aead, nil
+}
@@ -0,0 +1,114 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"errors"
+	"io"
+	"testing"
+)
+
+func sealX25519(t *testing.T, recipients []*ecdh.PublicKey, plaintext []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := NewEncryptWriterX25519(recipients, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(plaintext); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func openX25519(identity *ecdh.PrivateKey, data []byte) ([]byte, error) {
+	r, err := NewDecryptReader(identity.Bytes(), bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func TestX25519RoundTrip(t *testing.T) {
+	alice, _ := GenerateIdentity()
+	bob, _ := GenerateIdentity()
+	plaintext := bytes.Repeat([]byte("stacksnap"), GCMChunkSize/4)
+	data := sealX25519(t, []*ecdh.PublicKey{alice.PublicKey(), bob.PublicKey()}, plaintext)
+
+	for name, identity := range map[string]*ecdh.PrivateKey{"first recipient": alice, "second recipient": bob} {
+		t.Run(name, func(t *testing.T) {
+			got, err := openX25519(identity, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, plaintext) {
+				t.Fatal("decrypted data differs")
+			}
+		})
+	}
+}
+
+func TestX25519WrongIdentity(t *testing.T) {
+	alice, _ := GenerateIdentity()
+	mallory, _ := GenerateIdentity()
+	data := sealX25519(t, []*ecdh.PublicKey{alice.PublicKey()}, []byte("secret"))
+
+	if _, err := openX25519(mallory, data); !errors.Is(err, ErrNoMatchingRecipient) {
+		t.Fatalf("got %v, want ErrNoMatchingRecipient", err)
+	}
+}
+
+func TestX25519TamperedHeader(t *testing.T) {
+	alice, _ := GenerateIdentity()
+	data := sealX25519(t, []*ecdh.PublicKey{alice.PublicKey()}, []byte("secret"))
+
+	nonce := len(MagicHeader) + 1
+	count := nonce + 12
+	stanza := count + 1
+	tests := []struct {
+		name   string
+		offset int
+		value  byte
+	}{
+		{"nonce", nonce, data[nonce] ^ 0x01},
+		{"zero recipients", count, 0},
+		{"recipient fingerprint", stanza, data[stanza] ^ 0x01},
+		{"ephemeral key", stanza + recipientFingerprintLen, data[stanza+recipientFingerprintLen] ^ 0x01},
+		{"wrapped key", stanza + recipientFingerprintLen + 32, data[stanza+recipientFingerprintLen+32] ^ 0x01},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tampered := bytes.Clone(data)
+			tampered[tt.offset] = tt.value
+			if _, err := openX25519(alice, tampered); err == nil {
+				t.Fatal("tampered header accepted")
+			}
+		})
+	}
+}
+
+func TestParseRecipientRoundTrip(t *testing.T) {
+	identity, _ := GenerateIdentity()
+
+	pub, err := ParseRecipient(EncodeRecipient(identity.PublicKey()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pub.Equal(identity.PublicKey()) {
+		t.Fatal("recipient changed in encoding")
+	}
+	priv, err := ParseIdentity(EncodeIdentity(identity))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !priv.Equal(identity) {
+		t.Fatal("identity changed in encoding")
+	}
+
+	if _, err := ParseRecipient(EncodeIdentity(identity)); err == nil {
+		t.Fatal("identity accepted as a recipient")
+	}
+}
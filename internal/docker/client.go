@@ -2,11 +2,15 @@
 package docker
 
 import (
+	"archive/tar"
+	"bufio"
 	"bytes"
 	"context"
 	"fmt"
 	"io"
 	"os"
+	"path"
+	"strconv"
 	"strings"
 
 	"github.com/docker/docker/api/types"
@@ -39,6 +43,15 @@ func NewClient() (*Client, error) {
 	}, nil
 }
 
+func (c *Client) WithContext(ctx context.Context) *Client {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return &Client{
+		cli: c.cli,
+		ctx: ctx,
+	}
+}
 
 func (c *Client) Close() error {
 	return c.cli.Close()
@@ -291,11 +304,14 @@ func (c *Client) ExecInContainer(containerID string, cmd []string) ([]byte, erro
 		return nil, fmt.Errorf("failed to attach to exec: %w", err)
 	}
 	defer attachResp.Close()
-
+	stop := context.AfterFunc(c.ctx, attachResp.Close)
+	defer stop()
 
 	var stdoutBuf, stderrBuf []byte
 	stdoutBuf, _ = io.ReadAll(attachResp.Reader)
-
+	if err := c.ctx.Err(); err != nil {
+		return nil, err
+	}
 
 	inspectResp, err := c.cli.ContainerExecInspect(c.ctx, execResp.ID)
 	if err != nil {
@@ -348,8 +364,7 @@ func (c *Client) BackupVolume(volumeName string, w io.Writer) error {
 	if err != nil {
 		return fmt.Errorf("failed to create container: %w", err)
 	}
-	defer c.cli.ContainerRemove(c.ctx, resp.ID, container.RemoveOptions{Force: true})
-
+	defer c.cli.ContainerRemove(context.WithoutCancel(c.ctx), resp.ID, container.RemoveOptions{Force: true})
 
 	attachResp, err := c.cli.ContainerAttach(c.ctx, resp.ID, container.AttachOptions{
 		Stream: true,
@@ -360,7 +375,8 @@ func (c *Client) BackupVolume(volumeName string, w io.Writer) error {
 		return fmt.Errorf("failed to attach to container: %w", err)
 	}
 	defer attachResp.Close()
-
+	stop := context.AfterFunc(c.ctx, attachResp.Close)
+	defer stop()
 
 	if err := c.cli.ContainerStart(c.ctx, resp.ID, container.StartOptions{}); err != nil {
 		return fmt.Errorf("failed to start container: %w", err)
@@ -369,6 +385,9 @@ func (c *Client) BackupVolume(volumeName string, w io.Writer) error {
 
 
 	_, err = stdcopy.StdCopy(w, io.Discard, attachResp.Reader)
+	if ctxErr := c.ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
 	if err != nil {
 		return fmt.Errorf("failed to read backup stream: %w", err)
 	}
@@ -412,8 +431,7 @@ func (c *Client) RestoreVolume(volumeName string, r io.Reader) error {
 	if err != nil {
 		return fmt.Errorf("failed to create container: %w", err)
 	}
-	defer c.cli.ContainerRemove(c.ctx, resp.ID, container.RemoveOptions{Force: true})
-
+	defer c.cli.ContainerRemove(context.WithoutCancel(c.ctx), resp.ID, container.RemoveOptions{Force: true})
 
 	attachResp, err := c.cli.ContainerAttach(c.ctx, resp.ID, container.AttachOptions{
 		Stream: true,
@@ -423,7 +441,8 @@ func (c *Client) RestoreVolume(volumeName string, r io.Reader) error {
 		return fmt.Errorf("failed to attach to container: %w", err)
 	}
 	defer attachResp.Close()
-
+	stop := context.AfterFunc(c.ctx, attachResp.Close)
+	defer stop()
 
 	if err := c.cli.ContainerStart(c.ctx, resp.ID, container.StartOptions{}); err != nil {
 		return fmt.Errorf("failed to start container: %w", err)
@@ -431,6 +450,9 @@ func (c *Client) RestoreVolume(volumeName string, r io.Reader) error {
 
 
 	_, err = io.Copy(attachResp.Conn, r)
+	if ctxErr := c.ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
 	if err != nil {
 		return fmt.Errorf("failed to write restore data: %w", err)
 	}
@@ -456,6 +478,180 @@ func (c *Client) RestoreVolume(volumeName string, r io.Reader) error {
 	return nil
 }
 
+type VolumeFile struct {
+	Path    string
+	Size    int64
+	ModTime int64
+	Inode   uint64
+	Mode    uint32
+}
+
+func (f VolumeFile) IsDir() bool {
+	return f.Mode&modeTypeMask == modeDir
+}
+
+const (
+	modeTypeMask = 0o170000
+	modeDir      = 0o040000
+)
+
+// listVolumeFilesScript prints, for each batch find hands it, the batch size
+// on a line, one stat line per path, then the paths NUL-terminated. Stat
+// lines carry no names, so any byte in a file name is safe.
+const listVolumeFilesScript = `cd /volume && find . -xdev -mindepth 1 -exec sh -c 'printf "%s\n" "$#" && stat -c "%i %s %Y %f" "$@" && printf "%s\0" "$@"' sh {} +`
+
+func (c *Client) ListVolumeFiles(volumeName string) ([]VolumeFile, error) {
+	var out bytes.Buffer
+	if err := c.runInVolume(volumeName, []string{"sh", "-c", listVolumeFilesScript}, nil, &out); err != nil {
+		return nil, fmt.Errorf("failed to list volume files: %w", err)
+	}
+	return parseVolumeFiles(&out)
+}
+
+func parseVolumeFiles(r io.Reader) ([]VolumeFile, error) {
+	br := bufio.NewReader(r)
+	var files []VolumeFile
+	for {
+		line, err := br.ReadString('\n')
+		if err == io.EOF && line == "" {
+			return files, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("truncated file listing: %w", err)
+		}
+		count, err := strconv.Atoi(strings.TrimSuffix(line, "\n"))
+		if err != nil || count < 0 {
+			return nil, fmt.Errorf("unexpected file listing line: %q", line)
+		}
+
+		batch := make([]VolumeFile, count)
+		for i := range batch {
+			line, err := br.ReadString('\n')
+			if err != nil {
+				return nil, fmt.Errorf("truncated file listing: %w", err)
+			}
+			fields := strings.Fields(line)
+			if len(fields) != 4 {
+				return nil, fmt.Errorf("unexpected file listing line: %q", line)
+			}
+			inode, err1 := strconv.ParseUint(fields[0], 10, 64)
+			size, err2 := strconv.ParseInt(fields[1], 10, 64)
+			mtime, err3 := strconv.ParseInt(fields[2], 10, 64)
+			mode, err4 := strconv.ParseUint(fields[3], 16, 32)
+			if err1 != nil || err2 != nil || err3 != nil || err4 != nil {
+				return nil, fmt.Errorf("unexpected file listing line: %q", line)
+			}
+			batch[i] = VolumeFile{Size: size, ModTime: mtime, Inode: inode, Mode: uint32(mode)}
+		}
+		for i := range batch {
+			name, err := br.ReadString(0)
+			if err != nil {
+				return nil, fmt.Errorf("truncated file listing: %w", err)
+			}
+			batch[i].Path = path.Clean(strings.TrimSuffix(name, "\x00"))
+		}
+		files = append(files, batch...)
+	}
+}
+
+// BackupVolumeFiles archives exactly the given paths. Directories are
+// archived without their contents, since every changed file inside one is
+// listed in its own right.
+func (c *Client) BackupVolumeFiles(volumeName string, paths []string, w io.Writer) error {
+	if len(paths) == 0 {
+		return tar.NewWriter(w).Close()
+	}
+
+	var list strings.Builder
+	for _, p := range paths {
+		list.WriteString("./" + p + "\x00")
+	}
+	return c.runInVolume(volumeName, []string{"tar", "-cf", "-", "-C", "/volume", "--no-recursion", "--null", "-T", "-"}, strings.NewReader(list.String()), w)
+}
+
+func (c *Client) RemoveVolumeFiles(volumeName string, paths []string) error {
+	if len(paths) == 0 {
+		return nil
+	}
+
+	var list strings.Builder
+	for _, p := range paths {
+		list.WriteString("./" + p + "\x00")
+	}
+	return c.runInVolume(volumeName, []string{"sh", "-c", "cd /volume && xargs -0 rm -rf --"}, strings.NewReader(list.String()), io.Discard)
+}
+
+func (c *Client) runInVolume(volumeName string, cmd []string, stdin io.Reader, stdout io.Writer) error {
+	if err := c.ensureAlpine(); err != nil {
+		return err
+	}
+
+	resp, err := c.cli.ContainerCreate(c.ctx, &container.Config{
+		Image:        "alpine:latest",
+		Cmd:          cmd,
+		OpenStdin:    stdin != nil,
+		StdinOnce:    stdin != nil,
+		AttachStdin:  stdin != nil,
+		AttachStdout: true,
+		AttachStderr: true,
+	}, &container.HostConfig{
+		Mounts: []mount.Mount{
+			{
+				Type:   mount.TypeVolume,
+				Source: volumeName,
+				Target: "/volume",
+			},
+		},
+	}, nil, nil, "")
+	if err != nil {
+		return fmt.Errorf("failed to create container: %w", err)
+	}
+	defer c.cli.ContainerRemove(context.WithoutCancel(c.ctx), resp.ID, container.RemoveOptions{Force: true})
+
+	attachResp, err := c.cli.ContainerAttach(c.ctx, resp.ID, container.AttachOptions{
+		Stream: true,
+		Stdin:  stdin != nil,
+		Stdout: true,
+		Stderr: true,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to attach to container: %w", err)
+	}
+	defer attachResp.Close()
+	stop := context.AfterFunc(c.ctx, attachResp.Close)
+	defer stop()
+
+	if err := c.cli.ContainerStart(c.ctx, resp.ID, container.StartOptions{}); err != nil {
+		return fmt.Errorf("failed to start container: %w", err)
+	}
+
+	if stdin != nil {
+		go func() {
+			io.Copy(attachResp.Conn, stdin)
+			attachResp.CloseWrite()
+		}()
+	}
+
+	var stderr bytes.Buffer
+	_, err = stdcopy.StdCopy(stdout, &stderr, attachResp.Reader)
+	if ctxErr := c.ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read container output: %w", err)
+	}
+
+	statusCh, errCh := c.cli.ContainerWait(c.ctx, resp.ID, container.WaitConditionNotRunning)
+	select {
+	case err := <-errCh:
+		return fmt.Errorf("error waiting for container: %w", err)
+	case status := <-statusCh:
+		if status.StatusCode != 0 {
+			return fmt.Errorf("%s failed with code %d: %s", cmd[0], status.StatusCode, strings.TrimSpace(stderr.String()))
+		}
+	}
+	return nil
+}
 
 func (c *Client) ListComposeProjects() ([]string, error) {
 	containers, err := c.cli.ContainerList(c.ctx, container.ListOptions{All: true})
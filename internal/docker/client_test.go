@@ -0,0 +1,145 @@
+package docker
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestParseVolumeFiles(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    []VolumeFile
+		wantErr bool
+	}{
+		{name: "empty", in: ""},
+		{
+			name: "one batch",
+			in:   "2\n11 5 100 81a4\n12 4096 200 41ed\n./a.txt\x00./dir\x00",
+			want: []VolumeFile{
+				{Path: "a.txt", Size: 5, ModTime: 100, Inode: 11, Mode: 0o100644},
+				{Path: "dir", Size: 4096, ModTime: 200, Inode: 12, Mode: 0o040755},
+			},
+		},
+		{
+			name: "names with newlines and spaces across batches",
+			in:   "1\n1 0 1 81a4\n./line\nbreak\x001\n2 3 4 81a4\n./with space\x00",
+			want: []VolumeFile{
+				{Path: "line\nbreak", ModTime: 1, Inode: 1, Mode: 0o100644},
+				{Path: "with space", Size: 3, ModTime: 4, Inode: 2, Mode: 0o100644},
+			},
+		},
+		{name: "missing names", in: "1\n1 0 1 81a4\n", wantErr: true},
+		{name: "bad stat line", in: "1\n1 0 81a4\n./a\x00", wantErr: true},
+		{name: "bad count", in: "x\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseVolumeFiles(strings.NewReader(tt.in))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func requireTools(t *testing.T, tools ...string) {
+	t.Helper()
+	for _, tool := range tools {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s not available", tool)
+		}
+	}
+}
+
+func makeVolume(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, data := range map[string]string{
+		"plain.txt":         "plain",
+		"line\nbreak.txt":   "newline",
+		"dir/nested.txt":    "nested",
+		"dir/with space.md": "space",
+	} {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "empty"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestListVolumeFilesScript(t *testing.T) {
+	requireTools(t, "sh", "find", "stat")
+	dir := makeVolume(t)
+
+	script := strings.Replace(listVolumeFilesScript, "cd /volume", "cd '"+dir+"'", 1)
+	out, err := exec.Command("sh", "-c", script).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := parseVolumeFiles(bytes.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var paths []string
+	for _, f := range files {
+		paths = append(paths, f.Path)
+		if want := f.Path == "dir" || f.Path == "empty"; f.IsDir() != want {
+			t.Errorf("%q: IsDir = %v", f.Path, f.IsDir())
+		}
+	}
+	sort.Strings(paths)
+	want := []string{"dir", "dir/nested.txt", "dir/with space.md", "empty", "line\nbreak.txt", "plain.txt"}
+	if !slices.Equal(paths, want) {
+		t.Fatalf("got %q, want %q", paths, want)
+	}
+}
+
+func TestBackupVolumeFilesTarArgs(t *testing.T) {
+	requireTools(t, "tar")
+	dir := makeVolume(t)
+
+	// The same arguments BackupVolumeFiles passes inside the container.
+	cmd := exec.Command("tar", "-cf", "-", "-C", dir, "--no-recursion", "--null", "-T", "-")
+	cmd.Stdin = strings.NewReader("./dir\x00./line\nbreak.txt\x00./empty\x00")
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	tr := tar.NewReader(bytes.NewReader(out))
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, strings.TrimSuffix(h.Name, "/"))
+	}
+	want := []string{"./dir", "./line\nbreak.txt", "./empty"}
+	if !slices.Equal(names, want) {
+		t.Fatalf("got %q, want %q", names, want)
+	}
+}
@@ -0,0 +1,272 @@
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+type State string
+
+const (
+	StateQueued    State = "queued"
+	StateRunning   State = "running"
+	StateSucceeded State = "succeeded"
+	StateFailed    State = "failed"
+	StateCancelled State = "cancelled"
+)
+
+func (s State) Finished() bool {
+	return s == StateSucceeded || s == StateFailed || s == StateCancelled
+}
+
+const maxFinishedJobs = 200
+
+var (
+	ErrNotFound = errors.New("job not found")
+	ErrFinished = errors.New("job already finished")
+)
+
+type Job struct {
+	ID        string      `json:"id"`
+	Type      string      `json:"type"`
+	Stack     string      `json:"stack,omitempty"`
+	State     State       `json:"state"`
+	CreatedAt time.Time   `json:"created_at"`
+	StartedAt *time.Time  `json:"started_at,omitempty"`
+	EndedAt   *time.Time  `json:"ended_at,omitempty"`
+	Result    interface{} `json:"result,omitempty"`
+	Error     string      `json:"error,omitempty"`
+}
+
+type Func func(ctx context.Context) (interface{}, error)
+
+type idKey struct{}
+
+func IDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(idKey{}).(string)
+	return id
+}
+
+type job struct {
+	Job
+	fn     Func
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+type Manager struct {
+	mu      sync.Mutex
+	jobs    map[string]*job
+	active  map[string]bool
+	pending map[string][]*job
+}
+
+func NewManager() *Manager {
+	return &Manager{
+		jobs:    make(map[string]*job),
+		active:  make(map[string]bool),
+		pending: make(map[string][]*job),
+	}
+}
+
+func (m *Manager) Submit(jobType, stack string, fn Func) Job {
+	id := newID()
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), idKey{}, id))
+	j := &job{
+		Job: Job{
+			ID:        id,
+			Type:      jobType,
+			Stack:     stack,
+			State:     StateQueued,
+			CreatedAt: time.Now(),
+		},
+		fn:     fn,
+		ctx:    ctx,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.jobs[j.ID] = j
+	m.pruneLocked()
+
+	if stack != "" && m.active[stack] {
+		m.pending[stack] = append(m.pending[stack], j)
+		return j.Job
+	}
+	m.startLocked(j)
+	return j.Job
+}
+
+func (m *Manager) Busy(stack string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.active[stack] || len(m.pending[stack]) > 0
+}
+
+func (m *Manager) startLocked(j *job) {
+	if j.Stack != "" {
+		m.active[j.Stack] = true
+	}
+	started := time.Now()
+	j.StartedAt = &started
+	j.State = StateRunning
+	go m.run(j)
+}
+
+func (m *Manager) run(j *job) {
+	result, err := j.fn(j.ctx)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.finishLocked(j, result, err)
+	j.cancel()
+	close(j.done)
+
+	if j.Stack == "" {
+		return
+	}
+	delete(m.active, j.Stack)
+	if queue := m.pending[j.Stack]; len(queue) > 0 {
+		next := queue[0]
+		if len(queue) == 1 {
+			delete(m.pending, j.Stack)
+		} else {
+			m.pending[j.Stack] = queue[1:]
+		}
+		m.startLocked(next)
+	}
+}
+
+func (m *Manager) finishLocked(j *job, result interface{}, err error) {
+	ended := time.Now()
+	j.EndedAt = &ended
+	j.Result = result
+
+	switch {
+	case err != nil && j.ctx.Err() == context.Canceled:
+		j.State = StateCancelled
+		j.Error = err.Error()
+	case err != nil:
+		j.State = StateFailed
+		j.Error = err.Error()
+	default:
+		j.State = StateSucceeded
+	}
+}
+
+func (m *Manager) Get(id string) (Job, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	j, ok := m.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return j.Job, true
+}
+
+func (m *Manager) List() []Job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	list := make([]Job, 0, len(m.jobs))
+	for _, j := range m.jobs {
+		list = append(list, j.Job)
+	}
+
+	sort.Slice(list, func(i, k int) bool {
+		return list[i].CreatedAt.After(list[k].CreatedAt)
+	})
+	return list
+}
+
+func (m *Manager) Cancel(id string) (Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	j, ok := m.jobs[id]
+	if !ok {
+		return Job{}, ErrNotFound
+	}
+
+	if j.State.Finished() {
+		return j.Job, ErrFinished
+	}
+
+	j.cancel()
+	if j.State == StateQueued {
+		m.removePendingLocked(j)
+		m.finishLocked(j, nil, context.Canceled)
+		close(j.done)
+	}
+	return j.Job, nil
+}
+
+func (m *Manager) removePendingLocked(j *job) {
+	queue := m.pending[j.Stack]
+	for i, p := range queue {
+		if p == j {
+			queue = append(queue[:i:i], queue[i+1:]...)
+			break
+		}
+	}
+	if len(queue) == 0 {
+		delete(m.pending, j.Stack)
+	} else {
+		m.pending[j.Stack] = queue
+	}
+}
+
+func (m *Manager) Wait(ctx context.Context, id string) (Job, error) {
+	m.mu.Lock()
+	j, ok := m.jobs[id]
+	m.mu.Unlock()
+	if !ok {
+		return Job{}, ErrNotFound
+	}
+
+	select {
+	case <-j.done:
+	case <-ctx.Done():
+		return Job{}, ctx.Err()
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return j.Job, nil
+}
+
+func (m *Manager) pruneLocked() {
+	var finished []*job
+	for _, j := range m.jobs {
+		if j.State.Finished() {
+			finished = append(finished, j)
+		}
+	}
+	if len(finished) <= maxFinishedJobs {
+		return
+	}
+
+	sort.Slice(finished, func(i, k int) bool {
+		return finished[i].CreatedAt.Before(finished[k].CreatedAt)
+	})
+	for _, j := range finished[:len(finished)-maxFinishedJobs] {
+		delete(m.jobs, j.ID)
+	}
+}
+
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
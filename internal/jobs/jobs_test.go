@@ -0,0 +1,136 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func waitJob(t *testing.T, m *Manager, id string) Job {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	j, err := m.Wait(ctx, id)
+	if err != nil {
+		t.Fatalf("waiting for job %s: %v", id, err)
+	}
+	return j
+}
+
+func TestJobStates(t *testing.T) {
+	tests := []struct {
+		name      string
+		fn        Func
+		wantState State
+		wantErr   string
+	}{
+		{
+			name:      "succeeded",
+			fn:        func(ctx context.Context) (interface{}, error) { return "done", nil },
+			wantState: StateSucceeded,
+		},
+		{
+			name:      "failed",
+			fn:        func(ctx context.Context) (interface{}, error) { return nil, errors.New("boom") },
+			wantState: StateFailed,
+			wantErr:   "boom",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewManager()
+			j := waitJob(t, m, m.Submit("backup", "app", tt.fn).ID)
+			if j.State != tt.wantState || j.Error != tt.wantErr {
+				t.Fatalf("got state %s error %q, want %s %q", j.State, j.Error, tt.wantState, tt.wantErr)
+			}
+			if j.StartedAt == nil || j.EndedAt == nil {
+				t.Fatalf("start and end times not recorded: %+v", j)
+			}
+		})
+	}
+}
+
+func TestJobsSerializePerStack(t *testing.T) {
+	m := NewManager()
+	release := make(chan struct{})
+	running := make(chan string, 3)
+	block := func(name string) Func {
+		return func(ctx context.Context) (interface{}, error) {
+			running <- name
+			<-release
+			return nil, nil
+		}
+	}
+
+	first := m.Submit("backup", "app", block("first"))
+	second := m.Submit("restore", "app", block("second"))
+	other := m.Submit("backup", "other", block("other"))
+
+	started := map[string]bool{<-running: true, <-running: true}
+	if !started["first"] || !started["other"] {
+		t.Fatalf("started %v, want first and other", started)
+	}
+	if j, _ := m.Get(second.ID); j.State != StateQueued {
+		t.Fatalf("second job on the stack is %s, want queued", j.State)
+	}
+	if !m.Busy("app") {
+		t.Fatal("stack with a running job is not busy")
+	}
+
+	release <- struct{}{}
+	release <- struct{}{}
+	if name := <-running; name != "second" {
+		t.Fatalf("%s started after the first job, want second", name)
+	}
+	close(release)
+
+	for _, id := range []string{first.ID, second.ID, other.ID} {
+		if j := waitJob(t, m, id); j.State != StateSucceeded {
+			t.Fatalf("job %s ended %s", id, j.State)
+		}
+	}
+	if m.Busy("app") {
+		t.Fatal("stack still busy after its jobs finished")
+	}
+}
+
+func TestCancel(t *testing.T) {
+	m := NewManager()
+	started := make(chan struct{})
+	running := m.Submit("backup", "app", func(ctx context.Context) (interface{}, error) {
+		close(started)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	queued := m.Submit("restore", "app", func(ctx context.Context) (interface{}, error) {
+		t.Error("cancelled queued job ran")
+		return nil, nil
+	})
+	<-started
+
+	tests := []struct {
+		name string
+		id   string
+	}{
+		{"queued job", queued.ID},
+		{"running job", running.ID},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := m.Cancel(tt.id); err != nil {
+				t.Fatal(err)
+			}
+			if j := waitJob(t, m, tt.id); j.State != StateCancelled {
+				t.Fatalf("got state %s, want cancelled", j.State)
+			}
+		})
+	}
+
+	if _, err := m.Cancel(running.ID); !errors.Is(err, ErrFinished) {
+		t.Fatalf("cancelling a finished job: got %v, want ErrFinished", err)
+	}
+	if _, err := m.Cancel("missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("cancelling an unknown job: got %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,402 @@
+package keyring
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stacksnap/stacksnap/internal/crypto"
+)
+
+const (
+	fileVersion      = 1
+	PassphraseEnv    = "STACKSNAP_KEYRING_PASSPHRASE"
+	minPassphraseLen = 8
+	passphraseCheck  = "stacksnap-keyring"
+)
+
+var (
+	ErrLocked          = errors.New("keyring is locked")
+	ErrWrongPassphrase = errors.New("incorrect keyring passphrase")
+	ErrKeyNotFound     = errors.New("key not found in keyring")
+	ErrNoDefaultKey    = errors.New("keyring has no default key")
+)
+
+type Key struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	CreatedAt time.Time  `json:"created_at"`
+	RetiredAt *time.Time `json:"retired_at,omitempty"`
+	Wrapped   string     `json:"wrapped,omitempty"`
+}
+
+type Entry struct {
+	ID  string
+	Key []byte
+}
+
+type fileData struct {
+	Version int    `json:"version"`
+	Salt    string `json:"salt"`
+	Check   string `json:"check"`
+	Default string `json:"default,omitempty"`
+	Keys    []Key  `json:"keys,omitempty"`
+}
+
+type Keyring struct {
+	mu     sync.Mutex
+	path   string
+	data   fileData
+	master []byte
+}
+
+func Open(path string) (*Keyring, error) {
+	k := &Keyring{path: path}
+
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return k, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(raw, &k.data); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if k.data.Version > fileVersion {
+		return nil, fmt.Errorf("keyring %s has unsupported version %d", path, k.data.Version)
+	}
+	return k, nil
+}
+
+func Fingerprint(key []byte) string {
+	return crypto.KeyFingerprint(key)
+}
+
+func (k *Keyring) Initialized() bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.data.Salt != ""
+}
+
+func (k *Keyring) Locked() bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.master == nil
+}
+
+func (k *Keyring) Lock() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.master = nil
+}
+
+func (k *Keyring) Unlock(passphrase string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.data.Salt == "" {
+		return k.initLocked(passphrase)
+	}
+
+	salt, err := base64.StdEncoding.DecodeString(k.data.Salt)
+	if err != nil {
+		return fmt.Errorf("corrupt keyring salt: %w", err)
+	}
+	master, err := crypto.DeriveKeyFromPassword(passphrase, salt)
+	if err != nil {
+		return fmt.Errorf("corrupt keyring salt: %w", err)
+	}
+
+	check, err := unwrap(master, k.data.Check)
+	if err != nil || string(check) != passphraseCheck {
+		return ErrWrongPassphrase
+	}
+	k.master = master
+	return nil
+}
+
+func (k *Keyring) initLocked(passphrase string) error {
+	if len(passphrase) < minPassphraseLen {
+		return fmt.Errorf("keyring passphrase must be at least %d characters", minPassphraseLen)
+	}
+
+	salt := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return fmt.Errorf("failed to generate salt: %w", err)
+	}
+	master, err := crypto.DeriveKeyFromPassword(passphrase, salt)
+	if err != nil {
+		return err
+	}
+
+	check, err := wrap(master, []byte(passphraseCheck))
+	if err != nil {
+		return err
+	}
+
+	k.data = fileData{
+		Version: fileVersion,
+		Salt:    base64.StdEncoding.EncodeToString(salt),
+		Check:   check,
+	}
+	k.master = master
+	return k.saveLocked()
+}
+
+func (k *Keyring) UnlockFromEnv() (bool, error) {
+	passphrase := os.Getenv(PassphraseEnv)
+	if passphrase == "" {
+		return false, nil
+	}
+	return true, k.Unlock(passphrase)
+}
+
+func (k *Keyring) Keys() []Key {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	keys := make([]Key, len(k.data.Keys))
+	for i, key := range k.data.Keys {
+		key.Wrapped = ""
+		keys[i] = key
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+	})
+	return keys
+}
+
+func (k *Keyring) DefaultID() string {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.data.Default
+}
+
+func (k *Keyring) Generate(name string) (Key, error) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		return Key{}, err
+	}
+	return k.Add(name, key)
+}
+
+func (k *Keyring) Add(name string, key []byte) (Key, error) {
+	if err := crypto.ValidateKey(key); err != nil {
+		return Key{}, err
+	}
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return Key{}, fmt.Errorf("key name is required")
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.master == nil {
+		return Key{}, ErrLocked
+	}
+
+	id := Fingerprint(key)
+	for _, existing := range k.data.Keys {
+		if existing.ID == id {
+			return Key{}, fmt.Errorf("key %s is already in the keyring as %q", id, existing.Name)
+		}
+	}
+
+	wrapped, err := wrap(k.master, key)
+	if err != nil {
+		return Key{}, err
+	}
+
+	entry := Key{
+		ID:        id,
+		Name:      name,
+		CreatedAt: time.Now(),
+		Wrapped:   wrapped,
+	}
+	k.data.Keys = append(k.data.Keys, entry)
+	if k.data.Default == "" {
+		k.data.Default = id
+	}
+	if err := k.saveLocked(); err != nil {
+		return Key{}, err
+	}
+
+	entry.Wrapped = ""
+	return entry, nil
+}
+
+func (k *Keyring) SetDefault(id string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	key := k.findLocked(id)
+	if key == nil {
+		return ErrKeyNotFound
+	}
+	if key.RetiredAt != nil {
+		return fmt.Errorf("key %s is retired", id)
+	}
+	k.data.Default = key.ID
+	return k.saveLocked()
+}
+
+func (k *Keyring) Retire(id string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	key := k.findLocked(id)
+	if key == nil {
+		return ErrKeyNotFound
+	}
+	if key.RetiredAt != nil {
+		return nil
+	}
+	now := time.Now()
+	key.RetiredAt = &now
+	if k.data.Default == key.ID {
+		k.data.Default = ""
+	}
+	return k.saveLocked()
+}
+
+func (k *Keyring) Lookup(id string) (Key, bool) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	key := k.findLocked(id)
+	if key == nil {
+		return Key{}, false
+	}
+	info := *key
+	info.Wrapped = ""
+	return info, true
+}
+
+func (k *Keyring) Get(id string) ([]byte, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	key := k.findLocked(id)
+	if key == nil {
+		return nil, fmt.Errorf("%w: %s", ErrKeyNotFound, id)
+	}
+	if k.master == nil {
+		return nil, ErrLocked
+	}
+	return unwrap(k.master, key.Wrapped)
+}
+
+func (k *Keyring) Default() (string, []byte, error) {
+	id := k.DefaultID()
+	if id == "" {
+		return "", nil, ErrNoDefaultKey
+	}
+	key, err := k.Get(id)
+	if err != nil {
+		return "", nil, err
+	}
+	return id, key, nil
+}
+
+func (k *Keyring) Entries() ([]Entry, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.master == nil && len(k.data.Keys) > 0 {
+		return nil, ErrLocked
+	}
+
+	entries := make([]Entry, 0, len(k.data.Keys))
+	for _, key := range k.data.Keys {
+		raw, err := unwrap(k.master, key.Wrapped)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unwrap key %s: %w", key.ID, err)
+		}
+		entries = append(entries, Entry{ID: key.ID, Key: raw})
+	}
+	return entries, nil
+}
+
+func (k *Keyring) findLocked(id string) *Key {
+	for i := range k.data.Keys {
+		if k.data.Keys[i].ID == id || k.data.Keys[i].Name == id {
+			return &k.data.Keys[i]
+		}
+	}
+	return nil
+}
+
+func (k *Keyring) saveLocked() error {
+	if err := os.MkdirAll(filepath.Dir(k.path), 0700); err != nil {
+		return err
+	}
+
+	raw, err := json.MarshalIndent(k.data, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := k.path + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, k.path)
+}
+
+func wrap(master, plaintext []byte) (string, error) {
+	aead, err := newAEAD(master)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	sealed := aead.Seal(nonce, nonce, plaintext, nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func unwrap(master []byte, wrapped string) ([]byte, error) {
+	sealed, err := base64.StdEncoding.DecodeString(wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("corrupt keyring entry: %w", err)
+	}
+
+	aead, err := newAEAD(master)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("corrupt keyring entry")
+	}
+
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, crypto.ErrAuthenticationFailed
+	}
+	return plaintext, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
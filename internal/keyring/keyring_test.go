@@ -0,0 +1,88 @@
+package keyring
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestSealUnseal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keyring.json")
+	k, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := k.Unlock("short"); err == nil {
+		t.Fatal("keyring initialized with a short passphrase")
+	}
+	if err := k.Unlock("correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	key, err := k.Generate("primary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := k.Get(key.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		passphrase string
+		wantErr    error
+	}{
+		{"correct passphrase", "correct horse", nil},
+		{"wrong passphrase", "battery staple", ErrWrongPassphrase},
+		{"empty passphrase", "", ErrWrongPassphrase},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reopened, err := Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reopened.Locked() {
+				t.Fatal("reopened keyring is unlocked")
+			}
+			if _, err := reopened.Get(key.ID); !errors.Is(err, ErrLocked) {
+				t.Fatalf("get from a locked keyring: got %v, want ErrLocked", err)
+			}
+
+			err = reopened.Unlock(tt.passphrase)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("unlock: got %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if !reopened.Locked() {
+					t.Fatal("keyring unlocked by a wrong passphrase")
+				}
+				return
+			}
+			got, err := reopened.Get(key.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Fatal("unsealed key differs from the generated one")
+			}
+		})
+	}
+}
+
+func TestGetUnknownKey(t *testing.T) {
+	k, err := Open(filepath.Join(t.TempDir(), "keyring.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := k.Unlock("correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := k.Get("missing"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("got %v, want ErrKeyNotFound", err)
+	}
+	if _, _, err := k.Default(); !errors.Is(err, ErrNoDefaultKey) {
+		t.Fatalf("default of an empty keyring: got %v, want ErrNoDefaultKey", err)
+	}
+}
@@ -0,0 +1,213 @@
+package lock
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/stacksnap/stacksnap/internal/config"
+)
+
+var ErrLocked = errors.New("stack is locked")
+
+var unsafeChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+const pollInterval = time.Second
+
+type Info struct {
+	Stack      string    `json:"stack"`
+	Operation  string    `json:"operation"`
+	PID        int       `json:"pid"`
+	Host       string    `json:"host"`
+	AcquiredAt time.Time `json:"acquired_at"`
+}
+
+type LockedError struct {
+	Holder Info
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("stack %s is locked by a running %s (pid %d on %s, since %s)",
+		e.Holder.Stack, e.Holder.Operation, e.Holder.PID, e.Holder.Host,
+		e.Holder.AcquiredAt.Format(time.RFC3339))
+}
+
+func (e *LockedError) Is(target error) bool {
+	return target == ErrLocked
+}
+
+type Manager struct {
+	dir  string
+	mu   sync.Mutex
+	held map[string]bool
+}
+
+type Lock struct {
+	m     *Manager
+	stack string
+	path  string
+}
+
+var (
+	defaultOnce    sync.Once
+	defaultManager *Manager
+)
+
+func Default() *Manager {
+	defaultOnce.Do(func() {
+		defaultManager = NewManager(config.LocksDir())
+	})
+	return defaultManager
+}
+
+func NewManager(dir string) *Manager {
+	return &Manager{
+		dir:  dir,
+		held: make(map[string]bool),
+	}
+}
+
+func (m *Manager) path(stack string) string {
+	return filepath.Join(m.dir, unsafeChars.ReplaceAllString(stack, "_")+".lock")
+}
+
+func (m *Manager) TryAcquire(stack, operation string) (*Lock, error) {
+	if stack == "" {
+		return nil, fmt.Errorf("stack name is required for locking")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := os.MkdirAll(m.dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create lock directory: %w", err)
+	}
+
+	host, _ := os.Hostname()
+	info := Info{
+		Stack:      stack,
+		Operation:  operation,
+		PID:        os.Getpid(),
+		Host:       host,
+		AcquiredAt: time.Now(),
+	}
+	data, err := json.Marshal(info)
+	if err != nil {
+		return nil, err
+	}
+
+	p := m.path(stack)
+	for attempt := 0; attempt < 2; attempt++ {
+		f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			_, werr := f.Write(data)
+			cerr := f.Close()
+			if werr != nil || cerr != nil {
+				os.Remove(p)
+				return nil, fmt.Errorf("failed to write lock file: %v", errors.Join(werr, cerr))
+			}
+			m.held[stack] = true
+			return &Lock{m: m, stack: stack, path: p}, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("failed to create lock file: %w", err)
+		}
+
+		holder, live := m.holderLocked(stack)
+		if live {
+			return nil, &LockedError{Holder: holder}
+		}
+	}
+
+	return nil, fmt.Errorf("failed to acquire lock for stack %s", stack)
+}
+
+func (m *Manager) Holder(stack string) (Info, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.holderLocked(stack)
+}
+
+func (m *Manager) holderLocked(stack string) (Info, bool) {
+	p := m.path(stack)
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return Info{}, false
+	}
+
+	var info Info
+	if err := json.Unmarshal(data, &info); err != nil {
+		st, statErr := os.Stat(p)
+		if statErr == nil && time.Since(st.ModTime()) < 10*time.Second {
+			return Info{Stack: stack, Operation: "operation", AcquiredAt: st.ModTime()}, true
+		}
+		fmt.Printf(" Removing unreadable lock for %s\n", stack)
+		os.Remove(p)
+		return Info{}, false
+	}
+
+	if m.isStale(info) {
+		fmt.Printf(" Removing stale lock for %s (pid %d no longer running)\n", stack, info.PID)
+		os.Remove(p)
+		return Info{}, false
+	}
+	return info, true
+}
+
+func (m *Manager) isStale(info Info) bool {
+	host, _ := os.Hostname()
+	if info.Host != host {
+		return false
+	}
+	if info.PID == os.Getpid() {
+		return !m.held[info.Stack]
+	}
+	return !processAlive(info.PID)
+}
+
+// Acquire blocks until the stack's lock is free and takes it, or until
+// ctx is done.
+func (m *Manager) Acquire(ctx context.Context, stack, operation string) (*Lock, error) {
+	for {
+		l, err := m.TryAcquire(stack, operation)
+		if !errors.Is(err, ErrLocked) {
+			return l, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+func (l *Lock) Release() error {
+	l.m.mu.Lock()
+	defer l.m.mu.Unlock()
+
+	delete(l.m.held, l.stack)
+	if err := os.Remove(l.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to release lock for %s: %w", l.stack, err)
+	}
+	return nil
+}
+
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	err = p.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
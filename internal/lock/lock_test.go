@@ -0,0 +1,152 @@
+package lock
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func writeLock(t *testing.T, m *Manager, info Info) {
+	t.Helper()
+	if err := os.MkdirAll(m.dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(m.path(info.Stack), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTryAcquireExcludes(t *testing.T) {
+	m := NewManager(t.TempDir())
+	l, err := m.TryAcquire("app", "backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = m.TryAcquire("app", "restore")
+	var locked *LockedError
+	if !errors.As(err, &locked) || !errors.Is(err, ErrLocked) {
+		t.Fatalf("second acquire: got %v, want a LockedError", err)
+	}
+	if locked.Holder.Operation != "backup" || locked.Holder.PID != os.Getpid() {
+		t.Fatalf("holder %+v, want this process's backup", locked.Holder)
+	}
+
+	other, err := m.TryAcquire("other", "backup")
+	if err != nil {
+		t.Fatalf("lock on another stack: %v", err)
+	}
+	other.Release()
+
+	if err := l.Release(); err != nil {
+		t.Fatal(err)
+	}
+	l, err = m.TryAcquire("app", "restore")
+	if err != nil {
+		t.Fatalf("acquire after release: %v", err)
+	}
+	l.Release()
+}
+
+func TestStaleLockTakeover(t *testing.T) {
+	host, _ := os.Hostname()
+	tests := []struct {
+		name     string
+		holder   Info
+		takeover bool
+	}{
+		{"dead process", Info{PID: 1 << 30, Host: host}, true},
+		{"this process without the lock held", Info{PID: os.Getpid(), Host: host}, true},
+		{"live process", Info{PID: 1, Host: host}, false},
+		{"process on another host", Info{PID: 1 << 30, Host: host + "-elsewhere"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewManager(t.TempDir())
+			tt.holder.Stack = "app"
+			tt.holder.Operation = "backup"
+			tt.holder.AcquiredAt = time.Now()
+			writeLock(t, m, tt.holder)
+
+			l, err := m.TryAcquire("app", "restore")
+			if tt.takeover {
+				if err != nil {
+					t.Fatalf("stale lock not taken over: %v", err)
+				}
+				l.Release()
+				return
+			}
+			if !errors.Is(err, ErrLocked) {
+				t.Fatalf("got %v, want ErrLocked", err)
+			}
+			if _, held := m.Holder("app"); !held {
+				t.Fatal("live holder not reported")
+			}
+		})
+	}
+}
+
+func TestUnreadableLockFile(t *testing.T) {
+	m := NewManager(t.TempDir())
+	if err := os.MkdirAll(m.dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	p := m.path("app")
+	if err := os.WriteFile(p, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := m.TryAcquire("app", "backup"); !errors.Is(err, ErrLocked) {
+		t.Fatalf("fresh unreadable lock: got %v, want ErrLocked", err)
+	}
+
+	old := time.Now().Add(-time.Minute)
+	if err := os.Chtimes(p, old, old); err != nil {
+		t.Fatal(err)
+	}
+	l, err := m.TryAcquire("app", "backup")
+	if err != nil {
+		t.Fatalf("old unreadable lock not taken over: %v", err)
+	}
+	l.Release()
+}
+
+func TestAcquireWaits(t *testing.T) {
+	m := NewManager(t.TempDir())
+	held, err := m.TryAcquire("app", "backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := m.Acquire(ctx, "app", "restore"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("acquire of a held lock: got %v, want the context's error", err)
+	}
+
+	acquired := make(chan error, 1)
+	go func() {
+		l, err := m.Acquire(context.Background(), "app", "restore")
+		if err == nil {
+			l.Release()
+		}
+		acquired <- err
+	}()
+	held.Release()
+
+	select {
+	case err := <-acquired:
+		if err != nil {
+			t.Fatalf("acquire after release: %v", err)
+		}
+	case <-time.After(5 * pollInterval):
+		t.Fatal("acquire did not take the released lock")
+	}
+}
@@ -0,0 +1,131 @@
+package repo
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"math/bits"
+)
+
+const (
+	DefaultMinChunkSize = 512 * 1024
+	DefaultAvgChunkSize = 1024 * 1024
+	DefaultMaxChunkSize = 8 * 1024 * 1024
+)
+
+type gearTable [256]uint64
+
+func newGearTable(seed []byte) *gearTable {
+	var t gearTable
+	mac := hmac.New(sha256.New, seed)
+	var idx [2]byte
+	for i := range t {
+		mac.Reset()
+		binary.BigEndian.PutUint16(idx[:], uint16(i))
+		mac.Write(idx[:])
+		t[i] = binary.BigEndian.Uint64(mac.Sum(nil))
+	}
+	return &t
+}
+
+type Chunker struct {
+	r     io.Reader
+	gear  *gearTable
+	min   int
+	avg   int
+	max   int
+	maskS uint64
+	maskL uint64
+	buf   []byte
+	start int
+	end   int
+	eof   bool
+}
+
+func newChunker(r io.Reader, gear *gearTable, min, avg, max int) *Chunker {
+	b := bits.Len(uint(avg)) - 1
+	return &Chunker{
+		r:     r,
+		gear:  gear,
+		min:   min,
+		avg:   avg,
+		max:   max,
+		maskS: highMask(b + 2),
+		maskL: highMask(b - 2),
+		buf:   make([]byte, 2*max),
+	}
+}
+
+func highMask(n int) uint64 {
+	if n <= 0 {
+		return 0
+	}
+	return ^uint64(0) << (64 - n)
+}
+
+func (c *Chunker) Next() ([]byte, error) {
+	if err := c.fill(); err != nil {
+		return nil, err
+	}
+	if c.start == c.end {
+		return nil, io.EOF
+	}
+
+	n := c.cut(c.buf[c.start:c.end])
+	chunk := c.buf[c.start : c.start+n]
+	c.start += n
+	return chunk, nil
+}
+
+func (c *Chunker) fill() error {
+	if c.eof || c.end-c.start >= c.max {
+		return nil
+	}
+	if c.start > 0 {
+		c.end = copy(c.buf, c.buf[c.start:c.end])
+		c.start = 0
+	}
+	for c.end < len(c.buf) {
+		n, err := c.r.Read(c.buf[c.end:])
+		c.end += n
+		if err == io.EOF {
+			c.eof = true
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Chunker) cut(data []byte) int {
+	n := len(data)
+	if n <= c.min {
+		return n
+	}
+	if n > c.max {
+		n = c.max
+	}
+	normal := c.avg
+	if normal > n {
+		normal = n
+	}
+
+	var h uint64
+	i := c.min
+	for ; i < normal; i++ {
+		h = (h << 1) + c.gear[data[i]]
+		if h&c.maskS == 0 {
+			return i + 1
+		}
+	}
+	for ; i < n; i++ {
+		h = (h << 1) + c.gear[data[i]]
+		if h&c.maskL == 0 {
+			return i + 1
+		}
+	}
+	return n
+}
@@ -0,0 +1,121 @@
+package repo
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+func randomBytes(n int, seed int64) []byte {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+func chunkAll(t *testing.T, data []byte, gear *gearTable, min, avg, max int) [][]byte {
+	t.Helper()
+	c := newChunker(bytes.NewReader(data), gear, min, avg, max)
+	var chunks [][]byte
+	for {
+		chunk, err := c.Next()
+		if err == io.EOF {
+			return chunks
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		chunks = append(chunks, append([]byte(nil), chunk...))
+	}
+}
+
+func TestChunkerBoundaries(t *testing.T) {
+	const min, avg, max = 1 << 10, 4 << 10, 16 << 10
+	gear := newGearTable([]byte("test seed"))
+
+	tests := []struct {
+		name       string
+		data       []byte
+		wantChunks int
+	}{
+		{"empty", nil, 0},
+		{"one byte", []byte{1}, 1},
+		{"below min", randomBytes(min-1, 1), 1},
+		{"exactly min", randomBytes(min, 2), 1},
+		{"random", randomBytes(1<<20, 3), -1},
+		{"zeros", make([]byte, 100<<10), -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunks := chunkAll(t, tt.data, gear, min, avg, max)
+			if tt.wantChunks >= 0 && len(chunks) != tt.wantChunks {
+				t.Fatalf("got %d chunks, want %d", len(chunks), tt.wantChunks)
+			}
+			if got := bytes.Join(chunks, nil); !bytes.Equal(got, tt.data) {
+				t.Fatal("chunks do not reassemble the input")
+			}
+			for i, chunk := range chunks {
+				if len(chunk) > max {
+					t.Fatalf("chunk %d is %d bytes, above max %d", i, len(chunk), max)
+				}
+				if i < len(chunks)-1 && len(chunk) < min {
+					t.Fatalf("chunk %d is %d bytes, below min %d", i, len(chunk), min)
+				}
+			}
+		})
+	}
+}
+
+func TestChunkerMaxOnUniformData(t *testing.T) {
+	const min, avg, max = 1 << 10, 4 << 10, 16 << 10
+	chunks := chunkAll(t, make([]byte, 10*max), newGearTable([]byte("zeros")), min, avg, max)
+	for i, chunk := range chunks {
+		if len(chunk) != max {
+			t.Fatalf("chunk %d is %d bytes, want a forced cut at %d", i, len(chunk), max)
+		}
+	}
+}
+
+func TestChunkerResyncsAfterInsert(t *testing.T) {
+	const min, avg, max = 1 << 10, 4 << 10, 16 << 10
+	gear := newGearTable([]byte("test seed"))
+	data := randomBytes(1<<20, 4)
+
+	original := chunkAll(t, data, gear, min, avg, max)
+	shifted := chunkAll(t, append([]byte("inserted"), data...), gear, min, avg, max)
+
+	seen := make(map[string]bool, len(original))
+	for _, c := range original {
+		seen[string(c)] = true
+	}
+	shared := 0
+	for _, c := range shifted {
+		if seen[string(c)] {
+			shared++
+		}
+	}
+	if shared < len(original)-3 {
+		t.Fatalf("only %d of %d chunks shared after a prefix insert", shared, len(original))
+	}
+}
+
+func TestChunkerSeedChangesBoundaries(t *testing.T) {
+	const min, avg, max = 1 << 10, 4 << 10, 16 << 10
+	data := randomBytes(1<<20, 5)
+
+	a := chunkAll(t, data, newGearTable([]byte("seed a")), min, avg, max)
+	again := chunkAll(t, data, newGearTable([]byte("seed a")), min, avg, max)
+	b := chunkAll(t, data, newGearTable([]byte("seed b")), min, avg, max)
+
+	if len(a) != len(again) {
+		t.Fatal("chunking is not deterministic for the same seed")
+	}
+	for i := range a {
+		if !bytes.Equal(a[i], again[i]) {
+			t.Fatal("chunking is not deterministic for the same seed")
+		}
+	}
+	if len(a) == len(b) && bytes.Equal(a[0], b[0]) {
+		t.Fatal("different seeds produced the same boundaries")
+	}
+}
@@ -0,0 +1,343 @@
+package repo
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stacksnap/stacksnap/internal/crypto"
+	"github.com/stacksnap/stacksnap/internal/storage"
+)
+
+const (
+	Version      = 2
+	configKey    = "config.json"
+	chunksDir    = "chunks/"
+	snapshotsDir = "snapshots/"
+	idInfo       = "stacksnap-repo-chunk-id"
+	gearInfo     = "stacksnap-repo-gear"
+	objectInfo   = "stacksnap-repo-object:"
+)
+
+var (
+	ErrNotInitialized   = errors.New("repository is not initialized")
+	ErrExists           = errors.New("repository already exists")
+	ErrKeyRequired      = errors.New("repository is encrypted and no key was provided")
+	ErrWrongKey         = errors.New("key does not belong to this repository")
+	ErrSnapshotNotFound = errors.New("snapshot not found")
+	ErrChunkCorrupted   = errors.New("chunk does not match its ID")
+)
+
+type Config struct {
+	Version      int       `json:"version"`
+	ID           string    `json:"id"`
+	CreatedAt    time.Time `json:"created_at"`
+	Encrypted    bool      `json:"encrypted"`
+	KeyID        string    `json:"key_id,omitempty"`
+	ChunkerSeed  string    `json:"chunker_seed"`
+	MinChunkSize int       `json:"min_chunk_size"`
+	AvgChunkSize int       `json:"avg_chunk_size"`
+	MaxChunkSize int       `json:"max_chunk_size"`
+}
+
+// Repository is append-only: snapshots and chunks are never deleted, as
+// there is no prune or garbage collection for repositories yet.
+type Repository struct {
+	provider storage.Provider
+	prefix   string
+	key      []byte
+	idKey    []byte
+	gear     *gearTable
+	config   Config
+
+	mu    sync.Mutex
+	known map[string]bool
+}
+
+func Init(ctx context.Context, provider storage.Provider, prefix string, key []byte) (*Repository, error) {
+	if _, err := ReadConfig(ctx, provider, prefix); err == nil {
+		return nil, ErrExists
+	} else if !errors.Is(err, ErrNotInitialized) {
+		return nil, err
+	}
+	if key != nil {
+		if err := crypto.ValidateKey(key); err != nil {
+			return nil, err
+		}
+	}
+
+	id := make([]byte, 16)
+	seed := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, id); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(rand.Reader, seed); err != nil {
+		return nil, err
+	}
+
+	cfg := Config{
+		Version:      Version,
+		ID:           hex.EncodeToString(id),
+		CreatedAt:    time.Now().UTC(),
+		Encrypted:    key != nil,
+		ChunkerSeed:  hex.EncodeToString(seed),
+		MinChunkSize: DefaultMinChunkSize,
+		AvgChunkSize: DefaultAvgChunkSize,
+		MaxChunkSize: DefaultMaxChunkSize,
+	}
+	if key != nil {
+		cfg.KeyID = crypto.KeyFingerprint(key)
+	}
+
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := provider.Upload(ctx, path.Join(prefix, configKey), bytes.NewReader(data)); err != nil {
+		return nil, fmt.Errorf("failed to write repository config: %w", err)
+	}
+	return newRepository(provider, prefix, key, cfg)
+}
+
+func Open(ctx context.Context, provider storage.Provider, prefix string, key []byte) (*Repository, error) {
+	cfg, err := ReadConfig(ctx, provider, prefix)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Version != Version {
+		return nil, fmt.Errorf("unsupported repository version %d", cfg.Version)
+	}
+	if cfg.Encrypted {
+		if key == nil {
+			return nil, ErrKeyRequired
+		}
+		if crypto.KeyFingerprint(key) != cfg.KeyID {
+			return nil, ErrWrongKey
+		}
+	} else {
+		key = nil
+	}
+	return newRepository(provider, prefix, key, *cfg)
+}
+
+func OpenOrInit(ctx context.Context, provider storage.Provider, prefix string, key []byte) (*Repository, error) {
+	r, err := Open(ctx, provider, prefix, key)
+	if errors.Is(err, ErrNotInitialized) {
+		return Init(ctx, provider, prefix, key)
+	}
+	return r, err
+}
+
+func ReadConfig(ctx context.Context, provider storage.Provider, prefix string) (*Config, error) {
+	rc, err := provider.Download(ctx, path.Join(prefix, configKey))
+	if err != nil {
+		if _, statErr := provider.Stat(ctx, path.Join(prefix, configKey)); statErr != nil {
+			return nil, ErrNotInitialized
+		}
+		return nil, fmt.Errorf("failed to read repository config: %w", err)
+	}
+	defer rc.Close()
+
+	var cfg Config
+	if err := json.NewDecoder(rc).Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("invalid repository config: %w", err)
+	}
+	return &cfg, nil
+}
+
+func newRepository(provider storage.Provider, prefix string, key []byte, cfg Config) (*Repository, error) {
+	seed, err := hex.DecodeString(cfg.ChunkerSeed)
+	if err != nil || len(seed) == 0 {
+		return nil, fmt.Errorf("invalid repository chunker seed")
+	}
+	if cfg.MinChunkSize <= 0 || cfg.AvgChunkSize < cfg.MinChunkSize || cfg.MaxChunkSize < cfg.AvgChunkSize {
+		return nil, fmt.Errorf("invalid repository chunk sizes")
+	}
+
+	r := &Repository{
+		provider: provider,
+		prefix:   prefix,
+		key:      key,
+		config:   cfg,
+	}
+	if key != nil {
+		if r.idKey, err = hkdf.Key(sha256.New, key, []byte(cfg.ID), idInfo, 32); err != nil {
+			return nil, err
+		}
+		gearKey, err := hkdf.Key(sha256.New, key, seed, gearInfo, 32)
+		if err != nil {
+			return nil, err
+		}
+		seed = gearKey
+	}
+	r.gear = newGearTable(seed)
+	return r, nil
+}
+
+func (r *Repository) Config() Config {
+	return r.config
+}
+
+func (r *Repository) Encrypted() bool {
+	return r.config.Encrypted
+}
+
+func (r *Repository) chunkID(data []byte) string {
+	if r.idKey == nil {
+		sum := sha256.Sum256(data)
+		return hex.EncodeToString(sum[:])
+	}
+	mac := hmac.New(sha256.New, r.idKey)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (r *Repository) chunkKey(id string) string {
+	return path.Join(r.prefix, chunksDir, id[:2], id)
+}
+
+func (r *Repository) loadIndex(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.known != nil {
+		return nil
+	}
+
+	items, err := r.provider.List(ctx, path.Join(r.prefix, chunksDir)+"/")
+	if err != nil {
+		return fmt.Errorf("failed to list repository chunks: %w", err)
+	}
+	r.known = make(map[string]bool, len(items))
+	for _, item := range items {
+		r.known[path.Base(item.Key)] = true
+	}
+	return nil
+}
+
+func (r *Repository) hasChunk(id string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.known[id]
+}
+
+func (r *Repository) putChunk(ctx context.Context, id string, data []byte) (int64, error) {
+	blob, err := r.seal(chunksDir+id, data)
+	if err != nil {
+		return 0, err
+	}
+	if err := r.provider.Upload(ctx, r.chunkKey(id), bytes.NewReader(blob)); err != nil {
+		return 0, fmt.Errorf("failed to upload chunk %s: %w", id, err)
+	}
+
+	r.mu.Lock()
+	r.known[id] = true
+	r.mu.Unlock()
+	return int64(len(blob)), nil
+}
+
+func (r *Repository) getChunk(ctx context.Context, id string) ([]byte, error) {
+	rc, err := r.provider.Download(ctx, r.chunkKey(id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to download chunk %s: %w", id, err)
+	}
+	defer rc.Close()
+
+	data, err := r.open(chunksDir+id, rc)
+	if err != nil {
+		return nil, fmt.Errorf("chunk %s: %w", id, err)
+	}
+	if !hmac.Equal([]byte(r.chunkID(data)), []byte(id)) {
+		return nil, fmt.Errorf("%w: %s", ErrChunkCorrupted, id)
+	}
+	return data, nil
+}
+
+func (r *Repository) objectKey(name string) ([]byte, error) {
+	return hkdf.Key(sha256.New, r.key, []byte(r.config.ID), objectInfo+name, 32)
+}
+
+func (r *Repository) seal(name string, data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	var out io.WriteCloser = nopCloser{&buf}
+	if r.key != nil {
+		key, err := r.objectKey(name)
+		if err != nil {
+			return nil, err
+		}
+		enc, err := crypto.NewEncryptWriter(key, &buf)
+		if err != nil {
+			return nil, err
+		}
+		out = enc
+	}
+
+	gz := gzip.NewWriter(out)
+	if _, err := gz.Write(data); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	if err := out.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (r *Repository) open(name string, src io.Reader) ([]byte, error) {
+	if r.key != nil {
+		key, err := r.objectKey(name)
+		if err != nil {
+			return nil, err
+		}
+		dec, err := crypto.NewDecryptReader(key, src)
+		if err != nil {
+			return nil, err
+		}
+		src = dec
+	}
+
+	gz, err := gzip.NewReader(src)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+	return io.ReadAll(gz)
+}
+
+func (r *Repository) Snapshots(ctx context.Context) ([]storage.BackupItem, error) {
+	items, err := r.provider.List(ctx, path.Join(r.prefix, snapshotsDir)+"/")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list snapshots: %w", err)
+	}
+
+	snapshots := make([]storage.BackupItem, 0, len(items))
+	for _, item := range items {
+		name := path.Base(item.Key)
+		if !strings.HasSuffix(name, snapshotExt) {
+			continue
+		}
+		item.Key = strings.TrimSuffix(name, snapshotExt)
+		snapshots = append(snapshots, item)
+	}
+	return snapshots, nil
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
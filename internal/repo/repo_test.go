@@ -0,0 +1,209 @@
+package repo
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stacksnap/stacksnap/internal/crypto"
+	"github.com/stacksnap/stacksnap/internal/storage"
+)
+
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, 32)
+}
+
+func newTestRepo(t *testing.T, key []byte) (*Repository, string) {
+	t.Helper()
+	dir := t.TempDir()
+	provider, err := storage.NewLocalProvider(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := Init(context.Background(), provider, "repo", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r, dir
+}
+
+func testArchive(t *testing.T, files map[string][]byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for name, data := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func readArchive(t *testing.T, r io.Reader) map[string][]byte {
+	t.Helper()
+	files := make(map[string][]byte)
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return files
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[h.Name] = data
+	}
+}
+
+func TestSealUsesPerObjectKeys(t *testing.T) {
+	r, _ := newTestRepo(t, testKey(1))
+	data := []byte("the same plaintext in two objects")
+
+	blob, err := r.seal("chunks/aa", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		open    func() ([]byte, error)
+		wantErr bool
+	}{
+		{"same object", func() ([]byte, error) { return r.open("chunks/aa", bytes.NewReader(blob)) }, false},
+		{"other object", func() ([]byte, error) { return r.open("chunks/bb", bytes.NewReader(blob)) }, true},
+		{"snapshot namespace", func() ([]byte, error) { return r.open("snapshots/aa", bytes.NewReader(blob)) }, true},
+		{"master key", func() ([]byte, error) {
+			dec, err := crypto.NewDecryptReader(r.key, bytes.NewReader(blob))
+			if err != nil {
+				return nil, err
+			}
+			return io.ReadAll(dec)
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.open()
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected decryption to fail")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Fatal("plaintext mismatch")
+			}
+		})
+	}
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	files := map[string][]byte{
+		"compose.yaml":        []byte("services: {}\n"),
+		"volumes/data.tar":    randomBytes(3<<20, 1),
+		"volumes/empty.tar":   {},
+		"dumps/postgres.sql":  bytes.Repeat([]byte("INSERT INTO t VALUES (1);\n"), 50000),
+		"metadata.json":       []byte(`{"stack_name":"app"}`),
+		"volumes/copy.tar":    randomBytes(3<<20, 1),
+		"volumes/shifted.tar": append([]byte{0}, randomBytes(3<<20, 1)...),
+	}
+
+	for _, tt := range []struct {
+		name string
+		key  []byte
+	}{
+		{"plain", nil},
+		{"encrypted", testKey(2)},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r, _ := newTestRepo(t, tt.key)
+			ctx := context.Background()
+
+			snap, err := r.Store(ctx, "app_20260101_000000", bytes.NewReader(testArchive(t, files)), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if snap.Stats.ReusedChunks == 0 {
+				t.Fatal("identical and shifted volumes shared no chunks")
+			}
+
+			rc, err := r.OpenSnapshot(ctx, "app_20260101_000000")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rc.Close()
+			got := readArchive(t, rc)
+			for name, data := range files {
+				if !bytes.Equal(got[name], data) {
+					t.Fatalf("%s differs after restore", name)
+				}
+			}
+		})
+	}
+}
+
+func TestOpenKeyErrors(t *testing.T) {
+	r, _ := newTestRepo(t, testKey(3))
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		key  []byte
+		want error
+	}{
+		{"right key", testKey(3), nil},
+		{"no key", nil, ErrKeyRequired},
+		{"wrong key", testKey(4), ErrWrongKey},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Open(ctx, r.provider, r.prefix, tt.key)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestTamperedChunkIsRejected(t *testing.T) {
+	r, dir := newTestRepo(t, testKey(5))
+	ctx := context.Background()
+
+	files := map[string][]byte{"volumes/data.tar": randomBytes(1<<20, 2)}
+	snap, err := r.Store(ctx, "app_20260101_000000", bytes.NewReader(testArchive(t, files)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id := snap.Tree[0].Chunks[0]
+	chunkPath := filepath.Join(dir, "repo", "chunks", id[:2], id)
+	blob, err := os.ReadFile(chunkPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob[len(blob)-1] ^= 0xff
+	if err := os.WriteFile(chunkPath, blob, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := r.getChunk(ctx, id); err == nil {
+		t.Fatal("tampered chunk was accepted")
+	}
+}
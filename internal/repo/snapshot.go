@@ -0,0 +1,249 @@
+package repo
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/stacksnap/stacksnap/internal/storage"
+)
+
+const (
+	SnapshotVersion = 1
+	snapshotExt     = ".json"
+)
+
+type Snapshot struct {
+	Version   int               `json:"version"`
+	ID        string            `json:"id"`
+	CreatedAt time.Time         `json:"created_at"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+	Tree      []Node            `json:"tree"`
+	Stats     Stats             `json:"stats"`
+}
+
+type Node struct {
+	Name    string            `json:"name"`
+	Mode    int64             `json:"mode"`
+	ModTime time.Time         `json:"mtime"`
+	Size    int64             `json:"size"`
+	SHA256  string            `json:"sha256"`
+	Chunks  []string          `json:"chunks"`
+	PAX     map[string]string `json:"pax,omitempty"`
+}
+
+type Stats struct {
+	TotalBytes   int64 `json:"total_bytes"`
+	Chunks       int   `json:"chunks"`
+	NewChunks    int   `json:"new_chunks"`
+	StoredBytes  int64 `json:"stored_bytes"`
+	ReusedChunks int   `json:"reused_chunks"`
+}
+
+func (r *Repository) snapshotKey(id string) string {
+	return path.Join(r.prefix, snapshotsDir, id+snapshotExt)
+}
+
+func (r *Repository) Store(ctx context.Context, id string, archive io.Reader, metadata map[string]string) (*Snapshot, error) {
+	if err := r.loadIndex(ctx); err != nil {
+		return nil, err
+	}
+	if _, err := r.provider.Stat(ctx, r.snapshotKey(id)); err == nil {
+		return nil, fmt.Errorf("snapshot %s already exists", id)
+	}
+
+	snap := &Snapshot{
+		Version:   SnapshotVersion,
+		ID:        id,
+		CreatedAt: time.Now().UTC(),
+		Metadata:  metadata,
+	}
+
+	tr := tar.NewReader(archive)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read archive: %w", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		node, err := r.storeNode(ctx, header, tr, &snap.Stats)
+		if err != nil {
+			return nil, err
+		}
+		snap.Tree = append(snap.Tree, *node)
+	}
+
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return nil, err
+	}
+	blob, err := r.seal(snapshotsDir+id, data)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.provider.Upload(ctx, r.snapshotKey(id), bytes.NewReader(blob), storage.WithMetadata(metadata)); err != nil {
+		return nil, fmt.Errorf("failed to upload snapshot: %w", err)
+	}
+	return snap, nil
+}
+
+func (r *Repository) storeNode(ctx context.Context, header *tar.Header, src io.Reader, stats *Stats) (*Node, error) {
+	node := &Node{
+		Name:    header.Name,
+		Mode:    header.Mode,
+		ModTime: header.ModTime,
+		Chunks:  []string{},
+	}
+	for k, v := range header.PAXRecords {
+		if strings.Contains(k, ".") {
+			if node.PAX == nil {
+				node.PAX = make(map[string]string)
+			}
+			node.PAX[k] = v
+		}
+	}
+	sum := sha256.New()
+	chunker := newChunker(io.TeeReader(src, sum), r.gear, r.config.MinChunkSize, r.config.AvgChunkSize, r.config.MaxChunkSize)
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		chunk, err := chunker.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", header.Name, err)
+		}
+
+		id := r.chunkID(chunk)
+		if r.hasChunk(id) {
+			stats.ReusedChunks++
+		} else {
+			n, err := r.putChunk(ctx, id, chunk)
+			if err != nil {
+				return nil, err
+			}
+			stats.NewChunks++
+			stats.StoredBytes += n
+		}
+		stats.Chunks++
+		node.Size += int64(len(chunk))
+		node.Chunks = append(node.Chunks, id)
+	}
+
+	stats.TotalBytes += node.Size
+	node.SHA256 = hex.EncodeToString(sum.Sum(nil))
+	return node, nil
+}
+
+func (r *Repository) LoadSnapshot(ctx context.Context, id string) (*Snapshot, error) {
+	rc, err := r.provider.Download(ctx, r.snapshotKey(id))
+	if err != nil {
+		if _, statErr := r.provider.Stat(ctx, r.snapshotKey(id)); statErr != nil {
+			return nil, fmt.Errorf("%w: %s", ErrSnapshotNotFound, id)
+		}
+		return nil, fmt.Errorf("failed to download snapshot: %w", err)
+	}
+	defer rc.Close()
+
+	data, err := r.open(snapshotsDir+id, rc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot %s: %w", id, err)
+	}
+
+	var snap Snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, fmt.Errorf("invalid snapshot %s: %w", id, err)
+	}
+	if snap.Version != SnapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d", snap.Version)
+	}
+	return &snap, nil
+}
+
+func (r *Repository) NodeReader(ctx context.Context, node *Node) io.Reader {
+	return &nodeReader{ctx: ctx, repo: r, node: node, hash: sha256.New()}
+}
+
+func (r *Repository) OpenSnapshot(ctx context.Context, id string) (io.ReadCloser, error) {
+	snap, err := r.LoadSnapshot(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return r.ArchiveReader(ctx, snap), nil
+}
+
+func (r *Repository) ArchiveReader(ctx context.Context, snap *Snapshot) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		tw := tar.NewWriter(pw)
+		for i := range snap.Tree {
+			node := &snap.Tree[i]
+			header := &tar.Header{
+				Name:       node.Name,
+				Mode:       node.Mode,
+				Size:       node.Size,
+				ModTime:    node.ModTime,
+				PAXRecords: node.PAX,
+			}
+			if err := tw.WriteHeader(header); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			if _, err := io.Copy(tw, r.NodeReader(ctx, node)); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+		pw.CloseWithError(tw.Close())
+	}()
+	return pr
+}
+
+type nodeReader struct {
+	ctx  context.Context
+	repo *Repository
+	node *Node
+	hash hash.Hash
+	next int
+	buf  []byte
+}
+
+func (n *nodeReader) Read(p []byte) (int, error) {
+	for len(n.buf) == 0 {
+		if n.next == len(n.node.Chunks) {
+			if hex.EncodeToString(n.hash.Sum(nil)) != n.node.SHA256 {
+				return 0, fmt.Errorf("%w: %s does not match its recorded SHA-256", ErrChunkCorrupted, n.node.Name)
+			}
+			return 0, io.EOF
+		}
+		data, err := n.repo.getChunk(n.ctx, n.node.Chunks[n.next])
+		if err != nil {
+			return 0, err
+		}
+		n.hash.Write(data)
+		n.buf = data
+		n.next++
+	}
+
+	c := copy(p, n.buf)
+	n.buf = n.buf[c:]
+	return c, nil
+}
@@ -0,0 +1,91 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	tests := []struct {
+		expr    string
+		wantErr bool
+	}{
+		{"* * * * *", false},
+		{"@daily", false},
+		{"@HOURLY", false},
+		{"*/15 0-6,22-23 * * *", false},
+		{"0 9 * jan-jun mon-fri", false},
+		{"0 0 ? * 7", false},
+		{"", true},
+		{"* * * *", true},
+		{"* * * * * *", true},
+		{"60 * * * *", true},
+		{"* 24 * * *", true},
+		{"* * 0 * *", true},
+		{"* * * 13 *", true},
+		{"* * * * 8", true},
+		{"*/0 * * * *", true},
+		{"5-1 * * * *", true},
+		{"1,,2 * * * *", true},
+		{"a * * * *", true},
+		{"@weekdays", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			_, err := ParseCron(tt.expr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	at := func(s string) time.Time {
+		t.Helper()
+		ts, err := time.ParseInLocation("2006-01-02 15:04", s, time.UTC)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ts
+	}
+
+	// 1 January 2026 is a Thursday.
+	tests := []struct {
+		name  string
+		expr  string
+		after string
+		want  string
+	}{
+		{"every 15 minutes", "*/15 * * * *", "2026-01-01 10:07", "2026-01-01 10:15"},
+		{"step from an offset", "5/15 * * * *", "2026-01-01 10:06", "2026-01-01 10:20"},
+		{"strictly after", "0 3 * * *", "2026-01-01 03:00", "2026-01-02 03:00"},
+		{"hourly", "@hourly", "2026-01-01 10:00", "2026-01-01 11:00"},
+		{"next month", "0 0 1 * *", "2026-01-31 12:00", "2026-02-01 00:00"},
+		{"leap day", "0 0 29 2 *", "2026-03-01 00:00", "2028-02-29 00:00"},
+		{"sunday as 7", "0 0 * * 7", "2026-01-01 00:00", "2026-01-04 00:00"},
+		{"weekdays skip the weekend", "0 9 * * mon-fri", "2026-01-02 10:00", "2026-01-05 09:00"},
+		{"day of month or day of week", "0 0 13 * fri", "2026-01-01 00:00", "2026-01-02 00:00"},
+		{"day of month with any weekday", "0 0 13 * *", "2026-01-01 00:00", "2026-01-13 00:00"},
+		{"named months", "30 8 1 jul,dec *", "2026-01-01 00:00", "2026-07-01 08:30"},
+		{"never", "0 0 31 2 *", "2026-01-01 00:00", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := ParseCron(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := s.Next(at(tt.after))
+			if tt.want == "" {
+				if !got.IsZero() {
+					t.Fatalf("got %v, want no next run", got)
+				}
+				return
+			}
+			if want := at(tt.want); !got.Equal(want) {
+				t.Fatalf("got %v, want %v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,247 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/stacksnap/stacksnap/internal/config"
+)
+
+const (
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+)
+
+const maxSleep = time.Minute
+
+type RunFunc func(ctx context.Context, sc config.ScheduleConfig) error
+
+type Status struct {
+	config.ScheduleConfig
+	NextRun      *time.Time `json:"next_run,omitempty"`
+	LastRun      *time.Time `json:"last_run,omitempty"`
+	LastFinished *time.Time `json:"last_finished,omitempty"`
+	LastStatus   string     `json:"last_status,omitempty"`
+	LastError    string     `json:"last_error,omitempty"`
+	Running      bool       `json:"running"`
+	Error        string     `json:"error,omitempty"`
+}
+
+type runState struct {
+	LastRun      time.Time `json:"last_run"`
+	LastFinished time.Time `json:"last_finished,omitempty"`
+	LastStatus   string    `json:"last_status"`
+	LastError    string    `json:"last_error,omitempty"`
+}
+
+type entry struct {
+	config   config.ScheduleConfig
+	schedule *Schedule
+	err      error
+	next     time.Time
+	running  bool
+}
+
+type Scheduler struct {
+	mu        sync.Mutex
+	run       RunFunc
+	statePath string
+	entries   map[string]*entry
+	state     map[string]*runState
+	wake      chan struct{}
+}
+
+func New(statePath string, run RunFunc) *Scheduler {
+	s := &Scheduler{
+		run:       run,
+		statePath: statePath,
+		entries:   make(map[string]*entry),
+		state:     make(map[string]*runState),
+		wake:      make(chan struct{}, 1),
+	}
+
+	if data, err := os.ReadFile(statePath); err == nil {
+		if err := json.Unmarshal(data, &s.state); err != nil {
+			fmt.Printf(" Warning: failed to read scheduler state: %v\n", err)
+		}
+	}
+	if s.state == nil {
+		s.state = make(map[string]*runState)
+	}
+
+	return s
+}
+
+func (s *Scheduler) SetSchedules(schedules []config.ScheduleConfig) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	entries := make(map[string]*entry, len(schedules))
+	for _, sc := range schedules {
+		if sc.Stack == "" {
+			continue
+		}
+
+		e := &entry{config: sc}
+		if old, ok := s.entries[sc.Stack]; ok {
+			e.running = old.running
+		}
+
+		e.schedule, e.err = ParseCron(sc.Cron)
+		if e.err == nil {
+			e.next = s.firstRun(sc.Stack, e.schedule, now)
+		}
+		entries[sc.Stack] = e
+	}
+	s.entries = entries
+
+	s.notify()
+}
+
+func (s *Scheduler) firstRun(stack string, schedule *Schedule, now time.Time) time.Time {
+	st, ok := s.state[stack]
+	if !ok || st.LastRun.IsZero() {
+		return schedule.Next(now)
+	}
+
+	next := schedule.Next(st.LastRun)
+	if next.IsZero() || next.After(now) {
+		return next
+	}
+
+	return now
+}
+
+func (s *Scheduler) Start(ctx context.Context) error {
+	for {
+		now := time.Now()
+		wait := maxSleep
+
+		s.mu.Lock()
+		var due []*entry
+		for _, e := range s.entries {
+			if e.schedule == nil || e.config.Disabled || e.running || e.next.IsZero() {
+				continue
+			}
+			if !e.next.After(now) {
+				e.running = true
+				e.next = e.schedule.Next(now)
+				due = append(due, e)
+				continue
+			}
+			if d := e.next.Sub(now); d < wait {
+				wait = d
+			}
+		}
+		s.mu.Unlock()
+
+		for _, e := range due {
+			go s.execute(ctx, e.config)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-s.wake:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+func (s *Scheduler) execute(ctx context.Context, sc config.ScheduleConfig) {
+	started := time.Now()
+
+	s.mu.Lock()
+	s.state[sc.Stack] = &runState{LastRun: started, LastStatus: StatusRunning}
+	s.saveState()
+	s.mu.Unlock()
+
+	fmt.Printf(" Scheduled backup for %s started (%s)\n", sc.Stack, sc.Cron)
+	err := s.run(ctx, sc)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st := &runState{LastRun: started, LastFinished: time.Now(), LastStatus: StatusSucceeded}
+	if err != nil {
+		st.LastStatus = StatusFailed
+		st.LastError = err.Error()
+		fmt.Printf(" Scheduled backup for %s failed: %v\n", sc.Stack, err)
+	}
+	s.state[sc.Stack] = st
+	s.saveState()
+
+	if e, ok := s.entries[sc.Stack]; ok {
+		e.running = false
+		if e.schedule != nil && !e.next.After(st.LastFinished) {
+			e.next = e.schedule.Next(st.LastFinished)
+		}
+	}
+	s.notify()
+}
+
+func (s *Scheduler) Status() []Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	statuses := make([]Status, 0, len(s.entries))
+	for name, e := range s.entries {
+		st := Status{ScheduleConfig: e.config, Running: e.running}
+		if e.err != nil {
+			st.Error = e.err.Error()
+		}
+		if !e.next.IsZero() && !e.config.Disabled {
+			next := e.next
+			st.NextRun = &next
+		}
+		if rs, ok := s.state[name]; ok {
+			last := rs.LastRun
+			st.LastRun = &last
+			if !rs.LastFinished.IsZero() {
+				finished := rs.LastFinished
+				st.LastFinished = &finished
+			}
+			st.LastStatus = rs.LastStatus
+			st.LastError = rs.LastError
+		}
+		statuses = append(statuses, st)
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Stack < statuses[j].Stack
+	})
+	return statuses
+}
+
+func (s *Scheduler) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Scheduler) saveState() {
+	data, err := json.MarshalIndent(s.state, "", "  ")
+	if err != nil {
+		return
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.statePath), 0755); err != nil {
+		fmt.Printf(" Warning: failed to save scheduler state: %v\n", err)
+		return
+	}
+	if err := os.WriteFile(s.statePath, data, 0644); err != nil {
+		fmt.Printf(" Warning: failed to save scheduler state: %v\n", err)
+	}
+}
@@ -0,0 +1,98 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stacksnap/stacksnap/internal/config"
+)
+
+func newTestScheduler(t *testing.T, state map[string]*runState, run RunFunc) *Scheduler {
+	t.Helper()
+	statePath := filepath.Join(t.TempDir(), "schedule-state.json")
+	if state != nil {
+		data, err := json.Marshal(state)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(statePath, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return New(statePath, run)
+}
+
+func TestFirstRunCatchesUp(t *testing.T) {
+	schedule, err := ParseCron("0 3 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, 1, 10, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		lastRun time.Time
+		want    time.Time
+	}{
+		{"never ran", time.Time{}, time.Date(2026, 1, 11, 3, 0, 0, 0, time.UTC)},
+		{"ran at the last slot", time.Date(2026, 1, 10, 3, 0, 0, 0, time.UTC), time.Date(2026, 1, 11, 3, 0, 0, 0, time.UTC)},
+		{"missed one slot", time.Date(2026, 1, 9, 3, 0, 0, 0, time.UTC), now},
+		{"missed several slots", time.Date(2025, 12, 1, 3, 0, 0, 0, time.UTC), now},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var state map[string]*runState
+			if !tt.lastRun.IsZero() {
+				state = map[string]*runState{"app": {LastRun: tt.lastRun, LastStatus: StatusSucceeded}}
+			}
+			s := newTestScheduler(t, state, nil)
+			if got := s.firstRun("app", schedule, now); !got.Equal(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSchedulerRunsMissedBackupOnce(t *testing.T) {
+	state := map[string]*runState{"app": {LastRun: time.Now().Add(-48 * time.Hour), LastStatus: StatusSucceeded}}
+	ran := make(chan string, 4)
+	s := newTestScheduler(t, state, func(ctx context.Context, sc config.ScheduleConfig) error {
+		ran <- sc.Stack
+		return nil
+	})
+	s.SetSchedules([]config.ScheduleConfig{{Stack: "app", Cron: "@daily"}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Start(ctx)
+
+	select {
+	case <-ran:
+	case <-time.After(5 * time.Second):
+		t.Fatal("missed backup was not caught up")
+	}
+	select {
+	case <-ran:
+		t.Fatal("missed backup ran more than once")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		st := s.Status()
+		if len(st) == 1 && st[0].LastStatus == StatusSucceeded && !st[0].Running {
+			if st[0].NextRun == nil || !st[0].NextRun.After(time.Now()) {
+				t.Fatalf("next run not rescheduled: %+v", st[0])
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("run did not finish: %+v", st)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,193 @@
+package signing
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	SignatureVersion = 1
+	SignatureSuffix  = ".sig"
+	PublicKeyPrefix  = "ssnap-sig1"
+	privateKeyPrefix = "ssnap-sigkey1"
+	signatureContext = "stacksnap-signature-v1"
+)
+
+var (
+	ErrUnsigned        = errors.New("backup is not signed")
+	ErrUntrustedKey    = errors.New("backup is signed by an untrusted key")
+	ErrBadSignature    = errors.New("backup signature is invalid")
+	ErrDigestMismatch  = errors.New("backup contents do not match the signed digest")
+	ErrMetadataChanged = errors.New("backup metadata does not match the signed digest")
+)
+
+type Signature struct {
+	Version        int       `json:"version"`
+	KeyID          string    `json:"key_id"`
+	ArchiveSHA256  string    `json:"archive_sha256"`
+	ArchiveSize    int64     `json:"archive_size"`
+	MetadataSHA256 string    `json:"metadata_sha256"`
+	SignedAt       time.Time `json:"signed_at"`
+	Signature      string    `json:"signature"`
+}
+
+type Signer struct {
+	priv ed25519.PrivateKey
+}
+
+func LoadOrCreate(path string) (*Signer, error) {
+	s, err := Load(path)
+	if err == nil || !errors.Is(err, os.ErrNotExist) {
+		return s, err
+	}
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate signing key: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	encoded := privateKeyPrefix + base64.RawURLEncoding.EncodeToString(priv.Seed()) + "\n"
+	if err := os.WriteFile(path, []byte(encoded), 0600); err != nil {
+		return nil, fmt.Errorf("failed to write signing key: %w", err)
+	}
+	return &Signer{priv: priv}, nil
+}
+
+func Load(path string) (*Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	text := strings.TrimSpace(string(data))
+	if !strings.HasPrefix(text, privateKeyPrefix) {
+		return nil, fmt.Errorf("%s is not a StackSnap signing key", path)
+	}
+	seed, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(text, privateKeyPrefix))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("%s contains an invalid signing key", path)
+	}
+	return &Signer{priv: ed25519.NewKeyFromSeed(seed)}, nil
+}
+
+func (s *Signer) PublicKey() ed25519.PublicKey {
+	return s.priv.Public().(ed25519.PublicKey)
+}
+
+func (s *Signer) KeyID() string {
+	return KeyID(s.PublicKey())
+}
+
+func (s *Signer) Sign(archiveDigest []byte, archiveSize int64, metadataDigest []byte) *Signature {
+	sig := &Signature{
+		Version:        SignatureVersion,
+		KeyID:          s.KeyID(),
+		ArchiveSHA256:  hex.EncodeToString(archiveDigest),
+		ArchiveSize:    archiveSize,
+		MetadataSHA256: hex.EncodeToString(metadataDigest),
+		SignedAt:       time.Now().UTC(),
+	}
+	sig.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(s.priv, sig.message()))
+	return sig
+}
+
+func (sig *Signature) message() []byte {
+	return []byte(fmt.Sprintf("%s\nkey_id=%s\narchive_sha256=%s\narchive_size=%d\nmetadata_sha256=%s\nsigned_at=%s\n",
+		signatureContext, sig.KeyID, sig.ArchiveSHA256, sig.ArchiveSize, sig.MetadataSHA256, sig.SignedAt.Format(time.RFC3339Nano)))
+}
+
+func (sig *Signature) Verify(trusted []ed25519.PublicKey) error {
+	if sig == nil {
+		return ErrUnsigned
+	}
+	if sig.Version != SignatureVersion {
+		return fmt.Errorf("%w: unsupported version %d", ErrBadSignature, sig.Version)
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(sig.Signature)
+	if err != nil {
+		return ErrBadSignature
+	}
+
+	for _, pub := range trusted {
+		if KeyID(pub) != sig.KeyID {
+			continue
+		}
+		if !ed25519.Verify(pub, sig.message(), raw) {
+			return ErrBadSignature
+		}
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrUntrustedKey, sig.KeyID)
+}
+
+func (sig *Signature) CheckArchive(digest []byte, size int64) error {
+	if sig.ArchiveSHA256 != hex.EncodeToString(digest) || sig.ArchiveSize != size {
+		return ErrDigestMismatch
+	}
+	return nil
+}
+
+func (sig *Signature) CheckMetadata(metadata []byte) error {
+	sum := sha256.Sum256(metadata)
+	if sig.MetadataSHA256 != hex.EncodeToString(sum[:]) {
+		return ErrMetadataChanged
+	}
+	return nil
+}
+
+func (sig *Signature) Marshal() ([]byte, error) {
+	return json.MarshalIndent(sig, "", "  ")
+}
+
+func ParseSignature(data []byte) (*Signature, error) {
+	var sig Signature
+	if err := json.Unmarshal(data, &sig); err != nil {
+		return nil, fmt.Errorf("invalid signature file: %w", err)
+	}
+	return &sig, nil
+}
+
+func KeyID(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:8])
+}
+
+func EncodePublicKey(pub ed25519.PublicKey) string {
+	return PublicKeyPrefix + base64.RawURLEncoding.EncodeToString(pub)
+}
+
+func ParsePublicKey(s string) (ed25519.PublicKey, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, PublicKeyPrefix) {
+		return nil, fmt.Errorf("invalid signing key: expected %s prefix", PublicKeyPrefix)
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(s, PublicKeyPrefix))
+	if err != nil || len(raw) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid signing key %q", s)
+	}
+	return ed25519.PublicKey(raw), nil
+}
+
+func ParsePublicKeys(keys []string) ([]ed25519.PublicKey, error) {
+	out := make([]ed25519.PublicKey, 0, len(keys))
+	for _, k := range keys {
+		pub, err := ParsePublicKey(k)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, pub)
+	}
+	return out, nil
+}
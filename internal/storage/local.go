@@ -0,0 +1,370 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	localTempPrefix = ".stacksnap-tmp-"
+	localMetaDir    = ".meta"
+)
+
+type localMeta struct {
+	Checksum string            `json:"checksum"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+type LocalProvider struct {
+	root     string
+	sidecars bool
+}
+
+func NewLocalProvider(root string) (*LocalProvider, error) {
+	if root == "" {
+		return nil, fmt.Errorf("local storage path is required")
+	}
+
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve local storage path: %w", err)
+	}
+
+	if err := os.MkdirAll(abs, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create local storage directory: %w", err)
+	}
+
+	return &LocalProvider{root: abs, sidecars: true}, nil
+}
+
+// NewLocalDirProvider reads and writes files in an arbitrary directory, such
+// as the one holding an --output file. Unlike a storage root it keeps no
+// metadata sidecars, so Stat reports neither a checksum nor metadata.
+func NewLocalDirProvider(dir string) (*LocalProvider, error) {
+	l, err := NewLocalProvider(dir)
+	if err != nil {
+		return nil, err
+	}
+	l.sidecars = false
+	return l, nil
+}
+
+func (l *LocalProvider) Root() string {
+	return l.root
+}
+
+// localReserved reports whether a path segment belongs to the provider
+// itself: the metadata directory at the root, or an in-flight upload.
+func localReserved(part string, top bool) bool {
+	return (top && part == localMetaDir) || strings.HasPrefix(part, localTempPrefix)
+}
+
+func (l *LocalProvider) cleanKey(key string) (string, error) {
+	slashed := strings.ReplaceAll(key, "\\", "/")
+	for i, part := range strings.Split(strings.Trim(slashed, "/"), "/") {
+		if part == "." || part == ".." || localReserved(part, i == 0) {
+			return "", fmt.Errorf("invalid storage key %q", key)
+		}
+	}
+
+	clean := path.Clean("/" + slashed)
+	if clean == "/" {
+		return "", fmt.Errorf("invalid storage key %q", key)
+	}
+	return clean[1:], nil
+}
+
+func (l *LocalProvider) path(key string) (string, error) {
+	clean, err := l.cleanKey(key)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(l.root, filepath.FromSlash(clean)), nil
+}
+
+func (l *LocalProvider) metaPath(key string) (string, error) {
+	clean, err := l.cleanKey(key)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(l.root, localMetaDir, filepath.FromSlash(clean)+".json"), nil
+}
+
+func (l *LocalProvider) Upload(ctx context.Context, key string, data io.Reader, opts ...UploadOption) error {
+	o := applyUploadOptions(opts)
+
+	target, err := l.path(key)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(target)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", key, err)
+	}
+
+	tmp, err := os.CreateTemp(dir, localTempPrefix+"*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	tmpName := tmp.Name()
+
+	fail := func(err error) error {
+		tmp.Close()
+		os.Remove(tmpName)
+		return err
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, hash), &contextReader{ctx: ctx, r: data}); err != nil {
+		return fail(fmt.Errorf("failed to write %s: %w", key, err))
+	}
+	if err := tmp.Sync(); err != nil {
+		return fail(fmt.Errorf("failed to sync %s: %w", key, err))
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpName)
+		return fmt.Errorf("failed to close %s: %w", key, err)
+	}
+
+	// Metadata goes first so a visible object always has its metadata; if
+	// the rename fails the previous metadata is put back.
+	rollback := func() {}
+	if l.sidecars {
+		metaPath, err := l.metaPath(key)
+		if err != nil {
+			os.Remove(tmpName)
+			return err
+		}
+		prevMeta, prevErr := os.ReadFile(metaPath)
+		err = l.writeMeta(key, localMeta{
+			Checksum: ChecksumSHA256 + hex.EncodeToString(hash.Sum(nil)),
+			Metadata: o.Metadata,
+		})
+		if err != nil {
+			os.Remove(tmpName)
+			return err
+		}
+		rollback = func() {
+			if prevErr == nil {
+				os.WriteFile(metaPath, prevMeta, 0644)
+			} else {
+				os.Remove(metaPath)
+			}
+		}
+	}
+
+	if err := os.Rename(tmpName, target); err != nil {
+		os.Remove(tmpName)
+		rollback()
+		return fmt.Errorf("failed to move %s into place: %w", key, err)
+	}
+	return nil
+}
+
+func (l *LocalProvider) writeMeta(key string, meta localMeta) error {
+	p, err := l.metaPath(key)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return fmt.Errorf("failed to create metadata directory: %w", err)
+	}
+
+	tmp := p + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write metadata for %s: %w", key, err)
+	}
+	return os.Rename(tmp, p)
+}
+
+func (l *LocalProvider) readMeta(key string) localMeta {
+	var meta localMeta
+	if !l.sidecars {
+		return meta
+	}
+	p, err := l.metaPath(key)
+	if err != nil {
+		return meta
+	}
+	if data, err := os.ReadFile(p); err == nil {
+		json.Unmarshal(data, &meta)
+	}
+	return meta
+}
+
+func (l *LocalProvider) Download(ctx context.Context, key string) (io.ReadCloser, error) {
+	target, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(target)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+		}
+		return nil, fmt.Errorf("failed to open %s: %w", key, err)
+	}
+	return f, nil
+}
+
+func (l *LocalProvider) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	target, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(target)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+		}
+		return nil, fmt.Errorf("failed to stat %s: %w", key, err)
+	}
+	if info.IsDir() {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+
+	meta := l.readMeta(key)
+	clean, _ := l.cleanKey(key)
+
+	return &ObjectInfo{
+		BackupItem: BackupItem{
+			Key:          clean,
+			Size:         info.Size(),
+			LastModified: info.ModTime(),
+		},
+		Checksum: meta.Checksum,
+		Metadata: meta.Metadata,
+	}, nil
+}
+
+func (l *LocalProvider) Delete(ctx context.Context, key string) error {
+	target, err := l.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(target); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to delete %s: %w", key, err)
+	}
+
+	if p, err := l.metaPath(key); err == nil && l.sidecars {
+		os.Remove(p)
+	}
+	return nil
+}
+
+func (l *LocalProvider) ListPage(ctx context.Context, opts ListOptions) (*ListPage, error) {
+	items, err := l.List(ctx, opts.Prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Key < items[j].Key
+	})
+
+	start := 0
+	if opts.PageToken != "" {
+		start = sort.Search(len(items), func(i int) bool {
+			return items[i].Key > opts.PageToken
+		})
+	}
+	items = items[start:]
+
+	page := &ListPage{Items: items}
+	if opts.MaxKeys > 0 && len(items) > opts.MaxKeys {
+		page.Items = items[:opts.MaxKeys]
+		page.NextToken = page.Items[len(page.Items)-1].Key
+	}
+	return page, nil
+}
+
+func (l *LocalProvider) List(ctx context.Context, prefix string) ([]BackupItem, error) {
+	var items []BackupItem
+
+	err := filepath.WalkDir(l.root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if p == l.root {
+			return nil
+		}
+
+		if localReserved(d.Name(), filepath.Dir(p) == l.root) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		rel, err := filepath.Rel(l.root, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+
+		if d.IsDir() {
+			if !strings.HasPrefix(key+"/", prefix) && !strings.HasPrefix(prefix, key+"/") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if !d.Type().IsRegular() || !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+
+		items = append(items, BackupItem{
+			Key:          key,
+			Size:         info.Size(),
+			LastModified: info.ModTime(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list local backups: %w", err)
+	}
+
+	return items, nil
+}
+
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
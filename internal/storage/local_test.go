@@ -0,0 +1,165 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestLocalUploadChecksumAndMetadata(t *testing.T) {
+	p, err := NewLocalProvider(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	data := "backup contents"
+
+	if err := p.Upload(ctx, "app/backup.tar.gz", strings.NewReader(data), WithMetadata(map[string]string{"stack": "app"})); err != nil {
+		t.Fatal(err)
+	}
+	info, err := p.Stat(ctx, "app/backup.tar.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte(data))
+	if want := ChecksumSHA256 + hex.EncodeToString(sum[:]); info.Checksum != want {
+		t.Fatalf("checksum %q, want %q", info.Checksum, want)
+	}
+	if info.Metadata["stack"] != "app" {
+		t.Fatalf("metadata not stored: %v", info.Metadata)
+	}
+}
+
+func TestLocalUploadRollsBackMetadata(t *testing.T) {
+	root := t.TempDir()
+	p, err := NewLocalProvider(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	if err := p.Upload(ctx, "app/a.tar.gz", strings.NewReader("old"), WithMetadata(map[string]string{"v": "1"})); err != nil {
+		t.Fatal(err)
+	}
+	metaPath, _ := p.metaPath("app/a.tar.gz")
+	before, err := os.ReadFile(metaPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A directory in the way makes the final rename fail.
+	if err := os.Remove(filepath.Join(root, "app", "a.tar.gz")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(root, "app", "a.tar.gz", "blocker"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Upload(ctx, "app/a.tar.gz", strings.NewReader("new"), WithMetadata(map[string]string{"v": "2"})); err == nil {
+		t.Fatal("upload over a directory succeeded")
+	}
+	after, err := os.ReadFile(metaPath)
+	if err != nil || string(after) != string(before) {
+		t.Fatalf("metadata changed by a failed upload: %s", after)
+	}
+
+	if err := p.Upload(ctx, "app/b.tar.gz/x", strings.NewReader("x")); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Upload(ctx, "app/b.tar.gz", strings.NewReader("new")); err == nil {
+		t.Fatal("upload over a directory succeeded")
+	}
+	if metaPath, _ := p.metaPath("app/b.tar.gz"); fileExists(metaPath) {
+		t.Fatal("failed upload left metadata behind")
+	}
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func TestLocalKeys(t *testing.T) {
+	p, err := NewLocalProvider(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	tests := []struct {
+		key     string
+		wantErr bool
+	}{
+		{"app/backup.tar.gz", false},
+		{".hidden/backup.tar.gz", false},
+		{"app/.backup.tar.gz", false},
+		{"app/sub/.meta", false},
+		{"", true},
+		{"/", true},
+		{"..", true},
+		{"../outside.tar.gz", true},
+		{"app/../../outside.tar.gz", true},
+		{"app\\..\\outside.tar.gz", true},
+		{"./app.tar.gz", true},
+		{".meta/app.json", true},
+		{"app/.stacksnap-tmp-123", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			err := p.Upload(ctx, tt.key, strings.NewReader("data"))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	items, err := p.List(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, item := range items {
+		keys = append(keys, item.Key)
+	}
+	sort.Strings(keys)
+	want := []string{".hidden/backup.tar.gz", "app/.backup.tar.gz", "app/backup.tar.gz", "app/sub/.meta"}
+	if !slices.Equal(keys, want) {
+		t.Fatalf("listed %q, want %q", keys, want)
+	}
+}
+
+func TestLocalDirProviderKeepsNoSidecars(t *testing.T) {
+	dir := t.TempDir()
+	p, err := NewLocalDirProvider(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	if err := p.Upload(ctx, "app.tar.gz", strings.NewReader("data"), WithMetadata(map[string]string{"stack": "app"})); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "app.tar.gz" {
+		t.Fatalf("directory holds %v, want only the uploaded file", entries)
+	}
+
+	info, err := p.Stat(ctx, "app.tar.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Metadata != nil || info.Checksum != "" {
+		t.Fatalf("unexpected metadata %+v", info)
+	}
+	if err := p.Delete(ctx, "app.tar.gz"); err != nil {
+		t.Fatal(err)
+	}
+}
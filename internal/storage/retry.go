@@ -158,14 +158,10 @@ func NewRetryingProvider(p Provider, cfg RetryConfig) *RetryingProvider {
 	}
 }
 
-
-func (r *RetryingProvider) Upload(ctx context.Context, key string, data io.Reader) error {
-
-
-
+func (r *RetryingProvider) Upload(ctx context.Context, key string, data io.Reader, opts ...UploadOption) error {
 
 	return WithRetry(ctx, r.Config, func() error {
-		return r.Provider.Upload(ctx, key, data)
+		return r.Provider.Upload(ctx, key, data, opts...)
 	})
 }
 
@@ -202,3 +198,39 @@ func (r *RetryingProvider) List(ctx context.Context, prefix string) ([]BackupIte
 	}
 	return result, nil
 }
+
+func (r *RetryingProvider) ListPage(ctx context.Context, opts ListOptions) (*ListPage, error) {
+	var result *ListPage
+
+	err := WithRetry(ctx, r.Config, func() error {
+		var err error
+		result, err = r.Provider.ListPage(ctx, opts)
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (r *RetryingProvider) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	var result *ObjectInfo
+
+	err := WithRetry(ctx, r.Config, func() error {
+		var err error
+		result, err = r.Provider.Stat(ctx, key)
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (r *RetryingProvider) Delete(ctx context.Context, key string) error {
+	return WithRetry(ctx, r.Config, func() error {
+		return r.Provider.Delete(ctx, key)
+	})
+}
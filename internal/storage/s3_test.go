@@ -0,0 +1,39 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+func TestS3Checksum(t *testing.T) {
+	sum := sha256.Sum256([]byte("backup contents"))
+	b64 := base64.StdEncoding.EncodeToString(sum[:])
+	hexSum := hex.EncodeToString(sum[:])
+
+	tests := []struct {
+		name   string
+		value  string
+		typ    types.ChecksumType
+		want   string
+		wantOK bool
+	}{
+		{"full object", b64, types.ChecksumTypeFullObject, ChecksumSHA256 + hexSum, true},
+		{"no type reported", b64, "", ChecksumSHA256 + hexSum, true},
+		{"multipart", b64 + "-3", "", "sha256-composite:" + hexSum + "-3", true},
+		{"composite type", b64, types.ChecksumTypeComposite, "sha256-composite:" + hexSum, true},
+		{"missing", "", "", "", false},
+		{"not base64", "%%%", "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := s3Checksum(tt.value, tt.typ)
+			if got != tt.want || ok != tt.wantOK {
+				t.Fatalf("got (%q, %v), want (%q, %v)", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
@@ -2,8 +2,12 @@ package storage
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
 	"time"
+
+	"github.com/stacksnap/stacksnap/internal/config"
 )
 
 
@@ -13,14 +17,115 @@ type BackupItem struct {
 	LastModified time.Time
 }
 
+// ChecksumSHA256 prefixes an ObjectInfo checksum that is the hex SHA-256
+// of the whole object, whichever provider reported it.
+const ChecksumSHA256 = "sha256:"
 
-type Provider interface {
+type ObjectInfo struct {
+	BackupItem
+	// Checksum is ChecksumSHA256 followed by hex when the provider knows the
+	// object's SHA-256. Other forms are provider specific and only good for
+	// comparing two Stats of the same object.
+	Checksum string
+	Metadata map[string]string
+}
 
-	Upload(ctx context.Context, key string, data io.Reader) error
+type ListOptions struct {
+	Prefix    string
+	PageToken string
+	MaxKeys   int
+}
 
+type ListPage struct {
+	Items     []BackupItem
+	NextToken string
+}
 
-	Download(ctx context.Context, key string) (io.ReadCloser, error)
+type UploadOptions struct {
+	Metadata map[string]string
+}
 
+type UploadOption func(*UploadOptions)
+
+func WithMetadata(metadata map[string]string) UploadOption {
+	return func(o *UploadOptions) {
+		if o.Metadata == nil {
+			o.Metadata = make(map[string]string)
+		}
+		for k, v := range metadata {
+			o.Metadata[k] = v
+		}
+	}
+}
+
+func applyUploadOptions(opts []UploadOption) UploadOptions {
+	var o UploadOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+var ErrNotFound = errors.New("object not found")
+
+type Provider interface {
+	Upload(ctx context.Context, key string, data io.Reader, opts ...UploadOption) error
+
+	Download(ctx context.Context, key string) (io.ReadCloser, error)
 
 	List(ctx context.Context, prefix string) ([]BackupItem, error)
+
+	ListPage(ctx context.Context, opts ListOptions) (*ListPage, error)
+
+	Stat(ctx context.Context, key string) (*ObjectInfo, error)
+
+	Delete(ctx context.Context, key string) error
+}
+
+func Walk(ctx context.Context, p Provider, prefix string, fn func(BackupItem) error) error {
+	token := ""
+	for {
+		page, err := p.ListPage(ctx, ListOptions{Prefix: prefix, PageToken: token})
+		if err != nil {
+			return err
+		}
+
+		for _, item := range page.Items {
+			if err := fn(item); err != nil {
+				return err
+			}
+		}
+
+		if page.NextToken == "" {
+			return nil
+		}
+		token = page.NextToken
+	}
+}
+
+func listAll(ctx context.Context, p Provider, prefix string) ([]BackupItem, error) {
+	var items []BackupItem
+	err := Walk(ctx, p, prefix, func(item BackupItem) error {
+		items = append(items, item)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+func NewProviderFromConfig(ctx context.Context, cfg config.StorageConfig) (Provider, error) {
+	switch cfg.Type {
+	case config.StorageS3:
+		return NewS3Provider(ctx, cfg.S3Bucket, cfg.S3Region, cfg.S3Endpoint, cfg.S3AccessKey, cfg.S3SecretKey)
+	case config.StorageLocal, "":
+		path := cfg.Path
+		if path == "" {
+			path = config.DefaultBackupsPath()
+		}
+		return NewLocalProvider(path)
+	default:
+		return nil, fmt.Errorf("unsupported storage type %q", cfg.Type)
+	}
 }
@@ -1,4 +1,4 @@
-import { useEffect, useState } from "react"
+import { useEffect, useRef, useState } from "react"
 import posthog from 'posthog-js'
 import { Badge } from "@/components/ui/badge"
 import { Button } from "@/components/ui/button"
@@ -7,6 +7,7 @@ import { Topology } from "./components/Topology"
 import { StackDetail } from "./components/StackDetail"
 import { Settings } from "./components/Settings"
 import { Onboarding } from "./pages/Onboarding"
+import { Login } from "./pages/Login"
 import { AnimatePresence } from "framer-motion"
 import { AppSidebar } from "./components/AppSidebar"
 import { GlobalActivity } from "./components/GlobalActivity"
@@ -20,6 +21,18 @@ interface Stack {
   IsStandalone: boolean
 }
 
+interface StreamEvent {
+  id: number
+  time: string
+  job_id?: string
+  stack?: string
+  phase?: string
+  level: string
+  message?: string
+  bytes_processed?: number
+  bytes_total?: number
+}
+
 export default function App() {
   const [stacks, setStacks] = useState<Stack[]>([])
   const [loading, setLoading] = useState(true)
@@ -33,6 +46,8 @@ export default function App() {
   const [_error, setError] = useState("")
   const [backingUp, setBackingUp] = useState<string | null>(null)
   const [targetName, setTargetName] = useState<string | null>(null)
+  const [activeJobId, setActiveJobId] = useState<string | null>(null)
+  const activeJobRef = useRef<string | null>(null)
   const [showSettings, setShowSettings] = useState(false)
   const [showGlobalActivity, setShowGlobalActivity] = useState(false)
   const [sidebarOpen, setSidebarOpen] = useState(false)
@@ -40,23 +55,37 @@ export default function App() {
 
   const [setupRequired, setSetupRequired] = useState(false)
   const [checkingAuth, setCheckingAuth] = useState(true)
+  const [loginRequired, setLoginRequired] = useState(false)
+  const [adminConfigured, setAdminConfigured] = useState(true)
 
   useEffect(() => {
     checkHealth()
   }, [])
 
   const checkHealth = () => {
-    fetch("http://localhost:8080/api/health")
+    fetch("http://localhost:8080/api/auth/status")
       .then(res => res.json())
-      .then(data => {
-        if (data.status === "setup_required") {
-          setSetupRequired(true)
-        } else {
-          setSetupRequired(false)
-          fetchStacks()
-          fetchStats()
+      .then(status => {
+        setAdminConfigured(status.admin_configured)
+        if (!status.authenticated) {
+          setLoginRequired(true)
+          setCheckingAuth(false)
+          return
         }
-        setCheckingAuth(false)
+        setLoginRequired(false)
+
+        return fetch("http://localhost:8080/api/health")
+          .then(res => res.json())
+          .then(data => {
+            if (data.status === "setup_required") {
+              setSetupRequired(true)
+            } else {
+              setSetupRequired(false)
+              fetchStacks()
+              fetchStats()
+            }
+            setCheckingAuth(false)
+          })
       })
       .catch(err => {
         console.error("Health check failed", err)
@@ -67,7 +96,7 @@ export default function App() {
 
 
   useEffect(() => {
-    if (setupRequired) return
+    if (setupRequired || loginRequired) return
     const interval = setInterval(() => {
 
       fetchStats()
@@ -77,7 +106,7 @@ export default function App() {
       }
     }, 30000)
     return () => clearInterval(interval)
-  }, [selectedStack])
+  }, [selectedStack, setupRequired, loginRequired])
 
   const fetchStacks = () => {
     fetch("http://localhost:8080/api/stacks")
@@ -167,7 +196,9 @@ export default function App() {
       .then((res) => {
         if (!res.ok) throw new Error("Restore failed")
         posthog.capture('restore_triggered', { filename, project: selectedStack?.Name })
+        return res.json()
       })
+      .then((data) => setActiveJobId(data?.job_id || null))
       .catch((err) => {
         setProgressError("Failed to trigger restore: " + err.message)
       })
@@ -240,7 +271,8 @@ export default function App() {
         posthog.capture('backup_triggered', { project: projectName, ...opts })
         return res.json()
       })
-      .then(() => {
+      .then((data) => {
+        setActiveJobId(data?.job_id || null)
 
         setTimeout(() => {
           setBackingUp(null)
@@ -253,18 +285,40 @@ export default function App() {
   }
 
 
+  const handleCancelJob = () => {
+    if (!activeJobId) return
+    fetch(`http://localhost:8080/api/jobs/${activeJobId}/cancel`, { method: "POST" })
+      .then((res) => {
+        if (!res.ok) return res.text().then(text => { throw new Error(text) })
+      })
+      .catch((err) => alert("Error: " + err.message))
+  }
+
   const [progressLogs, setProgressLogs] = useState<string[]>([])
   const [progressError, setProgressError] = useState<string | null>(null)
   const [progressActive, setProgressActive] = useState(false)
 
   useEffect(() => {
-    if (!setupRequired && !checkingAuth) {
+    activeJobRef.current = activeJobId
+  }, [activeJobId])
+
+  useEffect(() => {
+    if (!setupRequired && !loginRequired && !checkingAuth) {
       console.log(" Connecting to Event Stream...")
-      const evtSource = new EventSource("http://localhost:8080/api/events")
+      const evtSource = new EventSource("http://localhost:8080/api/events", { withCredentials: true })
 
       evtSource.onmessage = (event) => {
-        const msg = event.data
-        if (msg === "COMPLETE") {
+        let ev: StreamEvent
+        try {
+          ev = JSON.parse(event.data)
+        } catch {
+          return
+        }
+
+        const jobId = activeJobRef.current
+        if (jobId && ev.job_id && ev.job_id !== jobId) return
+
+        if (ev.phase === "complete") {
 
 
           fetchStats()
@@ -277,23 +331,23 @@ export default function App() {
             setBackingUp(null)
             setProgressActive(false)
           }, 2000)
-        } else if (msg.startsWith("ERROR:")) {
-          setProgressError(msg.replace("ERROR:", "").trim())
-        } else {
-          setProgressLogs(prev => [...prev, msg])
+        } else if (ev.phase === "failed") {
+          setProgressError(ev.message || "Operation failed")
+        } else if (ev.message) {
+          const time = new Date(ev.time).toLocaleTimeString([], { hour12: false })
+          setProgressLogs(prev => [...prev, `[${time}] ${ev.level.toUpperCase()}: ${ev.message}`])
         }
       }
 
-      evtSource.onerror = (err) => {
-        console.error("EventSource failed:", err)
-        evtSource.close()
+      evtSource.onerror = () => {
+        console.warn("Event stream interrupted, reconnecting...")
       }
 
       return () => {
         evtSource.close()
       }
     }
-  }, [setupRequired, checkingAuth, selectedStack])
+  }, [setupRequired, loginRequired, checkingAuth, selectedStack])
 
 
 
@@ -308,6 +362,10 @@ export default function App() {
     </div>
   }
 
+  if (loginRequired) {
+    return <Login adminConfigured={adminConfigured} onComplete={() => checkHealth()} />
+  }
+
   if (setupRequired) {
     return <Onboarding onComplete={() => checkHealth()} />
   }
@@ -353,9 +411,11 @@ export default function App() {
             targetName={backingUp || targetName || "Restore Operation"}
             logs={progressLogs}
             error={progressError}
+            onCancel={activeJobId ? handleCancelJob : undefined}
             onComplete={() => {
               setBackingUp(null)
               setTargetName(null)
+              setActiveJobId(null)
               setProgressActive(false)
               setProgressLogs([])
               setProgressError(null)
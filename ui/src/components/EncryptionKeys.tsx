@@ -0,0 +1,182 @@
+import { useEffect, useState } from "react"
+import { Button } from "./ui/button"
+import { Input } from "./ui/input"
+import { Label } from "./ui/label"
+import { KeyRound, Lock } from "lucide-react"
+
+interface KeyInfo {
+    id: string
+    name: string
+    created_at: string
+    retired_at?: string
+}
+
+interface KeyringState {
+    initialized: boolean
+    locked: boolean
+    default: string
+    keys: KeyInfo[]
+}
+
+export function EncryptionKeys() {
+    const [state, setState] = useState<KeyringState | null>(null)
+    const [passphrase, setPassphrase] = useState("")
+    const [keyName, setKeyName] = useState("")
+    const [kitFor, setKitFor] = useState("")
+    const [kitPassphrase, setKitPassphrase] = useState("")
+    const [kitHint, setKitHint] = useState("")
+    const [busy, setBusy] = useState(false)
+    const [error, setError] = useState("")
+
+    const load = () => {
+        fetch("http://localhost:8080/api/keys")
+            .then(res => res.json())
+            .then(setState)
+            .catch(() => setError("Failed to load keyring"))
+    }
+
+    useEffect(() => {
+        load()
+    }, [])
+
+    const post = (path: string, body: any) => {
+        setBusy(true)
+        setError("")
+        return fetch(`http://localhost:8080/api/keys${path}`, {
+            method: "POST",
+            body: JSON.stringify(body),
+            headers: { "Content-Type": "application/json" }
+        })
+            .then(async res => {
+                if (!res.ok) throw new Error((await res.text()).trim())
+            })
+            .then(load)
+            .catch(err => setError(err.message))
+            .finally(() => setBusy(false))
+    }
+
+    const unlock = () => post("/unlock", { passphrase }).then(() => setPassphrase(""))
+    const createKey = () => {
+        setBusy(true)
+        setError("")
+        fetch("http://localhost:8080/api/keys", {
+            method: "POST",
+            body: JSON.stringify({ name: keyName }),
+            headers: { "Content-Type": "application/json" }
+        })
+            .then(async res => {
+                if (!res.ok) throw new Error((await res.text()).trim())
+                const key: KeyInfo = await res.json()
+                setKeyName("")
+                setKitFor(key.id)
+            })
+            .then(load)
+            .catch(err => setError(err.message))
+            .finally(() => setBusy(false))
+    }
+
+    const downloadKit = () => {
+        setBusy(true)
+        setError("")
+        fetch("http://localhost:8080/api/keys/recovery-kit", {
+            method: "POST",
+            body: JSON.stringify({ key_id: kitFor, passphrase: kitPassphrase, hint: kitHint, format: "text" }),
+            headers: { "Content-Type": "application/json" }
+        })
+            .then(async res => {
+                if (!res.ok) throw new Error((await res.text()).trim())
+                const url = URL.createObjectURL(await res.blob())
+                const a = document.createElement("a")
+                a.href = url
+                a.download = `stacksnap-recovery-${kitFor}.txt`
+                a.click()
+                URL.revokeObjectURL(url)
+                setKitFor("")
+                setKitPassphrase("")
+                setKitHint("")
+            })
+            .catch(err => setError(err.message))
+            .finally(() => setBusy(false))
+    }
+
+    if (!state) return null
+
+    return (
+        <div className="space-y-6 pt-6 border-t">
+            <div className="flex items-center gap-2 text-sm font-semibold uppercase tracking-wider text-muted-foreground">
+                <KeyRound className="w-4 h-4" /> Encryption Keys
+            </div>
+
+            {state.locked ? (
+                <div className="space-y-2">
+                    <Label>{state.initialized ? "Keyring Passphrase" : "Choose a Keyring Passphrase"}</Label>
+                    <div className="flex gap-2">
+                        <Input type="password" value={passphrase} onChange={e => setPassphrase(e.target.value)} />
+                        <Button variant="outline" onClick={unlock} disabled={busy || !passphrase}>
+                            <Lock className="w-4 h-4 mr-2" /> {state.initialized ? "Unlock" : "Create"}
+                        </Button>
+                    </div>
+                    <p className="text-xs text-muted-foreground">
+                        Keys are stored encrypted with this passphrase. It is never saved to disk.
+                    </p>
+                </div>
+            ) : (
+                <div className="space-y-2">
+                    <Label>New Key</Label>
+                    <div className="flex gap-2">
+                        <Input value={keyName} onChange={e => setKeyName(e.target.value)} placeholder="production" />
+                        <Button variant="outline" onClick={createKey} disabled={busy || !keyName}>Generate</Button>
+                    </div>
+                </div>
+            )}
+
+            {kitFor && (
+                <div className="space-y-2 p-3 rounded-md border">
+                    <Label>Recovery Kit for {kitFor}</Label>
+                    <p className="text-xs text-muted-foreground">
+                        Download a recovery kit now. With the kit and its passphrase you can rebuild this key if the server is lost.
+                    </p>
+                    <Input type="password" value={kitPassphrase} onChange={e => setKitPassphrase(e.target.value)} placeholder="Kit passphrase (min. 8 characters)" />
+                    <Input value={kitHint} onChange={e => setKitHint(e.target.value)} placeholder="Passphrase hint (optional)" />
+                    <div className="flex gap-2">
+                        <Button variant="outline" onClick={downloadKit} disabled={busy || kitPassphrase.length < 8}>Download Kit</Button>
+                        <Button variant="ghost" onClick={() => setKitFor("")} disabled={busy}>Later</Button>
+                    </div>
+                </div>
+            )}
+
+            {state.keys.length > 0 ? (
+                <div className="space-y-2">
+                    {state.keys.map(k => (
+                        <div key={k.id} className="flex items-center justify-between text-sm p-2 rounded-md border">
+                            <div>
+                                <div className="font-medium">{k.name}</div>
+                                <div className="text-[10px] font-mono text-muted-foreground">{k.id}</div>
+                            </div>
+                            <div className="flex items-center gap-2">
+                                {!state.locked && (
+                                    <Button variant="ghost" size="sm" disabled={busy} onClick={() => setKitFor(k.id)}>
+                                        Recovery Kit
+                                    </Button>
+                                )}
+                                {k.id === state.default ? (
+                                    <span className="text-[10px] font-bold uppercase text-emerald-500">Default</span>
+                                ) : !k.retired_at && (
+                                    <Button variant="ghost" size="sm" disabled={busy} onClick={() => post("/default", { id: k.id })}>
+                                        Make Default
+                                    </Button>
+                                )}
+                            </div>
+                        </div>
+                    ))}
+                </div>
+            ) : (
+                <p className="text-xs text-muted-foreground">
+                    No keys yet. Backups are stored unencrypted until a key is generated.
+                </p>
+            )}
+
+            {error && <div className="text-xs text-red-500">{error}</div>}
+        </div>
+    )
+}
@@ -7,6 +7,7 @@ interface OperationProgressProps {
     operation: "backup" | "restore"
     targetName: string
     onComplete?: () => void
+    onCancel?: () => void
     error?: string | null
     logs?: string[]
 }
@@ -32,7 +33,7 @@ const STEPS = {
     ]
 }
 
-export function OperationProgress({ isOpen, operation, targetName, onComplete, error, logs: externalLogs }: OperationProgressProps) {
+export function OperationProgress({ isOpen, operation, targetName, onComplete, onCancel, error, logs: externalLogs }: OperationProgressProps) {
     const [currentStep, setCurrentStep] = useState(0)
     const [internalLogs, setInternalLogs] = useState<string[]>([])
     const [progress, setProgress] = useState(0)
@@ -250,6 +251,15 @@ export function OperationProgress({ isOpen, operation, targetName, onComplete, e
 
                     </div>
 
+                    {/* Cancel Action */}
+                    {!error && progress < 100 && onCancel && (
+                        <div className="p-4 border-t border-zinc-800 flex justify-end relative z-10">
+                            <button onClick={onCancel} className="px-4 py-2 bg-zinc-900 hover:bg-zinc-800 text-zinc-300 border border-zinc-700 rounded-md text-sm font-medium transition-colors">
+                                Cancel
+                            </button>
+                        </div>
+                    )}
+
                     {/* Error Actions */}
                     {error && (
                         <div className="p-4 bg-red-950/20 border-t border-red-900/50 flex justify-end">
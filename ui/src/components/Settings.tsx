@@ -5,6 +5,7 @@ import { Input } from "./ui/input"
 import { Label } from "./ui/label"
 import { Select, SelectContent, SelectItem, SelectTrigger, SelectValue } from "./ui/select"
 import { X, Shield, Save, Cloud, HardDrive, AlertCircle } from "lucide-react"
+import { EncryptionKeys } from "./EncryptionKeys"
 
 interface SettingsProps {
     onClose: () => void
@@ -204,6 +205,8 @@ export function Settings({ onClose, onSave }: SettingsProps) {
                         </div>
                     </div>
 
+                    <EncryptionKeys />
+
                     {/* Advanced Section */}
                     <div className="space-y-6 pt-6 border-t">
                         <div className="flex items-center gap-2 text-sm font-semibold uppercase tracking-wider text-muted-foreground">
@@ -1,9 +1,9 @@
-import { useState } from "react"
+import { useEffect, useState } from "react"
 import { motion } from "framer-motion"
 import { AreaChart, Area, XAxis, YAxis, Tooltip, ResponsiveContainer } from "recharts"
 import { Badge } from "@/components/ui/badge"
 import { Button } from "@/components/ui/button"
-import { Box, RotateCcw, X, ChevronRight, History } from "lucide-react"
+import { Box, RotateCcw, X, ChevronRight, History, Clock } from "lucide-react"
 import { Tabs, TabsContent, TabsList, TabsTrigger } from "@/components/ui/tabs"
 import { VerificationReceipt } from "./VerificationReceipt"
 import { RestoreModal } from "./RestoreModal"
@@ -30,6 +30,58 @@ export function StackDetail({ stack, history, onClose, onRestore, onVerify, onRe
     const [includeAppCode, setIncludeAppCode] = useState(false)
     const [restoreModalOpen, setRestoreModalOpen] = useState(false)
     const [selectedBackup, setSelectedBackup] = useState<any>(null)
+    const [schedule, setSchedule] = useState<any>(null)
+    const [cronExpr, setCronExpr] = useState("")
+    const [scheduleError, setScheduleError] = useState<string | null>(null)
+
+    const applySchedules = (data: any) => {
+        const match = Array.isArray(data) ? data.find((s: any) => s.stack === stack.Name) : null
+        setSchedule(match || null)
+        setCronExpr(match?.cron || "")
+    }
+
+    const fetchSchedule = () => {
+        fetch("http://localhost:8080/api/schedules")
+            .then(res => res.json())
+            .then(applySchedules)
+            .catch(() => setSchedule(null))
+    }
+
+    useEffect(() => {
+        fetchSchedule()
+    }, [stack.Name])
+
+    const saveSchedule = () => {
+        setScheduleError(null)
+        fetch("http://localhost:8080/api/schedules", {
+            method: "POST",
+            body: JSON.stringify({
+                stack: stack.Name,
+                location: stack.ComposeFile ? stack.ComposeFile.replace("/docker-compose.yml", "") : "",
+                cron: cronExpr,
+                pause: pauseContainers,
+                include_db: includeDatabases,
+                verify: autoVerify,
+                snapshot_images: includeAppCode
+            }),
+            headers: { "Content-Type": "application/json" }
+        })
+            .then(res => {
+                if (!res.ok) return res.text().then(text => { throw new Error(text) })
+                return res.json()
+            })
+            .then(applySchedules)
+            .catch(err => setScheduleError(err.message))
+    }
+
+    const removeSchedule = () => {
+        fetch("http://localhost:8080/api/schedules/remove", {
+            method: "POST",
+            body: JSON.stringify({ stack: stack.Name }),
+            headers: { "Content-Type": "application/json" }
+        })
+            .then(() => fetchSchedule())
+    }
 
 
     const handleBackupTrigger = (options: any) => {
@@ -151,6 +203,52 @@ export function StackDetail({ stack, history, onClose, onRestore, onVerify, onRe
                                 </Button>
                             </div>
 
+                            {/* Schedule Card */}
+                            <div className="bg-zinc-900/40 border border-zinc-800 rounded-lg p-5 space-y-4">
+                                <div className="flex items-center justify-between">
+                                    <h3 className="text-sm font-medium text-zinc-200 flex items-center gap-2"><Clock className="w-3.5 h-3.5" /> Schedule</h3>
+                                    {schedule?.running && <span className="text-xs text-emerald-500 animate-pulse">Running...</span>}
+                                </div>
+                                <div className="flex items-center gap-2">
+                                    <input
+                                        value={cronExpr}
+                                        onChange={e => setCronExpr(e.target.value)}
+                                        placeholder="0 3 * * *"
+                                        className="flex-1 h-8 rounded border border-zinc-800 bg-zinc-950 px-3 text-xs font-mono text-zinc-200 placeholder:text-zinc-600"
+                                    />
+                                    <Button size="sm" className="h-8 bg-white text-black hover:bg-zinc-200" onClick={saveSchedule} disabled={!cronExpr}>
+                                        Save
+                                    </Button>
+                                    {schedule && (
+                                        <Button size="sm" variant="ghost" className="h-8 text-xs text-zinc-500 hover:text-white" onClick={removeSchedule}>
+                                            Remove
+                                        </Button>
+                                    )}
+                                </div>
+                                {scheduleError && <div className="text-xs text-red-500">{scheduleError}</div>}
+                                {schedule && (
+                                    <div className="grid grid-cols-2 gap-3 text-xs">
+                                        <div>
+                                            <div className="text-zinc-500 font-mono uppercase mb-1">Next Run</div>
+                                            <div className="text-zinc-300">{schedule.next_run ? new Date(schedule.next_run).toLocaleString() : "—"}</div>
+                                        </div>
+                                        <div>
+                                            <div className="text-zinc-500 font-mono uppercase mb-1">Last Run</div>
+                                            <div className="text-zinc-300">
+                                                {schedule.last_run ? new Date(schedule.last_run).toLocaleString() : "Never"}
+                                                {schedule.last_status && (
+                                                    <span className={`ml-2 ${schedule.last_status === "failed" ? "text-red-500" : schedule.last_status === "succeeded" ? "text-emerald-500" : "text-amber-500"}`}>
+                                                        {schedule.last_status}
+                                                    </span>
+                                                )}
+                                            </div>
+                                            {schedule.last_error && <div className="text-red-500 mt-1">{schedule.last_error}</div>}
+                                        </div>
+                                    </div>
+                                )}
+                                <p className="text-[10px] text-zinc-600">Scheduled runs use the backup options selected above.</p>
+                            </div>
+
                             {/* Stats Grid */}
                             <div className="grid grid-cols-2 gap-4">
                                 <div className="p-4 rounded-lg border border-zinc-800 bg-zinc-900/20">
@@ -265,7 +363,7 @@ export function StackDetail({ stack, history, onClose, onRestore, onVerify, onRe
                                                             <VerificationReceipt verification={item.verification} />
                                                         ) : (
                                                             <Badge variant="destructive" className="h-5 text-[10px] px-1.5" onClick={() => alert(item.verification.error_message)}>
-                                                                Failed
+                                                                {item.verification.outcome === "wrong_key" ? "Wrong Key" : item.verification.outcome === "key_required" ? "Key Required" : "Failed"}
                                                             </Badge>
                                                         )
                                                     ) : (
@@ -13,6 +13,10 @@ if (posthogKey) {
     })
 }
 
+const nativeFetch = window.fetch.bind(window)
+window.fetch = (input: RequestInfo | URL, init?: RequestInit) =>
+    nativeFetch(input, { credentials: 'include', ...init })
+
 const rootElement = document.getElementById('root')
 if (!rootElement) throw new Error('Failed to find the root element')
 
@@ -0,0 +1,104 @@
+import { useState } from "react"
+import { Button } from "@/components/ui/button"
+import { Card, CardContent, CardHeader, CardTitle, CardDescription, CardFooter } from "@/components/ui/card"
+import { Input } from "@/components/ui/input"
+import { Label } from "@/components/ui/label"
+
+import { Shield } from "lucide-react"
+
+interface LoginProps {
+    adminConfigured: boolean
+    onComplete: () => void
+}
+
+export function Login({ adminConfigured, onComplete }: LoginProps) {
+    const [username, setUsername] = useState("admin")
+    const [password, setPassword] = useState("")
+    const [confirm, setConfirm] = useState("")
+    const [loading, setLoading] = useState(false)
+    const [error, setError] = useState("")
+
+    const handleSubmit = () => {
+        if (!adminConfigured && password !== confirm) {
+            setError("Passwords do not match")
+            return
+        }
+
+        setLoading(true)
+        setError("")
+
+        const endpoint = adminConfigured ? "login" : "setup"
+        fetch(`http://localhost:8080/api/auth/${endpoint}`, {
+            method: "POST",
+            body: JSON.stringify({ username, password }),
+            headers: { "Content-Type": "application/json" }
+        })
+            .then(async res => {
+                if (!res.ok) throw new Error((await res.text()).trim() || "Login failed")
+                onComplete()
+            })
+            .catch(err => {
+                setError(err.message || "Something went wrong")
+                setLoading(false)
+            })
+    }
+
+    return (
+        <div className="min-h-screen bg-background flex items-center justify-center p-4">
+            <Card className="w-full max-w-sm shadow-2xl border-primary/20">
+                <CardHeader>
+                    <div className="flex items-center gap-2 mb-2 justify-center">
+                        <Shield className="w-8 h-8 text-primary" />
+                        <span className="text-2xl font-bold">StackSnap</span>
+                    </div>
+                    <CardTitle className="text-center">
+                        {adminConfigured ? "Sign In" : "Create Admin Account"}
+                    </CardTitle>
+                    <CardDescription className="text-center">
+                        {adminConfigured
+                            ? "Sign in to manage your backups."
+                            : "Choose the credentials used to access this dashboard."}
+                    </CardDescription>
+                </CardHeader>
+                <CardContent className="space-y-4">
+                    {error && (
+                        <div className="p-3 bg-destructive/10 text-destructive text-sm rounded-md border border-destructive/20 text-center">
+                            {error}
+                        </div>
+                    )}
+
+                    <div className="space-y-2">
+                        <Label>Username</Label>
+                        <Input value={username} onChange={e => setUsername(e.target.value)} />
+                    </div>
+                    <div className="space-y-2">
+                        <Label>Password</Label>
+                        <Input
+                            type="password"
+                            value={password}
+                            onChange={e => setPassword(e.target.value)}
+                            onKeyDown={e => e.key === "Enter" && adminConfigured && handleSubmit()}
+                        />
+                    </div>
+                    {!adminConfigured && (
+                        <div className="space-y-2">
+                            <Label>Confirm Password</Label>
+                            <Input
+                                type="password"
+                                value={confirm}
+                                onChange={e => setConfirm(e.target.value)}
+                                onKeyDown={e => e.key === "Enter" && handleSubmit()}
+                            />
+                            <p className="text-xs text-muted-foreground">At least 8 characters.</p>
+                        </div>
+                    )}
+                </CardContent>
+                <CardFooter>
+                    <Button className="w-full" onClick={handleSubmit} disabled={loading || !username || !password}>
+                        {loading ? "Please wait..." : adminConfigured ? "Sign In" : "Create Account"}
+                    </Button>
+                </CardFooter>
+            </Card>
+        </div>
+    )
+}